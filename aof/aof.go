@@ -7,134 +7,476 @@
 package aof
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"redis-go/interface/database"
 	"redis-go/lib/config"
 	"redis-go/lib/logger"
 	"redis-go/lib/utils"
+	"redis-go/rdb"
 	"redis-go/resp/connection"
-	"redis-go/resp/parser"
 	"redis-go/resp/reply"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	aofQueueSize = 1 << 16
+	aofQueueSize    = 1 << 16
+	defaultFilename = "appendonly.aof"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
 type payload struct {
-	cmdLine CmdLine
+	// 序列化之后的指令
+	data    []byte
 	dbIndex int
+	// 不为 nil 时表示在这个位置开始重写，用来返回开始重写的结果
+	rewriteStarted chan error
+	// always 策略下写入并 fsync 之后关闭
+	written chan struct{}
+	// 没有写入文件或者 fsync 失败时的错误，关闭 written 之前设置
+	err error
 }
 
 // AofHandler receive msgs from channel and write to AOF file
 type AofHandler struct {
-	database    database.Database
-	aofChan     chan *payload //写aof文件的缓存池
-	aofFile     *os.File      // .aof文件
-	aofFilename string        // 文件名
-	currentDB   int           // 记录上一条指令工作的 db
+	database  database.Database
+	aofChan   chan *payload //写aof文件的缓存池
+	aofFile   *os.File      // 正在写入的 incr 文件
+	dirname   string        // 保存 aof 文件和清单的目录
+	filename  string        // 文件名的前缀
+	manifest  *manifest     // 当前的清单，pausingAof 保护
+	currentDB int           // 记录上一条指令工作的 db，-1 表示下一条命令之前必须 select
+	// handleAof 写完所有命令之后关闭
+	aofFinished chan struct{}
+	closed      bool
+	// 重写时创建临时数据库加载旧的文件
+	tmpDBMaker func() database.DBEngine
+	// 写文件和重写时切换文件互斥，也保护下面的状态
+	pausingAof sync.Mutex
+	// 正在重写
+	rewriting bool
+	// 后台重写的协程，关闭时等待重写结束
+	rewriteWg sync.WaitGroup
+	// 所有文件的大小和上次重写之后（或者启动时）base 文件的大小，用于自动触发重写
+	currentSize int64
+	baseSize    int64
+	// 重写的统计信息，INFO persistence 展示
+	rewriteStart      time.Time
+	lastRewriteTime   time.Duration
+	lastRewriteFailed bool
+	rewrites          int64
+	// fsync 策略，启动时确定
+	fsync string
+	// everysec 策略下正在进行的 fsync，完成时关闭
+	fsyncDone chan struct{}
+	// 上次完成 fsync 的时间和耗时
+	lastFsync         time.Time
+	lastFsyncDuration time.Duration
+	// fsync 落后太多而被阻塞的写入次数
+	delayedFsync int64
+	// 写入失败或者 always 策略下 fsync 失败的错误，之后不再写入文件，数据库拒绝写命令
+	writeErr error
 }
 
 // NewAofHandler creates a new aof.AofHandler
-func NewAofHandler(db database.Database) (*AofHandler, error) {
+func NewAofHandler(db database.Database, tmpDBMaker func() database.DBEngine) (*AofHandler, error) {
 	handler := &AofHandler{}
-	handler.aofFilename = config.Properties.AppendFilename
+	handler.dirname = aofDirname()
+	handler.filename = aofFilename()
 	handler.database = db
-	//加载已有的数据
-	handler.LoadAof()
+	handler.tmpDBMaker = tmpDBMaker
+	handler.lastRewriteTime = -1
+	handler.fsync = fsyncPolicy()
+	// 读取清单，清单和目录中的文件对不上时拒绝启动
+	if err := handler.loadManifest(); err != nil {
+		return nil, err
+	}
+	//加载已有的数据，文件损坏时拒绝启动
+	if err := handler.LoadAof(); err != nil {
+		return nil, err
+	}
 	// 打开后就一直要用的，所以不需要 defer 关闭
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600) //读写方式打开文件
-	if err != nil {
+	if err := handler.openIncr(); err != nil {
 		return nil, err
 	}
-	handler.aofFile = aofFile
+	handler.baseSize, handler.currentSize = handler.fileSizes()
+	handler.lastFsync = time.Now()
 	// channel缓冲，缓冲区大小为 aofQueueSize
 	handler.aofChan = make(chan *payload, aofQueueSize)
+	handler.aofFinished = make(chan struct{})
 	// 异步的
 	go func() {
 		handler.handleAof()
 	}()
+	if handler.fsync == FsyncEverySec {
+		go handler.fsyncEverySec()
+	}
 	return handler, nil
 }
 
+// aofFilename appendfilename 是文件名的前缀，默认 appendonly.aof
+func aofFilename() string {
+	if config.Properties.AppendFilename == "" {
+		return defaultFilename
+	}
+	return filepath.Base(config.Properties.AppendFilename)
+}
+
+// aofDirname appenddirname 为相对路径时和 appendfilename 在同一个目录下，默认 appendonlydir
+func aofDirname() string {
+	dirname := config.Properties.AppendDirname
+	if dirname == "" {
+		dirname = defaultDirname
+	}
+	if filepath.IsAbs(dirname) {
+		return dirname
+	}
+	return filepath.Join(filepath.Dir(config.Properties.AppendFilename), dirname)
+}
+
+// ManifestPath 返回清单文件的路径
+func ManifestPath() string {
+	return filepath.Join(aofDirname(), aofFilename()+manifestSuffix)
+}
+
+// Exists 已经有 AOF 文件：清单存在或者有旧版本的单个 AOF 文件
+func Exists() bool {
+	if _, err := os.Stat(ManifestPath()); err == nil {
+		return true
+	}
+	_, err := os.Stat(config.Properties.AppendFilename)
+	return err == nil
+}
+
+func (handler *AofHandler) path(name string) string {
+	return filepath.Join(handler.dirname, name)
+}
+
+// loadManifest 读取清单，删除 history 文件，检查清单中的文件都存在并且没有多余的文件
+// 没有清单时把旧版本的单个 AOF 文件移到目录中作为 base
+func (handler *AofHandler) loadManifest() error {
+	if err := os.MkdirAll(handler.dirname, 0755); err != nil {
+		return err
+	}
+	m, err := readManifest(handler.path(handler.filename + manifestSuffix))
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &manifest{}
+		if err = handler.upgradeLegacyFile(m); err != nil {
+			return err
+		}
+	}
+	handler.manifest = m
+	handler.deleteHistory()
+	return checkSegments(handler.dirname, handler.filename, handler.manifest)
+}
+
+// upgradeLegacyFile 旧版本的 appendfilename 文件存在时移到目录中作为第一个 base 文件
+func (handler *AofHandler) upgradeLegacyFile(m *manifest) error {
+	legacy := config.Properties.AppendFilename
+	if info, err := os.Stat(legacy); err != nil || info.IsDir() {
+		return nil
+	}
+	base := m.nextBase(handler.filename, nil)
+	if err := os.Rename(legacy, handler.path(base.name)); err != nil {
+		return err
+	}
+	logger.Info("Moving the legacy aof file " + legacy + " into " + handler.path(base.name))
+	return writeManifest(handler.dirname, handler.filename, m)
+}
+
+// deleteHistory 删除 history 文件之后更新清单，删除失败的文件留到下次
+func (handler *AofHandler) deleteHistory() {
+	if len(handler.manifest.history) == 0 {
+		return
+	}
+	next := handler.manifest.copy()
+	next.history = next.history[:0]
+	for _, info := range handler.manifest.history {
+		if err := os.Remove(handler.path(info.name)); err != nil && !os.IsNotExist(err) {
+			logger.Warn(err)
+			next.history = append(next.history, info)
+		}
+	}
+	if err := writeManifest(handler.dirname, handler.filename, next); err != nil {
+		logger.Warn(err)
+		return
+	}
+	handler.manifest = next
+}
+
+// openIncr 启动时打开最后一个 incr 文件继续写入，没有时创建一个新的
+func (handler *AofHandler) openIncr() error {
+	// 每个文件都从 db 0 开始加载，写入第一条命令之前先 select
+	handler.currentDB = -1
+	m := handler.manifest
+	if len(m.incrs) == 0 {
+		file, next, err := handler.createIncr()
+		if err != nil {
+			return err
+		}
+		handler.aofFile = file
+		handler.manifest = next
+		return nil
+	}
+	file, err := os.OpenFile(handler.path(m.incrs[len(m.incrs)-1].name), os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	handler.aofFile = file
+	return nil
+}
+
+// createIncr 创建一个新的 incr 文件并更新清单，返回新的文件和清单，由调用者切换
+func (handler *AofHandler) createIncr() (*os.File, *manifest, error) {
+	next := handler.manifest.copy()
+	info := next.addIncr(handler.filename)
+	file, err := os.OpenFile(handler.path(info.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writeManifest(handler.dirname, handler.filename, next); err != nil {
+		_ = file.Close()
+		_ = os.Remove(handler.path(info.name))
+		return nil, nil, err
+	}
+	return file, next, nil
+}
+
+// fileSizes 返回 base 文件的大小和所有文件的大小
+func (handler *AofHandler) fileSizes() (baseSize int64, totalSize int64) {
+	for _, info := range handler.manifest.files() {
+		stat, err := os.Stat(handler.path(info.name))
+		if err != nil {
+			continue
+		}
+		if info == handler.manifest.base {
+			baseSize = stat.Size()
+		}
+		totalSize += stat.Size()
+	}
+	return baseSize, totalSize
+}
+
 // AddAof send command to aof goroutine through channel
 // Add payload(set k v) -> aofChan
-func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
+// always 策略下返回写入或者 fsync 的错误，其他策略不等待写入，总是返回 nil
+func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) error {
 	// 可以append且aofChan已经初始化
 	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
-			cmdLine: cmdLine,
+		// 入队前先序列化，之后参数被原地修改（如 SETBIT 修改 SET 存入的值）也不影响落盘的内容
+		p := &payload{
+			data:    reply.MakeMultiBulkReply(cmdLine).ToBytes(),
 			dbIndex: dbIndex,
 		}
+		// always 策略下等待 fsync 完成之后再回复客户端
+		// everysec 策略下 fsync 落后太多时阻塞调用方，而不是让命令堆积在队列中
+		switch handler.fsync {
+		case FsyncAlways:
+			p.written = make(chan struct{})
+		case FsyncEverySec:
+			handler.waitFsync()
+		}
+		handler.aofChan <- p
+		if p.written != nil {
+			<-p.written
+			return p.err
+		}
 	}
+	return nil
 }
 
 // handleAof listen aof channel and write into file
 // payload(set k v) <- aofChan 落盘
 func (handler *AofHandler) handleAof() {
 	// serialized execution
-	handler.currentDB = 0
+	defer close(handler.aofFinished)
 	for p := range handler.aofChan {
-		if p.dbIndex != handler.currentDB {
-			// 不一致 插入 select db
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(p.dbIndex))).ToBytes()
-			// 写入文件
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
+		if p.rewriteStarted != nil {
+			p.rewriteStarted <- handler.backgroundRewrite()
+			continue
+		}
+		p.err = handler.writeAof(p)
+		if p.written != nil {
+			close(p.written)
+		}
+		if handler.needRewrite() {
+			if err := handler.backgroundRewrite(); err != nil {
 				logger.Warn(err)
-				continue // skip this command
 			}
-			handler.currentDB = p.dbIndex
 		}
-		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
-		if err != nil {
-			logger.Warn(err)
+	}
+}
+
+// writeAof 写入一条命令，返回没有写入或者 fsync 失败的错误
+func (handler *AofHandler) writeAof(p *payload) error {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	// 写入或者 fsync 失败之后文件中的数据已经不可靠
+	if handler.writeErr != nil {
+		return handler.writeErr
+	}
+	if p.dbIndex != handler.currentDB {
+		// 不一致 插入 select db
+		data := reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(p.dbIndex))).ToBytes()
+		// 写入文件
+		if err := handler.write(data); err != nil {
+			return err
+		}
+		handler.currentDB = p.dbIndex
+	}
+	if err := handler.write(p.data); err != nil {
+		return err
+	}
+	if handler.fsync == FsyncAlways {
+		if err := handler.fsyncNow(); err != nil {
+			logger.Error("can't fsync aof file when the fsync policy is 'always': " + err.Error())
+			handler.writeErr = err
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteErr 返回写入或者 always 策略下 fsync 失败的错误，不为 nil 时数据库拒绝写命令
+func (handler *AofHandler) WriteErr() error {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	return handler.writeErr
+}
+
+// write 写入文件，失败时文件末尾可能只有半条命令，之后不再写入
+func (handler *AofHandler) write(data []byte) error {
+	n, err := handler.aofFile.Write(data)
+	handler.currentSize += int64(n)
+	if err != nil {
+		logger.Error("can't write aof file: " + err.Error())
+		handler.writeErr = err
+	}
+	return err
+}
+
+// Close 等待缓冲的命令都写入文件之后关闭文件
+func (handler *AofHandler) Close() {
+	close(handler.aofChan)
+	<-handler.aofFinished
+	// 只有 handleAof 会开始重写，它退出之后不会再有新的重写
+	handler.rewriteWg.Wait()
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	handler.closed = true
+	// 和 Redis 一样关闭之前无论什么策略都 fsync 一次
+	if err := handler.aofFile.Sync(); err != nil {
+		logger.Warn(err)
+	}
+	if err := handler.aofFile.Close(); err != nil {
+		logger.Warn(err)
+	}
+}
+
+// LoadAof 按清单的顺序加载 base 和 incr 文件
+// 最后一个文件末尾的命令不完整时，开启 aof-load-truncated 则把文件截断到最后一条完整的命令，否则返回错误
+// 其他位置损坏时总是返回错误，需要用 check-aof 检查和修复
+func (handler *AofHandler) LoadAof() error {
+	return handler.loadFiles(handler.manifest.files(), loadTruncated())
+}
+
+// loadFiles 依次加载文件，allowTruncated 为 true 时允许最后一个文件的末尾不完整
+func (handler *AofHandler) loadFiles(files []*aofInfo, allowTruncated bool) error {
+	for i, info := range files {
+		if err := handler.loadFile(handler.path(info.name), allowTruncated && i == len(files)-1); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-// LoadAof read aof file
-func (handler *AofHandler) LoadAof() {
-	logger.Info("LoadAof: read aof file: " + handler.aofFilename)
+func (handler *AofHandler) loadFile(path string, allowTruncated bool) error {
+	logger.Info("LoadAof: read aof file: " + path)
 	// 打开文件 只读方式打开文件
-	file, err := os.Open(handler.aofFilename)
+	file, err := os.Open(path)
 	if err != nil {
-		logger.Error(err)
-		return
+		return err
 	}
-	// 恢复数据的时候只打开一次就关闭
-	defer file.Close()
-	// file 实现了 reader 接口
-	ch := parser.ParseStream(file)
-	// 这边 selectDB 就初始化为 0 了
+	reader := bufio.NewReader(file)
+	// 和 Redis 一样根据文件头判断格式，Redis 开启 aof-use-rdb-preamble 时 base 文件是 RDB
+	if header, _ := reader.Peek(5); string(header) == "REDIS" {
+		err = handler.loadRdb(reader)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+	err = handler.loadCommands(reader)
+	// 恢复数据的时候只打开一次就关闭，截断之前先关闭
+	_ = file.Close()
+	var formatErr *FormatError
+	if !errors.As(err, &formatErr) {
+		return err
+	}
+	if !formatErr.Truncated || !allowTruncated {
+		return fmt.Errorf("%s: %w, use check-aof --fix to repair it", path, err)
+	}
+	logger.Warn("!!! Warning: short read while loading the AOF file " + path + " !!!")
+	logger.Warn(fmt.Sprintf("AOF loaded anyway because aof-load-truncated is enabled, truncating to offset %d", formatErr.Offset))
+	return os.Truncate(path, formatErr.Offset)
+}
+
+// loadRdb 加载 RDB 格式的文件，把每个 key 转换成命令执行
+func (handler *AofHandler) loadRdb(reader io.Reader) error {
 	fakeConn := &connection.Connection{}
-	for p := range ch {
-		if p.Err != nil {
-			// 文件结束符
-			if p.Err == io.EOF {
-				break
-			}
-			logger.Error(err)
-			continue
+	return rdb.NewDecoder(reader).Decode(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) error {
+		if dbIndex != fakeConn.GetDBIndex() {
+			handler.exec(fakeConn, utils.ToCmdLine("select", strconv.Itoa(dbIndex)))
 		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+		for _, cmdLine := range EntityToCmd(key, entity) {
+			handler.exec(fakeConn, cmdLine)
 		}
-		// 类型断言
-		r, ok := p.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("need multi bulk")
+		if expiration != nil {
+			handler.exec(fakeConn, ExpireToCmd(key, *expiration))
 		}
-		rep := handler.database.Exec(fakeConn, r.Args)
-		if reply.IsErrReply(rep) {
-			logger.Error(rep)
+		return nil
+	})
+}
+
+func (handler *AofHandler) exec(conn *connection.Connection, cmdLine CmdLine) {
+	rep := handler.database.Exec(conn, cmdLine)
+	if reply.IsErrReply(rep) {
+		logger.Error(rep)
+	}
+}
+
+// loadCommands 逐条执行 reader 中的命令
+func (handler *AofHandler) loadCommands(reader io.Reader) error {
+	aofReader := NewReader(reader)
+	// 这边 selectDB 就初始化为 0 了
+	fakeConn := &connection.Connection{}
+	for {
+		cmdLine, err := aofReader.ReadCommand()
+		if err != nil {
+			// 文件正好在命令之间结束
+			if err == io.EOF {
+				return nil
+			}
+			return err
 		}
+		handler.exec(fakeConn, cmdLine)
 	}
 }
+
+// loadTruncated 文件末尾的命令不完整时是否截断之后继续加载，和 Redis 一样默认 yes
+func loadTruncated() bool {
+	return strings.ToLower(config.Properties.AofLoadTruncated) != "no"
+}
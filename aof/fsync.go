@@ -0,0 +1,107 @@
+// Package aof -----------------------------
+// @file      : fsync.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/13 11:20
+// -------------------------------------------
+package aof
+
+import (
+	"errors"
+	"os"
+	"redis-go/lib/config"
+	"redis-go/lib/logger"
+	"strings"
+	"time"
+)
+
+// appendfsync 策略，和 Redis 一样
+// always: 每条命令写入之后 fsync，fsync 完成之前不回复客户端
+// everysec: 后台每秒 fsync 一次，最多丢失一到两秒的数据
+// no: 由操作系统决定什么时候刷盘
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// everysec 策略下 fsync 落后超过这个时间时阻塞写入，保证最多丢失两秒的数据
+const maxFsyncDelay = 2 * time.Second
+
+// fsyncPolicy 读取配置的 fsync 策略，默认 everysec
+func fsyncPolicy() string {
+	switch policy := strings.ToLower(config.Properties.AppendFsync); policy {
+	case FsyncAlways, FsyncNo:
+		return policy
+	}
+	return FsyncEverySec
+}
+
+// fsyncEverySec everysec 策略下后台每秒 fsync 一次，handleAof 退出时结束
+func (handler *AofHandler) fsyncEverySec() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			handler.backgroundFsync()
+		case <-handler.aofFinished:
+			return
+		}
+	}
+}
+
+// backgroundFsync 不持有锁执行 fsync，fsync 比较慢时不影响写入
+func (handler *AofHandler) backgroundFsync() {
+	handler.pausingAof.Lock()
+	if handler.closed {
+		handler.pausingAof.Unlock()
+		return
+	}
+	file := handler.aofFile
+	done := make(chan struct{})
+	handler.fsyncDone = done
+	handler.pausingAof.Unlock()
+
+	start := time.Now()
+	err := file.Sync()
+	// 开始重写时旧的 incr 文件已经 fsync 并关闭
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		logger.Warn(err)
+	}
+
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	handler.lastFsyncDuration = time.Since(start)
+	if err == nil {
+		handler.lastFsync = start
+	}
+	handler.fsyncDone = nil
+	close(done)
+}
+
+// waitFsync everysec 策略下 fsync 正在进行并且上次完成的 fsync 已经超过两秒时，调用方等待 fsync 完成之后再写入
+func (handler *AofHandler) waitFsync() {
+	handler.pausingAof.Lock()
+	done := handler.fsyncDone
+	delayed := done != nil && time.Since(handler.lastFsync) > maxFsyncDelay
+	if delayed {
+		handler.delayedFsync++
+	}
+	handler.pausingAof.Unlock()
+	if delayed {
+		<-done
+	}
+}
+
+// fsyncNow always 策略下写入之后立即 fsync，需要持有 pausingAof
+// Redis 在这里直接退出，这里返回错误，由调用方停止写入文件并拒绝之后的写命令
+func (handler *AofHandler) fsyncNow() error {
+	start := time.Now()
+	if err := handler.aofFile.Sync(); err != nil {
+		return err
+	}
+	handler.lastFsyncDuration = time.Since(start)
+	handler.lastFsync = start
+	return nil
+}
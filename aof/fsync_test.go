@@ -0,0 +1,148 @@
+package aof
+
+import (
+	"os"
+	"path/filepath"
+	"redis-go/interface/resp"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"testing"
+	"time"
+)
+
+type nopDatabase struct{}
+
+func (nopDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
+	return nil
+}
+
+func (nopDatabase) Close() {}
+
+func (nopDatabase) AfterClientClose(c resp.Connection) {}
+
+// fsync 落后超过两秒时阻塞写入，直到 fsync 完成
+func TestDelayedFsync(t *testing.T) {
+	old := *config.Properties
+	defer func() {
+		*config.Properties = old
+	}()
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFsync = FsyncEverySec
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	handler, err := NewAofHandler(nopDatabase{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	// 模拟一个很慢的 fsync
+	done := make(chan struct{})
+	handler.pausingAof.Lock()
+	handler.fsyncDone = done
+	handler.lastFsync = time.Now().Add(-3 * time.Second)
+	handler.pausingAof.Unlock()
+
+	// 调用方被阻塞，而不只是写文件的协程
+	added := make(chan struct{})
+	go func() {
+		handler.AddAof(0, utils.ToCmdLine("set", "k", "v"))
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("expected AddAof blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	status := handler.Status()
+	if status.CurrentSize != 0 || status.DelayedFsync != 1 || !status.PendingFsync {
+		t.Fatalf("expected write blocked, actually size %d, delayed %d", status.CurrentSize, status.DelayedFsync)
+	}
+
+	handler.pausingAof.Lock()
+	handler.fsyncDone = nil
+	handler.lastFsync = time.Now()
+	handler.pausingAof.Unlock()
+	close(done)
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("expected AddAof returned after fsync")
+	}
+	deadline := time.Now().Add(time.Second)
+	for handler.Status().CurrentSize == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected write after fsync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// always 策略下 fsync 失败时不退出，记录错误之后不再写入文件
+func TestFsyncAlwaysError(t *testing.T) {
+	old := *config.Properties
+	defer func() {
+		*config.Properties = old
+	}()
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFsync = FsyncAlways
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	handler, err := NewAofHandler(nopDatabase{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	// 管道可以写入但是不支持 fsync
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	handler.pausingAof.Lock()
+	_ = handler.aofFile.Close()
+	handler.aofFile = w
+	handler.pausingAof.Unlock()
+
+	if err = handler.AddAof(0, utils.ToCmdLine("set", "k", "v")); err == nil {
+		t.Fatal("expected fsync error")
+	}
+	if handler.WriteErr() == nil || !handler.Status().LastWriteFailed {
+		t.Fatal("expected fsync error")
+	}
+	size := handler.Status().CurrentSize
+	handler.AddAof(0, utils.ToCmdLine("set", "k", "v"))
+	if handler.Status().CurrentSize != size {
+		t.Error("expected no write after fsync error")
+	}
+}
+
+// 写入失败时同样记录错误，always 策略下等待写入的命令返回错误
+func TestWriteError(t *testing.T) {
+	old := *config.Properties
+	defer func() {
+		*config.Properties = old
+	}()
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFsync = FsyncAlways
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	handler, err := NewAofHandler(nopDatabase{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+
+	// 已经关闭的文件写入失败
+	handler.pausingAof.Lock()
+	_ = handler.aofFile.Close()
+	handler.pausingAof.Unlock()
+
+	if err = handler.AddAof(0, utils.ToCmdLine("set", "k", "v")); err == nil {
+		t.Fatal("expected write error")
+	}
+	if handler.WriteErr() == nil || !handler.Status().LastWriteFailed {
+		t.Fatal("expected write error")
+	}
+	if err = handler.AddAof(0, utils.ToCmdLine("set", "k", "v")); err == nil {
+		t.Error("expected write refused after write error")
+	}
+}
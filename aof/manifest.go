@@ -0,0 +1,330 @@
+// Package aof -----------------------------
+// @file      : manifest.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/17 10:15
+// -------------------------------------------
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"redis-go/lib/logger"
+	"strconv"
+	"strings"
+)
+
+// 和 Redis 7 一样的 multi-part AOF，appenddirname 目录中保存：
+// 一个 base 文件，上次重写生成的快照，<appendfilename>.<seq>.base.aof（Redis 开启 aof-use-rdb-preamble 时是 .base.rdb）
+// 若干 incr 文件，base 之后的写命令，<appendfilename>.<seq>.incr.aof，只有最后一个在写入
+// 清单文件 <appendfilename>.manifest，按顺序记录上面的文件，每行是 file <文件名> seq <序号> type <b|h|i>
+// 重写完成时不再使用的文件标记为 history，删除之后从清单中去掉
+// 清单总是先写入临时文件再替换，替换成功才算更新完成
+
+const (
+	defaultDirname = "appendonlydir"
+
+	baseSuffix     = ".base"
+	incrSuffix     = ".incr"
+	aofFormatExt   = ".aof"
+	rdbFormatExt   = ".rdb"
+	manifestSuffix = ".manifest"
+	tempPrefix     = "temp-"
+
+	aofTypeBase    = 'b'
+	aofTypeHistory = 'h'
+	aofTypeIncr    = 'i'
+)
+
+// aofInfo 清单中的一个文件
+type aofInfo struct {
+	name string
+	seq  int64
+	typ  byte
+}
+
+// manifest 清单的内容，文件的顺序就是加载的顺序
+type manifest struct {
+	base    *aofInfo
+	incrs   []*aofInfo
+	history []*aofInfo
+	// 已经使用过的最大的序号，新文件的序号加 1
+	baseSeq int64
+	incrSeq int64
+}
+
+// files 需要加载的文件：base 和所有的 incr
+func (m *manifest) files() []*aofInfo {
+	files := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *manifest) copy() *manifest {
+	c := *m
+	c.incrs = append([]*aofInfo(nil), m.incrs...)
+	c.history = append([]*aofInfo(nil), m.history...)
+	return &c
+}
+
+// addIncr 添加一个新的 incr 文件
+func (m *manifest) addIncr(filename string) *aofInfo {
+	m.incrSeq++
+	info := &aofInfo{
+		name: filename + "." + strconv.FormatInt(m.incrSeq, 10) + incrSuffix + aofFormatExt,
+		seq:  m.incrSeq,
+		typ:  aofTypeIncr,
+	}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// nextBase 重写生成的新 base 文件，旧的 base 和 replaced 中的 incr 都变成 history
+func (m *manifest) nextBase(filename string, replaced []*aofInfo) *aofInfo {
+	m.baseSeq++
+	base := &aofInfo{
+		name: filename + "." + strconv.FormatInt(m.baseSeq, 10) + baseSuffix + aofFormatExt,
+		seq:  m.baseSeq,
+		typ:  aofTypeBase,
+	}
+	for _, info := range replaced {
+		if info == m.base {
+			m.history = append(m.history, &aofInfo{name: info.name, seq: info.seq, typ: aofTypeHistory})
+			continue
+		}
+		for i, incr := range m.incrs {
+			if incr == info {
+				m.incrs = append(m.incrs[:i], m.incrs[i+1:]...)
+				m.history = append(m.history, &aofInfo{name: info.name, seq: info.seq, typ: aofTypeHistory})
+				break
+			}
+		}
+	}
+	m.base = base
+	return base
+}
+
+func (m *manifest) String() string {
+	builder := &strings.Builder{}
+	write := func(info *aofInfo) {
+		builder.WriteString(fmt.Sprintf("file %s seq %d type %c\n", info.name, info.seq, info.typ))
+	}
+	if m.base != nil {
+		write(m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return builder.String()
+}
+
+// parseManifest 解析清单，键值对的顺序不限，# 开头的行是注释
+func parseManifest(reader io.Reader) (*manifest, error) {
+	m := &manifest{}
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		invalid := fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, invalid
+		}
+		info := &aofInfo{seq: -1}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil || seq < 0 {
+					return nil, invalid
+				}
+				info.seq = seq
+			case "type":
+				if len(fields[i+1]) != 1 {
+					return nil, invalid
+				}
+				info.typ = fields[i+1][0]
+			}
+		}
+		// 文件名不能包含路径，防止清单指向目录之外的文件
+		if info.name == "" || info.seq < 0 || filepath.Base(info.name) != info.name || names[info.name] {
+			return nil, invalid
+		}
+		names[info.name] = true
+		switch info.typ {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, fmt.Errorf("invalid aof manifest line %d: found duplicate base file", lineNum)
+			}
+			m.base = info
+			m.baseSeq = info.seq
+		case aofTypeHistory:
+			m.history = append(m.history, info)
+		case aofTypeIncr:
+			// incr 文件的序号必须递增
+			if info.seq <= m.incrSeq && len(m.incrs) > 0 {
+				return nil, fmt.Errorf("invalid aof manifest line %d: incr file sequence is not increasing", lineNum)
+			}
+			m.incrs = append(m.incrs, info)
+			m.incrSeq = info.seq
+		default:
+			return nil, invalid
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readManifest 读取清单文件，文件不存在时返回 nil
+func readManifest(path string) (*manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	return parseManifest(file)
+}
+
+// ManifestFiles 返回清单中需要加载的文件的路径，按加载的顺序排列
+func ManifestFiles(manifestPath string) ([]string, error) {
+	m, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("aof manifest " + manifestPath + " doesn't exist")
+	}
+	paths := make([]string, 0, len(m.incrs)+1)
+	for _, info := range m.files() {
+		paths = append(paths, filepath.Join(filepath.Dir(manifestPath), info.name))
+	}
+	return paths, nil
+}
+
+// writeManifest 写入临时文件并 fsync 之后替换旧的清单，保证清单总是完整的
+func writeManifest(dirname string, filename string, m *manifest) error {
+	path := filepath.Join(dirname, filename+manifestSuffix)
+	tmpPath := filepath.Join(dirname, tempPrefix+filename+manifestSuffix)
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(m.String())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	syncDir(dirname)
+	return nil
+}
+
+// syncDir fsync 目录，保证重命名已经落盘，Windows 上不支持时忽略
+func syncDir(dirname string) {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return
+	}
+	_ = dir.Sync()
+	_ = dir.Close()
+}
+
+// parseSegment 解析 filename 对应的 base 或者 incr 文件的序号和类型，不是这两种文件时 ok 为 false
+func parseSegment(name string, filename string) (seq int64, typ byte, ok bool) {
+	if !strings.HasPrefix(name, filename+".") {
+		return 0, 0, false
+	}
+	rest := name[len(filename)+1:]
+	for _, suffix := range []string{baseSuffix + aofFormatExt, baseSuffix + rdbFormatExt, incrSuffix + aofFormatExt} {
+		if strings.HasSuffix(rest, suffix) {
+			seq, err := strconv.ParseInt(strings.TrimSuffix(rest, suffix), 10, 64)
+			if err != nil || seq < 0 {
+				return 0, 0, false
+			}
+			typ = aofTypeIncr
+			if strings.HasPrefix(suffix, baseSuffix) {
+				typ = aofTypeBase
+			}
+			return seq, typ, true
+		}
+	}
+	return 0, 0, false
+}
+
+// checkSegments 检查清单中的文件都存在，目录中没有清单之外的文件
+// 清单之外的空文件是创建 incr 之后、更新清单之前退出留下的，直接删除
+// 清单之外序号比清单中的 base 大的 base 文件是重写改名之后、更新清单之前退出留下的，清单中的文件仍然完整，也直接删除
+func checkSegments(dirname string, filename string, m *manifest) error {
+	listed := make(map[string]bool)
+	// 没有删除成功的 history 文件留到下次删除
+	for _, info := range m.history {
+		listed[info.name] = true
+	}
+	for _, info := range m.files() {
+		listed[info.name] = true
+		if _, err := os.Stat(filepath.Join(dirname, info.name)); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("aof file %s listed in the manifest doesn't exist", info.name)
+			}
+			return err
+		}
+	}
+	entries, err := os.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || listed[name] {
+			continue
+		}
+		seq, typ, ok := parseSegment(name, filename)
+		if !ok {
+			continue
+		}
+		if typ == aofTypeBase && seq > m.baseSeq {
+			logger.Warn("removing aof file " + name + " left by an unfinished rewrite")
+			_ = os.Remove(filepath.Join(dirname, name))
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			_ = os.Remove(filepath.Join(dirname, name))
+			continue
+		}
+		return errors.New("aof file " + name + " is not listed in the manifest, remove it or add it to the manifest")
+	}
+	return nil
+}
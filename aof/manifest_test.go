@@ -0,0 +1,201 @@
+package aof
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"redis-go/interface/database"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/rdb"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	m := &manifest{}
+	m.addIncr("appendonly.aof")
+	base := m.nextBase("appendonly.aof", nil)
+	replaced := m.files()
+	m.addIncr("appendonly.aof")
+	m.nextBase("appendonly.aof", replaced)
+	expected := "file appendonly.aof.2.base.aof seq 2 type b\n" +
+		"file appendonly.aof.1.base.aof seq 1 type h\n" +
+		"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n"
+	if m.String() != expected {
+		t.Fatalf("unexpected manifest %q", m.String())
+	}
+	if base.typ != aofTypeBase {
+		t.Error("replaced base should not be modified")
+	}
+
+	parsed, err := parseManifest(strings.NewReader("# comment\n\n" + expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != expected || parsed.baseSeq != 2 || parsed.incrSeq != 2 {
+		t.Errorf("unexpected parsed manifest %q", parsed.String())
+	}
+
+	for _, invalid := range []string{
+		"file a seq 1",
+		"file a seq x type b",
+		"file a seq 1 type x",
+		"file ../a seq 1 type b",
+		"file a seq 1 type b\nfile b seq 2 type b",
+		"file a seq 1 type i\nfile a seq 2 type i",
+		"file a seq 2 type i\nfile b seq 1 type i",
+	} {
+		if _, err = parseManifest(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestCheckSegments(t *testing.T) {
+	dir := t.TempDir()
+	m := &manifest{}
+	m.nextBase("appendonly.aof", nil)
+	m.addIncr("appendonly.aof")
+	if err := checkSegments(dir, "appendonly.aof", m); err == nil {
+		t.Error("expected missing file error")
+	}
+	for _, info := range m.files() {
+		if err := os.WriteFile(filepath.Join(dir, info.name), []byte("*1\r\n$4\r\nping\r\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 不相关的文件不影响检查
+	if err := os.WriteFile(filepath.Join(dir, "other.aof.1.incr.aof"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSegments(dir, "appendonly.aof", m); err != nil {
+		t.Fatal(err)
+	}
+
+	// 清单之外的空文件被删除，非空文件拒绝启动
+	extra := filepath.Join(dir, "appendonly.aof.2.incr.aof")
+	if err := os.WriteFile(extra, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSegments(dir, "appendonly.aof", m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Error("expected empty extra file removed")
+	}
+	if err := os.WriteFile(extra, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSegments(dir, "appendonly.aof", m); err == nil {
+		t.Error("expected extra file error")
+	}
+	if err := os.Remove(extra); err != nil {
+		t.Fatal(err)
+	}
+
+	// 序号更大的 base 文件是没有完成的重写留下的，直接删除，序号更小的拒绝启动
+	unfinished := filepath.Join(dir, "appendonly.aof.2.base.aof")
+	if err := os.WriteFile(unfinished, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSegments(dir, "appendonly.aof", m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(unfinished); !os.IsNotExist(err) {
+		t.Error("expected unfinished base removed")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "appendonly.aof.0.base.aof"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSegments(dir, "appendonly.aof", m); err == nil {
+		t.Error("expected extra file error")
+	}
+}
+
+// 重写把临时文件改名为新的 base 之后、写入清单之前退出，重启时仍然按旧的清单加载
+func TestRewriteCrash(t *testing.T) {
+	old := *config.Properties
+	defer func() {
+		*config.Properties = old
+	}()
+	config.Properties.AppendOnly = true
+	// always 策略下 AddAof 返回时已经写入文件
+	config.Properties.AppendFsync = FsyncAlways
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	handler, err := NewAofHandler(nopDatabase{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("set", "k", "v"))
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.AddAof(0, utils.ToCmdLine("set", "k2", "v2"))
+	if _, err = ctx.tmpFile.Write(makeAofData("select 0", "set k v")); err != nil {
+		t.Fatal(err)
+	}
+	next, err := handler.installRewrite(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟这时退出：不写入清单
+	handler.Close()
+	basePath := filepath.Join(aofDirname(), next.base.name)
+	if _, err = os.Stat(basePath); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &recordDatabase{}
+	handler, err = NewAofHandler(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+	if strings.Join(db.cmdLines, ",") != "select 0,set k v,select 0,set k2 v2" {
+		t.Errorf("unexpected commands %v", db.cmdLines)
+	}
+	if _, err = os.Stat(basePath); !os.IsNotExist(err) {
+		t.Error("expected unfinished base removed")
+	}
+}
+
+// base 文件是 RDB 格式时按 RDB 加载，之后加载 incr 文件
+func TestLoadRdbBase(t *testing.T) {
+	old := *config.Properties
+	defer func() {
+		*config.Properties = old
+	}()
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	dir := aofDirname()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	enc := rdb.NewEncoder(buf)
+	_ = enc.WriteHeader()
+	_ = enc.WriteSelectDB(1)
+	_ = enc.WriteEntry("k", &database.DataEntity{Data: []byte("v")}, nil)
+	_ = enc.WriteEnd()
+	if err := os.WriteFile(filepath.Join(dir, "appendonly.aof.1.base.rdb"), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), makeAofData("set k2 v2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"
+	if err := os.WriteFile(ManifestPath(), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := &recordDatabase{}
+	handler, err := NewAofHandler(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+	if strings.Join(db.cmdLines, ",") != "select 1,set k v,set k2 v2" {
+		t.Errorf("unexpected commands %v", db.cmdLines)
+	}
+}
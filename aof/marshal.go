@@ -0,0 +1,152 @@
+// Package aof -----------------------------
+// @file      : marshal.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/12 10:40
+// -------------------------------------------
+package aof
+
+import (
+	Hash "redis-go/datastruct/hash"
+	List "redis-go/datastruct/list"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
+	"redis-go/lib/utils"
+	"strconv"
+	"time"
+)
+
+// 重写时每条命令最多包含的元素数量，和 Redis 的 AOF_REWRITE_ITEMS_PER_CMD 一样
+// 避免一个很大的 key 生成一条很长的命令
+const rewriteItemsPerCmd = 64
+
+// EntityToCmd 把 key 对应的数据转换成能够恢复它的最少的命令
+func EntityToCmd(key string, entity *database.DataEntity) []CmdLine {
+	switch val := entity.Data.(type) {
+	case []byte:
+		return []CmdLine{utils.ToCmdLine2("set", []byte(key), val)}
+	case List.List:
+		return listToCmd(key, val)
+	case *Hash.Hash:
+		return hashToCmd(key, val)
+	case *set.Set:
+		return setToCmd(key, val)
+	case *SortedSet.SortedSet:
+		return zSetToCmd(key, val)
+	case *stream.Stream:
+		return streamToCmd(key, val)
+	}
+	return nil
+}
+
+// ExpireToCmd 过期时间转换成 PEXPIREAT
+func ExpireToCmd(key string, expireTime time.Time) CmdLine {
+	ms := expireTime.UnixNano() / int64(time.Millisecond)
+	return utils.ToCmdLine("pexpireat", key, strconv.FormatInt(ms, 10))
+}
+
+// batcher 把元素按 rewriteItemsPerCmd 分成多条命令
+type batcher struct {
+	cmdName string
+	key     string
+	// 每个元素的参数个数，如 ZADD 是 score member 两个
+	width   int
+	current CmdLine
+	cmds    []CmdLine
+}
+
+func (b *batcher) add(args ...[]byte) {
+	if b.current == nil {
+		b.current = utils.ToCmdLine(b.cmdName, b.key)
+	}
+	b.current = append(b.current, args...)
+	if (len(b.current)-2)/b.width == rewriteItemsPerCmd {
+		b.cmds = append(b.cmds, b.current)
+		b.current = nil
+	}
+}
+
+func (b *batcher) finish() []CmdLine {
+	if b.current != nil {
+		b.cmds = append(b.cmds, b.current)
+	}
+	return b.cmds
+}
+
+func listToCmd(key string, list List.List) []CmdLine {
+	b := &batcher{cmdName: "rpush", key: key, width: 1}
+	list.ForEach(func(i int, val []byte) bool {
+		b.add(val)
+		return true
+	})
+	return b.finish()
+}
+
+func hashToCmd(key string, hash *Hash.Hash) []CmdLine {
+	b := &batcher{cmdName: "hset", key: key, width: 2}
+	hash.ForEach(func(field string, value []byte) bool {
+		b.add([]byte(field), value)
+		return true
+	})
+	return b.finish()
+}
+
+func setToCmd(key string, s *set.Set) []CmdLine {
+	b := &batcher{cmdName: "sadd", key: key, width: 1}
+	s.ForEach(func(member string) bool {
+		b.add([]byte(member))
+		return true
+	})
+	return b.finish()
+}
+
+func zSetToCmd(key string, zset *SortedSet.SortedSet) []CmdLine {
+	b := &batcher{cmdName: "zadd", key: key, width: 2}
+	if zset.Len() > 0 {
+		zset.ForEachByRank(0, zset.Len(), false, func(element *SortedSet.Element) bool {
+			b.add([]byte(utils.FormatScore(element.Score)), []byte(element.Member))
+			return true
+		})
+	}
+	return b.finish()
+}
+
+// streamToCmd 消息用 XADD 恢复，ID 相关的状态用 XSETID 恢复
+// 消费组用 XGROUP CREATE 恢复，待确认的消息用 XCLAIM ... FORCE JUSTID 恢复，参考 Redis 的 rewriteStreamObject
+func streamToCmd(key string, s *stream.Stream) []CmdLine {
+	cmds := make([]CmdLine, 0)
+	if s.Len() > 0 {
+		s.ForEach(func(entry *stream.Entry) bool {
+			cmd := utils.ToCmdLine("xadd", key, entry.ID.String())
+			cmds = append(cmds, append(cmd, entry.Fields...))
+			return true
+		})
+	} else {
+		// 空的 stream 先添加一条再删掉
+		cmds = append(cmds, utils.ToCmdLine("xadd", key, "maxlen", "0", "0-1", "x", "y"))
+	}
+	cmds = append(cmds, utils.ToCmdLine("xsetid", key, s.LastID().String(),
+		"ENTRIESADDED", strconv.FormatInt(s.EntriesAdded(), 10),
+		"MAXDELETEDID", s.MaxDeletedID().String()))
+
+	for _, group := range s.Groups() {
+		cmds = append(cmds, utils.ToCmdLine("xgroup", "create", key, group.Name, group.LastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10)))
+		for _, consumer := range group.Consumers() {
+			pending := consumer.Pending()
+			if len(pending) == 0 {
+				cmds = append(cmds, utils.ToCmdLine("xgroup", "createconsumer", key, group.Name, consumer.Name))
+				continue
+			}
+			for _, pe := range pending {
+				cmds = append(cmds, utils.ToCmdLine("xclaim", key, group.Name, consumer.Name, "0", pe.ID.String(),
+					"TIME", strconv.FormatInt(pe.DeliveryTime.UnixNano()/int64(time.Millisecond), 10),
+					"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+					"FORCE", "JUSTID", "LASTID", group.LastID.String()))
+			}
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,130 @@
+// Package aof -----------------------------
+// @file      : reader.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/16 10:30
+// -------------------------------------------
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// AOF 文件中的每条命令都是 RESP 数组：*<参数个数>\r\n，然后每个参数是 $<长度>\r\n<内容>\r\n
+// 和 Redis 一样逐条读取并记录偏移，用来区分两种损坏：
+// 进程崩溃时最后一条命令只写了一半，文件在命令中间结束，截断到最后一条完整的命令就能修复
+// 文件中间的内容不符合格式，说明文件已经损坏，不能继续加载
+
+// 单个参数的最大长度，和 Redis 的 proto-max-bulk-len 默认值一样，防止损坏的长度导致分配过多内存
+const maxBulkLen = 512 * 1024 * 1024
+
+// 预分配参数列表的最大长度，更多的参数边读边分配
+const maxPreallocArgs = 1024
+
+// FormatError 文件中的第一条不完整或者格式错误的命令
+type FormatError struct {
+	// 最后一条完整的命令结束的位置，也就是第一条错误的命令开始的位置
+	Offset int64
+	// 为 true 表示文件在命令中间结束，否则是格式错误
+	Truncated bool
+	Reason    string
+}
+
+func (e *FormatError) Error() string {
+	if e.Truncated {
+		return fmt.Sprintf("unexpected end of file at offset %d: %s", e.Offset, e.Reason)
+	}
+	return fmt.Sprintf("bad file format at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Reader 逐条读取 AOF 文件中的命令
+type Reader struct {
+	r *bufio.Reader
+	// 已经读完的完整命令的结束位置
+	offset int64
+	// 当前命令已经读取的字节数
+	pending int64
+}
+
+// NewReader 创建 Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Offset 返回最后一条完整的命令结束的位置
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// ReadCommand 读取下一条命令，文件正好在命令之间结束时返回 io.EOF，否则出错时返回 *FormatError
+func (r *Reader) ReadCommand() (CmdLine, error) {
+	r.pending = 0
+	if _, err := r.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	count, err := r.readHeader('*')
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return nil, r.formatError(fmt.Sprintf("invalid argument count %d", count))
+	}
+	preAlloc := count
+	if preAlloc > maxPreallocArgs {
+		preAlloc = maxPreallocArgs
+	}
+	cmdLine := make(CmdLine, 0, preAlloc)
+	for i := int64(0); i < count; i++ {
+		length, err := r.readHeader('$')
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 || length > maxBulkLen {
+			return nil, r.formatError(fmt.Sprintf("invalid bulk length %d", length))
+		}
+		arg := make([]byte, length+2)
+		n, err := io.ReadFull(r.r, arg)
+		r.pending += int64(n)
+		if err != nil {
+			return nil, r.readError(err)
+		}
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, r.formatError("bulk string is not terminated by CRLF")
+		}
+		cmdLine = append(cmdLine, arg[:length])
+	}
+	r.offset += r.pending
+	return cmdLine, nil
+}
+
+// readHeader 读取 *<n>\r\n 或者 $<n>\r\n
+func (r *Reader) readHeader(prefix byte) (int64, error) {
+	line, err := r.r.ReadBytes('\n')
+	r.pending += int64(len(line))
+	if err != nil {
+		return 0, r.readError(err)
+	}
+	if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, r.formatError(fmt.Sprintf("expected '%c', got %q", prefix, line))
+	}
+	n, err := strconv.ParseInt(string(line[1:len(line)-2]), 10, 64)
+	if err != nil {
+		return 0, r.formatError(fmt.Sprintf("invalid number %q", line[1:len(line)-2]))
+	}
+	return n, nil
+}
+
+func (r *Reader) readError(err error) error {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &FormatError{Offset: r.offset, Truncated: true, Reason: "truncated command"}
+	}
+	return err
+}
+
+func (r *Reader) formatError(reason string) error {
+	return &FormatError{Offset: r.offset, Reason: reason}
+}
@@ -0,0 +1,161 @@
+package aof
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"redis-go/interface/resp"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strings"
+	"testing"
+)
+
+// recordDatabase 记录加载时执行的命令
+type recordDatabase struct {
+	nopDatabase
+	cmdLines []string
+}
+
+func (db *recordDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
+	db.cmdLines = append(db.cmdLines, string(bytes.Join(args, []byte(" "))))
+	return reply.MakeOkReply()
+}
+
+func makeAofData(cmdLines ...string) []byte {
+	buf := &bytes.Buffer{}
+	for _, cmdLine := range cmdLines {
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(strings.Split(cmdLine, " ")...)).ToBytes())
+	}
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	data := makeAofData("select 0", "set k v", "rpush list a b c")
+	reader := NewReader(bytes.NewReader(data))
+	for _, expected := range []string{"select 0", "set k v", "rpush list a b c"} {
+		cmdLine, err := reader.ReadCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(bytes.Join(cmdLine, []byte(" "))); actual != expected {
+			t.Errorf("expected %s, actually %s", expected, actual)
+		}
+	}
+	if _, err := reader.ReadCommand(); err != io.EOF {
+		t.Errorf("expected EOF, actually %v", err)
+	}
+	if reader.Offset() != int64(len(data)) {
+		t.Errorf("expected offset %d, actually %d", len(data), reader.Offset())
+	}
+
+	// 在任意位置截断都能找到最后一条完整的命令
+	ends := []int{len(makeAofData("select 0")), len(makeAofData("select 0", "set k v"))}
+	for i := 1; i < len(data); i++ {
+		reader = NewReader(bytes.NewReader(data[:i]))
+		var err error
+		for err == nil {
+			_, err = reader.ReadCommand()
+		}
+		expectedOffset := 0
+		for _, end := range ends {
+			if end <= i {
+				expectedOffset = end
+			}
+		}
+		if expectedOffset == i {
+			if err != io.EOF {
+				t.Errorf("truncated at %d: expected EOF, actually %v", i, err)
+			}
+			continue
+		}
+		var formatErr *FormatError
+		if !errors.As(err, &formatErr) || !formatErr.Truncated || formatErr.Offset != int64(expectedOffset) {
+			t.Errorf("truncated at %d: expected truncated at %d, actually %v", i, expectedOffset, err)
+		}
+	}
+}
+
+func TestReaderCorrupted(t *testing.T) {
+	for _, corrupted := range []string{
+		"+OK\r\n",
+		"*x\r\n",
+		"*0\r\n",
+		"*1\n$1\r\na\r\n",
+		"*1\r\n:1\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$1\r\nab\r\n",
+		"*1\r\n$1000000000\r\n",
+	} {
+		data := append(makeAofData("set k v"), corrupted...)
+		data = append(data, makeAofData("set k v2")...)
+		reader := NewReader(bytes.NewReader(data))
+		var err error
+		for err == nil {
+			_, err = reader.ReadCommand()
+		}
+		var formatErr *FormatError
+		if !errors.As(err, &formatErr) || formatErr.Truncated || formatErr.Offset != int64(len(makeAofData("set k v"))) {
+			t.Errorf("%q: expected bad format, actually %v", corrupted, err)
+		}
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	old := *config.Properties
+	defer func() {
+		*config.Properties = old
+	}()
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	valid := makeAofData("set k v", "set k2 v2")
+	data := append(valid, "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$2\r\nv"...)
+
+	// 不允许截断时拒绝加载，旧版本的文件已经移到目录中作为 base 文件
+	config.Properties.AofLoadTruncated = "no"
+	if err := os.WriteFile(config.Properties.AppendFilename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAofHandler(&recordDatabase{}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	basePath := filepath.Join(aofDirname(), "appendonly.aof.1.base.aof")
+
+	config.Properties.AofLoadTruncated = "yes"
+	db := &recordDatabase{}
+	handler, err := NewAofHandler(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+	if strings.Join(db.cmdLines, ",") != "set k v,set k2 v2" {
+		t.Errorf("unexpected commands %v", db.cmdLines)
+	}
+	loaded, err := os.ReadFile(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, valid) {
+		t.Errorf("expected file truncated to %d bytes, actually %d", len(valid), len(loaded))
+	}
+
+	// 文件中间损坏时总是拒绝加载
+	data = append(makeAofData("set k v"), "*1\r\n$1\r\nab\r\n"...)
+	data = append(data, makeAofData("set k v2")...)
+	if err = os.WriteFile(basePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewAofHandler(&recordDatabase{}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// 只有最后一个文件允许截断，base 文件末尾不完整时拒绝加载
+	if err = os.WriteFile(basePath, append(valid, "*3\r\n"...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewAofHandler(&recordDatabase{}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,255 @@
+// Package aof -----------------------------
+// @file      : rewrite.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/12 14:05
+// -------------------------------------------
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"redis-go/interface/database"
+	"redis-go/lib/config"
+	"redis-go/lib/logger"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"time"
+)
+
+// AOF 重写，和 Redis 7 一样在后台进行
+// 1. 暂停写入，之后的命令写入一个新的 incr 文件，记下之前的 base 和 incr 文件
+// 2. 把这些文件加载到临时数据库中，遍历临时数据库生成最少的命令写入临时文件
+// 3. 临时文件重命名为新的 base 文件，原子地更新清单，旧的文件变成 history 之后删除
+// 重写期间命令仍然写入 incr 文件，重写失败或者进程退出都不会丢失数据
+
+// ErrRewriteInProgress 已经在重写
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// rewriteCtx 一次重写的上下文
+type rewriteCtx struct {
+	tmpFile *os.File
+	// 开始重写时的 base 和 incr 文件，重写完成后被新的 base 替换
+	files []*aofInfo
+}
+
+// Status AOF 的状态，INFO persistence 展示
+type Status struct {
+	RewriteInProgress  bool
+	Rewrites           int64
+	LastRewriteTime    time.Duration // 没有重写过时为 -1
+	CurrentRewriteTime time.Duration // 没有在重写时为 -1
+	LastRewriteFailed  bool
+	CurrentSize        int64
+	BaseSize           int64
+	FsyncPolicy        string
+	PendingFsync       bool
+	DelayedFsync       int64
+	LastFsyncDuration  time.Duration
+	LastFsyncDelay     time.Duration // 距离上次完成 fsync 的时间
+	LastWriteFailed    bool
+}
+
+// Status 返回 AOF 的状态
+func (handler *AofHandler) Status() *Status {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	status := &Status{
+		RewriteInProgress:  handler.rewriting,
+		Rewrites:           handler.rewrites,
+		LastRewriteTime:    handler.lastRewriteTime,
+		CurrentRewriteTime: -1,
+		LastRewriteFailed:  handler.lastRewriteFailed,
+		CurrentSize:        handler.currentSize,
+		BaseSize:           handler.baseSize,
+		FsyncPolicy:        handler.fsync,
+		PendingFsync:       handler.fsyncDone != nil,
+		DelayedFsync:       handler.delayedFsync,
+		LastFsyncDuration:  handler.lastFsyncDuration,
+		LastFsyncDelay:     time.Since(handler.lastFsync),
+		LastWriteFailed:    handler.writeErr != nil,
+	}
+	if handler.rewriting {
+		status.CurrentRewriteTime = time.Since(handler.rewriteStart)
+	}
+	return status
+}
+
+// needRewrite 文件比上次重写之后增长了 auto-aof-rewrite-percentage 并且不小于 auto-aof-rewrite-min-size 时自动重写
+func (handler *AofHandler) needRewrite() bool {
+	percentage := int64(config.Properties.AutoAofRewritePercentage)
+	if percentage <= 0 {
+		return false
+	}
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	if handler.rewriting || handler.currentSize < int64(config.Properties.AutoAofRewriteMinSize) {
+		return false
+	}
+	base := handler.baseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (handler.currentSize - base) * 100 / base
+	return growth >= percentage
+}
+
+// BackgroundRewrite 开始重写并在后台完成，已经在重写时返回 ErrRewriteInProgress
+// 通过 aofChan 交给 handleAof 开始重写，之前执行的命令都在旧的文件中，之后的命令都在新的 incr 文件中
+func (handler *AofHandler) BackgroundRewrite() error {
+	started := make(chan error, 1)
+	handler.aofChan <- &payload{rewriteStarted: started}
+	return <-started
+}
+
+// backgroundRewrite 在 handleAof 中调用，此时之前的命令都已经写入文件
+func (handler *AofHandler) backgroundRewrite() error {
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		return err
+	}
+	handler.rewriteWg.Add(1)
+	go func() {
+		defer handler.rewriteWg.Done()
+		_ = handler.finishRewrite(ctx, handler.doRewrite(ctx))
+	}()
+	return nil
+}
+
+// startRewrite 暂停写入，切换到新的 incr 文件并创建临时文件
+func (handler *AofHandler) startRewrite() (*rewriteCtx, error) {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	if handler.closed {
+		return nil, errors.New("aof handler is closed")
+	}
+	if handler.rewriting {
+		return nil, ErrRewriteInProgress
+	}
+	// 临时文件和 base 文件在同一个目录下才能原子地替换
+	tmpFile, err := os.CreateTemp(handler.dirname, tempPrefix+"rewriteaof-*"+aofFormatExt)
+	if err != nil {
+		return nil, err
+	}
+	files := handler.manifest.files()
+	incrFile, next, err := handler.createIncr()
+	if err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return nil, err
+	}
+	// 旧的 incr 文件不会再写入，重写时要加载完整的内容
+	if err = handler.aofFile.Sync(); err != nil {
+		logger.Warn(err)
+	}
+	_ = handler.aofFile.Close()
+	handler.aofFile = incrFile
+	handler.manifest = next
+	// 新的文件从 db 0 开始加载
+	handler.currentDB = -1
+	handler.rewriting = true
+	handler.rewriteStart = time.Now()
+	logger.Info("Background append only file rewriting started")
+	return &rewriteCtx{
+		tmpFile: tmpFile,
+		files:   files,
+	}, nil
+}
+
+// doRewrite 加载开始重写时的 base 和 incr 文件，把数据转换成命令写入临时文件
+func (handler *AofHandler) doRewrite(ctx *rewriteCtx) error {
+	tmpDB := handler.tmpDBMaker()
+	defer tmpDB.Close()
+	tmpHandler := &AofHandler{
+		database: tmpDB,
+		dirname:  handler.dirname,
+	}
+	if err := tmpHandler.loadFiles(ctx.files, false); err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(ctx.tmpFile)
+	var err error
+	writeCmd := func(cmdLine CmdLine) {
+		if err == nil {
+			_, err = writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+		}
+	}
+	for i := 0; i < config.Properties.Databases; i++ {
+		selected := false
+		tmpDB.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			// 空的 db 不需要切换
+			if !selected {
+				writeCmd(utils.ToCmdLine("select", strconv.Itoa(i)))
+				selected = true
+			}
+			for _, cmdLine := range EntityToCmd(key, entity) {
+				writeCmd(cmdLine)
+			}
+			if expiration != nil {
+				writeCmd(ExpireToCmd(key, *expiration))
+			}
+			return err == nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// installRewrite 临时文件 fsync 之后改名为新的 base 文件，返回包含新 base 的清单，调用方负责写入清单
+// 改名之后、清单写入之前退出时，新的 base 文件不在清单中并且序号比清单中的大，启动时由 checkSegments 删除
+func (handler *AofHandler) installRewrite(ctx *rewriteCtx) (*manifest, error) {
+	if err := ctx.tmpFile.Sync(); err != nil {
+		return nil, err
+	}
+	if err := ctx.tmpFile.Close(); err != nil {
+		return nil, err
+	}
+	next := handler.manifest.copy()
+	base := next.nextBase(handler.filename, ctx.files)
+	if err := os.Rename(ctx.tmpFile.Name(), handler.path(base.name)); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// finishRewrite 临时文件重命名为新的 base 文件，更新清单之后删除旧的文件
+func (handler *AofHandler) finishRewrite(ctx *rewriteCtx, err error) error {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	defer func() {
+		handler.rewriting = false
+		handler.lastRewriteTime = time.Since(handler.rewriteStart)
+		handler.lastRewriteFailed = err != nil
+		if err != nil {
+			_ = ctx.tmpFile.Close()
+			_ = os.Remove(ctx.tmpFile.Name())
+			logger.Error("Background append only file rewriting failed: " + err.Error())
+		}
+	}()
+	if err == nil && handler.closed {
+		err = errors.New("aof handler is closed")
+	}
+	if err != nil {
+		return err
+	}
+	var next *manifest
+	if next, err = handler.installRewrite(ctx); err != nil {
+		return err
+	}
+	// 清单替换成功才算完成，失败时仍然使用旧的文件
+	if err = writeManifest(handler.dirname, handler.filename, next); err != nil {
+		_ = os.Remove(handler.path(next.base.name))
+		return err
+	}
+	handler.manifest = next
+	handler.deleteHistory()
+	handler.baseSize, handler.currentSize = handler.fileSizes()
+	handler.rewrites++
+	logger.Info("Background AOF rewrite finished successfully")
+	return nil
+}
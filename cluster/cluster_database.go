@@ -80,6 +80,10 @@ func (cluster *ClusterDatabase) Exec(client resp.Connection, args [][]byte) (res
 	}()
 
 	cmdName := strings.ToLower(string(args[0]))
+	// 订阅模式下不能转发其他命令，交给本节点检查，回复和订阅的消息经过同一个队列
+	if client != nil && client.SubsCount() > 0 {
+		return cluster.db.Exec(client, args)
+	}
 	cmdFunc, ok := router[cmdName]
 	if !ok {
 		reply.MakeErrReply(" cluster mode not supported cmd" + cmdName)
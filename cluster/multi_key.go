@@ -0,0 +1,153 @@
+// Package cluster -----------------------------
+// @file      : multi_key.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/23 16:05
+// -------------------------------------------
+package cluster
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// relayMultiKey 涉及多个 key 的指令，所有的 key 都在同一个节点上才能转发执行
+func relayMultiKey(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte, keys []string) resp.Reply {
+	if len(keys) == 0 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	peer := cluster.peerPicker.PickNode(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.peerPicker.PickNode(key) != peer {
+			return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	return cluster.relay(peer, c, cmdArgs)
+}
+
+// allKeysFunc 指令后面的参数都是 key，如 SINTER k1 k2 ...
+func allKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	keys := make([]string, 0, len(cmdArgs)-1)
+	for _, arg := range cmdArgs[1:] {
+		keys = append(keys, string(arg))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// keysExceptLastFunc 除了最后一个参数都是 key，如 BLPOP k1 k2 timeout
+func keysExceptLastFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 3 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	keys := make([]string, 0, len(cmdArgs)-2)
+	for _, arg := range cmdArgs[1 : len(cmdArgs)-1] {
+		keys = append(keys, string(arg))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// twoKeysFunc 前两个参数是 key，如 SMOVE src dest member
+func twoKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 3 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, []string{string(cmdArgs[1]), string(cmdArgs[2])})
+}
+
+// numKeysFunc 第一个参数是 key 的数量，后面跟着 key，如 SINTERCARD numkeys k1 k2 ...
+func numKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 3 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	numKeys, err := strconv.Atoi(string(cmdArgs[1]))
+	if err != nil || numKeys <= 0 || numKeys > len(cmdArgs)-2 {
+		// 交给节点返回具体的错误
+		return cluster.db.Exec(c, cmdArgs)
+	}
+	keys := make([]string, 0, numKeys)
+	for _, arg := range cmdArgs[2 : numKeys+2] {
+		keys = append(keys, string(arg))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// timeoutNumKeysFunc 第一个参数是超时时间，第二个参数是 key 的数量，如 BLMPOP timeout numkeys k1 k2 ...
+func timeoutNumKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 4 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	numKeys, err := strconv.Atoi(string(cmdArgs[2]))
+	if err != nil || numKeys <= 0 || numKeys > len(cmdArgs)-3 {
+		// 交给节点返回具体的错误
+		return cluster.db.Exec(c, cmdArgs)
+	}
+	keys := make([]string, 0, numKeys)
+	for _, arg := range cmdArgs[3 : numKeys+3] {
+		keys = append(keys, string(arg))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// destNumKeysFunc 第一个参数是目标 key，第二个参数是 key 的数量，如 ZUNIONSTORE dest numkeys k1 k2 ...
+func destNumKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 4 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	numKeys, err := strconv.Atoi(string(cmdArgs[2]))
+	if err != nil || numKeys <= 0 || numKeys > len(cmdArgs)-3 {
+		// 交给节点返回具体的错误
+		return cluster.db.Exec(c, cmdArgs)
+	}
+	keys := make([]string, 0, numKeys+1)
+	keys = append(keys, string(cmdArgs[1]))
+	for _, arg := range cmdArgs[3 : numKeys+3] {
+		keys = append(keys, string(arg))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// pairKeysFunc 参数是 key value 对，如 MSET k1 v1 k2 v2 ...
+func pairKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 3 || len(cmdArgs)%2 != 1 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	keys := make([]string, 0, len(cmdArgs)/2)
+	for i := 1; i < len(cmdArgs); i += 2 {
+		keys = append(keys, string(cmdArgs[i]))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// bitOpFunc 第一个参数是运算类型，后面都是 key，如 BITOP AND dest k1 k2 ...
+func bitOpFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 4 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	keys := make([]string, 0, len(cmdArgs)-2)
+	for _, arg := range cmdArgs[2:] {
+		keys = append(keys, string(arg))
+	}
+	return relayMultiKey(cluster, c, cmdArgs, keys)
+}
+
+// streamsKeysFunc STREAMS 后面一半是 key 一半是 ID，如 XREAD COUNT 2 STREAMS k1 k2 id1 id2
+func streamsKeysFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	for i := 1; i < len(cmdArgs); i++ {
+		if strings.ToUpper(string(cmdArgs[i])) != "STREAMS" {
+			continue
+		}
+		rest := cmdArgs[i+1:]
+		if len(rest) == 0 || len(rest)%2 != 0 {
+			break
+		}
+		keys := make([]string, 0, len(rest)/2)
+		for _, arg := range rest[:len(rest)/2] {
+			keys = append(keys, string(arg))
+		}
+		return relayMultiKey(cluster, c, cmdArgs, keys)
+	}
+	// 交给节点返回具体的错误
+	return cluster.db.Exec(c, cmdArgs)
+}
@@ -0,0 +1,58 @@
+// Package cluster -----------------------------
+// @file      : pfcount.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/25 17:20
+// -------------------------------------------
+package cluster
+
+import (
+	"redis-go/datastruct/hyperloglog"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+)
+
+// PFCOUNT k1 k2 ...
+// key 分布在多个节点上时，从各个节点取回 HyperLogLog 的原始字符串，合并之后再计算基数
+func pfCount(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 2 {
+		return reply.MakeArgNumErrReply(string(cmdArgs[0]))
+	}
+	peer := cluster.peerPicker.PickNode(string(cmdArgs[1]))
+	sameNode := true
+	for _, arg := range cmdArgs[2:] {
+		if cluster.peerPicker.PickNode(string(arg)) != peer {
+			sameNode = false
+			break
+		}
+	}
+	// 都在同一个节点上直接转发，可以利用节点上的基数缓存
+	if sameNode {
+		return cluster.relay(peer, c, cmdArgs)
+	}
+
+	hlls := make([][]byte, 0, len(cmdArgs)-1)
+	for _, arg := range cmdArgs[1:] {
+		key := string(arg)
+		result := cluster.relay(cluster.peerPicker.PickNode(key), c, utils.ToCmdLine("get", key))
+		switch r := result.(type) {
+		case *reply.BulkReply:
+			if err := hyperloglog.Validate(r.Arg); err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			hlls = append(hlls, r.Arg)
+		case *reply.NullBulkReply:
+			// 不存在的 key 视为空的 HyperLogLog
+		case reply.ErrorReply:
+			return r
+		default:
+			return &reply.UnknowErrReply{}
+		}
+	}
+	registers, err := hyperloglog.Merge(hlls...)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeIntReply(hyperloglog.CountRegisters(registers))
+}
@@ -0,0 +1,51 @@
+// Package cluster -----------------------------
+// @file      : pubsub.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/8 16:20
+// -------------------------------------------
+package cluster
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+)
+
+// 转发给其他节点的 PUBLISH，收到之后只在本节点发布，避免再次广播
+const relayPublishCmd = "_publish"
+
+// 订阅关系保存在客户端连接的节点上
+func execLocal(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	return cluster.db.Exec(c, cmdArgs)
+}
+
+// PUBLISH channel message
+// 订阅者可能连接在任意节点上，发布到所有节点，返回所有节点收到消息的订阅者的数量之和
+func publish(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	relayArgs := utils.ToCmdLine2(relayPublishCmd, cmdArgs[1:]...)
+	var count int64
+	for _, node := range cluster.nodes {
+		var result resp.Reply
+		if node == cluster.self {
+			result = cluster.db.Exec(c, cmdArgs)
+		} else {
+			result = cluster.relay(node, c, relayArgs)
+		}
+		if reply.IsErrReply(result) {
+			return result
+		}
+		if intReply, ok := result.(*reply.IntReply); ok {
+			count += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(count)
+}
+
+// _publish channel message
+func relayPublish(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	return cluster.db.Exec(c, utils.ToCmdLine2("publish", cmdArgs[1:]...))
+}
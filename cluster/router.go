@@ -18,13 +18,162 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
 	routerMap["getset"] = defaultFunc
+	routerMap["incr"] = defaultFunc
+	routerMap["decr"] = defaultFunc
+	routerMap["incrby"] = defaultFunc
+	routerMap["decrby"] = defaultFunc
+	routerMap["incrbyfloat"] = defaultFunc
+	routerMap["append"] = defaultFunc
+	routerMap["setrange"] = defaultFunc
+	routerMap["getrange"] = defaultFunc
+	routerMap["getdel"] = defaultFunc
+	routerMap["getex"] = defaultFunc
+	routerMap["setbit"] = defaultFunc
+	routerMap["getbit"] = defaultFunc
+	routerMap["bitcount"] = defaultFunc
+	routerMap["bitpos"] = defaultFunc
+	routerMap["bitfield"] = defaultFunc
+	routerMap["bitfield_ro"] = defaultFunc
+	routerMap["pfadd"] = defaultFunc
+	routerMap["strlen"] = defaultFunc
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["expiretime"] = defaultFunc
+	routerMap["pexpiretime"] = defaultFunc
+	routerMap["persist"] = defaultFunc
+	routerMap["lpush"] = defaultFunc
+	routerMap["lpushx"] = defaultFunc
+	routerMap["rpush"] = defaultFunc
+	routerMap["rpushx"] = defaultFunc
+	routerMap["lpop"] = defaultFunc
+	routerMap["rpop"] = defaultFunc
+	routerMap["lrange"] = defaultFunc
+	routerMap["lindex"] = defaultFunc
+	routerMap["lset"] = defaultFunc
+	routerMap["lrem"] = defaultFunc
+	routerMap["linsert"] = defaultFunc
+	routerMap["ltrim"] = defaultFunc
+	routerMap["llen"] = defaultFunc
+	routerMap["hset"] = defaultFunc
+	routerMap["hmset"] = defaultFunc
+	routerMap["hsetnx"] = defaultFunc
+	routerMap["hget"] = defaultFunc
+	routerMap["hmget"] = defaultFunc
+	routerMap["hdel"] = defaultFunc
+	routerMap["hexists"] = defaultFunc
+	routerMap["hlen"] = defaultFunc
+	routerMap["hstrlen"] = defaultFunc
+	routerMap["hgetall"] = defaultFunc
+	routerMap["hkeys"] = defaultFunc
+	routerMap["hvals"] = defaultFunc
+	routerMap["hincrby"] = defaultFunc
+	routerMap["hincrbyfloat"] = defaultFunc
+	routerMap["hrandfield"] = defaultFunc
+	routerMap["hscan"] = defaultFunc
+	routerMap["sadd"] = defaultFunc
+	routerMap["srem"] = defaultFunc
+	routerMap["sismember"] = defaultFunc
+	routerMap["smismember"] = defaultFunc
+	routerMap["smembers"] = defaultFunc
+	routerMap["scard"] = defaultFunc
+	routerMap["spop"] = defaultFunc
+	routerMap["srandmember"] = defaultFunc
+	routerMap["sscan"] = defaultFunc
+	routerMap["zadd"] = defaultFunc
+	routerMap["zincrby"] = defaultFunc
+	routerMap["zscore"] = defaultFunc
+	routerMap["zmscore"] = defaultFunc
+	routerMap["zcard"] = defaultFunc
+	routerMap["zrank"] = defaultFunc
+	routerMap["zrevrank"] = defaultFunc
+	routerMap["zrem"] = defaultFunc
+	routerMap["zcount"] = defaultFunc
+	routerMap["zlexcount"] = defaultFunc
+	routerMap["zrange"] = defaultFunc
+	routerMap["zrevrange"] = defaultFunc
+	routerMap["zrangebyscore"] = defaultFunc
+	routerMap["zrevrangebyscore"] = defaultFunc
+	routerMap["zpopmin"] = defaultFunc
+	routerMap["zpopmax"] = defaultFunc
+	routerMap["zscan"] = defaultFunc
+	routerMap["geoadd"] = defaultFunc
+	routerMap["geopos"] = defaultFunc
+	routerMap["geodist"] = defaultFunc
+	routerMap["geohash"] = defaultFunc
+	routerMap["geosearch"] = defaultFunc
+	routerMap["xadd"] = defaultFunc
+	routerMap["xtrim"] = defaultFunc
+	routerMap["xlen"] = defaultFunc
+	routerMap["xdel"] = defaultFunc
+	routerMap["xrange"] = defaultFunc
+	routerMap["xrevrange"] = defaultFunc
+	routerMap["xsetid"] = defaultFunc
+	routerMap["xack"] = defaultFunc
+	routerMap["xpending"] = defaultFunc
+	routerMap["xclaim"] = defaultFunc
+	routerMap["xautoclaim"] = defaultFunc
+	routerMap["xgroup"] = subCmdKeyFunc
+	routerMap["xinfo"] = subCmdKeyFunc
 	// 特殊模式的指令
 	routerMap["ping"] = ping
 	routerMap["rename"] = Rename
 	routerMap["renamenx"] = Rename
 	routerMap["flushdb"] = flushdb
+	routerMap["flushall"] = flushdb
+	// 订阅只在本节点，发布广播到所有节点
+	routerMap["subscribe"] = execLocal
+	routerMap["unsubscribe"] = execLocal
+	routerMap["psubscribe"] = execLocal
+	routerMap["punsubscribe"] = execLocal
+	routerMap["pubsub"] = execLocal
+	routerMap["publish"] = publish
+	routerMap[relayPublishCmd] = relayPublish
 	routerMap["del"] = Del
 	routerMap["select"] = execSelect
+	routerMap["pfcount"] = pfCount
+	routerMap["scan"] = scan
+	// 服务器命令只在本节点执行
+	routerMap["bgrewriteaof"] = execLocal
+	routerMap["info"] = execLocal
+	routerMap["save"] = execLocal
+	routerMap["bgsave"] = execLocal
+	routerMap["lastsave"] = execLocal
+	// 多 key 的指令，要求 key 都在同一个节点上
+	routerMap["mget"] = allKeysFunc
+	routerMap["mset"] = pairKeysFunc
+	routerMap["msetnx"] = pairKeysFunc
+	routerMap["bitop"] = bitOpFunc
+	routerMap["pfmerge"] = allKeysFunc
+	routerMap["sinter"] = allKeysFunc
+	routerMap["sunion"] = allKeysFunc
+	routerMap["sdiff"] = allKeysFunc
+	routerMap["sinterstore"] = allKeysFunc
+	routerMap["sunionstore"] = allKeysFunc
+	routerMap["sdiffstore"] = allKeysFunc
+	routerMap["smove"] = twoKeysFunc
+	routerMap["sintercard"] = numKeysFunc
+	routerMap["zunion"] = numKeysFunc
+	routerMap["zinter"] = numKeysFunc
+	routerMap["zunionstore"] = destNumKeysFunc
+	routerMap["zinterstore"] = destNumKeysFunc
+	routerMap["xread"] = streamsKeysFunc
+	routerMap["xreadgroup"] = streamsKeysFunc
+	routerMap["lmove"] = twoKeysFunc
+	routerMap["lmpop"] = numKeysFunc
+	routerMap["zmpop"] = numKeysFunc
+	// 阻塞的指令，转发执行时等待的时间受节点间客户端的超时限制
+	routerMap["blpop"] = keysExceptLastFunc
+	routerMap["brpop"] = keysExceptLastFunc
+	routerMap["blmove"] = twoKeysFunc
+	routerMap["blmpop"] = timeoutNumKeysFunc
+	routerMap["bzpopmin"] = keysExceptLastFunc
+	routerMap["bzpopmax"] = keysExceptLastFunc
+	routerMap["bzmpop"] = timeoutNumKeysFunc
+	routerMap["geosearchstore"] = twoKeysFunc
 	return routerMap
 }
 
@@ -37,3 +186,16 @@ func defaultFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte)
 	peer := cluster.peerPicker.PickNode(key)
 	return cluster.relay(peer, c, cmdArgs)
 }
+
+// XGROUP CREATE key ...
+// XINFO STREAM key
+// subCmdKeyFunc 第一个参数是子命令，第二个参数是 key
+func subCmdKeyFunc(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 3 {
+		// 交给节点返回具体的错误
+		return cluster.db.Exec(c, cmdArgs)
+	}
+	key := string(cmdArgs[2])
+	peer := cluster.peerPicker.PickNode(key)
+	return cluster.relay(peer, c, cmdArgs)
+}
@@ -0,0 +1,64 @@
+// Package cluster -----------------------------
+// @file      : scan.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/3 17:20
+// -------------------------------------------
+package cluster
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/resp/reply"
+	"sort"
+	"strconv"
+)
+
+// scan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 集群的游标 = 节点内的游标 * 节点数 + 节点的下标，客户端用同一个循环就可以依次遍历所有的节点
+// 每个节点的 nodes 顺序不同，按地址排序保证任意节点都能解析出相同的节点
+func scan(cluster *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	if len(cmdArgs) < 2 {
+		return reply.MakeArgNumErrReply("scan")
+	}
+	cursor, err := strconv.ParseUint(string(cmdArgs[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	nodes := make([]string, len(cluster.nodes))
+	copy(nodes, cluster.nodes)
+	sort.Strings(nodes)
+	nodeCount := uint64(len(nodes))
+	nodeIndex := cursor % nodeCount
+	localCursor := cursor / nodeCount
+
+	args := make([][]byte, len(cmdArgs))
+	copy(args, cmdArgs)
+	args[1] = []byte(strconv.FormatUint(localCursor, 10))
+	result := cluster.relay(nodes[nodeIndex], c, args)
+	if reply.IsErrReply(result) {
+		return result
+	}
+	// 本节点返回 MultiRawReply，其他节点的回复解析之后也是 MultiRawReply
+	raw, ok := result.(*reply.MultiRawReply)
+	if !ok || len(raw.Replies) != 2 {
+		return reply.MakeErrReply("ERR unexpected scan reply")
+	}
+	cursorReply, ok := raw.Replies[0].(*reply.BulkReply)
+	if !ok {
+		return reply.MakeErrReply("ERR unexpected scan reply")
+	}
+	next, err := strconv.ParseUint(string(cursorReply.Arg), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR unexpected scan reply")
+	}
+	if next == 0 {
+		// 当前节点遍历完了，从下一个节点的开头继续，最后一个节点遍历完时游标回到 0
+		next = (nodeIndex + 1) % nodeCount
+	} else {
+		next = next*nodeCount + nodeIndex
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(next, 10))),
+		raw.Replies[1],
+	})
+}
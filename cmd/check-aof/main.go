@@ -0,0 +1,137 @@
+// Package main -----------------------------
+// @file      : main.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/16 11:20
+// -------------------------------------------
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"redis-go/aof"
+	"redis-go/interface/database"
+	"redis-go/rdb"
+	"strings"
+	"time"
+)
+
+// check-aof 检查 AOF 文件，和 redis-check-aof 一样报告第一条错误的命令的位置
+// 加上 --fix 时把文件截断到最后一条完整的命令，之后的内容都会被丢弃
+// 参数是清单文件时按顺序检查其中的每个文件，只有最后一个文件可以修复
+// RDB 格式的 base 文件只检查不修复
+// 用法：check-aof [--fix] <file.aof|file.manifest>
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last valid command")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--fix] <file.aof|file.manifest>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+	if strings.HasSuffix(filename, ".manifest") {
+		os.Exit(checkManifest(filename, *fix))
+	}
+	os.Exit(check(filename, *fix))
+}
+
+// checkManifest 按清单的顺序检查每个文件，遇到无效的文件时停止
+func checkManifest(manifestPath string, fix bool) int {
+	files, err := aof.ManifestFiles(manifestPath)
+	if err != nil {
+		fmt.Println("Cannot read manifest:", err)
+		return 1
+	}
+	for i, filename := range files {
+		// 前面的文件截断之后后面的命令也不能再加载，所以只修复最后一个文件
+		if code := check(filename, fix && i == len(files)-1); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// checkRdb 检查 RDB 格式的 base 文件
+func checkRdb(filename string, reader io.Reader) int {
+	keys := 0
+	err := rdb.NewDecoder(reader).Decode(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) error {
+		keys++
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("RDB preamble of %s is not valid: %s\n", filename, err)
+		return 1
+	}
+	fmt.Printf("RDB preamble analyzed: filename=%s, keys=%d\n", filename, keys)
+	fmt.Println("RDB preamble is OK")
+	return 0
+}
+
+// check 返回进程的退出码，文件有效或者修复成功时为 0
+func check(filename string, fix bool) int {
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Println("Cannot open file:", err)
+		return 1
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		fmt.Println("Cannot stat file:", err)
+		return 1
+	}
+	size := info.Size()
+	buffered := bufio.NewReader(file)
+	if header, _ := buffered.Peek(5); string(header) == "REDIS" {
+		defer file.Close()
+		return checkRdb(filename, buffered)
+	}
+	reader := aof.NewReader(buffered)
+	commands := 0
+	for {
+		_, err = reader.ReadCommand()
+		if err != nil {
+			break
+		}
+		commands++
+	}
+	_ = file.Close()
+
+	var formatErr *aof.FormatError
+	if err != io.EOF && !errors.As(err, &formatErr) {
+		fmt.Println("Cannot read file:", err)
+		return 1
+	}
+	offset := reader.Offset()
+	if formatErr != nil {
+		fmt.Printf("0x%08x: %s\n", formatErr.Offset, formatErr.Reason)
+	}
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+		filename, size, offset, commands, size-offset)
+	if formatErr == nil {
+		fmt.Println("AOF is valid")
+		return 0
+	}
+	if !fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		return 1
+	}
+	if !formatErr.Truncated {
+		fmt.Printf("Warning: the file is corrupted in the middle, %d bytes after offset %d will be discarded\n", size-offset, offset)
+	}
+	if err = os.Truncate(filename, offset); err != nil {
+		fmt.Println("Failed to truncate AOF:", err)
+		return 1
+	}
+	fmt.Println("Successfully truncated AOF")
+	return 0
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"redis-go/aof"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setAppendOnly 开启 aof 并写入临时目录，返回恢复配置的函数
+func setAppendOnly(t *testing.T) func() {
+	old := *config.Properties
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	return func() {
+		*config.Properties = old
+	}
+}
+
+func TestAofRewrite(t *testing.T) {
+	defer setAppendOnly(t)()
+	database := NewStandaloneDatabase()
+	conn := &connection.Connection{}
+	for i := 0; i < 1000; i++ {
+		database.Exec(conn, utils.ToCmdLine("set", "k", strconv.Itoa(i)))
+	}
+	for i := 0; i < 100; i++ {
+		database.Exec(conn, utils.ToCmdLine("rpush", "list", strconv.Itoa(i)))
+	}
+	database.Exec(conn, utils.ToCmdLine("hset", "hash", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("zadd", "zset", "1.5", "a", "2", "b"))
+	database.Exec(conn, utils.ToCmdLine("sadd", "set", "a", "b", "c"))
+	database.Exec(conn, utils.ToCmdLine("set", "ttl", "v", "ex", "1000"))
+	database.Exec(conn, utils.ToCmdLine("xadd", "stream", "1-1", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("xgroup", "create", "stream", "g", "0"))
+	database.Exec(conn, utils.ToCmdLine("xreadgroup", "group", "g", "c", "streams", "stream", ">"))
+	database.Exec(conn, utils.ToCmdLine("xgroup", "create", "empty", "g", "$", "mkstream"))
+	conn.SelectDB(3)
+	database.Exec(conn, utils.ToCmdLine("set", "db3", "v"))
+	database.Exec(conn, utils.ToCmdLine("del", "db3"))
+	database.Exec(conn, utils.ToCmdLine("set", "db3", "v2"))
+
+	result := database.Exec(conn, utils.ToCmdLine("bgrewriteaof"))
+	if reply.IsErrReply(result) {
+		t.Fatal(string(result.ToBytes()))
+	}
+	// 重写期间的写入追加到新文件
+	database.Exec(conn, utils.ToCmdLine("set", "during", "rewrite"))
+	waitRewrite(t, database)
+	database.Exec(conn, utils.ToCmdLine("set", "after", "rewrite"))
+	database.Close()
+
+	// 重写之后只剩新的 base 文件和重写开始时创建的 incr 文件
+	files, err := aof.ManifestFiles(aof.ManifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !strings.HasSuffix(files[0], ".base.aof") || !strings.HasSuffix(files[1], ".incr.aof") {
+		t.Fatalf("unexpected aof files %v", files)
+	}
+	entries, err := os.ReadDir(filepath.Dir(aof.ManifestPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected history files deleted, actually %d files", len(entries))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 4096 {
+		t.Errorf("expected rewritten file smaller than 4096, actually %d", len(data))
+	}
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	for _, cmdLine := range [][]string{
+		{"get", "k"},
+		{"lrange", "list", "0", "-1"},
+		{"hgetall", "hash"},
+		{"zrange", "zset", "0", "-1", "withscores"},
+		{"smismember", "set", "a", "b", "c", "d"},
+		{"xpending", "stream", "g"},
+		{"xinfo", "groups", "empty"},
+		{"type", "empty"},
+	} {
+		expected := database.Exec(&connection.Connection{}, utils.ToCmdLine(cmdLine...))
+		actual := loaded.Exec(&connection.Connection{}, utils.ToCmdLine(cmdLine...))
+		if !utils.BytesEquals(expected.ToBytes(), actual.ToBytes()) {
+			t.Errorf("%v: expected %q, actually %q", cmdLine, expected.ToBytes(), actual.ToBytes())
+		}
+	}
+	result = loaded.Exec(&connection.Connection{}, utils.ToCmdLine("ttl", "ttl"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl, actually %q", result.ToBytes())
+	}
+	loadedConn := &connection.Connection{}
+	loadedConn.SelectDB(3)
+	for key, value := range map[string]string{"db3": "v2", "during": "rewrite", "after": "rewrite"} {
+		result = loaded.Exec(loadedConn, utils.ToCmdLine("get", key))
+		if string(result.ToBytes()) != string(reply.MakeBulkReply([]byte(value)).ToBytes()) {
+			t.Errorf("expected %s, actually %q", value, result.ToBytes())
+		}
+	}
+}
+
+func TestAutoAofRewrite(t *testing.T) {
+	defer setAppendOnly(t)()
+	config.Properties.AutoAofRewritePercentage = 100
+	config.Properties.AutoAofRewriteMinSize = 1024
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	for i := 0; i < 100; i++ {
+		database.Exec(conn, utils.ToCmdLine("set", "k", strconv.Itoa(i)))
+	}
+	deadline := time.Now().Add(time.Second)
+	for database.aofHandler.Status().Rewrites == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected automatic rewrite")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	result := database.Exec(conn, utils.ToCmdLine("info", "persistence"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || !strings.Contains(string(bulkResult.Arg), "aof_enabled:1") {
+		t.Errorf("expected persistence info, actually %q", result.ToBytes())
+	}
+}
+
+// waitRewrite 等待后台重写完成
+func waitRewrite(t *testing.T, database *StandaloneDatabase) {
+	deadline := time.Now().Add(time.Second)
+	for database.aofHandler.Status().RewriteInProgress {
+		if time.Now().After(deadline) {
+			t.Fatal("rewrite timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if database.aofHandler.Status().LastRewriteFailed {
+		t.Fatal("rewrite failed")
+	}
+}
+
+// always 策略下回复客户端之前命令已经写入文件
+func TestAppendFsyncAlways(t *testing.T) {
+	defer setAppendOnly(t)()
+	config.Properties.AppendFsync = "always"
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	for i := 0; i < 10; i++ {
+		database.Exec(conn, utils.ToCmdLine("set", "k"+strconv.Itoa(i), "v"))
+		files, err := aof.ManifestFiles(aof.ManifestPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(files[len(files)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "k"+strconv.Itoa(i)) {
+			t.Fatalf("expected k%d written before reply", i)
+		}
+	}
+	result := database.Exec(conn, utils.ToCmdLine("info", "persistence"))
+	if !strings.Contains(string(result.ToBytes()), "aof_fsync:always") {
+		t.Errorf("expected fsync policy, actually %q", result.ToBytes())
+	}
+}
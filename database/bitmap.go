@@ -0,0 +1,543 @@
+// Package database -----------------------------
+// @file      : bitmap.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/25 11:20
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"redis-go/datastruct/bitmap"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// 位图最多 512MB，即 2^32 位
+const maxBitOffset = maxStringLen*8 - 1
+
+// parseBitOffset 解析位的偏移量
+func parseBitOffset(arg []byte) (int64, reply.ErrorReply) {
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	return offset, nil
+}
+
+// normalizeBitRange 处理 BITCOUNT BITPOS 的范围，负数表示从末尾开始计算，返回闭区间
+func normalizeBitRange(start, end, size int64) (int64, int64) {
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end
+}
+
+// parseRangeUnit 解析范围的单位，默认为 BYTE
+func parseRangeUnit(args [][]byte) (isBit bool, errReply reply.ErrorReply) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if len(args) > 1 {
+		return false, reply.MakeSyntaxErrReply()
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "BYTE":
+		return false, nil
+	case "BIT":
+		return true, nil
+	}
+	return false, reply.MakeSyntaxErrReply()
+}
+
+// SETBIT k1 offset value
+func execSetBit(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	valStr := string(args[2])
+	if valStr != "0" && valStr != "1" {
+		return reply.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	bm := bitmap.FromBytes(bytes)
+	old := bm.SetBit(offset, valStr[0]-'0')
+	// 扩展之后底层的字节可能变了，需要重新存入
+	db.PutEntity(key, &database.DataEntity{
+		Data: bm.ToBytes(),
+	})
+
+	db.addAof(utils.ToCmdLine2("setbit", args...))
+	return reply.MakeIntReply(int64(old))
+}
+
+// GETBIT k1 offset
+func execGetBit(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeIntReply(int64(bitmap.FromBytes(bytes).GetBit(offset)))
+}
+
+// BITCOUNT k1 [start end [BYTE | BIT]]
+func execBitCount(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if len(args) == 2 || len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	var start, end int64 = 0, -1
+	isBit := false
+	if len(args) >= 3 {
+		var errReply reply.ErrorReply
+		if start, errReply = parseInt64(args[1]); errReply != nil {
+			return errReply
+		}
+		if end, errReply = parseInt64(args[2]); errReply != nil {
+			return errReply
+		}
+		if isBit, errReply = parseRangeUnit(args[3:]); errReply != nil {
+			return errReply
+		}
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(bytes) == 0 {
+		return reply.MakeIntReply(0)
+	}
+	bm := bitmap.FromBytes(bytes)
+	size := int64(len(bytes))
+	if isBit {
+		size = bm.BitSize()
+	}
+	start, end = normalizeBitRange(start, end, size)
+	if start > end {
+		return reply.MakeIntReply(0)
+	}
+	// 统一换算成位的范围
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+	return reply.MakeIntReply(bm.BitCount(start, end))
+}
+
+// BITPOS k1 bit [start [end [BYTE | BIT]]]
+func execBitPos(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	bitStr := string(args[1])
+	if bitStr != "0" && bitStr != "1" {
+		return reply.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	bit := bitStr[0] - '0'
+	if len(args) > 5 {
+		return reply.MakeSyntaxErrReply()
+	}
+	var start, end int64 = 0, -1
+	endGiven, isBit := false, false
+	var errReply reply.ErrorReply
+	if len(args) >= 3 {
+		if start, errReply = parseInt64(args[2]); errReply != nil {
+			return errReply
+		}
+	}
+	if len(args) >= 4 {
+		if end, errReply = parseInt64(args[3]); errReply != nil {
+			return errReply
+		}
+		endGiven = true
+		if isBit, errReply = parseRangeUnit(args[4:]); errReply != nil {
+			return errReply
+		}
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	// 不存在的 key 视为全 0 的空字符串
+	if bytes == nil {
+		if bit == 1 {
+			return reply.MakeIntReply(-1)
+		}
+		return reply.MakeIntReply(0)
+	}
+	bm := bitmap.FromBytes(bytes)
+	size := int64(len(bytes))
+	if isBit {
+		size = bm.BitSize()
+	}
+	start, end = normalizeBitRange(start, end, size)
+	if start > end {
+		return reply.MakeIntReply(-1)
+	}
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+	pos := bm.BitPos(bit, start, end)
+	// 查找 0 并且没有指定结束位置时，字符串右侧视为用 0 填充
+	if pos == -1 && bit == 0 && !endGiven {
+		return reply.MakeIntReply(bm.BitSize())
+	}
+	return reply.MakeIntReply(pos)
+}
+
+// prepareBitOp BITOP op destkey key [key ...]
+func prepareBitOp(args [][]byte) ([]string, []string) {
+	return writeFirstKeyReadRest(args[1:])
+}
+
+// BITOP AND | OR | XOR | NOT destkey key [key ...]
+func execBitOp(db *DB, args [][]byte) resp.Reply {
+	var op int
+	switch strings.ToUpper(string(args[0])) {
+	case "AND":
+		op = bitmap.OpAnd
+	case "OR":
+		op = bitmap.OpOr
+	case "XOR":
+		op = bitmap.OpXor
+	case "NOT":
+		op = bitmap.OpNot
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	dest := string(args[1])
+	keys := args[2:]
+	if op == bitmap.OpNot && len(keys) != 1 {
+		return reply.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+	}
+
+	sources := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		bytes, errReply := db.getAsString(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		sources = append(sources, bytes)
+	}
+	result := bitmap.BitOp(op, sources...)
+	// 结果为空字符串时删除 destkey
+	if len(result) == 0 {
+		db.Remove(dest)
+	} else {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		db.Persist(dest)
+	}
+
+	db.addAof(utils.ToCmdLine2("bitop", args...))
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+// BITFIELD 溢出的处理方式
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// BITFIELD 的子命令类型
+const (
+	bitFieldGet = iota
+	bitFieldSet
+	bitFieldIncrBy
+)
+
+// bitFieldOp BITFIELD 的一个子命令
+type bitFieldOp struct {
+	opType   int
+	signed   bool
+	width    int
+	offset   int64
+	value    int64
+	overflow int
+}
+
+// parseBitFieldType 解析 i8 u16 这样的类型，有符号最多 64 位，无符号最多 63 位
+func parseBitFieldType(arg []byte) (signed bool, width int, errReply reply.ErrorReply) {
+	errReply = reply.MakeErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	s := string(arg)
+	if len(s) < 2 {
+		return false, 0, errReply
+	}
+	switch s[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+		signed = false
+	default:
+		return false, 0, errReply
+	}
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, errReply
+	}
+	return signed, width, nil
+}
+
+// parseBitFieldOffset 解析偏移量，#N 表示第 N 个 width 位的位置
+func parseBitFieldOffset(arg []byte, width int) (int64, reply.ErrorReply) {
+	s := string(arg)
+	multiply := false
+	if strings.HasPrefix(s, "#") {
+		multiply = true
+		s = s[1:]
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	errReply := reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	if err != nil || offset < 0 {
+		return 0, errReply
+	}
+	if multiply {
+		if offset > math.MaxInt64/int64(width) {
+			return 0, errReply
+		}
+		offset *= int64(width)
+	}
+	// 先减再比较，offset 很大时相加会溢出
+	if offset > maxBitOffset-int64(width)+1 {
+		return 0, errReply
+	}
+	return offset, nil
+}
+
+// parseBitFieldOps 解析 BITFIELD 的所有子命令，readonly 时只允许 GET
+func parseBitFieldOps(args [][]byte, readonly bool) ([]*bitFieldOp, reply.ErrorReply) {
+	ops := make([]*bitFieldOp, 0)
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		subCmd := strings.ToUpper(string(args[i]))
+		if readonly && subCmd != "GET" {
+			return nil, reply.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		switch subCmd {
+		case "OVERFLOW":
+			if i+1 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, reply.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+		case "GET", "SET", "INCRBY":
+			argCount := 3
+			if subCmd == "GET" {
+				argCount = 2
+			}
+			if i+argCount > len(args)-1 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			signed, width, errReply := parseBitFieldType(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			offset, errReply := parseBitFieldOffset(args[i+2], width)
+			if errReply != nil {
+				return nil, errReply
+			}
+			op := &bitFieldOp{
+				signed:   signed,
+				width:    width,
+				offset:   offset,
+				overflow: overflow,
+			}
+			switch subCmd {
+			case "GET":
+				op.opType = bitFieldGet
+			case "SET":
+				op.opType = bitFieldSet
+			case "INCRBY":
+				op.opType = bitFieldIncrBy
+			}
+			if op.opType != bitFieldGet {
+				value, errReply := parseInt64(args[i+3])
+				if errReply != nil {
+					return nil, errReply
+				}
+				op.value = value
+			}
+			ops = append(ops, op)
+			i += argCount + 1
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return ops, nil
+}
+
+// readBitField 读取整数，有符号的需要做符号扩展
+func readBitField(bm *bitmap.BitMap, op *bitFieldOp) int64 {
+	value := bm.GetBits(op.offset, op.width)
+	if op.signed && op.width < 64 && value&(1<<(op.width-1)) != 0 {
+		value |= math.MaxUint64 << op.width
+	}
+	return int64(value)
+}
+
+// wrapBitField 只保留低 width 位，有符号的需要做符号扩展
+func wrapBitField(value uint64, signed bool, width int) int64 {
+	if width == 64 {
+		return int64(value)
+	}
+	mask := uint64(math.MaxUint64) << width
+	if signed && value&(1<<(width-1)) != 0 {
+		return int64(value | mask)
+	}
+	return int64(value &^ mask)
+}
+
+// checkBitFieldOverflow 计算 value + incr，参考 Redis 的 checkUnsignedBitfieldOverflow 和 checkSignedBitfieldOverflow
+// 返回计算的结果以及是否溢出，FAIL 模式下溢出的结果没有意义
+func checkBitFieldOverflow(value int64, incr int64, signed bool, width int, overflow int) (int64, bool) {
+	if !signed {
+		max := uint64(1)<<width - 1
+		uValue := uint64(value)
+		maxIncr := max - uValue
+		if uValue > max || (incr > 0 && uint64(incr) > maxIncr) {
+			if overflow == overflowSat {
+				return int64(max), true
+			}
+			return wrapBitField(uValue+uint64(incr), false, width), true
+		} else if incr < 0 && uint64(-incr) > uValue {
+			// incr 为 MinInt64 时 -incr 仍然是它自己，转换成 uint64 正好是 2^63
+			if overflow == overflowSat {
+				return 0, true
+			}
+			return wrapBitField(uValue+uint64(incr), false, width), true
+		}
+		return int64(uValue + uint64(incr)), false
+	}
+
+	var max int64 = math.MaxInt64
+	if width < 64 {
+		max = int64(1)<<(width-1) - 1
+	}
+	min := -max - 1
+	maxIncr := max - value
+	minIncr := min - value
+	if value > max || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		if overflow == overflowSat {
+			return max, true
+		}
+		return wrapBitField(uint64(value)+uint64(incr), true, width), true
+	} else if value < min || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		if overflow == overflowSat {
+			return min, true
+		}
+		return wrapBitField(uint64(value)+uint64(incr), true, width), true
+	}
+	return value + incr, false
+}
+
+// bitFieldGeneric BITFIELD BITFIELD_RO 的公共逻辑
+func bitFieldGeneric(db *DB, args [][]byte, readonly bool) resp.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:], readonly)
+	if errReply != nil {
+		return errReply
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	bm := bitmap.FromBytes(bytes)
+	results := make([]resp.Reply, 0, len(ops))
+	modified := false
+	for _, op := range ops {
+		if op.opType == bitFieldGet {
+			results = append(results, reply.MakeIntReply(readBitField(bm, op)))
+			continue
+		}
+		old := readBitField(bm, op)
+		var newValue int64
+		var overflowed bool
+		if op.opType == bitFieldSet {
+			newValue, overflowed = checkBitFieldOverflow(op.value, 0, op.signed, op.width, op.overflow)
+		} else {
+			newValue, overflowed = checkBitFieldOverflow(old, op.value, op.signed, op.width, op.overflow)
+		}
+		// FAIL 模式下溢出的操作不执行，返回 nil
+		if overflowed && op.overflow == overflowFail {
+			results = append(results, reply.MakeNullBulkReply())
+			continue
+		}
+		bm.SetBits(op.offset, op.width, uint64(newValue))
+		modified = true
+		if op.opType == bitFieldSet {
+			results = append(results, reply.MakeIntReply(old))
+		} else {
+			results = append(results, reply.MakeIntReply(newValue))
+		}
+	}
+
+	if modified {
+		db.PutEntity(key, &database.DataEntity{
+			Data: bm.ToBytes(),
+		})
+		db.addAof(utils.ToCmdLine2("bitfield", args...))
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+// BITFIELD k1 [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP | SAT | FAIL] ...
+func execBitField(db *DB, args [][]byte) resp.Reply {
+	return bitFieldGeneric(db, args, false)
+}
+
+// BITFIELD_RO k1 [GET type offset ...]
+func execBitFieldRO(db *DB, args [][]byte) resp.Reply {
+	return bitFieldGeneric(db, args, true)
+}
+
+func init() {
+	RegisterCommand("SetBit", execSetBit, writeFirstKey, 4)
+	RegisterCommand("GetBit", execGetBit, readFirstKey, 3)
+	// BITCOUNT k1 [start end [BYTE | BIT]]
+	RegisterCommand("BitCount", execBitCount, readFirstKey, -2)
+	// BITPOS k1 bit [start [end [BYTE | BIT]]]
+	RegisterCommand("BitPos", execBitPos, readFirstKey, -3)
+	// BITOP AND | OR | XOR | NOT destkey key [key ...]
+	RegisterCommand("BitOp", execBitOp, prepareBitOp, -4)
+	RegisterCommand("BitField", execBitField, writeFirstKey, -2)
+	RegisterCommand("BitField_RO", execBitFieldRO, readFirstKey, -2)
+}
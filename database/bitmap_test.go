@@ -0,0 +1,205 @@
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+)
+
+func TestBitCommands(t *testing.T) {
+	db := makeDB()
+	result := db.Exec(nil, utils.ToCmdLine("setbit", "k", "7", "1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("getbit", "k", "7"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("get", "k"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "\x01" {
+		t.Errorf("unexpected value %q", result.ToBytes())
+	}
+	result = db.Exec(nil, utils.ToCmdLine("setbit", "k", "7", "2"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+
+	db.Exec(nil, utils.ToCmdLine("set", "k", "foobar"))
+	for _, c := range []struct {
+		cmdLine  []string
+		expected int64
+	}{
+		{[]string{"bitcount", "k"}, 26},
+		{[]string{"bitcount", "k", "1", "1"}, 6},
+		{[]string{"bitcount", "k", "5", "30", "bit"}, 17},
+		{[]string{"bitcount", "none"}, 0},
+	} {
+		result = db.Exec(nil, utils.ToCmdLine(c.cmdLine...))
+		if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != c.expected {
+			t.Errorf("%v: expected %d, actually %s", c.cmdLine, c.expected, string(result.ToBytes()))
+		}
+	}
+
+	for _, c := range []struct {
+		value    string
+		args     []string
+		expected int64
+	}{
+		{"\xff\xf0\x00", []string{"0"}, 12},
+		{"\x00\xff\xf0", []string{"1", "0"}, 8},
+		{"\x00\xff\xf0", []string{"1", "2"}, 16},
+		{"\x00\xff\xf0", []string{"1", "7", "15", "bit"}, 8},
+		{"\x00\x00\x00", []string{"1"}, -1},
+		// 没有指定 end 时查找 0 可以超出字符串的末尾
+		{"\xff\xff\xff", []string{"0"}, 24},
+		{"\xff\xff\xff", []string{"0", "0", "-1"}, -1},
+	} {
+		db.Exec(nil, utils.ToCmdLine("set", "k", c.value))
+		result = db.Exec(nil, utils.ToCmdLine(append([]string{"bitpos", "k"}, c.args...)...))
+		if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != c.expected {
+			t.Errorf("bitpos %q %v: expected %d, actually %s", c.value, c.args, c.expected, string(result.ToBytes()))
+		}
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "a", "foobar"))
+	db.Exec(nil, utils.ToCmdLine("set", "b", "abcdef"))
+	db.Exec(nil, utils.ToCmdLine("set", "c", "ab"))
+	for _, c := range []struct {
+		cmdLine  []string
+		expected string
+	}{
+		{[]string{"bitop", "and", "dest", "a", "b"}, "`bc`ab"},
+		{[]string{"bitop", "or", "dest", "a", "b"}, "goofev"},
+		{[]string{"bitop", "xor", "dest", "a", "b"}, "\x07\r\x0c\x06\x04\x14"},
+		{[]string{"bitop", "not", "dest", "a"}, "\x99\x90\x90\x9d\x9e\x8d"},
+		// 较短的字符串用 0 补齐
+		{[]string{"bitop", "or", "dest", "c", "a"}, "goobar"},
+	} {
+		result := db.Exec(nil, utils.ToCmdLine(c.cmdLine...))
+		if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != int64(len(c.expected)) {
+			t.Errorf("%v: expected %d, actually %s", c.cmdLine, len(c.expected), string(result.ToBytes()))
+		}
+		result = db.Exec(nil, utils.ToCmdLine("get", "dest"))
+		if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != c.expected {
+			t.Errorf("%v: expected %q, actually %q", c.cmdLine, c.expected, result.ToBytes())
+		}
+	}
+	result := db.Exec(nil, utils.ToCmdLine("bitop", "not", "dest", "a", "b"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+	// 所有的源 key 都不存在时删除目标 key
+	result = db.Exec(nil, utils.ToCmdLine("bitop", "and", "dest", "none"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("exists", "dest"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected dest deleted, actually %s", string(result.ToBytes()))
+	}
+}
+
+// bitFieldReply 构造 BITFIELD 的回复，nil 表示 FAIL 模式下溢出
+func bitFieldReply(values ...*int64) []byte {
+	replies := make([]resp.Reply, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			replies = append(replies, reply.MakeNullBulkReply())
+		} else {
+			replies = append(replies, reply.MakeIntReply(*value))
+		}
+	}
+	return reply.MakeMultiRawReply(replies).ToBytes()
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+// 和 Redis 一样检查上溢和下溢，无符号的字段不会返回负数
+func TestBitFieldOverflow(t *testing.T) {
+	db := makeDB()
+	const maxU63 = int64(1<<63 - 1)
+	const maxI64 = int64(1<<63 - 1)
+	const minI64 = -maxI64 - 1
+	for _, c := range []struct {
+		typ      string
+		start    int64
+		incr     int64
+		overflow string
+		expected *int64 // nil 表示返回 nil 并且值不变
+	}{
+		{"u8", 0, -1, "wrap", int64Ptr(255)},
+		{"u8", 0, -1, "sat", int64Ptr(0)},
+		{"u8", 0, -1, "fail", nil},
+		{"u8", 10, -300, "wrap", int64Ptr(222)},
+		{"u8", 255, 1, "wrap", int64Ptr(0)},
+		{"u8", 255, 1, "sat", int64Ptr(255)},
+		{"u8", 255, 1, "fail", nil},
+		{"u8", 10, -10, "fail", int64Ptr(0)},
+		{"i8", -128, -1, "wrap", int64Ptr(127)},
+		{"i8", -128, -1, "sat", int64Ptr(-128)},
+		{"i8", -128, -1, "fail", nil},
+		{"i8", 127, 1, "wrap", int64Ptr(-128)},
+		{"i8", 127, 1, "sat", int64Ptr(127)},
+		{"i8", 127, 1, "fail", nil},
+		{"i8", 100, -200, "fail", int64Ptr(-100)},
+		{"u63", 0, -1, "wrap", int64Ptr(maxU63)},
+		{"u63", 0, -1, "sat", int64Ptr(0)},
+		{"u63", 0, -1, "fail", nil},
+		{"u63", 0, minI64, "sat", int64Ptr(0)},
+		{"u63", maxU63, 1, "wrap", int64Ptr(0)},
+		{"u63", maxU63, 1, "sat", int64Ptr(maxU63)},
+		{"u63", maxU63, 1, "fail", nil},
+		{"i64", minI64, -1, "wrap", int64Ptr(maxI64)},
+		{"i64", minI64, -1, "sat", int64Ptr(minI64)},
+		{"i64", minI64, -1, "fail", nil},
+		{"i64", maxI64, 1, "wrap", int64Ptr(minI64)},
+		{"i64", maxI64, 1, "sat", int64Ptr(maxI64)},
+		{"i64", maxI64, 1, "fail", nil},
+		{"i64", -1, minI64 + 1, "fail", int64Ptr(minI64)},
+	} {
+		db.Exec(nil, utils.ToCmdLine("del", "k"))
+		db.Exec(nil, utils.ToCmdLine("bitfield", "k", "set", c.typ, "0", strconv.FormatInt(c.start, 10)))
+		result := db.Exec(nil, utils.ToCmdLine("bitfield", "k", "overflow", c.overflow,
+			"incrby", c.typ, "0", strconv.FormatInt(c.incr, 10)))
+		if !utils.BytesEquals(result.ToBytes(), bitFieldReply(c.expected)) {
+			t.Errorf("%s %d incrby %d %s: unexpected reply %q", c.typ, c.start, c.incr, c.overflow, result.ToBytes())
+		}
+		stored := c.start
+		if c.expected != nil {
+			stored = *c.expected
+		}
+		result = db.Exec(nil, utils.ToCmdLine("bitfield_ro", "k", "get", c.typ, "0"))
+		if !utils.BytesEquals(result.ToBytes(), bitFieldReply(&stored)) {
+			t.Errorf("%s %d incrby %d %s: expected %d stored, actually %q", c.typ, c.start, c.incr, c.overflow, stored, result.ToBytes())
+		}
+	}
+
+	// SET 的值超出范围时同样按照 OVERFLOW 处理，返回旧值
+	db.Exec(nil, utils.ToCmdLine("del", "k"))
+	result := db.Exec(nil, utils.ToCmdLine("bitfield", "k", "overflow", "sat", "set", "u8", "0", "300",
+		"get", "u8", "0", "overflow", "fail", "set", "i8", "#1", "200", "get", "i8", "8"))
+	if !utils.BytesEquals(result.ToBytes(), bitFieldReply(int64Ptr(0), int64Ptr(255), nil, int64Ptr(0))) {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = db.Exec(nil, utils.ToCmdLine("bitfield_ro", "k", "set", "u8", "0", "1"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+	// 很大的偏移量不会溢出
+	offsetErr := reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	execCases(t, db, []cmdCase{
+		{"bitfield k get u8 9223372036854775807", offsetErr},
+		{"bitfield k set u8 9223372036854775807 1", offsetErr},
+		{"bitfield k incrby u8 9223372036854775807 1", offsetErr},
+		{"bitfield_ro k get i64 9223372036854775807", offsetErr},
+		{"bitfield k get u8 4294967289", offsetErr},
+	})
+}
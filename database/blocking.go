@@ -0,0 +1,221 @@
+// Package database -----------------------------
+// @file      : blocking.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/10 15:20
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"redis-go/interface/resp"
+	"redis-go/resp/reply"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 阻塞命令，参考 Redis 的 blocked.c
+// 没有数据时客户端在 key 上排队等待，写命令执行之后唤醒等待这些 key 的客户端
+// 会消费数据的客户端（BLPOP、XREADGROUP 等）按照阻塞的先后顺序每次唤醒一个，处理完之后由它的写命令唤醒下一个
+// 只读的客户端（XREAD）全部唤醒
+
+// blockedClient 一个正在阻塞的客户端
+type blockedClient struct {
+	c       resp.Connection
+	keys    []string
+	consume bool
+	// 等待的 key 被修改了
+	ready chan struct{}
+	// 客户端断开
+	canceled chan struct{}
+}
+
+// blockingKeys 记录每个 key 上等待的客户端
+type blockingKeys struct {
+	mu sync.Mutex
+	// key → 按照阻塞先后排列的客户端
+	keys map[string][]*blockedClient
+	// 连接 → 阻塞的客户端，用于客户端断开时取消阻塞
+	clients map[resp.Connection]*blockedClient
+	// 阻塞的客户端的数量，为 0 时写命令不需要加锁检查
+	count int32
+}
+
+func makeBlockingKeys() *blockingKeys {
+	return &blockingKeys{
+		keys:    make(map[string][]*blockedClient),
+		clients: make(map[resp.Connection]*blockedClient),
+	}
+}
+
+func (b *blockingKeys) add(bc *blockedClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range bc.keys {
+		b.keys[key] = append(b.keys[key], bc)
+	}
+	// 用于 aof 重放和测试的连接为 nil，不会断开
+	if bc.c != nil {
+		b.clients[bc.c] = bc
+	}
+	atomic.AddInt32(&b.count, 1)
+}
+
+func (b *blockingKeys) remove(bc *blockedClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range bc.keys {
+		clients := b.keys[key]
+		for i, other := range clients {
+			if other == bc {
+				clients = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(b.keys, key)
+		} else {
+			b.keys[key] = clients
+		}
+	}
+	if bc.c != nil && b.clients[bc.c] == bc {
+		delete(b.clients, bc.c)
+	}
+	atomic.AddInt32(&b.count, -1)
+}
+
+// signal 唤醒等待 key 的第一个消费数据的客户端和所有只读的客户端
+func (b *blockingKeys) signal(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	consumerSignaled := false
+	for _, bc := range b.keys[key] {
+		if bc.consume {
+			if consumerSignaled {
+				continue
+			}
+			consumerSignaled = true
+		}
+		// 已经有未处理的唤醒时不需要重复唤醒
+		select {
+		case bc.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// cancel 客户端断开，取消它的阻塞
+func (b *blockingKeys) cancel(c resp.Connection) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bc, ok := b.clients[c]
+	if !ok {
+		return
+	}
+	delete(b.clients, c)
+	close(bc.canceled)
+}
+
+// signalKeys 写命令执行之后唤醒等待这些 key 的客户端
+func (db *DB) signalKeys(keys ...string) {
+	if atomic.LoadInt32(&db.blocking.count) == 0 {
+		return
+	}
+	for _, key := range keys {
+		db.blocking.signal(key)
+	}
+}
+
+// unblockClient 客户端断开时取消阻塞
+func (db *DB) unblockClient(c resp.Connection) {
+	if atomic.LoadInt32(&db.blocking.count) == 0 {
+		return
+	}
+	db.blocking.cancel(c)
+}
+
+// blockingWait 阻塞命令等待的参数
+type blockingWait struct {
+	// 等待的 key
+	keys []string
+	// 命令持有锁的 key，等待期间释放
+	writeKeys []string
+	readKeys  []string
+	// 0 表示一直等待
+	timeout time.Duration
+	// 是否会消费数据，决定唤醒的方式
+	consume bool
+}
+
+// block 等待直到 try 返回结果，超时或者客户端断开时返回 nil
+// 调用方需要持有 db 的读锁和 key 的锁，等待期间释放，否则写命令和 EXEC 都无法执行
+// 和 Redis 一样，事务中的阻塞命令不会阻塞
+func (db *DB) block(c resp.Connection, wait *blockingWait, try func() resp.Reply) resp.Reply {
+	if result := try(); result != nil {
+		return result
+	}
+	if db.inMulti {
+		return nil
+	}
+	bc := &blockedClient{
+		c:        c,
+		keys:     wait.keys,
+		consume:  wait.consume,
+		ready:    make(chan struct{}, 1),
+		canceled: make(chan struct{}),
+	}
+	// 先在 key 上排队再检查连接，客户端在这之后断开一定能取消阻塞
+	db.blocking.add(bc)
+	defer db.blocking.remove(bc)
+	if c != nil {
+		if !c.Block() {
+			return nil
+		}
+		defer c.Unblock()
+	}
+	var timeout <-chan time.Time
+	if wait.timeout > 0 {
+		timer := time.NewTimer(wait.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		db.locker.RWUnLocks(wait.writeKeys, wait.readKeys)
+		db.mu.RUnlock()
+		canceled := false
+		select {
+		case <-bc.ready:
+		case <-timeout:
+			canceled = true
+		case <-bc.canceled:
+			canceled = true
+		}
+		db.mu.RLock()
+		db.locker.RWLocks(wait.writeKeys, wait.readKeys)
+		// 等待期间可能开始了快照
+		db.preserve(wait.writeKeys...)
+		if canceled {
+			return nil
+		}
+		if result := try(); result != nil {
+			return result
+		}
+	}
+}
+
+// parseBlockTimeout 解析以秒为单位的超时时间，可以是小数，0 表示一直等待
+func parseBlockTimeout(arg []byte) (time.Duration, reply.ErrorReply) {
+	timeout, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, reply.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, reply.MakeErrReply("ERR timeout is negative")
+	}
+	if timeout*float64(time.Second) > math.MaxInt64 {
+		return 0, reply.MakeErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"redis-go/resp/reply"
+	"testing"
+	"time"
+)
+
+// execAsync 在另一个协程中执行阻塞命令
+func execAsync(database *StandaloneDatabase, c resp.Connection, args ...string) <-chan string {
+	done := make(chan string, 1)
+	go func() {
+		done <- string(database.Exec(c, utils.ToCmdLine(args...)).ToBytes())
+	}()
+	// 等待客户端阻塞
+	time.Sleep(30 * time.Millisecond)
+	return done
+}
+
+func TestBLPop(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+
+	database.Exec(conn, utils.ToCmdLine("rpush", "l2", "a"))
+	result := database.Exec(conn, utils.ToCmdLine("blpop", "l1", "l2", "0"))
+	if string(result.ToBytes()) != "*2\r\n$2\r\nl2\r\n$1\r\na\r\n" {
+		t.Errorf("expected [l2 a], actually %q", result.ToBytes())
+	}
+
+	// 超时返回 nil
+	start := time.Now()
+	result = database.Exec(conn, utils.ToCmdLine("brpop", "l1", "0.05"))
+	if string(result.ToBytes()) != "*-1\r\n" || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected nil after timeout, actually %q", result.ToBytes())
+	}
+
+	// 写入之后唤醒
+	done := execAsync(database, &connection.Connection{}, "brpop", "l1", "1")
+	database.Exec(conn, utils.ToCmdLine("lpush", "l1", "x"))
+	if result := <-done; result != "*2\r\n$2\r\nl1\r\n$1\r\nx\r\n" {
+		t.Errorf("expected [l1 x], actually %q", result)
+	}
+	result = database.Exec(conn, utils.ToCmdLine("exists", "l1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected empty list removed, actually %q", result.ToBytes())
+	}
+
+	database.Exec(conn, utils.ToCmdLine("set", "s", "v"))
+	result = database.Exec(conn, utils.ToCmdLine("blpop", "s", "0"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected wrong type error, actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("blpop", "l1", "-1"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected negative timeout error, actually %q", result.ToBytes())
+	}
+}
+
+// 先阻塞的客户端先得到数据
+func TestBlockingFIFO(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+
+	first := execAsync(database, &connection.Connection{}, "blpop", "l", "1")
+	second := execAsync(database, &connection.Connection{}, "blpop", "l", "1")
+	database.Exec(conn, utils.ToCmdLine("rpush", "l", "a"))
+	if result := <-first; result != "*2\r\n$1\r\nl\r\n$1\r\na\r\n" {
+		t.Errorf("expected first client gets a, actually %q", result)
+	}
+	select {
+	case result := <-second:
+		t.Fatalf("expected second client still blocked, actually %q", result)
+	case <-time.After(30 * time.Millisecond):
+	}
+	database.Exec(conn, utils.ToCmdLine("rpush", "l", "b"))
+	if result := <-second; result != "*2\r\n$1\r\nl\r\n$1\r\nb\r\n" {
+		t.Errorf("expected second client gets b, actually %q", result)
+	}
+}
+
+func TestBlockingInMulti(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("blpop", "l", "0"))
+	database.Exec(conn, utils.ToCmdLine("bzpopmin", "z", "0"))
+	database.Exec(conn, utils.ToCmdLine("blmove", "l", "l2", "left", "right", "0"))
+	// 事务中的阻塞命令立即返回
+	result := database.Exec(conn, utils.ToCmdLine("exec"))
+	if string(result.ToBytes()) != "*3\r\n*-1\r\n*-1\r\n$-1\r\n" {
+		t.Errorf("expected nil replies, actually %q", result.ToBytes())
+	}
+}
+
+// 客户端断开时取消阻塞
+func TestBlockingClientClose(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	client := &connection.Connection{}
+	done := execAsync(database, client, "blpop", "l", "0")
+	if !client.MarkClosing() {
+		t.Fatal("expected client blocked")
+	}
+	database.AfterClientClose(client)
+	select {
+	case result := <-done:
+		if result != "*-1\r\n" {
+			t.Errorf("expected nil, actually %q", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected client unblocked")
+	}
+	// 断开之后写入的数据不会被取走
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("rpush", "l", "a"))
+	result := database.Exec(conn, utils.ToCmdLine("llen", "l"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %q", result.ToBytes())
+	}
+}
+
+func TestLMove(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+
+	database.Exec(conn, utils.ToCmdLine("rpush", "src", "a", "b"))
+	result := database.Exec(conn, utils.ToCmdLine("lmove", "src", "dest", "right", "left"))
+	if string(result.ToBytes()) != "$1\r\nb\r\n" {
+		t.Errorf("expected b, actually %q", result.ToBytes())
+	}
+	// 同一个列表时旋转
+	database.Exec(conn, utils.ToCmdLine("rpush", "dest", "c"))
+	database.Exec(conn, utils.ToCmdLine("lmove", "dest", "dest", "left", "right"))
+	result = database.Exec(conn, utils.ToCmdLine("lrange", "dest", "0", "-1"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\nc\r\n$1\r\nb\r\n" {
+		t.Errorf("expected [c b], actually %q", result.ToBytes())
+	}
+
+	done := execAsync(database, &connection.Connection{}, "blmove", "empty", "dest", "left", "left", "1")
+	database.Exec(conn, utils.ToCmdLine("rpush", "empty", "x"))
+	if result := <-done; result != "$1\r\nx\r\n" {
+		t.Errorf("expected x, actually %q", result)
+	}
+	result = database.Exec(conn, utils.ToCmdLine("lindex", "dest", "0"))
+	if string(result.ToBytes()) != "$1\r\nx\r\n" {
+		t.Errorf("expected x, actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("blmove", "empty", "dest", "left", "left", "0.01"))
+	if string(result.ToBytes()) != "$-1\r\n" {
+		t.Errorf("expected nil, actually %q", result.ToBytes())
+	}
+}
+
+func TestMPop(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+
+	database.Exec(conn, utils.ToCmdLine("rpush", "l2", "a", "b", "c"))
+	result := database.Exec(conn, utils.ToCmdLine("lmpop", "2", "l1", "l2", "right", "count", "2"))
+	if string(result.ToBytes()) != "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\nc\r\n$1\r\nb\r\n" {
+		t.Errorf("expected [l2 [c b]], actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("lmpop", "1", "l1", "left"))
+	if string(result.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expected nil, actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("lmpop", "0", "l1", "left"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected numkeys error, actually %q", result.ToBytes())
+	}
+
+	database.Exec(conn, utils.ToCmdLine("zadd", "z", "1", "a", "2", "b", "3", "c"))
+	result = database.Exec(conn, utils.ToCmdLine("zmpop", "1", "z", "max", "count", "2"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\nz\r\n*2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("expected [z [[c 3] [b 2]]], actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("bzmpop", "0", "1", "z", "min"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\nz\r\n*1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n" {
+		t.Errorf("expected [z [[a 1]]], actually %q", result.ToBytes())
+	}
+
+	done := execAsync(database, &connection.Connection{}, "blmpop", "1", "2", "l1", "l3", "left", "count", "5")
+	database.Exec(conn, utils.ToCmdLine("rpush", "l3", "x", "y"))
+	if result := <-done; result != "*2\r\n$2\r\nl3\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n" {
+		t.Errorf("expected [l3 [x y]], actually %q", result)
+	}
+}
+
+func TestBZPop(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+
+	done := execAsync(database, &connection.Connection{}, "bzpopmax", "z1", "z2", "1")
+	database.Exec(conn, utils.ToCmdLine("zadd", "z2", "1", "a", "2", "b"))
+	if result := <-done; result != "*3\r\n$2\r\nz2\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("expected [z2 b 2], actually %q", result)
+	}
+	result := database.Exec(conn, utils.ToCmdLine("bzpopmin", "z1", "z2", "0"))
+	if string(result.ToBytes()) != "*3\r\n$2\r\nz2\r\n$1\r\na\r\n$1\r\n1\r\n" {
+		t.Errorf("expected [z2 a 1], actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("bzpopmin", "z2", "0.01"))
+	if string(result.ToBytes()) != "*-1\r\n" {
+		t.Errorf("expected nil, actually %q", result.ToBytes())
+	}
+}
+
+// 阻塞命令以非阻塞的形式写入 aof，重放之后数据一致
+func TestBlockingAof(t *testing.T) {
+	db := makeDB()
+	cmdLines := make([]CmdLine, 0)
+	db.addAof = func(line CmdLine) {
+		cmdLines = append(cmdLines, line)
+	}
+	for _, cmdLine := range [][]string{
+		{"rpush", "l", "a", "b", "c", "d"},
+		{"blpop", "l", "0"},
+		{"blmove", "l", "l2", "right", "left", "0"},
+		{"blmpop", "0", "1", "l", "left", "count", "5"},
+		{"zadd", "z", "1", "a", "2", "b", "3", "c"},
+		{"bzpopmax", "z", "0"},
+		{"bzmpop", "0", "1", "z", "min"},
+	} {
+		result := db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		if reply.IsErrReply(result) {
+			t.Fatalf("%v: %s", cmdLine, string(result.ToBytes()))
+		}
+	}
+	replayed := makeDB()
+	for _, cmdLine := range cmdLines {
+		result := replayed.Exec(nil, cmdLine)
+		if reply.IsErrReply(result) {
+			t.Fatalf("replay %q: %s", cmdLine, string(result.ToBytes()))
+		}
+	}
+	for _, cmdLine := range [][]string{
+		{"lrange", "l", "0", "-1"},
+		{"lrange", "l2", "0", "-1"},
+		{"zrange", "z", "0", "-1", "withscores"},
+	} {
+		expected := db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		actual := replayed.Exec(nil, utils.ToCmdLine(cmdLine...))
+		if !utils.BytesEquals(expected.ToBytes(), actual.ToBytes()) {
+			t.Errorf("%v: expected %q, actually %q", cmdLine, expected.ToBytes(), actual.ToBytes())
+		}
+	}
+}
@@ -6,7 +6,10 @@
 // -------------------------------------------
 package database
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 // 支持的 指令表
 // 每个指令对应一个 command 结构体
@@ -15,14 +18,135 @@ var cmdTable = make(map[string]*command)
 type command struct {
 	// 对应的执行的方法
 	executor ExecFunc
+	// 阻塞命令的执行方法，和 executor 二选一
+	blockingExecutor BlockingExecFunc
+	// 执行之前取出要写和要读的 key
+	prepare PreFunc
 	// 参数的数量
 	arity int
+	// 影响整个 db 的命令，和 EXEC 一样独占 db.mu 执行，如 FLUSHDB
+	exclusive bool
 }
 
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+// PreFunc 分析命令的参数（不含命令名），返回要写的 key 和要读的 key
+// 执行完之后写入的 key 的版本号会增加，用于 WATCH
+type PreFunc func(args [][]byte) ([]string, []string)
+
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, arity int) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
 		executor: executor,
+		prepare:  prepare,
 		arity:    arity,
 	}
 }
+
+// RegisterBlockingCommand 注册阻塞命令，执行时需要连接，客户端断开时取消阻塞
+func RegisterBlockingCommand(name string, executor BlockingExecFunc, prepare PreFunc, arity int) {
+	name = strings.ToLower(name)
+	cmdTable[name] = &command{
+		blockingExecutor: executor,
+		prepare:          prepare,
+		arity:            arity,
+	}
+}
+
+// RegisterExclusiveCommand 注册影响整个 db 的命令，执行时独占 db 而不是对 key 加锁
+func RegisterExclusiveCommand(name string, executor ExecFunc, arity int) {
+	name = strings.ToLower(name)
+	cmdTable[name] = &command{
+		executor:  executor,
+		prepare:   noPrepare,
+		arity:     arity,
+		exclusive: true,
+	}
+}
+
+// isWriteCommand 是否是会写入 key 的命令，未知命令和参数错误交给 DB.Exec 处理
+func isWriteCommand(cmdLine [][]byte) bool {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return false
+	}
+	writeKeys, _ := cmd.prepare(cmdLine[1:])
+	return len(writeKeys) > 0
+}
+
+// 常用的 PreFunc
+
+// noPrepare 不涉及 key 的命令
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+// writeFirstKey 第一个参数是要写的 key
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, nil
+}
+
+// readFirstKey 第一个参数是要读的 key
+func readFirstKey(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0])}
+}
+
+// writeAllKeys 所有参数都是要写的 key
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	return toKeys(args), nil
+}
+
+// writeKeysExceptLast 除了最后一个参数都是要写的 key，如 BLPOP k1 k2 timeout
+func writeKeysExceptLast(args [][]byte) ([]string, []string) {
+	return toKeys(args[:len(args)-1]), nil
+}
+
+// readAllKeys 所有参数都是要读的 key
+func readAllKeys(args [][]byte) ([]string, []string) {
+	return nil, toKeys(args)
+}
+
+// writeFirstKeyReadRest 第一个参数是目标 key，后面的都是要读的 key，如 SINTERSTORE dest k1 k2
+func writeFirstKeyReadRest(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, toKeys(args[1:])
+}
+
+// writeTwoKeys 前两个参数都是要写的 key，如 RENAME src dest
+func writeTwoKeys(args [][]byte) ([]string, []string) {
+	return toKeys(args[:2]), nil
+}
+
+// readNumKeys numkeys key [key ...]，如 ZUNION 2 k1 k2
+func readNumKeys(args [][]byte) ([]string, []string) {
+	return nil, numKeys(args)
+}
+
+// writeNumKeys numkeys key [key ...]，如 LMPOP 2 k1 k2 LEFT
+func writeNumKeys(args [][]byte) ([]string, []string) {
+	return numKeys(args), nil
+}
+
+// writeNumKeysAfterTimeout timeout numkeys key [key ...]，如 BLMPOP 0 2 k1 k2 LEFT
+func writeNumKeysAfterTimeout(args [][]byte) ([]string, []string) {
+	return numKeys(args[1:]), nil
+}
+
+// writeFirstKeyReadNumKeys dest numkeys key [key ...]，如 ZUNIONSTORE dest 2 k1 k2
+func writeFirstKeyReadNumKeys(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, numKeys(args[1:])
+}
+
+// numKeys 解析 numkeys key [key ...] 中的 key，numkeys 不合法时交给执行函数报错
+func numKeys(args [][]byte) []string {
+	num, err := strconv.Atoi(string(args[0]))
+	if err != nil || num <= 0 || num > len(args)-1 {
+		return nil
+	}
+	return toKeys(args[1 : num+1])
+}
+
+func toKeys(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
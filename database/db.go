@@ -8,27 +8,68 @@ package database
 
 import (
 	"redis-go/datastruct/dict"
+	"redis-go/datastruct/lock"
 	"redis-go/interface/database"
 	"redis-go/interface/resp"
 	"redis-go/resp/reply"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DB struct {
-	index  int
-	data   dict.Dict
-	addAof func(line CmdLine)
+	index int
+	data  dict.Dict
+	// key → 过期时间 time.Time
+	ttlMap dict.Dict
+	// key → *watchedKey，只记录正在被 WATCH 的 key，没有连接 WATCH 之后删除
+	versionMap dict.Dict
+	// 普通命令共享，EXEC 独占，保证事务中的命令连续执行
+	mu sync.RWMutex
+	// 普通命令执行之前对要读写的 key 加锁，保证多个 key 的命令是原子的
+	locker *lock.Locks
+	// 是否正在执行 EXEC，事务中的阻塞命令不会阻塞
+	inMulti bool
+	// key → 估算的内存大小 int64
+	sizeMap dict.Dict
+	// 所有 key 估算的内存之和，原子操作
+	usedMemory int64
+	addAof     func(line CmdLine)
+	// 发布键空间通知
+	publish func(channel []byte, message []byte)
+	// 启动时解析的 notify-keyspace-events，为 0 时不发送通知
+	notifyFlags int
+	// 阻塞命令等待的 key
+	blocking *blockingKeys
+	// 正在保存 RDB 时的快照，修改 key 之前先保存旧值，读写都需要持有 mu
+	snapshot *snapshot
 }
 
+const (
+	// 分段锁的数量
+	lockerSize = 1024
+	// ConcurrentDict 的 shard 数量
+	dataDictSize = 1 << 10
+	ttlDictSize  = 1 << 8
+)
+
 type ExecFunc func(db *DB, args [][]byte) resp.Reply
+type BlockingExecFunc func(db *DB, c resp.Connection, args [][]byte) resp.Reply
 type CmdLine = [][]byte
 
 func makeDB() *DB {
 	db := &DB{
 		// 该接口用哪个实现
-		data: dict.MakeSyncDict(),
+		data:       dict.MakeConcurrent(dataDictSize),
+		ttlMap:     dict.MakeConcurrent(ttlDictSize),
+		versionMap: dict.MakeConcurrent(ttlDictSize),
+		sizeMap:    dict.MakeConcurrent(dataDictSize),
+		blocking:   makeBlockingKeys(),
+		locker:     lock.Make(lockerSize),
 		// 必须初始化，防止第一次运行出现错误（恢复数据的时候）
-		addAof: func(line CmdLine) {},
+		addAof:  func(line CmdLine) {},
+		publish: func(channel []byte, message []byte) {},
 	}
 	return db
 }
@@ -44,9 +85,36 @@ func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
 	if !validateArity(cmd.arity, cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
-	fun := cmd.executor
+	if cmd.exclusive {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return db.execCommand(c, cmd, cmdLine)
+	}
+	writeKeys, readKeys := cmd.prepare(cmdLine[1:])
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.locker.RWLocks(writeKeys, readKeys)
+	defer db.locker.RWUnLocks(writeKeys, readKeys)
+	return db.execCommand(c, cmd, cmdLine)
+}
+
+// execCommand 执行命令并增加写入的 key 的版本号，调用方需要持有锁
+func (db *DB) execCommand(c resp.Connection, cmd *command, cmdLine CmdLine) resp.Reply {
 	// SET K V → K V
-	return fun(db, cmdLine[1:])
+	args := cmdLine[1:]
+	writeKeys, _ := cmd.prepare(args)
+	db.preserve(writeKeys...)
+	var result resp.Reply
+	if cmd.blockingExecutor != nil {
+		result = cmd.blockingExecutor(db, c, args)
+	} else {
+		result = cmd.executor(db, args)
+	}
+	db.addVersion(writeKeys...)
+	db.updateMemory(writeKeys...)
+	// 唤醒等待这些 key 的阻塞命令
+	db.signalKeys(writeKeys...)
+	return result
 }
 
 // SET K V → arity = 3
@@ -70,33 +138,176 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	if !ok {
 		return nil, false
 	}
+	// 惰性删除：访问的时候才检查是否过期
+	if db.IsExpired(key) {
+		return nil, false
+	}
 	entity, _ := raw.(*database.DataEntity)
+	touchEntity(entity)
 	return entity, true
 }
 
+// 写入之前先清理掉已经过期但还没被删除的 key，避免残留的过期时间作用到新的值上
+
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.data.Put(key, entity)
+	db.IsExpired(key)
+	initAccess(entity)
+	result := db.data.Put(key, entity)
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	return result
 }
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
+	db.IsExpired(key)
+	initAccess(entity)
 	return db.data.PutIfExists(key, entity)
 }
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.data.PutIfAbsent(key, entity)
+	db.IsExpired(key)
+	initAccess(entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	return result
 }
 func (db *DB) Remove(key string) {
+	// 过期删除和淘汰不经过 execCommand
+	db.preserve(key)
 	db.data.Remove(key)
+	db.ttlMap.Remove(key)
+	db.removeMemory(key)
 }
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
+		_, exists := db.GetEntity(key)
 		if exists {
 			db.Remove(key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 			deleted++
 		}
 	}
 	return deleted
 }
+
+// removeEmpty 集合类型的最后一个元素被删除之后删除 key，和 Redis 一样发送 del 通知
+func (db *DB) removeEmpty(key string) {
+	db.Remove(key)
+	db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+}
+
+// Flush 清空 db，调用方需要独占 db.mu
 func (db *DB) Flush() {
+	db.preserveAll()
+	// 清空之前让所有 key 的 WATCH 失效
+	db.versionMap.ForEach(func(key string, val interface{}) bool {
+		atomic.AddUint32(&val.(*watchedKey).version, 1)
+		return true
+	})
 	db.data.Clear()
+	db.ttlMap.Clear()
+	db.sizeMap.Clear()
+	atomic.StoreInt64(&db.usedMemory, 0)
+}
+
+/* ---- TTL ---- */
+
+// Expire 设置 key 的过期时间
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist 取消 key 的过期时间
+func (db *DB) Persist(key string) int {
+	return db.ttlMap.Remove(key)
+}
+
+// GetExpireTime 返回 key 的过期时间，没有设置过期时间则 ok 为 false
+func (db *DB) GetExpireTime(key string) (expireTime time.Time, ok bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+// IsExpired 检查 key 是否已经过期，过期的 key 会被顺便删除
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.GetExpireTime(key)
+	if !ok {
+		return false
+	}
+	expired := time.Now().After(expireTime)
+	if expired {
+		db.Remove(key)
+		// 过期删除也算修改
+		db.addVersion(key)
+		db.notifyKeyspaceEvent(notifyExpired, "expired", key)
+	}
+	return expired
+}
+
+// ttlExpired 只检查 key 是否过期，不删除
+// 用于 KEYS、SCAN 这类不对 key 加锁的遍历，避免删除其他命令正在写入的 key
+func (db *DB) ttlExpired(key string) bool {
+	expireTime, ok := db.GetExpireTime(key)
+	return ok && time.Now().After(expireTime)
+}
+
+/* ---- Version ---- */
+
+// watchedKey 被 WATCH 的 key 的版本号，refs 是 WATCH 它的连接数
+// refs 只在持有 key 的锁时修改，Flush 持有 mu 时也会增加 version，所以 version 用原子操作
+type watchedKey struct {
+	version uint32
+	refs    int
+}
+
+// addVersion 增加 key 的版本号，没有被 WATCH 的 key 不需要记录
+func (db *DB) addVersion(keys ...string) {
+	for _, key := range keys {
+		if raw, ok := db.versionMap.Get(key); ok {
+			atomic.AddUint32(&raw.(*watchedKey).version, 1)
+		}
+	}
+}
+
+// GetVersion 返回 key 的版本号，没有被 WATCH 的 key 为 0
+func (db *DB) GetVersion(key string) uint32 {
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint32(&raw.(*watchedKey).version)
+}
+
+// watchKey 开始记录 key 的版本号并返回当前的版本号，调用方需要持有 key 的锁
+func (db *DB) watchKey(key string) uint32 {
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		raw = &watchedKey{}
+		db.versionMap.Put(key, raw)
+	}
+	watched := raw.(*watchedKey)
+	watched.refs++
+	return atomic.LoadUint32(&watched.version)
+}
+
+// unwatchKey 取消一个连接对 key 的 WATCH，没有连接 WATCH 之后不再记录版本号
+func (db *DB) unwatchKey(key string) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.locker.Lock(key)
+	defer db.locker.UnLock(key)
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		return
+	}
+	watched := raw.(*watchedKey)
+	watched.refs--
+	if watched.refs <= 0 {
+		db.versionMap.Remove(key)
+	}
 }
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cmdCase 一条命令和期望的回复，命令的参数用空格隔开
+type cmdCase struct {
+	cmdLine  string
+	expected resp.Reply
+}
+
+// execCases 依次执行命令并比较回复
+func execCases(t *testing.T, db *DB, cases []cmdCase) {
+	t.Helper()
+	for _, c := range cases {
+		result := db.Exec(nil, utils.ToCmdLine(strings.Fields(c.cmdLine)...))
+		if !utils.BytesEquals(result.ToBytes(), c.expected.ToBytes()) {
+			t.Errorf("%s: expected %q, actually %q", c.cmdLine, c.expected.ToBytes(), result.ToBytes())
+		}
+	}
+}
+
+// bulks 构造多个字符串的回复
+func bulks(values ...string) resp.Reply {
+	return reply.MakeMultiBulkReply(utils.ToCmdLine(values...))
+}
+
+func TestConcurrentIncr(t *testing.T) {
+	db := makeDB()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				db.Exec(nil, utils.ToCmdLine("incr", "counter"))
+			}
+		}()
+	}
+	wg.Wait()
+	result := db.Exec(nil, utils.ToCmdLine("get", "counter"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "20000" {
+		t.Errorf("expected 20000, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestConcurrentGetSet(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "k", "init"))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				result := db.Exec(nil, utils.ToCmdLine("getset", "k", strconv.Itoa(i*50+j)))
+				old := string(result.(*reply.BulkReply).Arg)
+				mu.Lock()
+				seen[old]++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 每个值只会被 GETSET 取出一次
+	for value, count := range seen {
+		if count != 1 {
+			t.Fatalf("value %s read %d times", value, count)
+		}
+	}
+	if len(seen) != 1000 {
+		t.Errorf("expected 1000, actually %d", len(seen))
+	}
+}
+
+func TestConcurrentRename(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "a", "v"))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if i%2 == 0 {
+					db.Exec(nil, utils.ToCmdLine("rename", "a", "b"))
+				} else {
+					db.Exec(nil, utils.ToCmdLine("renamenx", "b", "a"))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 无论怎么交替，都只剩下一个 key
+	result := db.Exec(nil, utils.ToCmdLine("exists", "a", "b"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+}
+
+// FLUSHDB 独占 db，等正在执行的命令结束之后才清空
+func TestFlushDBExclusive(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "k", "v"))
+	// 模拟正在执行的命令
+	db.mu.RLock()
+	done := make(chan struct{})
+	go func() {
+		db.Exec(nil, utils.ToCmdLine("flushdb"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected flushdb to wait for running commands")
+	case <-time.After(30 * time.Millisecond):
+	}
+	db.mu.RUnlock()
+	<-done
+	if n := db.data.Len(); n != 0 || db.usedMemory != 0 {
+		t.Errorf("expected empty db, actually %d keys %d bytes", n, db.usedMemory)
+	}
+}
@@ -0,0 +1,449 @@
+// Package database -----------------------------
+// @file      : evict.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/5 10:40
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"math/rand"
+	"redis-go/datastruct/dict"
+	Hash "redis-go/datastruct/hash"
+	List "redis-go/datastruct/list"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 内存淘汰策略，参考 Redis 的 evict.c
+const (
+	policyNoEviction     = "noeviction"
+	policyAllKeysLRU     = "allkeys-lru"
+	policyAllKeysLFU     = "allkeys-lfu"
+	policyAllKeysRandom  = "allkeys-random"
+	policyVolatileLRU    = "volatile-lru"
+	policyVolatileLFU    = "volatile-lfu"
+	policyVolatileRandom = "volatile-random"
+	policyVolatileTTL    = "volatile-ttl"
+)
+
+const (
+	// LRU 时钟只有 24 位，大约 194 天回绕一次
+	lruClockMax = 1<<24 - 1
+	// 新 key 的 LFU 计数器初始值，避免刚写入就被淘汰
+	lfuInitVal = 5
+	// 计数器的对数因子，越大计数器增长越慢
+	lfuLogFactor = 10
+	// 计数器每过多少分钟减 1
+	lfuDecayTime = 1
+	// 淘汰池的大小
+	evictionPoolSize = 16
+	// 默认每次抽样的 key 的数量
+	defaultMaxMemorySamples = 5
+	// 每个 key 固定的开销：dict 中的节点、DataEntity 等
+	keyOverhead = 64
+)
+
+// oomErr 超过内存上限且无法淘汰时，拒绝会增加内存的写命令
+const oomErr = "OOM command not allowed when used memory > 'maxmemory'."
+
+// noDenyOOMCmds 只会删除或者不增加内存的写命令，内存不足的时候也可以执行，用来释放内存
+var noDenyOOMCmds = map[string]bool{
+	"del": true, "getdel": true, "rename": true, "renamenx": true,
+	"expire": true, "pexpire": true, "expireat": true, "pexpireat": true, "persist": true,
+	"lpop": true, "rpop": true, "ltrim": true, "lrem": true,
+	"srem": true, "spop": true, "smove": true,
+	"zrem": true, "zpopmin": true, "zpopmax": true,
+	"hdel": true,
+	"xdel": true, "xtrim": true, "xack": true, "xclaim": true, "xautoclaim": true, "xreadgroup": true,
+}
+
+// maxMemoryPolicy 配置的淘汰策略
+func maxMemoryPolicy() string {
+	policy := strings.ToLower(config.Properties.MaxMemoryPolicy)
+	if policy == "" {
+		return policyNoEviction
+	}
+	return policy
+}
+
+func isLFUPolicy(policy string) bool {
+	return policy == policyAllKeysLFU || policy == policyVolatileLFU
+}
+
+/* ---- LRU ---- */
+
+// lruClock 当前的 LRU 时钟，单位秒
+func lruClock() uint32 {
+	return uint32(time.Now().Unix()) & lruClockMax
+}
+
+// estimateIdleTime 距离上次访问的秒数，考虑时钟回绕
+func estimateIdleTime(lru uint32) uint64 {
+	now := lruClock()
+	if now >= lru {
+		return uint64(now - lru)
+	}
+	return uint64(now) + uint64(lruClockMax-lru)
+}
+
+/* ---- LFU ---- */
+
+// lfuTimeInMinutes 当前时间的分钟数，只保留 16 位
+func lfuTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xffff
+}
+
+// lfuTimeElapsed 距离 ldt 过去的分钟数，考虑回绕
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 0xffff - ldt + now
+}
+
+// lfuLogIncr 对数计数器，计数器越大增加的概率越小
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return 255
+	}
+	baseVal := float64(counter) - lfuInitVal
+	if baseVal < 0 {
+		baseVal = 0
+	}
+	p := 1.0 / (baseVal*lfuLogFactor + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn 按照过去的时间衰减计数器
+func lfuDecrAndReturn(lru uint32) uint32 {
+	ldt := lru >> 8
+	counter := lru & 255
+	periods := lfuTimeElapsed(ldt) / lfuDecayTime
+	if periods > 0 {
+		if periods > counter {
+			return 0
+		}
+		return counter - periods
+	}
+	return counter
+}
+
+// initAccess 新写入的 key 初始化访问信息，已经有访问信息的（如 RENAME）保留
+func initAccess(entity *database.DataEntity) {
+	if atomic.LoadUint32(&entity.LRU) != 0 {
+		return
+	}
+	if isLFUPolicy(maxMemoryPolicy()) {
+		atomic.StoreUint32(&entity.LRU, lfuTimeInMinutes()<<8|lfuInitVal)
+	} else {
+		atomic.StoreUint32(&entity.LRU, lruClock())
+	}
+}
+
+// touchEntity 访问 key 的时候更新访问信息
+func touchEntity(entity *database.DataEntity) {
+	if isLFUPolicy(maxMemoryPolicy()) {
+		counter := lfuDecrAndReturn(atomic.LoadUint32(&entity.LRU))
+		counter = lfuLogIncr(counter)
+		atomic.StoreUint32(&entity.LRU, lfuTimeInMinutes()<<8|counter)
+		return
+	}
+	atomic.StoreUint32(&entity.LRU, lruClock())
+}
+
+/* ---- 内存估算 ---- */
+
+// 估算集合大小时抽样的元素数量，和 MEMORY USAGE 的默认值一致
+const memorySamples = 5
+
+// estimateSize 估算 key 占用的内存，集合类型抽样几个元素估算平均大小，避免每次写入都遍历整个集合
+func estimateSize(key string, entity *database.DataEntity) int64 {
+	size := int64(keyOverhead + len(key))
+	switch value := entity.Data.(type) {
+	case []byte:
+		size += int64(len(value))
+	case List.List:
+		sampled, total := 0, 0
+		value.ForEach(func(i int, val []byte) bool {
+			sampled++
+			total += len(val) + 16
+			return sampled < memorySamples
+		})
+		size += averageSize(total, sampled, value.Len())
+	case *Hash.Hash:
+		sampled, total := 0, 0
+		value.ForEach(func(field string, val []byte) bool {
+			sampled++
+			total += len(field) + len(val) + 32
+			return sampled < memorySamples
+		})
+		size += averageSize(total, sampled, value.Len())
+	case *set.Set:
+		sampled, total := 0, 0
+		value.ForEach(func(member string) bool {
+			sampled++
+			total += len(member) + 16
+			return sampled < memorySamples
+		})
+		size += averageSize(total, sampled, value.Len())
+	case *SortedSet.SortedSet:
+		sampled, total := 0, 0
+		if value.Len() > 0 {
+			stop := value.Len()
+			if stop > memorySamples {
+				stop = memorySamples
+			}
+			value.ForEachByRank(0, stop, false, func(element *SortedSet.Element) bool {
+				sampled++
+				// 跳表节点和 dict 中的节点
+				total += len(element.Member) + 64
+				return true
+			})
+		}
+		size += averageSize(total, sampled, int(value.Len()))
+	case *stream.Stream:
+		sampled, total := 0, 0
+		value.ForEach(func(entry *stream.Entry) bool {
+			sampled++
+			total += 32
+			for _, field := range entry.Fields {
+				total += len(field)
+			}
+			return sampled < memorySamples
+		})
+		size += averageSize(total, sampled, int(value.Len()))
+	}
+	return size
+}
+
+func averageSize(total int, sampled int, length int) int64 {
+	if sampled == 0 {
+		return 0
+	}
+	return int64(float64(total) / float64(sampled) * float64(length))
+}
+
+// updateMemory 命令执行之后重新估算写入的 key 的内存
+func (db *DB) updateMemory(keys ...string) {
+	for _, key := range keys {
+		var oldSize int64
+		if raw, ok := db.sizeMap.Get(key); ok {
+			oldSize = raw.(int64)
+		}
+		var newSize int64
+		if raw, ok := db.data.Get(key); ok {
+			newSize = estimateSize(key, raw.(*database.DataEntity))
+			db.sizeMap.Put(key, newSize)
+		} else {
+			db.sizeMap.Remove(key)
+		}
+		atomic.AddInt64(&db.usedMemory, newSize-oldSize)
+	}
+}
+
+// removeMemory 删除 key 的时候减去它的内存，过期删除和淘汰也会经过这里
+func (db *DB) removeMemory(key string) {
+	raw, ok := db.sizeMap.Get(key)
+	if !ok {
+		return
+	}
+	// 并发删除同一个 key 时只减一次
+	if db.sizeMap.Remove(key) == 1 {
+		atomic.AddInt64(&db.usedMemory, -raw.(int64))
+	}
+}
+
+// UsedMemory 估算的 db 使用的内存
+func (db *DB) UsedMemory() int64 {
+	return atomic.LoadInt64(&db.usedMemory)
+}
+
+/* ---- 淘汰 ---- */
+
+// evictionCandidate 淘汰池中的候选 key，score 越大越优先淘汰
+type evictionCandidate struct {
+	db    *DB
+	key   string
+	score uint64
+}
+
+// usedMemory 所有 db 估算的内存之和
+func (database *StandaloneDatabase) usedMemory() int64 {
+	var used int64
+	for _, db := range database.dbSet {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// freeMemoryIfNeeded 超过内存上限时按照淘汰策略删除 key，返回内存是否在上限以内
+// 参考 Redis 的 performEvictions，使用抽样的近似 LRU/LFU，淘汰池在多次淘汰之间保留
+func (database *StandaloneDatabase) freeMemoryIfNeeded() bool {
+	maxMemory := int64(config.Properties.MaxMemory)
+	if maxMemory <= 0 || database.usedMemory() <= maxMemory {
+		return true
+	}
+	policy := maxMemoryPolicy()
+	if policy == policyNoEviction {
+		return false
+	}
+	database.evictMu.Lock()
+	defer database.evictMu.Unlock()
+	for database.usedMemory() > maxMemory {
+		var db *DB
+		var key string
+		if policy == policyAllKeysRandom || policy == policyVolatileRandom {
+			db, key = database.randomEvictionKey(policy)
+		} else {
+			db, key = database.bestEvictionKey(policy)
+		}
+		if db == nil {
+			// 没有可以淘汰的 key，如 volatile 策略下没有设置过期时间的 key
+			return false
+		}
+		db.evictKey(key)
+	}
+	return true
+}
+
+// randomEvictionKey 依次从每个 db 中随机选一个 key
+func (database *StandaloneDatabase) randomEvictionKey(policy string) (*DB, string) {
+	for i := 0; i < len(database.dbSet); i++ {
+		database.nextEvictDB = (database.nextEvictDB + 1) % len(database.dbSet)
+		db := database.dbSet[database.nextEvictDB]
+		keys := db.evictionDict(policy).RandomKeys(1)
+		if len(keys) > 0 {
+			return db, keys[0]
+		}
+	}
+	return nil, ""
+}
+
+// bestEvictionKey 从每个 db 中抽样填充淘汰池，返回淘汰池中分数最高且仍然存在的 key
+func (database *StandaloneDatabase) bestEvictionKey(policy string) (*DB, string) {
+	samples := config.Properties.MaxMemorySamples
+	if samples <= 0 {
+		samples = defaultMaxMemorySamples
+	}
+	for _, db := range database.dbSet {
+		for _, key := range db.evictionDict(policy).RandomDistinctKeys(samples) {
+			score, ok := db.evictionScore(policy, key)
+			if ok {
+				database.evictionPoolInsert(evictionCandidate{db: db, key: key, score: score})
+			}
+		}
+	}
+	// 从分数最高的开始，跳过已经被删除的 key
+	for i := len(database.evictionPool) - 1; i >= 0; i-- {
+		candidate := database.evictionPool[i]
+		database.evictionPool = database.evictionPool[:i]
+		if _, ok := candidate.db.data.Get(candidate.key); ok {
+			return candidate.db, candidate.key
+		}
+	}
+	return nil, ""
+}
+
+// evictionPoolInsert 淘汰池按照分数从小到大排序，满了之后替换掉分数最低的
+func (database *StandaloneDatabase) evictionPoolInsert(candidate evictionCandidate) {
+	pool := database.evictionPool
+	for i := range pool {
+		if pool[i].db == candidate.db && pool[i].key == candidate.key {
+			pool[i].score = candidate.score
+			return
+		}
+	}
+	i := 0
+	for i < len(pool) && pool[i].score < candidate.score {
+		i++
+	}
+	if len(pool) >= evictionPoolSize {
+		if i == 0 {
+			// 比池中所有的 key 都不适合淘汰
+			return
+		}
+		// 去掉分数最低的
+		pool = pool[1:]
+		i--
+	}
+	pool = append(pool, evictionCandidate{})
+	copy(pool[i+1:], pool[i:])
+	pool[i] = candidate
+	database.evictionPool = pool
+}
+
+// evictionDict allkeys 策略从所有的 key 中抽样，volatile 策略只从设置了过期时间的 key 中抽样
+func (db *DB) evictionDict(policy string) dict.Dict {
+	if strings.HasPrefix(policy, "volatile") {
+		return db.ttlMap
+	}
+	return db.data
+}
+
+// evictionScore 计算淘汰的优先级，分数越大越优先淘汰
+func (db *DB) evictionScore(policy string, key string) (uint64, bool) {
+	raw, ok := db.data.Get(key)
+	if !ok {
+		return 0, false
+	}
+	entity := raw.(*database.DataEntity)
+	switch policy {
+	case policyAllKeysLRU, policyVolatileLRU:
+		return estimateIdleTime(atomic.LoadUint32(&entity.LRU)), true
+	case policyAllKeysLFU, policyVolatileLFU:
+		return uint64(255 - lfuDecrAndReturn(atomic.LoadUint32(&entity.LRU))), true
+	case policyVolatileTTL:
+		// 越早过期越优先淘汰
+		expireTime, ok := db.GetExpireTime(key)
+		if !ok {
+			return 0, false
+		}
+		return math.MaxInt64 - uint64(expireTime.UnixMilli()), true
+	}
+	return 0, false
+}
+
+// evictKey 删除被淘汰的 key，和 Redis 一样以 DEL 写入 aof
+func (db *DB) evictKey(key string) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db.locker.Lock(key)
+	defer db.locker.UnLock(key)
+	if _, ok := db.data.Get(key); !ok {
+		return
+	}
+	db.Remove(key)
+	db.addVersion(key)
+	db.addAof(utils.ToCmdLine("del", key))
+	db.notifyKeyspaceEvent(notifyEvicted, "evicted", key)
+}
+
+// checkMemory 写命令执行之前按需淘汰，仍然超过上限时拒绝会增加内存的命令
+// 返回 nil 表示可以执行
+func (database *StandaloneDatabase) checkMemory(cmdLine CmdLine) reply.ErrorReply {
+	if database.loading || config.Properties.MaxMemory <= 0 {
+		return nil
+	}
+	if !isWriteCommand(cmdLine) {
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !database.freeMemoryIfNeeded() && !noDenyOOMCmds[cmdName] {
+		return reply.MakeErrReply(oomErr)
+	}
+	return nil
+}
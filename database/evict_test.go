@@ -0,0 +1,184 @@
+package database
+
+import (
+	"redis-go/interface/database"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setMaxMemory 修改内存上限和淘汰策略，返回恢复原配置的函数
+func setMaxMemory(maxMemory int, policy string) func() {
+	oldMemory, oldPolicy := config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy
+	config.Properties.MaxMemory = maxMemory
+	config.Properties.MaxMemoryPolicy = policy
+	return func() {
+		config.Properties.MaxMemory = oldMemory
+		config.Properties.MaxMemoryPolicy = oldPolicy
+	}
+}
+
+func TestUsedMemory(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "a", strings.Repeat("x", 100)))
+	size := db.UsedMemory()
+	if size < 100 {
+		t.Fatalf("expected at least 100, actually %d", size)
+	}
+	db.Exec(nil, utils.ToCmdLine("rpush", "l", "1", "2", "3", "4", "5", "6", "7", "8"))
+	if db.UsedMemory() <= size {
+		t.Fatalf("expected more than %d, actually %d", size, db.UsedMemory())
+	}
+	db.Exec(nil, utils.ToCmdLine("rename", "l", "l2"))
+	db.Exec(nil, utils.ToCmdLine("del", "l2"))
+	if db.UsedMemory() != size {
+		t.Fatalf("expected %d, actually %d", size, db.UsedMemory())
+	}
+	db.Exec(nil, utils.ToCmdLine("flushdb"))
+	if db.UsedMemory() != 0 {
+		t.Fatalf("expected 0, actually %d", db.UsedMemory())
+	}
+}
+
+func TestNoEviction(t *testing.T) {
+	defer setMaxMemory(1000, policyNoEviction)()
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	value := strings.Repeat("x", 100)
+	var result = database.Exec(conn, utils.ToCmdLine("set", "k0", value))
+	for i := 1; i < 20 && !reply.IsErrReply(result); i++ {
+		result = database.Exec(conn, utils.ToCmdLine("set", "k"+strconv.Itoa(i), value))
+	}
+	expected := "-OOM command not allowed when used memory > 'maxmemory'.\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Fatalf("expected %q, actually %q", expected, string(result.ToBytes()))
+	}
+	// 读命令和删除命令仍然可以执行
+	result = database.Exec(conn, utils.ToCmdLine("get", "k0"))
+	if string(result.ToBytes()) != "$100\r\n"+value+"\r\n" {
+		t.Errorf("expected value, actually %q", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("del", "k0", "k1"))
+	if string(result.ToBytes()) != ":2\r\n" {
+		t.Errorf("expected 2, actually %q", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("set", "k0", value))
+	if string(result.ToBytes()) != "+OK\r\n" {
+		t.Errorf("expected OK, actually %q", string(result.ToBytes()))
+	}
+
+	// 事务中排队时拒绝
+	database.Exec(conn, utils.ToCmdLine("set", "k1", value))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	result = database.Exec(conn, utils.ToCmdLine("set", "k2", value))
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if !strings.HasPrefix(string(result.ToBytes()), "-EXECABORT") {
+		t.Errorf("expected EXECABORT, actually %q", string(result.ToBytes()))
+	}
+}
+
+func TestAllKeysLRU(t *testing.T) {
+	defer setMaxMemory(5000, policyAllKeysLRU)()
+	standalone := NewStandaloneDatabase()
+	defer standalone.Close()
+	conn := &connection.Connection{}
+	db := standalone.dbSet[0]
+	value := strings.Repeat("x", 100)
+	standalone.Exec(conn, utils.ToCmdLine("set", "hot", value))
+	for i := 0; i < 200; i++ {
+		// 模拟 hot 一直被访问，其他 key 很久没有访问
+		raw, _ := db.data.Get("hot")
+		raw.(*database.DataEntity).LRU = lruClock()
+		result := standalone.Exec(conn, utils.ToCmdLine("set", "k"+strconv.Itoa(i), value))
+		if reply.IsErrReply(result) {
+			t.Fatalf("unexpected error %q", string(result.ToBytes()))
+		}
+		if raw, ok := db.data.Get("k" + strconv.Itoa(i)); ok {
+			raw.(*database.DataEntity).LRU = lruClock() - 100
+		}
+		if standalone.usedMemory() > 5000+int64(200) {
+			t.Fatalf("expected about 5000, actually %d", standalone.usedMemory())
+		}
+	}
+	if db.data.Len() >= 200 {
+		t.Fatalf("expected keys evicted, actually %d", db.data.Len())
+	}
+	result := standalone.Exec(conn, utils.ToCmdLine("exists", "hot"))
+	if string(result.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected hot key kept, actually %q", string(result.ToBytes()))
+	}
+}
+
+func TestVolatileTTL(t *testing.T) {
+	defer setMaxMemory(3000, policyVolatileTTL)()
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 10; i++ {
+		database.Exec(conn, utils.ToCmdLine("set", "p"+strconv.Itoa(i), value))
+	}
+	database.Exec(conn, utils.ToCmdLine("set", "soon", value, "ex", "10"))
+	database.Exec(conn, utils.ToCmdLine("set", "later", value, "ex", "1000"))
+	// 超过上限之后先淘汰最早过期的 key
+	db := database.dbSet[0]
+	evicted := false
+	for i := 0; i < 20 && !evicted; i++ {
+		result := database.Exec(conn, utils.ToCmdLine("set", "n"+strconv.Itoa(i), value))
+		if reply.IsErrReply(result) {
+			t.Fatalf("unexpected error %q", string(result.ToBytes()))
+		}
+		if _, ok := db.data.Get("soon"); !ok {
+			evicted = true
+			if _, ok := db.data.Get("later"); !ok {
+				t.Fatal("expected later kept")
+			}
+		}
+	}
+	if !evicted {
+		t.Fatal("expected soon evicted")
+	}
+	// 没有设置过期时间的 key 不会被淘汰，最终拒绝写入
+	result := database.Exec(conn, utils.ToCmdLine("set", "x", value))
+	for i := 0; i < 50 && !reply.IsErrReply(result); i++ {
+		result = database.Exec(conn, utils.ToCmdLine("set", "x"+strconv.Itoa(i), value))
+	}
+	if !strings.HasPrefix(string(result.ToBytes()), "-OOM") {
+		t.Errorf("expected OOM, actually %q", string(result.ToBytes()))
+	}
+	if _, ok := db.data.Get("p0"); !ok {
+		t.Error("expected persistent key kept")
+	}
+}
+
+func TestLFUCounter(t *testing.T) {
+	defer setMaxMemory(0, policyAllKeysLFU)()
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "a", "1"))
+	raw, _ := db.data.Get("a")
+	entity := raw.(*database.DataEntity)
+	if counter := entity.LRU & 255; counter != lfuInitVal {
+		t.Fatalf("expected %d, actually %d", lfuInitVal, counter)
+	}
+	for i := 0; i < 1000; i++ {
+		db.Exec(nil, utils.ToCmdLine("get", "a"))
+	}
+	counter := entity.LRU & 255
+	// 对数计数器，1000 次访问大约增加到 20 左右
+	if counter <= lfuInitVal || counter > 100 {
+		t.Fatalf("expected logarithmic counter, actually %d", counter)
+	}
+	// 每过一分钟减 1
+	entity.LRU = (lfuTimeInMinutes()-3)&0xffff<<8 | counter
+	if decayed := lfuDecrAndReturn(entity.LRU); decayed != counter-3 {
+		t.Fatalf("expected %d, actually %d", counter-3, decayed)
+	}
+}
@@ -0,0 +1,470 @@
+// Package database -----------------------------
+// @file      : geo.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/28 14:30
+// -------------------------------------------
+package database
+
+import (
+	"fmt"
+	"redis-go/datastruct/geohash"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 地理位置保存在有序集合中，分数是 52 位的 geohash
+
+// parseGeoUnit 返回单位对应的米数
+func parseGeoUnit(arg []byte) (float64, reply.ErrorReply) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, reply.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// parseLongLat 解析经纬度，超出范围时返回错误
+func parseLongLat(lonArg []byte, latArg []byte) (float64, float64, reply.ErrorReply) {
+	longitude, err := strconv.ParseFloat(string(lonArg), 64)
+	if err != nil {
+		return 0, 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	latitude, err := strconv.ParseFloat(string(latArg), 64)
+	if err != nil {
+		return 0, 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	if !geohash.ValidCoord(longitude, latitude) {
+		return 0, 0, reply.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+	}
+	return longitude, latitude, nil
+}
+
+// formatGeoDistance 距离保留 4 位小数
+func formatGeoDistance(distance float64) []byte {
+	return []byte(strconv.FormatFloat(distance, 'f', 4, 64))
+}
+
+// formatGeoCoord 坐标和 Redis 一样保留 17 位小数并去掉末尾的 0
+func formatGeoCoord(coord float64) []byte {
+	s := strconv.FormatFloat(coord, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return []byte(s)
+}
+
+func geoCoordReply(longitude float64, latitude float64) resp.Reply {
+	return reply.MakeMultiBulkReply([][]byte{formatGeoCoord(longitude), formatGeoCoord(latitude)})
+}
+
+// GEOADD key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]
+// 转换成 ZADD 执行，AOF 中也记录为 ZADD
+func execGeoAdd(db *DB, args [][]byte) resp.Reply {
+	zaddArgs := [][]byte{args[0]}
+	nx, xx := false, false
+	i := 1
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "NX" {
+			nx = true
+		} else if option == "XX" {
+			xx = true
+		} else if option != "CH" {
+			break
+		}
+		zaddArgs = append(zaddArgs, args[i])
+	}
+	if (len(args)-i)%3 != 0 || len(args) == i || (nx && xx) {
+		return reply.MakeSyntaxErrReply()
+	}
+	for ; i < len(args); i += 3 {
+		longitude, latitude, errReply := parseLongLat(args[i], args[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		hash, _ := geohash.Encode(longitude, latitude, geohash.StepMax)
+		score := utils.FormatScore(float64(geohash.Align52Bits(hash)))
+		zaddArgs = append(zaddArgs, []byte(score), args[i+2])
+	}
+	return execZAdd(db, zaddArgs)
+}
+
+// GEOPOS key [member [member ...]]
+func execGeoPos(db *DB, args [][]byte) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]resp.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if sortedSet == nil {
+			result = append(result, reply.MakeNullMultiBulkReply())
+			continue
+		}
+		element, exists := sortedSet.Get(string(member))
+		if !exists {
+			result = append(result, reply.MakeNullMultiBulkReply())
+			continue
+		}
+		result = append(result, geoCoordReply(geohash.DecodeScore(element.Score)))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// GEODIST key member1 member2 [M | KM | FT | MI]
+func execGeoDist(db *DB, args [][]byte) resp.Reply {
+	conversion := 1.0
+	if len(args) == 4 {
+		var errReply reply.ErrorReply
+		conversion, errReply = parseGeoUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	} else if len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeNullBulkReply()
+	}
+	e1, ok1 := sortedSet.Get(string(args[1]))
+	e2, ok2 := sortedSet.Get(string(args[2]))
+	if !ok1 || !ok2 {
+		return reply.MakeNullBulkReply()
+	}
+	lon1, lat1 := geohash.DecodeScore(e1.Score)
+	lon2, lat2 := geohash.DecodeScore(e2.Score)
+	return reply.MakeBulkReply(formatGeoDistance(geohash.Distance(lon1, lat1, lon2, lat2) / conversion))
+}
+
+// GEOHASH key [member [member ...]]
+func execGeoHash(db *DB, args [][]byte) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if sortedSet == nil {
+			result = append(result, nil)
+			continue
+		}
+		element, exists := sortedSet.Get(string(member))
+		if !exists {
+			result = append(result, nil)
+			continue
+		}
+		result = append(result, []byte(geohash.ToString(element.Score)))
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// 搜索结果的排序方式
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+// geoSearchArgs GEOSEARCH 和 GEOSEARCHSTORE 的参数
+type geoSearchArgs struct {
+	fromMember []byte
+	fromLonLat bool
+	byRadius   bool
+	byBox      bool
+	shape      geohash.Shape
+	sort       int
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeDist  bool
+}
+
+// geoPoint 搜索到的点
+type geoPoint struct {
+	member    string
+	score     float64
+	longitude float64
+	latitude  float64
+	// 到中心的距离，单位米
+	distance float64
+}
+
+// parseGeoSearch 解析 FROMMEMBER | FROMLONLAT，BYRADIUS | BYBOX，以及其他可选参数
+func parseGeoSearch(cmdName string, args [][]byte, store bool) (*geoSearchArgs, reply.ErrorReply) {
+	searchArgs := &geoSearchArgs{}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		remaining := len(args) - i - 1
+		switch {
+		case option == "FROMMEMBER" && remaining >= 1:
+			if searchArgs.fromMember != nil || searchArgs.fromLonLat {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			searchArgs.fromMember = args[i+1]
+			i++
+		case option == "FROMLONLAT" && remaining >= 2:
+			if searchArgs.fromMember != nil || searchArgs.fromLonLat {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			longitude, latitude, errReply := parseLongLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			searchArgs.fromLonLat = true
+			searchArgs.shape.Longitude = longitude
+			searchArgs.shape.Latitude = latitude
+			i += 2
+		case option == "BYRADIUS" && remaining >= 2:
+			if searchArgs.byRadius || searchArgs.byBox {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			radius, err := strconv.ParseFloat(string(args[i+1]), 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return nil, reply.MakeErrReply("ERR radius cannot be negative")
+			}
+			conversion, errReply := parseGeoUnit(args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			searchArgs.byRadius = true
+			searchArgs.shape.Radius = radius
+			searchArgs.shape.Conversion = conversion
+			i += 2
+		case option == "BYBOX" && remaining >= 3:
+			if searchArgs.byRadius || searchArgs.byBox {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			width, err1 := strconv.ParseFloat(string(args[i+1]), 64)
+			height, err2 := strconv.ParseFloat(string(args[i+2]), 64)
+			if err1 != nil || err2 != nil {
+				return nil, reply.MakeErrReply("ERR need numeric width and height")
+			}
+			if width < 0 || height < 0 {
+				return nil, reply.MakeErrReply("ERR height or width cannot be negative")
+			}
+			conversion, errReply := parseGeoUnit(args[i+3])
+			if errReply != nil {
+				return nil, errReply
+			}
+			searchArgs.byBox = true
+			searchArgs.shape.IsBox = true
+			searchArgs.shape.Width = width
+			searchArgs.shape.Height = height
+			searchArgs.shape.Conversion = conversion
+			i += 3
+		case option == "ASC":
+			searchArgs.sort = geoSortAsc
+		case option == "DESC":
+			searchArgs.sort = geoSortDesc
+		case option == "COUNT" && remaining >= 1:
+			count, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			if count <= 0 {
+				return nil, reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			searchArgs.count = int(count)
+			i++
+			if i+1 < len(args) && strings.ToUpper(string(args[i+1])) == "ANY" {
+				searchArgs.any = true
+				i++
+			}
+		case option == "WITHCOORD" && !store:
+			searchArgs.withCoord = true
+		case option == "WITHDIST" && !store:
+			searchArgs.withDist = true
+		case option == "WITHHASH" && !store:
+			searchArgs.withHash = true
+		case option == "STOREDIST" && store:
+			searchArgs.storeDist = true
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	if searchArgs.fromMember == nil && !searchArgs.fromLonLat {
+		return nil, reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+	}
+	if !searchArgs.byRadius && !searchArgs.byBox {
+		return nil, reply.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+	}
+	// 指定了 COUNT 但没有 ANY 时，需要排序之后取最近的几个
+	if searchArgs.count > 0 && searchArgs.sort == geoSortNone && !searchArgs.any {
+		searchArgs.sort = geoSortAsc
+	}
+	return searchArgs, nil
+}
+
+// geoSearch 在有序集合中搜索范围内的点
+// 参考 Redis 的 membersOfAllNeighbors，依次搜索中心和周围的格子，COUNT ANY 时找到足够的点就停止
+func geoSearch(sortedSet *SortedSet.SortedSet, searchArgs *geoSearchArgs) []*geoPoint {
+	limit := 0
+	if searchArgs.any {
+		limit = searchArgs.count
+	}
+	shape := &searchArgs.shape
+	points := make([]*geoPoint, 0)
+	areas := geohash.SearchAreas(shape)
+	lastProcessed := -1
+	for i, area := range areas {
+		if area.IsZero() {
+			continue
+		}
+		// 半径很大的时候相邻的格子可能相同，跳过和上一个相同的格子
+		if lastProcessed >= 0 && area == areas[lastProcessed] {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		// 格子对应分数范围 [min, max)
+		min := &SortedSet.ScoreBorder{Value: float64(geohash.Align52Bits(area))}
+		next := geohash.Bits{Bits: area.Bits + 1, Step: area.Step}
+		max := &SortedSet.ScoreBorder{Value: float64(geohash.Align52Bits(next)), Exclude: true}
+		sortedSet.ForEach(min, max, 0, -1, false, func(element *SortedSet.Element) bool {
+			longitude, latitude := geohash.DecodeScore(element.Score)
+			distance, ok := geohash.DistanceIfInShape(shape, longitude, latitude)
+			if !ok {
+				return true
+			}
+			points = append(points, &geoPoint{
+				member:    element.Member,
+				score:     element.Score,
+				longitude: longitude,
+				latitude:  latitude,
+				distance:  distance,
+			})
+			return limit == 0 || len(points) < limit
+		})
+		lastProcessed = i
+	}
+
+	switch searchArgs.sort {
+	case geoSortAsc:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].distance < points[j].distance
+		})
+	case geoSortDesc:
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].distance > points[j].distance
+		})
+	}
+	if searchArgs.count > 0 && len(points) > searchArgs.count {
+		points = points[:searchArgs.count]
+	}
+	return points
+}
+
+// prepareGeoSearch 取出有序集合并确定搜索的中心，key 不存在时返回 nil
+func (db *DB) prepareGeoSearch(key string, searchArgs *geoSearchArgs) (*SortedSet.SortedSet, reply.ErrorReply) {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil || sortedSet == nil {
+		return nil, errReply
+	}
+	if searchArgs.fromMember != nil {
+		element, exists := sortedSet.Get(string(searchArgs.fromMember))
+		if !exists {
+			return nil, reply.MakeErrReply("ERR could not decode requested zset member")
+		}
+		searchArgs.shape.Longitude, searchArgs.shape.Latitude = geohash.DecodeScore(element.Score)
+	}
+	return sortedSet, nil
+}
+
+// GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius M | KM | FT | MI | BYBOX width height M | KM | FT | MI
+// [ASC | DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *DB, args [][]byte) resp.Reply {
+	searchArgs, errReply := parseGeoSearch("GEOSEARCH", args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.prepareGeoSearch(string(args[0]), searchArgs)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	points := geoSearch(sortedSet, searchArgs)
+	withOptions := searchArgs.withDist || searchArgs.withHash || searchArgs.withCoord
+	result := make([]resp.Reply, 0, len(points))
+	for _, point := range points {
+		if !withOptions {
+			result = append(result, reply.MakeBulkReply([]byte(point.member)))
+			continue
+		}
+		item := []resp.Reply{reply.MakeBulkReply([]byte(point.member))}
+		if searchArgs.withDist {
+			item = append(item, reply.MakeBulkReply(formatGeoDistance(point.distance/searchArgs.shape.Conversion)))
+		}
+		if searchArgs.withHash {
+			item = append(item, reply.MakeIntReply(int64(point.score)))
+		}
+		if searchArgs.withCoord {
+			item = append(item, geoCoordReply(point.longitude, point.latitude))
+		}
+		result = append(result, reply.MakeMultiRawReply(item))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// prepareGeoSearchStore 写 destination，读 source
+func prepareGeoSearchStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius M | KM | FT | MI | BYBOX width height M | KM | FT | MI
+// [ASC | DESC] [COUNT count [ANY]] [STOREDIST]
+func execGeoSearchStore(db *DB, args [][]byte) resp.Reply {
+	searchArgs, errReply := parseGeoSearch("GEOSEARCHSTORE", args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.prepareGeoSearch(string(args[1]), searchArgs)
+	if errReply != nil {
+		return errReply
+	}
+	result := SortedSet.Make()
+	if sortedSet != nil {
+		for _, point := range geoSearch(sortedSet, searchArgs) {
+			score := point.score
+			// STOREDIST 保存距离，使用命令中的单位
+			if searchArgs.storeDist {
+				score = point.distance / searchArgs.shape.Conversion
+			}
+			result.Add(point.member, score)
+		}
+	}
+	return zStore(db, "geosearchstore", args, result)
+}
+
+func init() {
+	RegisterCommand("GeoAdd", execGeoAdd, writeFirstKey, -5)
+	RegisterCommand("GeoPos", execGeoPos, readFirstKey, -2)
+	RegisterCommand("GeoDist", execGeoDist, readFirstKey, -4)
+	RegisterCommand("GeoHash", execGeoHash, readFirstKey, -2)
+	RegisterCommand("GeoSearch", execGeoSearch, readFirstKey, -7)
+	RegisterCommand("GeoSearchStore", execGeoSearchStore, prepareGeoSearchStore, -8)
+}
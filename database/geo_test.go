@@ -0,0 +1,47 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	db := makeDB()
+	result := db.Exec(nil, utils.ToCmdLine("geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 2 {
+		t.Errorf("expected 2, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geodist", "Sicily", "Palermo", "Catania", "km"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "166.2742" {
+		t.Errorf("expected 166.2742, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geohash", "Sicily", "Palermo", "Catania"))
+	if string(result.ToBytes()) != "*2\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n" {
+		t.Errorf("expected geohash strings, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geopos", "Sicily", "NonExisting"))
+	if string(result.ToBytes()) != "*1\r\n*-1\r\n" {
+		t.Errorf("expected nil position, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc"))
+	if string(result.ToBytes()) != "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n" {
+		t.Errorf("expected Catania Palermo, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geosearch", "Sicily", "frommember", "Palermo", "byradius", "100", "km"))
+	if string(result.ToBytes()) != "*1\r\n$7\r\nPalermo\r\n" {
+		t.Errorf("expected Palermo, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "storedist"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 2 {
+		t.Errorf("expected 2, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("zscore", "dst", "Catania"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "56.4412578701582" {
+		t.Errorf("expected 56.4412578701582, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("geoadd", "Sicily", "200", "100", "x"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+}
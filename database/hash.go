@@ -0,0 +1,433 @@
+// Package database -----------------------------
+// @file      : hash.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/22 16:20
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	Hash "redis-go/datastruct/hash"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// getAsHash 取出 key 对应的哈希，key 不存在时返回 nil
+func (db *DB) getAsHash(key string) (*Hash.Hash, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	hash, ok := entity.Data.(*Hash.Hash)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return hash, nil
+}
+
+// getOrInitHash 取出 key 对应的哈希，key 不存在时新建一个
+func (db *DB) getOrInitHash(key string) (hash *Hash.Hash, isNew bool, errReply reply.ErrorReply) {
+	hash, errReply = db.getAsHash(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if hash == nil {
+		hash = Hash.MakeHash()
+		db.PutEntity(key, &database.DataEntity{
+			Data: hash,
+		})
+		isNew = true
+	}
+	return hash, isNew, nil
+}
+
+// HSET k1 f1 v1 [f2 v2 ...]
+func execHSet(db *DB, args [][]byte) resp.Reply {
+	// field value 需要成对出现
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+
+	hash, _, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		added += hash.Set(string(args[i]), args[i+1])
+	}
+
+	db.notifyKeyspaceEvent(notifyHash, "hset", key)
+	db.addAof(utils.ToCmdLine2("hset", args...))
+	return reply.MakeIntReply(int64(added))
+}
+
+// HMSET k1 f1 v1 [f2 v2 ...] 已弃用，等价于 HSET 但是返回 OK
+func execHMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hmset")
+	}
+	key := string(args[0])
+
+	hash, _, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	for i := 1; i < len(args); i += 2 {
+		hash.Set(string(args[i]), args[i+1])
+	}
+
+	db.notifyKeyspaceEvent(notifyHash, "hset", key)
+	db.addAof(utils.ToCmdLine2("hmset", args...))
+	return reply.MakeOkReply()
+}
+
+// HSETNX k1 f1 v1
+func execHSetNX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	value := args[2]
+
+	hash, _, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := hash.SetIfAbsent(field, value)
+
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyHash, "hset", key)
+		db.addAof(utils.ToCmdLine2("hsetnx", args...))
+	}
+	return reply.MakeIntReply(int64(result))
+}
+
+// HGET k1 f1
+func execHGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeNullBulkReply()
+	}
+	value, exists := hash.Get(field)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(value)
+}
+
+// HMGET k1 f1 [f2 ...]
+func execHMGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	fields := args[1:]
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	// 不存在的 field 返回 nil
+	result := make([][]byte, len(fields))
+	if hash == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, field := range fields {
+		value, exists := hash.Get(string(field))
+		if exists {
+			result[i] = value
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// HDEL k1 f1 [f2 ...]
+func execHDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	fields := args[1:]
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, field := range fields {
+		deleted += hash.Remove(string(field))
+	}
+	if deleted > 0 {
+		db.notifyKeyspaceEvent(notifyHash, "hdel", key)
+	}
+	// 哈希空了就删除这个 key
+	if hash.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("hdel", args...))
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// HEXISTS k1 f1
+func execHExists(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeIntReply(0)
+	}
+	if _, exists := hash.Get(field); exists {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// HLEN k1
+func execHLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(hash.Len()))
+}
+
+// HSTRLEN k1 f1
+func execHStrLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeIntReply(0)
+	}
+	value, exists := hash.Get(field)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// HGETALL k1
+func execHGetAll(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	result := make([][]byte, 0, hash.Len()*2)
+	hash.ForEach(func(field string, value []byte) bool {
+		result = append(result, []byte(field), value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(result)
+}
+
+// HKEYS k1
+func execHKeys(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	fields := make([][]byte, 0, hash.Len())
+	hash.ForEach(func(field string, value []byte) bool {
+		fields = append(fields, []byte(field))
+		return true
+	})
+	return reply.MakeMultiBulkReply(fields)
+}
+
+// HVALS k1
+func execHVals(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	values := make([][]byte, 0, hash.Len())
+	hash.ForEach(func(field string, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+	return reply.MakeMultiBulkReply(values)
+}
+
+// HINCRBY k1 f1 increment
+func execHIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	hash, _, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	var current int64
+	value, exists := hash.Get(field)
+	if exists {
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR hash value is not an integer")
+		}
+	}
+	// 溢出检测
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	result := current + delta
+	hash.Set(field, []byte(strconv.FormatInt(result, 10)))
+
+	db.notifyKeyspaceEvent(notifyHash, "hincrby", key)
+	db.addAof(utils.ToCmdLine2("hincrby", args...))
+	return reply.MakeIntReply(result)
+}
+
+// HINCRBYFLOAT k1 f1 increment
+func execHIncrByFloat(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+
+	hash, _, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	var current float64
+	value, exists := hash.Get(field)
+	if exists {
+		current, err = strconv.ParseFloat(string(value), 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return reply.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	result := current + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return reply.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+	resultBytes := []byte(utils.FormatFloat(result))
+	hash.Set(field, resultBytes)
+	db.notifyKeyspaceEvent(notifyHash, "hincrbyfloat", key)
+
+	// 浮点运算的结果与平台有关，和 Redis 一样以 HSET 的形式写入 aof
+	db.addAof(utils.ToCmdLine2("hset", args[0], args[1], resultBytes))
+	return reply.MakeBulkReply(resultBytes)
+}
+
+// HRANDFIELD k1 [count [WITHVALUES]]
+// count > 0 返回不重复的 field，count < 0 返回可重复的 -count 个 field
+func execHRandField(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	withCount := len(args) >= 2
+	count := int64(1)
+	withValues := false
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if len(args) == 3 {
+			if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+				return reply.MakeSyntaxErrReply()
+			}
+			withValues = true
+		} else if len(args) > 3 {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	hash, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		if withCount {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+	if !withCount {
+		return reply.MakeBulkReply([]byte(hash.RandomFields(1)[0]))
+	}
+	var fields []string
+	if count >= 0 {
+		fields = hash.RandomDistinctFields(int(count))
+	} else {
+		if count < -math.MaxInt32 {
+			return reply.MakeErrReply("ERR value is out of range")
+		}
+		fields = hash.RandomFields(int(-count))
+	}
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			value, _ := hash.Get(field)
+			result = append(result, value)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func init() {
+	// HSET k1 f1 v1 [f2 v2 ...]
+	RegisterCommand("HSet", execHSet, writeFirstKey, -4)
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, -4)
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, 4)
+	RegisterCommand("HGet", execHGet, readFirstKey, 3)
+	RegisterCommand("HMGet", execHMGet, readFirstKey, -3)
+	RegisterCommand("HDel", execHDel, writeFirstKey, -3)
+	RegisterCommand("HExists", execHExists, readFirstKey, 3)
+	RegisterCommand("HLen", execHLen, readFirstKey, 2)
+	RegisterCommand("HStrLen", execHStrLen, readFirstKey, 3)
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, 2)
+	RegisterCommand("HKeys", execHKeys, readFirstKey, 2)
+	RegisterCommand("HVals", execHVals, readFirstKey, 2)
+	// HINCRBY k1 f1 increment
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, 4)
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, 4)
+	// HRANDFIELD k1 [count [WITHVALUES]]
+	RegisterCommand("HRandField", execHRandField, readFirstKey, -2)
+}
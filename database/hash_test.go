@@ -0,0 +1,114 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"hset h f1 v1 f2 v2", reply.MakeIntReply(2)},
+		{"hset h f1 new f3 v3", reply.MakeIntReply(1)},
+		{"hmset h f4 v4", reply.MakeOkReply()},
+		{"hset h f5", reply.MakeArgNumErrReply("hset")},
+		{"hsetnx h f1 v", reply.MakeIntReply(0)},
+		{"hsetnx h f6 v6", reply.MakeIntReply(1)},
+		{"hget h f1", reply.MakeBulkReply([]byte("new"))},
+		{"hget h none", reply.MakeNullBulkReply()},
+		{"hget none f1", reply.MakeNullBulkReply()},
+		{"hmget h f1 none f2", reply.MakeMultiBulkReply([][]byte{[]byte("new"), nil, []byte("v2")})},
+		{"hexists h f3", reply.MakeIntReply(1)},
+		{"hexists h none", reply.MakeIntReply(0)},
+		{"hlen h", reply.MakeIntReply(5)},
+		{"hstrlen h f1", reply.MakeIntReply(3)},
+		{"hstrlen h none", reply.MakeIntReply(0)},
+		{"hdel h f3 f4 f6 none", reply.MakeIntReply(3)},
+		// 小的 hash 是 listpack 编码，保持插入的顺序
+		{"hgetall h", bulks("f1", "new", "f2", "v2")},
+		{"hkeys h", bulks("f1", "f2")},
+		{"hvals h", bulks("new", "v2")},
+		{"hgetall none", reply.MakeEmptyMultiBulkReply()},
+		// 删除最后一个字段之后删除 key
+		{"hdel h f1 f2", reply.MakeIntReply(2)},
+		{"exists h", reply.MakeIntReply(0)},
+		{"set s v", reply.MakeOkReply()},
+		{"hset s f v", &reply.WrongTypeErrReply{}},
+		{"hget s f", &reply.WrongTypeErrReply{}},
+	})
+}
+
+func TestHashIncr(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"hincrby h n 5", reply.MakeIntReply(5)},
+		{"hincrby h n -7", reply.MakeIntReply(-2)},
+		{"hincrby h n x", reply.MakeErrReply("ERR value is not an integer or out of range")},
+		{"hset h s abc big " + strconv.FormatInt(1<<62, 10), reply.MakeIntReply(2)},
+		{"hincrby h s 1", reply.MakeErrReply("ERR hash value is not an integer")},
+		{"hincrby h big " + strconv.FormatInt(1<<62, 10), reply.MakeErrReply("ERR increment or decrement would overflow")},
+		{"hset h f 10.5", reply.MakeIntReply(1)},
+		{"hincrbyfloat h f 0.1", reply.MakeBulkReply([]byte("10.6"))},
+		{"hincrbyfloat h f -0.6", reply.MakeBulkReply([]byte("10"))},
+		{"hincrbyfloat h s 1", reply.MakeErrReply("ERR hash value is not a float")},
+		{"hincrbyfloat h f x", reply.MakeErrReply("ERR value is not a valid float")},
+		{"hset h max 1.7e308", reply.MakeIntReply(1)},
+		{"hincrbyfloat h max 1.7e308", reply.MakeErrReply("ERR increment would produce NaN or Infinity")},
+		{"hget h f", reply.MakeBulkReply([]byte("10"))},
+	})
+}
+
+func TestHRandField(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"hset h a 1 b 2 c 3", reply.MakeIntReply(3)},
+		{"hrandfield none", reply.MakeNullBulkReply()},
+		{"hrandfield none 2", reply.MakeEmptyMultiBulkReply()},
+		{"hrandfield h 0", reply.MakeEmptyMultiBulkReply()},
+	})
+	fields := map[string]string{"a": "1", "b": "2", "c": "3"}
+	result := db.Exec(nil, utils.ToCmdLine("hrandfield", "h"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || fields[string(bulkResult.Arg)] == "" {
+		t.Errorf("expected random field, actually %q", result.ToBytes())
+	}
+	// count 为正数时不重复，最多返回所有字段
+	result = db.Exec(nil, utils.ToCmdLine("hrandfield", "h", "5", "withvalues"))
+	multiBulk, ok := result.(*reply.MultiBulkReply)
+	if !ok || len(multiBulk.Args) != 6 {
+		t.Fatalf("expected 3 fields with values, actually %q", result.ToBytes())
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(multiBulk.Args); i += 2 {
+		field := string(multiBulk.Args[i])
+		if seen[field] || fields[field] != string(multiBulk.Args[i+1]) {
+			t.Errorf("unexpected field %s", field)
+		}
+		seen[field] = true
+	}
+	// count 为负数时可以重复，正好返回 -count 个
+	result = db.Exec(nil, utils.ToCmdLine("hrandfield", "h", "-10"))
+	if multiBulk, ok = result.(*reply.MultiBulkReply); !ok || len(multiBulk.Args) != 10 {
+		t.Errorf("expected 10 fields, actually %q", result.ToBytes())
+	}
+}
+
+// 字段较多时转换成 hashtable 编码
+func TestLargeHash(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 1000; i++ {
+		db.Exec(nil, utils.ToCmdLine("hset", "h", "f"+strconv.Itoa(i), strconv.Itoa(i)))
+	}
+	execCases(t, db, []cmdCase{
+		{"hlen h", reply.MakeIntReply(1000)},
+		{"hget h f999", reply.MakeBulkReply([]byte("999"))},
+		{"hdel h f0 f1", reply.MakeIntReply(2)},
+		{"hexists h f0", reply.MakeIntReply(0)},
+		{"hlen h", reply.MakeIntReply(998)},
+	})
+	result := db.Exec(nil, utils.ToCmdLine("hgetall", "h"))
+	if multiBulk, ok := result.(*reply.MultiBulkReply); !ok || len(multiBulk.Args) != 1996 {
+		t.Errorf("expected 998 fields, actually %d bytes", len(result.ToBytes()))
+	}
+}
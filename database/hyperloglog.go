@@ -0,0 +1,141 @@
+// Package database -----------------------------
+// @file      : hyperloglog.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/25 16:30
+// -------------------------------------------
+package database
+
+import (
+	"redis-go/datastruct/hyperloglog"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+)
+
+// getAsHyperLogLog 取出 key 对应的 HyperLogLog，key 不存在时返回 nil
+// HyperLogLog 和 Redis 一样以字符串的形式存储
+func (db *DB) getAsHyperLogLog(key string) ([]byte, reply.ErrorReply) {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil || bytes == nil {
+		return nil, errReply
+	}
+	if err := hyperloglog.Validate(bytes); err != nil {
+		return nil, reply.MakeErrReply(err.Error())
+	}
+	return bytes, nil
+}
+
+// PFADD k1 [element ...]
+func execPFAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	hll, errReply := db.getAsHyperLogLog(key)
+	if errReply != nil {
+		return errReply
+	}
+	updated := false
+	if hll == nil {
+		hll = hyperloglog.Make()
+		updated = true
+	}
+	hll, changed, err := hyperloglog.Add(hll, args[1:]...)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	if !updated && !changed {
+		return reply.MakeIntReply(0)
+	}
+	// 稀疏表示转换之后是新的字节，需要重新存入
+	db.PutEntity(key, &database.DataEntity{
+		Data: hll,
+	})
+
+	db.addAof(utils.ToCmdLine2("pfadd", args...))
+	return reply.MakeIntReply(1)
+}
+
+// preparePFCount 单个 key 时可能写回基数缓存，和 Redis 一样当作写命令加写锁
+func preparePFCount(args [][]byte) ([]string, []string) {
+	if len(args) == 1 {
+		return writeFirstKey(args)
+	}
+	return readAllKeys(args)
+}
+
+// PFCOUNT k1 [k2 ...]
+func execPFCount(db *DB, args [][]byte) resp.Reply {
+	// 单个 key 的时候使用并更新基数缓存，此时持有 key 的写锁
+	if len(args) == 1 {
+		key := string(args[0])
+		hll, errReply := db.getAsHyperLogLog(key)
+		if errReply != nil {
+			return errReply
+		}
+		if hll == nil {
+			return reply.MakeIntReply(0)
+		}
+		count, cached, err := hyperloglog.Count(hll)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		// 不能原地写回缓存，GET 的回复可能还在引用旧的字节
+		if cached != nil {
+			db.PutEntity(key, &database.DataEntity{
+				Data: cached,
+			})
+		}
+		return reply.MakeIntReply(count)
+	}
+
+	hlls := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		hll, errReply := db.getAsHyperLogLog(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if hll != nil {
+			hlls = append(hlls, hll)
+		}
+	}
+	registers, err := hyperloglog.Merge(hlls...)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeIntReply(hyperloglog.CountRegisters(registers))
+}
+
+// PFMERGE dest [src ...]
+func execPFMerge(db *DB, args [][]byte) resp.Reply {
+	dest := string(args[0])
+	// dest 已经存在的时候也参与合并
+	hlls := make([][]byte, 0, len(args))
+	dense := false
+	for _, arg := range args {
+		hll, errReply := db.getAsHyperLogLog(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if hll != nil {
+			hlls = append(hlls, hll)
+			dense = dense || hyperloglog.IsDense(hll)
+		}
+	}
+	registers, err := hyperloglog.Merge(hlls...)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	// 和 Redis 一样，只要有一个是密集表示结果就使用密集表示
+	db.PutEntity(dest, &database.DataEntity{
+		Data: hyperloglog.FromRegisters(registers, dense),
+	})
+
+	db.addAof(utils.ToCmdLine2("pfmerge", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("PFAdd", execPFAdd, writeFirstKey, -2)
+	RegisterCommand("PFCount", execPFCount, preparePFCount, -2)
+	RegisterCommand("PFMerge", execPFMerge, writeFirstKeyReadRest, -2)
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"math"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestPFAdd(t *testing.T) {
+	db := makeDB()
+	result := db.Exec(nil, utils.ToCmdLine("pfadd", "hll", "a", "b", "c"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("pfadd", "hll", "a"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+	// 没有元素时只创建 key
+	result = db.Exec(nil, utils.ToCmdLine("pfadd", "empty"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+	db.Exec(nil, utils.ToCmdLine("pfadd", "hll2", "c", "d", "e"))
+	for _, c := range []struct {
+		cmdLine  []string
+		expected int64
+	}{
+		{[]string{"pfcount", "hll"}, 3},
+		{[]string{"pfcount", "hll", "hll2"}, 5},
+		{[]string{"pfcount", "empty"}, 0},
+		{[]string{"pfcount", "none"}, 0},
+	} {
+		result = db.Exec(nil, utils.ToCmdLine(c.cmdLine...))
+		if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != c.expected {
+			t.Errorf("%v: expected %d, actually %s", c.cmdLine, c.expected, string(result.ToBytes()))
+		}
+	}
+
+	db.Exec(nil, utils.ToCmdLine("set", "str", "value"))
+	for _, cmdLine := range [][]string{
+		{"pfadd", "str", "a"},
+		{"pfcount", "str"},
+		{"pfcount", "hll", "str"},
+		{"pfmerge", "hll", "str"},
+	} {
+		result = db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		if !reply.IsErrReply(result) {
+			t.Errorf("%v: expected error, actually %s", cmdLine, string(result.ToBytes()))
+		}
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 10000; i++ {
+		db.Exec(nil, utils.ToCmdLine("pfadd", "hll"+strconv.Itoa(i%2), strconv.Itoa(i)))
+	}
+	db.Exec(nil, utils.ToCmdLine("pfadd", "dest", "0", "extra"))
+	result := db.Exec(nil, utils.ToCmdLine("pfmerge", "dest", "hll0", "hll1", "none"))
+	if _, ok := result.(*reply.OkReply); !ok {
+		t.Fatalf("expected OK, actually %s", string(result.ToBytes()))
+	}
+	// 标准误差是 0.81%，误差不超过 3%
+	for _, cmdLine := range [][]string{
+		{"pfcount", "dest"},
+		{"pfcount", "hll0", "hll1", "dest"},
+	} {
+		result = db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		intResult, ok := result.(*reply.IntReply)
+		if !ok || math.Abs(float64(intResult.Code)-10001) > 300 {
+			t.Errorf("%v: expected about 10001, actually %s", cmdLine, string(result.ToBytes()))
+		}
+	}
+	result = db.Exec(nil, utils.ToCmdLine("pfmerge", "new"))
+	if _, ok := result.(*reply.OkReply); !ok {
+		t.Fatalf("expected OK, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("pfcount", "new"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+}
+
+// 单个 key 的 PFCOUNT 会写回基数缓存，并发执行时需要加写锁，用 -race 运行
+func TestConcurrentPFCount(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 1000; i++ {
+		db.Exec(nil, utils.ToCmdLine("pfadd", "hll", strconv.Itoa(i)))
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// 添加新元素让缓存失效
+				if j%10 == 0 {
+					db.Exec(nil, utils.ToCmdLine("pfadd", "hll", "new"+strconv.Itoa(i*100+j)))
+				}
+				result := db.Exec(nil, utils.ToCmdLine("pfcount", "hll"))
+				if _, ok := result.(*reply.IntReply); !ok {
+					t.Errorf("expected count, actually %s", string(result.ToBytes()))
+					return
+				}
+				// 读取 GET 返回的字节，和 PFCOUNT 写回缓存并发
+				_ = db.Exec(nil, utils.ToCmdLine("get", "hll")).ToBytes()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -7,6 +7,12 @@
 package database
 
 import (
+	Hash "redis-go/datastruct/hash"
+	List "redis-go/datastruct/list"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
 	"redis-go/interface/resp"
 	"redis-go/lib/utils"
 	"redis-go/lib/wildcard"
@@ -61,14 +67,32 @@ func execType(db *DB, args [][]byte) resp.Reply {
 	entity, exists := db.GetEntity(key)
 	if !exists {
 		// +none/r/n
-		reply.MakeStatusReply("none")
+		return reply.MakeStatusReply("none")
 	}
+	typ := typeName(entity)
+	if typ == "" {
+		return &reply.UnknowErrReply{}
+	}
+	return reply.MakeStatusReply(typ)
+}
+
+// typeName 值的类型名称，和 TYPE 命令的回复一致
+func typeName(entity *database.DataEntity) string {
 	switch entity.Data.(type) {
 	case []byte:
-		reply.MakeStatusReply("string")
-		// TODO:
+		return "string"
+	case List.List:
+		return "list"
+	case *Hash.Hash:
+		return "hash"
+	case *set.Set:
+		return "set"
+	case *SortedSet.SortedSet:
+		return "zset"
+	case *stream.Stream:
+		return "stream"
 	}
-	return &reply.UnknowErrReply{}
+	return ""
 }
 
 // RENAME k1 k2  k1:v → k2:v
@@ -77,12 +101,20 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	dest := string(args[1])
 	entity, exists := db.GetEntity(src)
 	if !exists {
-		reply.MakeErrReply("no such key")
+		return reply.MakeErrReply("ERR no such key")
 	}
+	expireTime, hasTTL := db.GetExpireTime(src)
 	// 放新的
 	db.PutEntity(dest, entity)
 	// 删旧的
 	db.Remove(src)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_from", src)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_to", dest)
+	// 过期时间跟着 key 走
+	db.Persist(dest)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
 
 	db.addAof(utils.ToCmdLine2("rename", args...))
 
@@ -101,10 +133,16 @@ func execRenamenx(db *DB, args [][]byte) resp.Reply {
 
 	entity, exists := db.GetEntity(src)
 	if !exists {
-		reply.MakeErrReply("no such key")
+		return reply.MakeErrReply("ERR no such key")
 	}
+	expireTime, hasTTL := db.GetExpireTime(src)
 	db.PutEntity(dest, entity)
 	db.Remove(src)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_from", src)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_to", dest)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
 
 	db.addAof(utils.ToCmdLine2("renamenx", args...))
 
@@ -117,7 +155,7 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
 	db.data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
+		if pattern.IsMatch(key) && !db.ttlExpired(key) {
 			result = append(result, []byte(key))
 		}
 		return true
@@ -126,16 +164,17 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("DEL", execDel, -2)
-	RegisterCommand("EXISTS", execExists, -2)
+	RegisterCommand("DEL", execDel, writeAllKeys, -2)
+	RegisterCommand("EXISTS", execExists, readAllKeys, -2)
 	// 忽略掉 FULSHDB 后续的一些参数
 	// FLUSHDB a b c 忽略掉 a b c
-	RegisterCommand("FlushDB", execFlushDB, -1)
+	// 清空期间不能有其他命令读写这个 db
+	RegisterExclusiveCommand("FlushDB", execFlushDB, -1)
 	// TYPE k1
-	RegisterCommand("TYPE", execType, 2)
+	RegisterCommand("TYPE", execType, readFirstKey, 2)
 	// RENAME k1 k2
-	RegisterCommand("RENAME", execRename, 3)
-	RegisterCommand("RENAMENX", execRenamenx, 3)
+	RegisterCommand("RENAME", execRename, writeTwoKeys, 3)
+	RegisterCommand("RENAMENX", execRenamenx, writeTwoKeys, 3)
 	// KEYS *
-	RegisterCommand("KEYS", execKeys, 2)
+	RegisterCommand("KEYS", execKeys, noPrepare, 2)
 }
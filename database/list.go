@@ -0,0 +1,706 @@
+// Package database -----------------------------
+// @file      : list.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/21 15:40
+// -------------------------------------------
+package database
+
+import (
+	List "redis-go/datastruct/list"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// getAsList 取出 key 对应的列表，key 不存在时返回 nil
+func (db *DB) getAsList(key string) (List.List, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := entity.Data.(List.List)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return list, nil
+}
+
+// getOrInitList 取出 key 对应的列表，key 不存在时新建一个
+func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply reply.ErrorReply) {
+	list, errReply = db.getAsList(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if list == nil {
+		list = List.NewQuickList()
+		db.PutEntity(key, &database.DataEntity{
+			Data: list,
+		})
+		isNew = true
+	}
+	return list, isNew, nil
+}
+
+// normalizeRange 把 [start, stop] 的闭区间（支持负数下标）转换成 [start, stop) 的左闭右开区间
+// 区间为空时返回 start >= stop
+func normalizeRange(start, stop int64, size int) (int, int) {
+	if start < 0 {
+		start = int64(size) + start
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop < 0 {
+		stop = int64(size) + stop
+	}
+	if stop >= int64(size) {
+		stop = int64(size) - 1
+	}
+	if start >= int64(size) || stop < start {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+// LPUSH k1 v1 v2 ...
+func execLPush(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range values {
+		list.PushFront(value)
+	}
+
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
+	db.addAof(utils.ToCmdLine2("lpush", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// LPUSHX k1 v1 v2 ... 只有列表存在时才插入
+func execLPushX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range values {
+		list.PushFront(value)
+	}
+
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
+	db.addAof(utils.ToCmdLine2("lpushx", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// RPUSH k1 v1 v2 ...
+func execRPush(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, _, errReply := db.getOrInitList(key)
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range values {
+		list.PushBack(value)
+	}
+
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
+	db.addAof(utils.ToCmdLine2("rpush", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// RPUSHX k1 v1 v2 ... 只有列表存在时才插入
+func execRPushX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	values := args[1:]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	for _, value := range values {
+		list.PushBack(value)
+	}
+
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
+	db.addAof(utils.ToCmdLine2("rpushx", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// popGeneric LPOP RPOP 的公共逻辑
+// 不带 count 返回单个元素，带 count 返回数组
+func popGeneric(db *DB, cmdName string, args [][]byte, fromHead bool) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	count := 1
+	if withCount {
+		c, err := strconv.Atoi(string(args[1]))
+		if err != nil || c < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = c
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if withCount {
+			return reply.MakeNullMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+	values := db.listPop(key, list, fromHead, count)
+
+	if len(values) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	if withCount {
+		return reply.MakeMultiBulkReply(values)
+	}
+	return reply.MakeBulkReply(values[0])
+}
+
+// LPOP k1 [count]
+func execLPop(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, "lpop", args, true)
+}
+
+// RPOP k1 [count]
+func execRPop(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, "rpop", args, false)
+}
+
+// LRANGE k1 start stop
+func execLRange(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	begin, end := normalizeRange(start, stop, list.Len())
+	if begin >= end {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return reply.MakeMultiBulkReply(list.Range(begin, end))
+}
+
+// LINDEX k1 index
+func execLIndex(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeNullBulkReply()
+	}
+	size := int64(list.Len())
+	if index64 < 0 {
+		index64 = size + index64
+	}
+	if index64 < 0 || index64 >= size {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(list.Get(int(index64)))
+}
+
+// LSET k1 index v1
+func execLSet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	index64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	size := int64(list.Len())
+	if index64 < 0 {
+		index64 = size + index64
+	}
+	if index64 < 0 || index64 >= size {
+		return reply.MakeErrReply("ERR index out of range")
+	}
+	list.Set(int(index64), value)
+
+	db.notifyKeyspaceEvent(notifyList, "lset", key)
+	db.addAof(utils.ToCmdLine2("lset", args...))
+	return reply.MakeOkReply()
+}
+
+// LREM k1 count v1
+// count > 0 从头删除 count 个，count < 0 从尾删除 -count 个，count = 0 全部删除
+func execLRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	count := int(count64)
+	value := args[2]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	expected := func(val []byte) bool {
+		return utils.BytesEquals(val, value)
+	}
+	var removed int
+	if count == 0 {
+		removed = list.RemoveAllByVal(expected)
+	} else if count > 0 {
+		removed = list.RemoveByVal(expected, count)
+	} else {
+		removed = list.ReverseRemoveByVal(expected, -count)
+	}
+	if removed > 0 {
+		db.notifyKeyspaceEvent(notifyList, "lrem", key)
+	}
+	if list.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("lrem", args...))
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// LINSERT k1 BEFORE|AFTER pivot v1
+func execLInsert(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	where := strings.ToUpper(string(args[1]))
+	if where != "BEFORE" && where != "AFTER" {
+		return reply.MakeSyntaxErrReply()
+	}
+	pivot := args[2]
+	value := args[3]
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	index := list.IndexOf(func(val []byte) bool {
+		return utils.BytesEquals(val, pivot)
+	})
+	// 没有找到 pivot
+	if index < 0 {
+		return reply.MakeIntReply(-1)
+	}
+	if where == "AFTER" {
+		index++
+	}
+	list.Insert(index, value)
+
+	db.notifyKeyspaceEvent(notifyList, "linsert", key)
+	db.addAof(utils.ToCmdLine2("linsert", args...))
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// LTRIM k1 start stop
+func execLTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeOkReply()
+	}
+	begin, end := normalizeRange(start, stop, list.Len())
+	list.Trim(begin, end)
+	db.notifyKeyspaceEvent(notifyList, "ltrim", key)
+	if list.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	db.addAof(utils.ToCmdLine2("ltrim", args...))
+	return reply.MakeOkReply()
+}
+
+// LLEN k1
+func execLLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(list.Len()))
+}
+
+// listPop 从列表的一端弹出最多 count 个元素，列表空了就删除 key
+func (db *DB) listPop(key string, list List.List, fromHead bool, count int) [][]byte {
+	event := "rpop"
+	if fromHead {
+		event = "lpop"
+	}
+	if count > list.Len() {
+		count = list.Len()
+	}
+	values := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if fromHead {
+			values = append(values, list.RemoveFirst())
+		} else {
+			values = append(values, list.RemoveLast())
+		}
+	}
+	if count > 0 {
+		db.notifyKeyspaceEvent(notifyList, event, key)
+	}
+	if list.Len() == 0 {
+		db.removeEmpty(key)
+	}
+	return values
+}
+
+// parseDirection 解析 LEFT | RIGHT，LEFT 表示列表的头部
+func parseDirection(arg []byte) (fromHead bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// lmoveGeneric 从 src 的一端弹出一个元素放到 dest 的一端，src 不存在时返回 nil
+// LMOVE 和 BLMOVE 都以 LMOVE 写入 aof
+func lmoveGeneric(db *DB, args [][]byte, fromHead bool, toHead bool) ([]byte, reply.ErrorReply) {
+	src, dest := string(args[0]), string(args[1])
+	srcList, errReply := db.getAsList(src)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if srcList == nil {
+		return nil, nil
+	}
+	// 目标的类型不对时不弹出
+	if _, errReply := db.getAsList(dest); errReply != nil {
+		return nil, errReply
+	}
+	value := db.listPop(src, srcList, fromHead, 1)[0]
+	// src 和 dest 相同并且只有一个元素时，弹出之后 key 被删除了，重新创建
+	destList, _, _ := db.getOrInitList(dest)
+	if toHead {
+		destList.PushFront(value)
+		db.notifyKeyspaceEvent(notifyList, "lpush", dest)
+	} else {
+		destList.PushBack(value)
+		db.notifyKeyspaceEvent(notifyList, "rpush", dest)
+	}
+
+	db.addAof(utils.ToCmdLine2("lmove", args[:4]...))
+	return value, nil
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args [][]byte) resp.Reply {
+	fromHead, ok1 := parseDirection(args[2])
+	toHead, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	value, errReply := lmoveGeneric(db, args, fromHead, toHead)
+	if errReply != nil {
+		return errReply
+	}
+	if value == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(value)
+}
+
+// parseMPop 解析 numkeys key [key ...] where [COUNT count]，LMPOP 和 ZMPOP 共用
+// where 为 first 或者 last 之一，如 LEFT | RIGHT、MIN | MAX
+func parseMPop(args [][]byte, first string, last string) (keys []string, fromFirst bool, count int, errReply reply.ErrorReply) {
+	num, err := strconv.Atoi(string(args[0]))
+	if err != nil || num <= 0 {
+		return nil, false, 0, reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	// numkeys 很大时 num+2 会溢出
+	if num > len(args)-2 {
+		return nil, false, 0, reply.MakeSyntaxErrReply()
+	}
+	keys = toKeys(args[1 : num+1])
+	switch strings.ToUpper(string(args[num+1])) {
+	case first:
+		fromFirst = true
+	case last:
+		fromFirst = false
+	default:
+		return nil, false, 0, reply.MakeSyntaxErrReply()
+	}
+	count = 1
+	rest := args[num+2:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+			return nil, false, 0, reply.MakeSyntaxErrReply()
+		}
+		count, err = strconv.Atoi(string(rest[1]))
+		if err != nil || count <= 0 {
+			return nil, false, 0, reply.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	return keys, fromFirst, count, nil
+}
+
+// lmpopGeneric 从第一个非空的列表中弹出最多 count 个元素，都为空时返回 nil
+// strict 为 false 时跳过类型不对的 key，用于阻塞之后的重试：和 Redis 一样只有列表才能唤醒阻塞的客户端
+func lmpopGeneric(db *DB, keys []string, fromHead bool, count int, strict bool) (string, [][]byte, reply.ErrorReply) {
+	for _, key := range keys {
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			if strict {
+				return "", nil, errReply
+			}
+			continue
+		}
+		if list == nil {
+			continue
+		}
+		values := db.listPop(key, list, fromHead, count)
+		cmdName := "rpop"
+		if fromHead {
+			cmdName = "lpop"
+		}
+		db.addAof(utils.ToCmdLine(cmdName, key, strconv.Itoa(len(values))))
+		return key, values, nil
+	}
+	return "", nil, nil
+}
+
+// mpopReply [key, [v1, v2 ...]]
+func mpopReply(key string, values resp.Reply) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(key)),
+		values,
+	})
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func execLMPop(db *DB, args [][]byte) resp.Reply {
+	keys, fromHead, count, errReply := parseMPop(args, "LEFT", "RIGHT")
+	if errReply != nil {
+		return errReply
+	}
+	key, values, errReply := lmpopGeneric(db, keys, fromHead, count, true)
+	if errReply != nil {
+		return errReply
+	}
+	if values == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return mpopReply(key, reply.MakeMultiBulkReply(values))
+}
+
+// bpopGeneric BLPOP BRPOP 的公共逻辑，返回 [key, value]
+func bpopGeneric(db *DB, c resp.Connection, args [][]byte, fromHead bool) resp.Reply {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := toKeys(args[:len(args)-1])
+	// 第一次尝试时类型不对返回错误，阻塞之后的重试跳过类型不对的 key
+	strict := true
+	try := func() resp.Reply {
+		defer func() { strict = false }()
+		key, values, errReply := lmpopGeneric(db, keys, fromHead, 1, strict)
+		if errReply != nil {
+			return errReply
+		}
+		if values == nil {
+			return nil
+		}
+		return reply.MakeMultiBulkReply([][]byte{[]byte(key), values[0]})
+	}
+	result := db.block(c, &blockingWait{
+		keys:      keys,
+		writeKeys: keys,
+		timeout:   timeout,
+		consume:   true,
+	}, try)
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// BLPOP key [key ...] timeout
+func execBLPop(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	return bpopGeneric(db, c, args, true)
+}
+
+// BRPOP key [key ...] timeout
+func execBRPop(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	return bpopGeneric(db, c, args, false)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func execBLMove(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	fromHead, ok1 := parseDirection(args[2])
+	toHead, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	timeout, errReply := parseBlockTimeout(args[4])
+	if errReply != nil {
+		return errReply
+	}
+	strict := true
+	result := db.block(c, &blockingWait{
+		keys:      []string{string(args[0])},
+		writeKeys: toKeys(args[:2]),
+		timeout:   timeout,
+		consume:   true,
+	}, func() resp.Reply {
+		defer func() { strict = false }()
+		value, errReply := lmoveGeneric(db, args, fromHead, toHead)
+		if errReply != nil {
+			// 阻塞之后的重试和 Redis 一样只在目标的类型不对时返回错误
+			if _, destErr := db.getAsList(string(args[1])); strict || destErr != nil {
+				return errReply
+			}
+			return nil
+		}
+		if value == nil {
+			return nil
+		}
+		return reply.MakeBulkReply(value)
+	})
+	if result == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return result
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func execBLMPop(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	timeout, errReply := parseBlockTimeout(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	keys, fromHead, count, errReply := parseMPop(args[1:], "LEFT", "RIGHT")
+	if errReply != nil {
+		return errReply
+	}
+	// 第一次尝试时类型不对返回错误，阻塞之后的重试跳过类型不对的 key
+	strict := true
+	try := func() resp.Reply {
+		defer func() { strict = false }()
+		key, values, errReply := lmpopGeneric(db, keys, fromHead, count, strict)
+		if errReply != nil {
+			return errReply
+		}
+		if values == nil {
+			return nil
+		}
+		return mpopReply(key, reply.MakeMultiBulkReply(values))
+	}
+	result := db.block(c, &blockingWait{
+		keys:      keys,
+		writeKeys: keys,
+		timeout:   timeout,
+		consume:   true,
+	}, try)
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+func init() {
+	// LPUSH k1 v1 v2 ...
+	RegisterCommand("LPush", execLPush, writeFirstKey, -3)
+	RegisterCommand("LPushX", execLPushX, writeFirstKey, -3)
+	RegisterCommand("RPush", execRPush, writeFirstKey, -3)
+	RegisterCommand("RPushX", execRPushX, writeFirstKey, -3)
+	// LPOP k1 [count]
+	RegisterCommand("LPop", execLPop, writeFirstKey, -2)
+	RegisterCommand("RPop", execRPop, writeFirstKey, -2)
+	// LRANGE k1 0 -1
+	RegisterCommand("LRange", execLRange, readFirstKey, 4)
+	RegisterCommand("LIndex", execLIndex, readFirstKey, 3)
+	RegisterCommand("LSet", execLSet, writeFirstKey, 4)
+	RegisterCommand("LRem", execLRem, writeFirstKey, 4)
+	// LINSERT k1 BEFORE|AFTER pivot v1
+	RegisterCommand("LInsert", execLInsert, writeFirstKey, 5)
+	RegisterCommand("LTrim", execLTrim, writeFirstKey, 4)
+	RegisterCommand("LLen", execLLen, readFirstKey, 2)
+	// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+	RegisterCommand("LMove", execLMove, writeTwoKeys, 5)
+	// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+	RegisterCommand("LMPop", execLMPop, writeNumKeys, -4)
+	// 阻塞命令
+	RegisterBlockingCommand("BLPop", execBLPop, writeKeysExceptLast, -3)
+	RegisterBlockingCommand("BRPop", execBRPop, writeKeysExceptLast, -3)
+	RegisterBlockingCommand("BLMove", execBLMove, writeTwoKeys, 6)
+	RegisterBlockingCommand("BLMPop", execBLMPop, writeNumKeysAfterTimeout, -5)
+}
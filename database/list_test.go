@@ -0,0 +1,108 @@
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+)
+
+func TestListPushPop(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"rpush l a b c", reply.MakeIntReply(3)},
+		{"lpush l x y", reply.MakeIntReply(5)},
+		{"lrange l 0 -1", bulks("y", "x", "a", "b", "c")},
+		{"lrange l -2 100", bulks("b", "c")},
+		{"lrange l 3 1", reply.MakeEmptyMultiBulkReply()},
+		{"lindex l 0", reply.MakeBulkReply([]byte("y"))},
+		{"lindex l -1", reply.MakeBulkReply([]byte("c"))},
+		{"lindex l 10", reply.MakeNullBulkReply()},
+		{"llen l", reply.MakeIntReply(5)},
+		{"lpop l", reply.MakeBulkReply([]byte("y"))},
+		{"rpop l 2", bulks("c", "b")},
+		{"lrange l 0 -1", bulks("x", "a")},
+		{"lpop none", reply.MakeNullBulkReply()},
+		{"llen none", reply.MakeIntReply(0)},
+		// 只有 key 存在时才插入
+		{"lpushx none a", reply.MakeIntReply(0)},
+		{"exists none", reply.MakeIntReply(0)},
+		{"rpushx l z", reply.MakeIntReply(3)},
+		// 弹出最后的元素之后删除 key
+		{"rpop l 10", bulks("z", "a", "x")},
+		{"exists l", reply.MakeIntReply(0)},
+		{"set s v", reply.MakeOkReply()},
+		{"lpush s a", &reply.WrongTypeErrReply{}},
+		{"lrange s 0 -1", &reply.WrongTypeErrReply{}},
+	})
+}
+
+func TestListModify(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"rpush l x a z", reply.MakeIntReply(3)},
+		{"lset l 1 A", reply.MakeOkReply()},
+		{"lset l 10 v", reply.MakeErrReply("ERR index out of range")},
+		{"lset none 0 v", reply.MakeErrReply("ERR no such key")},
+		{"linsert l before A m", reply.MakeIntReply(4)},
+		{"linsert l after z n", reply.MakeIntReply(5)},
+		{"linsert l after nope v", reply.MakeIntReply(-1)},
+		{"linsert none before a b", reply.MakeIntReply(0)},
+		{"linsert l middle A v", reply.MakeSyntaxErrReply()},
+		{"lrange l 0 -1", bulks("x", "m", "A", "z", "n")},
+
+		{"rpush r a b a c a", reply.MakeIntReply(5)},
+		{"lrem r 2 a", reply.MakeIntReply(2)},
+		{"lrange r 0 -1", bulks("b", "c", "a")},
+		{"lrem r -1 a", reply.MakeIntReply(1)},
+		{"lrem r 0 b", reply.MakeIntReply(1)},
+		{"lrange r 0 -1", bulks("c")},
+
+		{"rpush t 1 2 3 4 5", reply.MakeIntReply(5)},
+		{"ltrim t 1 -2", reply.MakeOkReply()},
+		{"lrange t 0 -1", bulks("2", "3", "4")},
+		{"ltrim t 5 10", reply.MakeOkReply()},
+		{"exists t", reply.MakeIntReply(0)},
+	})
+}
+
+func TestListMove(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"rpush src a b c", reply.MakeIntReply(3)},
+		{"lmove src dst right left", reply.MakeBulkReply([]byte("c"))},
+		{"lmove src dst left right", reply.MakeBulkReply([]byte("a"))},
+		{"lrange dst 0 -1", bulks("c", "a")},
+		// 源和目标相同时旋转列表
+		{"lmove dst dst left right", reply.MakeBulkReply([]byte("c"))},
+		{"lrange dst 0 -1", bulks("a", "c")},
+		{"lmove none dst left left", reply.MakeNullBulkReply()},
+		{"lmpop 2 none src left count 5", reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("src")), bulks("b"),
+		})},
+		{"lmpop 2 none src left", reply.MakeNullMultiBulkReply()},
+		{"lmpop 0 src left", reply.MakeErrReply("ERR numkeys should be greater than 0")},
+		{"lmpop 1 dst right count 0", reply.MakeErrReply("ERR count should be greater than 0")},
+		// numkeys 很大时不会溢出
+		{"lmpop 9223372036854775807 src left", reply.MakeSyntaxErrReply()},
+	})
+}
+
+// 元素较多时跨越多个 quicklist 节点
+func TestLargeList(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 1000; i++ {
+		db.Exec(nil, utils.ToCmdLine("rpush", "l", strconv.Itoa(i)))
+	}
+	execCases(t, db, []cmdCase{
+		{"llen l", reply.MakeIntReply(1000)},
+		{"lindex l 500", reply.MakeBulkReply([]byte("500"))},
+		{"lrange l 498 501", bulks("498", "499", "500", "501")},
+		{"lset l 700 x", reply.MakeOkReply()},
+		{"lrem l 0 x", reply.MakeIntReply(1)},
+		{"linsert l before 0 first", reply.MakeIntReply(1000)},
+		{"ltrim l 0 1", reply.MakeOkReply()},
+		{"lrange l 0 -1", bulks("first", "0")},
+	})
+}
@@ -0,0 +1,90 @@
+// Package database -----------------------------
+// @file      : notify.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/9 10:30
+// -------------------------------------------
+package database
+
+import (
+	"strconv"
+)
+
+// 键空间通知，参考 Redis 的 notify.c
+// 修改 key 的时候发布 __keyspace@<db>__:<key> → 事件 和 __keyevent@<db>__:<事件> → key 两条消息
+
+// 事件的类型，对应 notify-keyspace-events 中的字母
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g 和类型无关的命令，如 DEL EXPIRE RENAME
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x 过期删除
+	notifyEvicted              // e 内存淘汰
+	notifyStream               // t
+	notifyKeyMiss              // m 读取不存在的 key
+	notifyNew                  // n 新建 key
+	// A 是 g$lshzxet 的别名，不包含 m 和 n
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyEvicted | notifyStream
+)
+
+// parseKeyspaceEvents 解析 notify-keyspace-events，有不认识的字母时返回 -1
+func parseKeyspaceEvents(classes string) int {
+	flags := 0
+	for _, c := range classes {
+		switch c {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 't':
+			flags |= notifyStream
+		case 'm':
+			flags |= notifyKeyMiss
+		case 'n':
+			flags |= notifyNew
+		default:
+			return -1
+		}
+	}
+	return flags
+}
+
+// notifyKeyspaceEvent 按照配置发布键空间通知，typ 为事件的类型
+func (db *DB) notifyKeyspaceEvent(typ int, event string, key string) {
+	flags := db.notifyFlags
+	// 默认关闭
+	if flags&typ == 0 {
+		return
+	}
+	index := strconv.Itoa(db.index)
+	if flags&notifyKeyspace != 0 {
+		db.publish([]byte("__keyspace@"+index+"__:"+key), []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		db.publish([]byte("__keyevent@"+index+"__:"+event), []byte(key))
+	}
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscriberConn 记录推送给订阅者的消息
+type subscriberConn struct {
+	connection.Connection
+	written chan []byte
+}
+
+func (c *subscriberConn) Write(b []byte) error {
+	c.written <- b
+	return nil
+}
+
+func (c *subscriberConn) WriteTimeout(b []byte, timeout time.Duration) error {
+	return c.Write(b)
+}
+
+// expectMessages 依次检查收到的消息，每条消息为 channel 和 message
+func (c *subscriberConn) expectMessages(t *testing.T, expected ...string) {
+	t.Helper()
+	for i := 0; i+1 < len(expected); i += 2 {
+		select {
+		case b := <-c.written:
+			msg := string(b)
+			if !strings.Contains(msg, "\r\n"+expected[i]+"\r\n") || !strings.HasSuffix(msg, "\r\n"+expected[i+1]+"\r\n") {
+				t.Fatalf("expected %s %s, actually %q", expected[i], expected[i+1], msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s %s, actually nothing", expected[i], expected[i+1])
+		}
+	}
+	select {
+	case b := <-c.written:
+		t.Fatalf("unexpected message %q", string(b))
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func setKeyspaceEvents(classes string) func() {
+	old := config.Properties.NotifyKeyspaceEvents
+	config.Properties.NotifyKeyspaceEvents = classes
+	return func() {
+		config.Properties.NotifyKeyspaceEvents = old
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	defer setKeyspaceEvents("KEA")()
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	sub := &subscriberConn{written: make(chan []byte, 100)}
+	database.Exec(sub, utils.ToCmdLine("psubscribe", "__key*__:*"))
+	sub.expectMessages(t, "psubscribe", ":1")
+
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1", "ex", "100"))
+	sub.expectMessages(t,
+		"__keyspace@0__:a", "set", "__keyevent@0__:set", "a",
+		"__keyspace@0__:a", "expire", "__keyevent@0__:expire", "a")
+	database.Exec(conn, utils.ToCmdLine("incr", "a"))
+	sub.expectMessages(t, "__keyspace@0__:a", "incrby", "__keyevent@0__:incrby", "a")
+	database.Exec(conn, utils.ToCmdLine("rename", "a", "b"))
+	sub.expectMessages(t,
+		"__keyspace@0__:a", "rename_from", "__keyevent@0__:rename_from", "a",
+		"__keyspace@0__:b", "rename_to", "__keyevent@0__:rename_to", "b")
+	database.Exec(conn, utils.ToCmdLine("del", "b", "c"))
+	sub.expectMessages(t, "__keyspace@0__:b", "del", "__keyevent@0__:del", "b")
+	// 失败的命令不发送通知
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1", "xx"))
+	database.Exec(conn, utils.ToCmdLine("get", "a"))
+	sub.expectMessages(t)
+
+	database.Exec(conn, utils.ToCmdLine("select", "1"))
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1", "px", "1"))
+	sub.expectMessages(t,
+		"__keyspace@1__:a", "set", "__keyevent@1__:set", "a",
+		"__keyspace@1__:a", "expire", "__keyevent@1__:expire", "a")
+	time.Sleep(5 * time.Millisecond)
+	database.Exec(conn, utils.ToCmdLine("ttl", "a"))
+	sub.expectMessages(t, "__keyspace@1__:a", "expired", "__keyevent@1__:expired", "a")
+}
+
+func TestKeyspaceEventsFlags(t *testing.T) {
+	if flags := parseKeyspaceEvents("KEA"); flags&notifyKeyMiss != 0 || flags&notifyExpired == 0 {
+		t.Errorf("unexpected flags %b", flags)
+	}
+	if flags := parseKeyspaceEvents("Kq"); flags != -1 {
+		t.Errorf("expected invalid, actually %b", flags)
+	}
+
+	defer setKeyspaceEvents("Exm")()
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	sub := &subscriberConn{written: make(chan []byte, 100)}
+	database.Exec(sub, utils.ToCmdLine("psubscribe", "__key*__:*"))
+	sub.expectMessages(t, "psubscribe", ":1")
+	// 只有 keyevent 的 expired 和 keymiss
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1", "px", "1"))
+	database.Exec(conn, utils.ToCmdLine("del", "b"))
+	sub.expectMessages(t)
+	time.Sleep(5 * time.Millisecond)
+	database.Exec(conn, utils.ToCmdLine("get", "a"))
+	sub.expectMessages(t, "__keyevent@0__:expired", "a", "__keyevent@0__:keymiss", "a")
+}
+
+// 集合类型的命令按照类型发送通知，最后一个元素被删除时发送 del
+func TestTypeKeyspaceEvents(t *testing.T) {
+	defer setKeyspaceEvents("El$shzt")()
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	sub := &subscriberConn{written: make(chan []byte, 100)}
+	database.Exec(sub, utils.ToCmdLine("psubscribe", "__keyevent@0__:*"))
+	sub.expectMessages(t, "psubscribe", ":1")
+
+	database.Exec(conn, utils.ToCmdLine("rpush", "l", "a", "b"))
+	sub.expectMessages(t, "__keyevent@0__:rpush", "l")
+	database.Exec(conn, utils.ToCmdLine("lpop", "l"))
+	sub.expectMessages(t, "__keyevent@0__:lpop", "l")
+	// 没有 g，弹出最后一个元素时不发送 del
+	database.Exec(conn, utils.ToCmdLine("rpop", "l"))
+	sub.expectMessages(t, "__keyevent@0__:rpop", "l")
+
+	database.Exec(conn, utils.ToCmdLine("sadd", "s", "a"))
+	sub.expectMessages(t, "__keyevent@0__:sadd", "s")
+	// 没有添加元素时不发送通知
+	database.Exec(conn, utils.ToCmdLine("sadd", "s", "a"))
+	sub.expectMessages(t)
+	database.Exec(conn, utils.ToCmdLine("hset", "h", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("hdel", "h", "f"))
+	sub.expectMessages(t, "__keyevent@0__:hset", "h", "__keyevent@0__:hdel", "h")
+	database.Exec(conn, utils.ToCmdLine("zadd", "z", "1", "a"))
+	database.Exec(conn, utils.ToCmdLine("zincrby", "z", "1", "a"))
+	sub.expectMessages(t, "__keyevent@0__:zadd", "z", "__keyevent@0__:zincr", "z")
+	database.Exec(conn, utils.ToCmdLine("xadd", "x", "*", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("xgroup", "create", "x", "g", "0"))
+	sub.expectMessages(t, "__keyevent@0__:xadd", "x", "__keyevent@0__:xgroup-create", "x")
+}
+
+// 没有开启的类型不发送通知
+func TestTypeKeyspaceEventsFilter(t *testing.T) {
+	defer setKeyspaceEvents("Klg")()
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	sub := &subscriberConn{written: make(chan []byte, 100)}
+	database.Exec(sub, utils.ToCmdLine("psubscribe", "__key*__:*"))
+	sub.expectMessages(t, "psubscribe", ":1")
+
+	database.Exec(conn, utils.ToCmdLine("sadd", "s", "a"))
+	database.Exec(conn, utils.ToCmdLine("hset", "h", "f", "v"))
+	sub.expectMessages(t)
+	database.Exec(conn, utils.ToCmdLine("lpush", "l", "a"))
+	database.Exec(conn, utils.ToCmdLine("lpop", "l"))
+	sub.expectMessages(t,
+		"__keyspace@0__:l", "lpush",
+		"__keyspace@0__:l", "lpop",
+		"__keyspace@0__:l", "del")
+	// 集合类型的最后一个元素被删除时，没有开启 s 也会发送 del
+	database.Exec(conn, utils.ToCmdLine("srem", "s", "a"))
+	sub.expectMessages(t, "__keyspace@0__:s", "del")
+}
+
+// 管道中订阅模式下的回复和之后直接写回的回复按照命令的顺序到达
+func TestSubscribeReplyOrder(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	sub := &subscriberConn{written: make(chan []byte, 100)}
+	// 和 handler 一样，非空的回复直接写回
+	exec := func(args ...string) {
+		if result := database.Exec(sub, utils.ToCmdLine(args...)); len(result.ToBytes()) > 0 {
+			_ = sub.Write(result.ToBytes())
+		}
+	}
+	exec("subscribe", "ch")
+	exec("ping")
+	exec("get", "x")
+	exec("unsubscribe")
+	exec("get", "x")
+	for _, expected := range []string{
+		"*3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n",
+		"*2\r\n$4\r\npong\r\n$0\r\n\r\n",
+		"-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n",
+		"*3\r\n$11\r\nunsubscribe\r\n$2\r\nch\r\n:0\r\n",
+		"$-1\r\n",
+	} {
+		select {
+		case b := <-sub.written:
+			if string(b) != expected {
+				t.Fatalf("expected %q, actually %q", expected, string(b))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q, actually nothing", expected)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Package database -----------------------------
+// @file      : rdb.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 17:30
+// -------------------------------------------
+package database
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"redis-go/aof"
+	dbface "redis-go/interface/database"
+	"redis-go/lib/config"
+	"redis-go/lib/logger"
+	"redis-go/rdb"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RDB 持久化，SAVE 在前台保存，BGSAVE 和满足 save 规则时在后台保存，保存期间不阻塞写命令，见 snapshot.go
+
+const (
+	defaultRdbFilename = "dump.rdb"
+	// 检查 save 规则的间隔，和 Redis 默认的 hz 10 一样
+	saveCheckInterval = 100 * time.Millisecond
+	// BGSAVE 失败之后至少间隔这么久才按照 save 规则重试，和 Redis 一样
+	bgsaveRetryDelay = 5 * time.Second
+)
+
+var errSaveInProgress = errors.New("ERR Background save already in progress")
+
+// saveRule save <seconds> <changes>，seconds 秒内至少有 changes 次修改时保存
+type saveRule struct {
+	seconds int64
+	changes int64
+}
+
+// parseSaveRules 解析 "900 1 300 10" 这样的配置，格式不正确时忽略
+func parseSaveRules(value string) []saveRule {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		logger.Warn("invalid save rules: " + value)
+		return nil
+	}
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			logger.Warn("invalid save rules: " + value)
+			return nil
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules
+}
+
+// rdbState RDB 持久化的状态，INFO persistence 展示
+type rdbState struct {
+	mu        sync.Mutex
+	saving    bool
+	saveStart time.Time
+	// 上次成功保存的时间，启动时为启动的时间
+	lastSave time.Time
+	// 上次 BGSAVE 开始的时间、结果和耗时，没有执行过时耗时为 -1
+	lastBgsaveTry    time.Time
+	lastBgsaveFailed bool
+	lastBgsaveTime   time.Duration
+	// 等待后台保存结束
+	wg sync.WaitGroup
+}
+
+func rdbFilename() string {
+	if config.Properties.DBFilename == "" {
+		return defaultRdbFilename
+	}
+	return config.Properties.DBFilename
+}
+
+// save SAVE，在当前 goroutine 中保存
+func (database *StandaloneDatabase) save() error {
+	snapshots, dirty, err := database.startSave(false)
+	if err != nil {
+		return err
+	}
+	err = database.writeRdb(snapshots)
+	database.finishSave(dirty, false, err)
+	return err
+}
+
+// backgroundSave BGSAVE，在后台保存，已经在保存时返回 errSaveInProgress
+func (database *StandaloneDatabase) backgroundSave() error {
+	snapshots, dirty, err := database.startSave(true)
+	if err != nil {
+		return err
+	}
+	logger.Info("Background saving started")
+	database.rdbState.wg.Add(1)
+	go func() {
+		defer database.rdbState.wg.Done()
+		database.finishSave(dirty, true, database.writeRdb(snapshots))
+	}()
+	return nil
+}
+
+// startSave 在所有 db 上开始快照，返回快照和快照时的修改次数
+func (database *StandaloneDatabase) startSave(background bool) ([]*snapshot, int64, error) {
+	state := &database.rdbState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.saving {
+		return nil, 0, errSaveInProgress
+	}
+	state.saving = true
+	state.saveStart = time.Now()
+	if background {
+		state.lastBgsaveTry = state.saveStart
+	}
+
+	// 独占所有 db，快照开始时没有正在执行的写命令
+	for _, db := range database.dbSet {
+		db.mu.Lock()
+	}
+	dirty := atomic.LoadInt64(&database.dirty)
+	snapshots := make([]*snapshot, len(database.dbSet))
+	for i, db := range database.dbSet {
+		snapshots[i] = db.startSnapshot()
+	}
+	for _, db := range database.dbSet {
+		db.mu.Unlock()
+	}
+	return snapshots, dirty, nil
+}
+
+// finishSave 记录保存的结果，成功时减去快照之前的修改次数
+func (database *StandaloneDatabase) finishSave(dirty int64, background bool, err error) {
+	state := &database.rdbState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.saving = false
+	if background {
+		state.lastBgsaveFailed = err != nil
+		state.lastBgsaveTime = time.Since(state.saveStart)
+	}
+	if err != nil {
+		logger.Error("Failed saving the DB: " + err.Error())
+		return
+	}
+	atomic.AddInt64(&database.dirty, -dirty)
+	state.lastSave = time.Now()
+	logger.Info("DB saved on disk")
+}
+
+// writeRdb 把快照写入临时文件，完成之后原子地替换旧文件
+func (database *StandaloneDatabase) writeRdb(snapshots []*snapshot) (err error) {
+	// 出错时也要结束所有的快照，否则之后的修改会一直保存旧值
+	defer func() {
+		for _, db := range database.dbSet {
+			db.endSnapshot()
+		}
+	}()
+	filename := rdbFilename()
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+
+	writer := bufio.NewWriter(tmpFile)
+	enc := rdb.NewEncoder(writer)
+	if err = enc.WriteHeader(); err != nil {
+		return err
+	}
+	for _, aux := range [][2]string{
+		{"redis-ver", "7.2.0"},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"aof-base", "0"},
+	} {
+		if err = enc.WriteAux(aux[0], aux[1]); err != nil {
+			return err
+		}
+	}
+	for i, db := range database.dbSet {
+		if err = db.saveSnapshot(enc, snapshots[i]); err != nil {
+			return err
+		}
+	}
+	if err = enc.WriteEnd(); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// loadRdb 启动时加载 RDB 文件，文件不存在时返回 false
+func (database *StandaloneDatabase) loadRdb() (bool, error) {
+	file, err := os.Open(rdbFilename())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	now := time.Now()
+	err = rdb.NewDecoder(file).Decode(func(dbIndex int, key string, entity *dbface.DataEntity, expiration *time.Time) error {
+		if dbIndex >= len(database.dbSet) {
+			return fmt.Errorf("FATAL: Data file was created with a Redis server configured to handle more than %d databases", len(database.dbSet))
+		}
+		// 和 Redis 一样不加载已经过期的 key
+		if expiration != nil && now.After(*expiration) {
+			return nil
+		}
+		db := database.dbSet[dbIndex]
+		db.PutEntity(key, entity)
+		if expiration != nil {
+			db.Expire(key, *expiration)
+		}
+		db.updateMemory(key)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	logger.Info("DB loaded from disk")
+	return true, nil
+}
+
+// writeLoadedToAof 开启了 AOF 但是数据是从 RDB 加载的，把数据写入新的 AOF 文件
+// 否则下次启动时只加载 AOF 会丢失这些数据
+func (database *StandaloneDatabase) writeLoadedToAof() {
+	for i := range database.dbSet {
+		dbIndex := i
+		database.ForEach(dbIndex, func(key string, entity *dbface.DataEntity, expiration *time.Time) bool {
+			for _, cmdLine := range aof.EntityToCmd(key, entity) {
+				database.aofHandler.AddAof(dbIndex, cmdLine)
+			}
+			if expiration != nil {
+				database.aofHandler.AddAof(dbIndex, aof.ExpireToCmd(key, *expiration))
+			}
+			return true
+		})
+	}
+}
+
+// saveLoop 定期检查 save 规则，满足任意一条时开始 BGSAVE
+func (database *StandaloneDatabase) saveLoop() {
+	ticker := time.NewTicker(saveCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			database.checkSaveRules()
+		case <-database.closed:
+			return
+		}
+	}
+}
+
+func (database *StandaloneDatabase) checkSaveRules() {
+	dirty := atomic.LoadInt64(&database.dirty)
+	state := &database.rdbState
+	state.mu.Lock()
+	saving := state.saving
+	lastSave := state.lastSave
+	canRetry := !state.lastBgsaveFailed || time.Since(state.lastBgsaveTry) > bgsaveRetryDelay
+	state.mu.Unlock()
+	if saving || !canRetry {
+		return
+	}
+	for _, rule := range database.saveRules {
+		if dirty >= rule.changes && time.Since(lastSave) >= time.Duration(rule.seconds)*time.Second {
+			logger.Info(fmt.Sprintf("%d changes in %d seconds. Saving...", rule.changes, rule.seconds))
+			_ = database.backgroundSave()
+			return
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setRdbFile RDB 文件写入临时目录，返回恢复配置的函数
+func setRdbFile(t *testing.T) func() {
+	old := *config.Properties
+	config.Properties.DBFilename = filepath.Join(t.TempDir(), "dump.rdb")
+	return func() {
+		*config.Properties = old
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	defer setRdbFile(t)()
+	database := NewStandaloneDatabase()
+	conn := &connection.Connection{}
+	for i := 0; i < 100; i++ {
+		database.Exec(conn, utils.ToCmdLine("rpush", "list", strconv.Itoa(i)))
+	}
+	database.Exec(conn, utils.ToCmdLine("set", "k", "v"))
+	database.Exec(conn, utils.ToCmdLine("hset", "hash", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("zadd", "zset", "1.5", "a", "2", "b"))
+	database.Exec(conn, utils.ToCmdLine("sadd", "set", "a", "b", "c"))
+	database.Exec(conn, utils.ToCmdLine("set", "ttl", "v", "ex", "1000"))
+	database.Exec(conn, utils.ToCmdLine("set", "expired", "v", "px", "1"))
+	database.Exec(conn, utils.ToCmdLine("xadd", "stream", "1-1", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("xgroup", "create", "stream", "g", "0"))
+	database.Exec(conn, utils.ToCmdLine("xreadgroup", "group", "g", "c", "streams", "stream", ">"))
+	conn.SelectDB(3)
+	database.Exec(conn, utils.ToCmdLine("set", "db3", "v"))
+	time.Sleep(2 * time.Millisecond)
+
+	result := database.Exec(conn, utils.ToCmdLine("save"))
+	if !utils.BytesEquals(result.ToBytes(), reply.MakeOkReply().ToBytes()) {
+		t.Fatal(string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("lastsave"))
+	if intResult, ok := result.(*reply.IntReply); !ok || time.Now().Unix()-intResult.Code > 1 {
+		t.Errorf("expected last save time, actually %q", result.ToBytes())
+	}
+	result = database.Exec(conn, utils.ToCmdLine("info", "persistence"))
+	if !strings.Contains(string(result.ToBytes()), "rdb_changes_since_last_save:0") {
+		t.Errorf("expected no changes since last save, actually %q", result.ToBytes())
+	}
+	database.Close()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	for _, cmdLine := range [][]string{
+		{"get", "k"},
+		{"lrange", "list", "0", "-1"},
+		{"hgetall", "hash"},
+		{"zrange", "zset", "0", "-1", "withscores"},
+		{"smismember", "set", "a", "b", "c", "d"},
+		{"xpending", "stream", "g"},
+		{"exists", "expired"},
+	} {
+		expected := database.Exec(&connection.Connection{}, utils.ToCmdLine(cmdLine...))
+		actual := loaded.Exec(&connection.Connection{}, utils.ToCmdLine(cmdLine...))
+		if !utils.BytesEquals(expected.ToBytes(), actual.ToBytes()) {
+			t.Errorf("%v: expected %q, actually %q", cmdLine, expected.ToBytes(), actual.ToBytes())
+		}
+	}
+	result = loaded.Exec(&connection.Connection{}, utils.ToCmdLine("ttl", "ttl"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code <= 0 {
+		t.Errorf("expected ttl, actually %q", result.ToBytes())
+	}
+	loadedConn := &connection.Connection{}
+	loadedConn.SelectDB(3)
+	result = loaded.Exec(loadedConn, utils.ToCmdLine("get", "db3"))
+	if !utils.BytesEquals(result.ToBytes(), reply.MakeBulkReply([]byte("v")).ToBytes()) {
+		t.Errorf("expected v, actually %q", result.ToBytes())
+	}
+}
+
+// BGSAVE 期间的写入不会出现在快照中
+func TestBGSaveConcurrentWrites(t *testing.T) {
+	defer setRdbFile(t)()
+	database := NewStandaloneDatabase()
+	conn := &connection.Connection{}
+	const n = 5000
+	for i := 0; i < n; i++ {
+		database.Exec(conn, utils.ToCmdLine("rpush", "k"+strconv.Itoa(i), "old"))
+	}
+	result := database.Exec(conn, utils.ToCmdLine("bgsave"))
+	if string(result.ToBytes()) != "+Background saving started\r\n" {
+		t.Fatal(string(result.ToBytes()))
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			c := &connection.Connection{}
+			for i := w; i < n; i += 4 {
+				key := "k" + strconv.Itoa(i)
+				switch i % 3 {
+				case 0:
+					database.Exec(c, utils.ToCmdLine("rpush", key, "new"))
+				case 1:
+					database.Exec(c, utils.ToCmdLine("del", key))
+				default:
+					database.Exec(c, utils.ToCmdLine("set", "new"+strconv.Itoa(i), "v"))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	waitSave(t, database)
+	database.Close()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	for i := 0; i < n; i++ {
+		result = loaded.Exec(conn, utils.ToCmdLine("lrange", "k"+strconv.Itoa(i), "0", "-1"))
+		if !utils.BytesEquals(result.ToBytes(), reply.MakeMultiBulkReply(utils.ToCmdLine("old")).ToBytes()) {
+			t.Fatalf("k%d: expected [old], actually %q", i, result.ToBytes())
+		}
+	}
+	result = loaded.Exec(conn, utils.ToCmdLine("keys", "*"))
+	if multiResult, ok := result.(*reply.MultiBulkReply); !ok || len(multiResult.Args) != n {
+		t.Errorf("expected %d keys, actually %q", n, result.ToBytes())
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	defer setRdbFile(t)()
+	config.Properties.Save = "3600 1 60 5"
+	database := NewStandaloneDatabase()
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("set", "k", "v"))
+	// 只有一次修改，两条规则的时间都还没到
+	time.Sleep(3 * saveCheckInterval)
+	if _, err := os.Stat(config.Properties.DBFilename); err == nil {
+		t.Fatal("unexpected save")
+	}
+	database.rdbState.mu.Lock()
+	database.rdbState.lastSave = time.Now().Add(-time.Hour)
+	database.rdbState.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(config.Properties.DBFilename); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected automatic save")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitSave(t, database)
+	// 关闭时再保存一次
+	database.Exec(conn, utils.ToCmdLine("set", "k", "v2"))
+	database.Close()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	result := loaded.Exec(conn, utils.ToCmdLine("get", "k"))
+	if !utils.BytesEquals(result.ToBytes(), reply.MakeBulkReply([]byte("v2")).ToBytes()) {
+		t.Errorf("expected v2, actually %q", result.ToBytes())
+	}
+}
+
+// 开启 AOF 但是没有 AOF 文件时从 RDB 恢复，并把数据写入 AOF
+func TestLoadRdbWithoutAof(t *testing.T) {
+	defer setRdbFile(t)()
+	database := NewStandaloneDatabase()
+	database.Exec(&connection.Connection{}, utils.ToCmdLine("set", "k", "v"))
+	database.Exec(&connection.Connection{}, utils.ToCmdLine("save"))
+	database.Close()
+
+	defer setAppendOnly(t)()
+	loaded := NewStandaloneDatabase()
+	loaded.Close()
+	if err := os.Remove(config.Properties.DBFilename); err != nil {
+		t.Fatal(err)
+	}
+	fromAof := NewStandaloneDatabase()
+	defer fromAof.Close()
+	result := fromAof.Exec(&connection.Connection{}, utils.ToCmdLine("get", "k"))
+	if !utils.BytesEquals(result.ToBytes(), reply.MakeBulkReply([]byte("v")).ToBytes()) {
+		t.Errorf("expected v, actually %q", result.ToBytes())
+	}
+}
+
+// waitSave 等待后台保存完成
+func waitSave(t *testing.T, database *StandaloneDatabase) {
+	database.rdbState.wg.Wait()
+	database.rdbState.mu.Lock()
+	defer database.rdbState.mu.Unlock()
+	if database.rdbState.lastBgsaveFailed {
+		t.Fatal("bgsave failed")
+	}
+}
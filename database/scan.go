@@ -0,0 +1,206 @@
+// Package database -----------------------------
+// @file      : scan.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/3 16:10
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/lib/wildcard"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// 每次遍历的默认数量，和 Redis 一致
+const defaultScanCount = 10
+
+type scanArgs struct {
+	cursor  int
+	pattern *wildcard.Pattern
+	count   int
+	// SCAN 的 TYPE 选项
+	typ string
+	// HSCAN 的 NOVALUES 或者 ZSCAN 的 NOSCORES
+	noValues bool
+}
+
+// parseScanArgs 解析 cursor [MATCH pattern] [COUNT count]，allowType 表示支持 TYPE，noValuesFlag 为空表示不支持
+func parseScanArgs(args [][]byte, allowType bool, noValuesFlag string) (*scanArgs, reply.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, reply.MakeErrReply("ERR invalid cursor")
+	}
+	result := &scanArgs{
+		count: defaultScanCount,
+	}
+	// 超出范围的游标直接结束遍历
+	if cursor > math.MaxInt32 {
+		result.cursor = -1
+	} else {
+		result.cursor = int(cursor)
+	}
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "MATCH" && i+1 < len(args):
+			result.pattern = wildcard.CompilePattern(string(args[i+1]))
+			i++
+		case option == "COUNT" && i+1 < len(args):
+			count, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			if count < 1 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			result.count = int(count)
+			i++
+		case option == "TYPE" && allowType && i+1 < len(args):
+			result.typ = strings.ToLower(string(args[i+1]))
+			i++
+		case noValuesFlag != "" && option == noValuesFlag:
+			result.noValues = true
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return result, nil
+}
+
+func (scan *scanArgs) match(s string) bool {
+	return scan.pattern == nil || scan.pattern.IsMatch(s)
+}
+
+// makeScanReply [cursor, [element ...]]
+func makeScanReply(cursor int, elements [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.Itoa(cursor))),
+		reply.MakeMultiBulkReply(elements),
+	})
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 游标是 ConcurrentDict 的 shard 下标，每次遍历完整的 shard，不需要在服务端保存状态
+// shard 的数量固定，遍历期间一直存在的 key 一定会被返回，即使期间写入了新的 key
+func execScan(db *DB, args [][]byte) resp.Reply {
+	scan, errReply := parseScanArgs(args, true, "")
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	if scan.cursor < 0 {
+		return makeScanReply(0, result)
+	}
+	next := db.data.Scan(scan.cursor, scan.count, func(key string, val interface{}) bool {
+		if !scan.match(key) || db.ttlExpired(key) {
+			return true
+		}
+		if scan.typ != "" {
+			entity, _ := val.(*database.DataEntity)
+			if entity == nil || typeName(entity) != scan.typ {
+				return true
+			}
+		}
+		result = append(result, []byte(key))
+		return true
+	})
+	return makeScanReply(next, result)
+}
+
+// 和 Redis 一样，紧凑编码的 value 一次返回所有的元素，游标为 0，其他编码按照 COUNT 分批返回
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+// 哈希表编码的游标是 shard 的下标
+func execHScan(db *DB, args [][]byte) resp.Reply {
+	scan, errReply := parseScanArgs(args[1:], false, "NOVALUES")
+	if errReply != nil {
+		return errReply
+	}
+	hash, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	if hash == nil || scan.cursor < 0 {
+		return makeScanReply(0, result)
+	}
+	next := hash.Scan(scan.cursor, scan.count, func(field string, value []byte) bool {
+		if scan.match(field) {
+			result = append(result, []byte(field))
+			if !scan.noValues {
+				result = append(result, value)
+			}
+		}
+		return true
+	})
+	return makeScanReply(next, result)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+// 哈希表编码的游标是 shard 的下标
+func execSScan(db *DB, args [][]byte) resp.Reply {
+	scan, errReply := parseScanArgs(args[1:], false, "")
+	if errReply != nil {
+		return errReply
+	}
+	set, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	if set == nil || scan.cursor < 0 {
+		return makeScanReply(0, result)
+	}
+	next := set.Scan(scan.cursor, scan.count, func(member string) bool {
+		if scan.match(member) {
+			result = append(result, []byte(member))
+		}
+		return true
+	})
+	return makeScanReply(next, result)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count] [NOSCORES]
+// 元素较少时一次返回所有元素，否则游标是字典 shard 的下标
+func execZScan(db *DB, args [][]byte) resp.Reply {
+	scan, errReply := parseScanArgs(args[1:], false, "NOSCORES")
+	if errReply != nil {
+		return errReply
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	if sortedSet == nil || scan.cursor < 0 {
+		return makeScanReply(0, result)
+	}
+	next := sortedSet.Scan(scan.cursor, scan.count, func(element *SortedSet.Element) bool {
+		if scan.match(element.Member) {
+			result = append(result, []byte(element.Member))
+			if !scan.noValues {
+				result = append(result, []byte(utils.FormatScore(element.Score)))
+			}
+		}
+		return true
+	})
+	return makeScanReply(next, result)
+}
+
+func init() {
+	// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+	RegisterCommand("Scan", execScan, noPrepare, -2)
+	// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+	RegisterCommand("HScan", execHScan, readFirstKey, -3)
+	// SSCAN key cursor [MATCH pattern] [COUNT count]
+	RegisterCommand("SScan", execSScan, readFirstKey, -3)
+	// ZSCAN key cursor [MATCH pattern] [COUNT count] [NOSCORES]
+	RegisterCommand("ZScan", execZScan, readFirstKey, -3)
+}
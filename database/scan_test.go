@@ -0,0 +1,227 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// scanAll 用 SCAN 遍历所有的 key，返回每个 key 出现的次数
+func scanAll(t *testing.T, db *DB, args ...string) map[string]int {
+	seen := make(map[string]int)
+	cursor := "0"
+	for {
+		cmdLine := utils.ToCmdLine(append([]string{"scan", cursor}, args...)...)
+		result, ok := db.Exec(nil, cmdLine).(*reply.MultiRawReply)
+		if !ok {
+			t.Fatalf("expected multi raw reply")
+		}
+		cursor = string(result.Replies[0].(*reply.BulkReply).Arg)
+		for _, key := range result.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(key)]++
+		}
+		if cursor == "0" {
+			return seen
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 1000; i++ {
+		db.Exec(nil, utils.ToCmdLine("set", "str:"+strconv.Itoa(i), "v"))
+	}
+	for i := 0; i < 100; i++ {
+		db.Exec(nil, utils.ToCmdLine("sadd", "set:"+strconv.Itoa(i), "m"))
+	}
+	seen := scanAll(t, db, "count", "50")
+	if len(seen) != 1100 {
+		t.Fatalf("expected 1100, actually %d", len(seen))
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Fatalf("key %s returned %d times", key, count)
+		}
+	}
+	if seen = scanAll(t, db, "match", "set:*"); len(seen) != 100 {
+		t.Errorf("expected 100, actually %d", len(seen))
+	}
+	if seen = scanAll(t, db, "type", "set", "count", "1000"); len(seen) != 100 {
+		t.Errorf("expected 100, actually %d", len(seen))
+	}
+	if seen = scanAll(t, db, "match", "str:1*", "type", "string"); len(seen) != 111 {
+		t.Errorf("expected 111, actually %d", len(seen))
+	}
+	result := db.Exec(nil, utils.ToCmdLine("scan", "0", "count", "0"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("scan", "abc"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestScanWhileWriting(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 500; i++ {
+		db.Exec(nil, utils.ToCmdLine("set", "old:"+strconv.Itoa(i), "v"))
+	}
+	seen := make(map[string]int)
+	cursor, round := "0", 0
+	for {
+		result := db.Exec(nil, utils.ToCmdLine("scan", cursor)).(*reply.MultiRawReply)
+		cursor = string(result.Replies[0].(*reply.BulkReply).Arg)
+		for _, key := range result.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(key)]++
+		}
+		// 遍历期间写入新的 key
+		for i := 0; i < 50; i++ {
+			db.Exec(nil, utils.ToCmdLine("set", "new:"+strconv.Itoa(round*50+i), "v"))
+		}
+		round++
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if seen["old:"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("old:%d not returned", i)
+		}
+	}
+}
+
+func TestValueScan(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("hset", "h", "a1", "1", "a2", "2", "b1", "3"))
+	result := db.Exec(nil, utils.ToCmdLine("hscan", "h", "0", "match", "a*"))
+	raw := result.(*reply.MultiRawReply)
+	if string(raw.Replies[0].ToBytes()) != "$1\r\n0\r\n" || len(raw.Replies[1].(*reply.MultiBulkReply).Args) != 4 {
+		t.Errorf("unexpected hscan reply %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("hscan", "h", "0", "novalues"))
+	if len(result.(*reply.MultiRawReply).Replies[1].(*reply.MultiBulkReply).Args) != 3 {
+		t.Errorf("unexpected hscan reply %s", string(result.ToBytes()))
+	}
+	db.Exec(nil, utils.ToCmdLine("sadd", "s", "1", "2", "3"))
+	result = db.Exec(nil, utils.ToCmdLine("sscan", "s", "0", "match", "[12]"))
+	if len(result.(*reply.MultiRawReply).Replies[1].(*reply.MultiBulkReply).Args) != 2 {
+		t.Errorf("unexpected sscan reply %s", string(result.ToBytes()))
+	}
+	db.Exec(nil, utils.ToCmdLine("zadd", "z", "1", "a", "2.5", "b"))
+	result = db.Exec(nil, utils.ToCmdLine("zscan", "z", "0"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$3\r\n2.5\r\n" {
+		t.Errorf("unexpected zscan reply %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("zscan", "z", "0", "noscores"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+		t.Errorf("unexpected zscan reply %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("zscan", "none", "0"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\n0\r\n*0\r\n" {
+		t.Errorf("unexpected zscan reply %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("sscan", "h", "0"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected wrong type, actually %s", string(result.ToBytes()))
+	}
+}
+
+// scanValue 用 HSCAN、SSCAN 或者 ZSCAN 遍历 key 的所有元素，返回每个元素出现的次数和遍历的轮数
+func scanValue(t *testing.T, db *DB, cmd string, key string, args ...string) (map[string]int, int) {
+	seen := make(map[string]int)
+	cursor := "0"
+	for rounds := 1; ; rounds++ {
+		cmdLine := utils.ToCmdLine(append([]string{cmd, key, cursor}, args...)...)
+		result, ok := db.Exec(nil, cmdLine).(*reply.MultiRawReply)
+		if !ok {
+			t.Fatalf("expected multi raw reply")
+		}
+		cursor = string(result.Replies[0].(*reply.BulkReply).Arg)
+		for _, element := range result.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(element)]++
+		}
+		if cursor == "0" {
+			return seen, rounds
+		}
+	}
+}
+
+// 哈希表编码按照 COUNT 分批返回，每个元素只返回一次
+func TestLargeValueScan(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 1000; i++ {
+		member := "m" + strconv.Itoa(i)
+		db.Exec(nil, utils.ToCmdLine("hset", "h", member, "v"))
+		db.Exec(nil, utils.ToCmdLine("sadd", "s", member))
+		db.Exec(nil, utils.ToCmdLine("zadd", "z", strconv.Itoa(i), member))
+	}
+	for _, c := range []struct {
+		cmd  string
+		key  string
+		args []string
+	}{
+		{"hscan", "h", []string{"count", "20", "novalues"}},
+		{"sscan", "s", []string{"count", "20"}},
+		{"zscan", "z", []string{"count", "20", "noscores"}},
+	} {
+		seen, rounds := scanValue(t, db, c.cmd, c.key, c.args...)
+		if len(seen) != 1000 {
+			t.Errorf("%s: expected 1000 elements, actually %d", c.cmd, len(seen))
+		}
+		for element, count := range seen {
+			if count != 1 {
+				t.Errorf("%s: %s returned %d times", c.cmd, element, count)
+			}
+		}
+		if rounds < 10 {
+			t.Errorf("%s: expected multiple rounds, actually %d", c.cmd, rounds)
+		}
+	}
+
+	// COUNT 很大时一次遍历完，不会溢出
+	seen, rounds := scanValue(t, db, "zscan", "z", "count", "9223372036854775807", "noscores")
+	if len(seen) != 1000 || rounds != 1 {
+		t.Errorf("expected 1000 elements in one round, actually %d in %d", len(seen), rounds)
+	}
+	// 遍历期间删除其他元素时，一直存在的元素一定会被返回
+	seen = make(map[string]int)
+	cursor := "0"
+	for i := 0; ; i++ {
+		result := db.Exec(nil, utils.ToCmdLine("zscan", "z", cursor, "count", "50", "noscores")).(*reply.MultiRawReply)
+		for _, member := range result.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(member)]++
+		}
+		// 删除分数最小的元素
+		db.Exec(nil, utils.ToCmdLine("zpopmin", "z", "10"))
+		cursor = string(result.Replies[0].(*reply.BulkReply).Arg)
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 500; i < 1000; i++ {
+		if seen["m"+strconv.Itoa(i)] != 1 {
+			t.Fatalf("m%d returned %d times", i, seen["m"+strconv.Itoa(i)])
+		}
+	}
+	// 遍历期间一直存在的元素一定会被返回
+	seen = make(map[string]int)
+	cursor = "0"
+	for i := 0; ; i++ {
+		result := db.Exec(nil, utils.ToCmdLine("sscan", "s", cursor, "count", "50")).(*reply.MultiRawReply)
+		for _, member := range result.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(member)]++
+		}
+		db.Exec(nil, utils.ToCmdLine("sadd", "s", "new"+strconv.Itoa(i)))
+		cursor = string(result.Replies[0].(*reply.BulkReply).Arg)
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if seen["m"+strconv.Itoa(i)] != 1 {
+			t.Fatalf("m%d returned %d times", i, seen["m"+strconv.Itoa(i)])
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package database -----------------------------
+// @file      : server.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/12 16:30
+// -------------------------------------------
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/config"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 和具体的 db 无关的服务器命令
+
+// isServerCommand 是否是服务器命令
+func isServerCommand(cmdName string) bool {
+	switch cmdName {
+	case "bgrewriteaof", "info", "save", "bgsave", "lastsave", "flushall":
+		return true
+	}
+	return false
+}
+
+// execServerCommand 执行服务器命令
+func execServerCommand(database *StandaloneDatabase, cmdName string, args [][]byte) resp.Reply {
+	switch cmdName {
+	case "bgrewriteaof":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execBGRewriteAof(database)
+	case "info":
+		return execInfo(database, args[1:])
+	case "save", "lastsave":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		if cmdName == "save" {
+			return execSave(database)
+		}
+		return execLastSave(database)
+	case "bgsave":
+		// BGSAVE SCHEDULE 用于等待 AOF 重写结束，这里两者可以同时进行，直接开始保存
+		if len(args) > 2 || (len(args) == 2 && strings.ToLower(string(args[1])) != "schedule") {
+			return reply.MakeSyntaxErrReply()
+		}
+		return execBGSave(database)
+	case "flushall":
+		return execFlushAll(database, args[1:])
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
+}
+
+// BGREWRITEAOF
+func execBGRewriteAof(database *StandaloneDatabase) resp.Reply {
+	if database.aofHandler == nil {
+		return reply.MakeErrReply("ERR Append only file is not enabled")
+	}
+	if err := database.aofHandler.BackgroundRewrite(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+// FLUSHALL 清空所有 db
+func execFlushAll(database *StandaloneDatabase, args [][]byte) resp.Reply {
+	// 和 BGSAVE 一样按照下标从小到大独占所有 db
+	for _, db := range database.dbSet {
+		db.mu.Lock()
+	}
+	for _, db := range database.dbSet {
+		db.Flush()
+	}
+	database.dbSet[0].addAof(utils.ToCmdLine2("flushall", args...))
+	for _, db := range database.dbSet {
+		db.mu.Unlock()
+	}
+	return reply.MakeOkReply()
+}
+
+// SAVE
+func execSave(database *StandaloneDatabase) resp.Reply {
+	if err := database.save(); err != nil {
+		if err == errSaveInProgress {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// BGSAVE [SCHEDULE]
+func execBGSave(database *StandaloneDatabase) resp.Reply {
+	if err := database.backgroundSave(); err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	return reply.MakeStatusReply("Background saving started")
+}
+
+// LASTSAVE 上次成功保存的 unix 时间戳
+func execLastSave(database *StandaloneDatabase) resp.Reply {
+	database.rdbState.mu.Lock()
+	defer database.rdbState.mu.Unlock()
+	return reply.MakeIntReply(database.rdbState.lastSave.Unix())
+}
+
+// INFO [section ...]
+// 目前只有 persistence 一节，没有指定或者指定 all、default、everything 时都返回
+func execInfo(database *StandaloneDatabase, args [][]byte) resp.Reply {
+	sections := map[string]bool{}
+	for _, arg := range args {
+		sections[strings.ToLower(string(arg))] = true
+	}
+	all := len(args) == 0 || sections["all"] || sections["default"] || sections["everything"]
+	builder := &strings.Builder{}
+	if all || sections["persistence"] {
+		writeInfoSection(builder, "Persistence", database.persistenceInfo())
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+// infoField INFO 中的一项
+type infoField struct {
+	name  string
+	value string
+}
+
+func writeInfoSection(builder *strings.Builder, title string, fields []infoField) {
+	if builder.Len() > 0 {
+		builder.WriteString("\r\n")
+	}
+	builder.WriteString("# " + title + "\r\n")
+	for _, field := range fields {
+		builder.WriteString(field.name + ":" + field.value + "\r\n")
+	}
+}
+
+// persistenceInfo INFO persistence，字段和 Redis 一致
+func (database *StandaloneDatabase) persistenceInfo() []infoField {
+	fields := []infoField{
+		{"loading", boolInfo(database.loading)},
+	}
+	fields = append(fields, database.rdbInfo()...)
+	fields = append(fields, infoField{"aof_enabled", boolInfo(config.Properties.AppendOnly)})
+	if database.aofHandler == nil {
+		return append(fields,
+			infoField{"aof_rewrite_in_progress", "0"},
+			infoField{"aof_rewrites", "0"},
+			infoField{"aof_last_rewrite_time_sec", "-1"},
+			infoField{"aof_current_rewrite_time_sec", "-1"},
+			infoField{"aof_last_bgrewrite_status", "ok"},
+		)
+	}
+	status := database.aofHandler.Status()
+	lastStatus := "ok"
+	if status.LastRewriteFailed {
+		lastStatus = "err"
+	}
+	writeStatus := "ok"
+	if status.LastWriteFailed {
+		writeStatus = "err"
+	}
+	return append(fields,
+		infoField{"aof_rewrite_in_progress", boolInfo(status.RewriteInProgress)},
+		infoField{"aof_rewrites", strconv.FormatInt(status.Rewrites, 10)},
+		infoField{"aof_last_rewrite_time_sec", durationInfo(status.LastRewriteTime)},
+		infoField{"aof_current_rewrite_time_sec", durationInfo(status.CurrentRewriteTime)},
+		infoField{"aof_last_bgrewrite_status", lastStatus},
+		infoField{"aof_last_write_status", writeStatus},
+		infoField{"aof_current_size", strconv.FormatInt(status.CurrentSize, 10)},
+		infoField{"aof_base_size", strconv.FormatInt(status.BaseSize, 10)},
+		infoField{"aof_fsync", status.FsyncPolicy},
+		infoField{"aof_pending_bio_fsync", boolInfo(status.PendingFsync)},
+		infoField{"aof_delayed_fsync", strconv.FormatInt(status.DelayedFsync, 10)},
+		infoField{"aof_last_fsync_usec", strconv.FormatInt(int64(status.LastFsyncDuration/time.Microsecond), 10)},
+		infoField{"aof_last_fsync_delay_ms", strconv.FormatInt(int64(status.LastFsyncDelay/time.Millisecond), 10)},
+	)
+}
+
+// rdbInfo INFO persistence 中 RDB 相关的字段
+func (database *StandaloneDatabase) rdbInfo() []infoField {
+	state := &database.rdbState
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	lastStatus := "ok"
+	if state.lastBgsaveFailed {
+		lastStatus = "err"
+	}
+	currentTime := time.Duration(-1)
+	if state.saving {
+		currentTime = time.Since(state.saveStart)
+	}
+	return []infoField{
+		{"rdb_changes_since_last_save", strconv.FormatInt(atomic.LoadInt64(&database.dirty), 10)},
+		{"rdb_bgsave_in_progress", boolInfo(state.saving)},
+		{"rdb_last_save_time", strconv.FormatInt(state.lastSave.Unix(), 10)},
+		{"rdb_last_bgsave_status", lastStatus},
+		{"rdb_last_bgsave_time_sec", durationInfo(state.lastBgsaveTime)},
+		{"rdb_current_bgsave_time_sec", durationInfo(currentTime)},
+	}
+}
+
+func boolInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// durationInfo 以秒为单位，负数表示没有
+func durationInfo(d time.Duration) string {
+	if d < 0 {
+		return "-1"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
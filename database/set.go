@@ -0,0 +1,468 @@
+// Package database -----------------------------
+// @file      : set.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/23 14:30
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"redis-go/datastruct/set"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// getAsSet 取出 key 对应的集合，key 不存在时返回 nil
+func (db *DB) getAsSet(key string) (*set.Set, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*set.Set)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+// getOrInitSet 取出 key 对应的集合，key 不存在时新建一个
+func (db *DB) getOrInitSet(key string) (s *set.Set, isNew bool, errReply reply.ErrorReply) {
+	s, errReply = db.getAsSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if s == nil {
+		s = set.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: s,
+		})
+		isNew = true
+	}
+	return s, isNew, nil
+}
+
+// getSets 取出多个 key 对应的集合，不存在的 key 视为空集合
+func (db *DB) getSets(keys [][]byte) ([]*set.Set, reply.ErrorReply) {
+	sets := make([]*set.Set, 0, len(keys))
+	for _, key := range keys {
+		s, errReply := db.getAsSet(string(key))
+		if errReply != nil {
+			return nil, errReply
+		}
+		if s == nil {
+			s = set.Make()
+		}
+		sets = append(sets, s)
+	}
+	return sets, nil
+}
+
+func membersToReply(members []string) resp.Reply {
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// SADD k1 m1 [m2 ...]
+func execSAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	s, _, errReply := db.getOrInitSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for _, member := range members {
+		added += s.Add(string(member))
+	}
+	if added > 0 {
+		db.notifyKeyspaceEvent(notifySet, "sadd", key)
+	}
+
+	db.addAof(utils.ToCmdLine2("sadd", args...))
+	return reply.MakeIntReply(int64(added))
+}
+
+// SREM k1 m1 [m2 ...]
+func execSRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := 0
+	for _, member := range members {
+		removed += s.Remove(string(member))
+	}
+	if removed > 0 {
+		db.notifyKeyspaceEvent(notifySet, "srem", key)
+	}
+	// 集合空了就删除这个 key
+	if s.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("srem", args...))
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// SISMEMBER k1 m1
+func execSIsMember(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || !s.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(1)
+}
+
+// SMISMEMBER k1 m1 [m2 ...]
+func execSMIsMember(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]resp.Reply, len(members))
+	for i, member := range members {
+		if s != nil && s.Has(string(member)) {
+			result[i] = reply.MakeIntReply(1)
+		} else {
+			result[i] = reply.MakeIntReply(0)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// SMEMBERS k1
+func execSMembers(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return membersToReply(s.Members())
+}
+
+// SCARD k1
+func execSCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(s.Len()))
+}
+
+// SPOP k1 [count]
+func execSPop(db *DB, args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	count := 1
+	if withCount {
+		c, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || c < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		if c > math.MaxInt32 {
+			c = math.MaxInt32
+		}
+		count = int(c)
+	}
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if withCount {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+	members := s.RandomDistinctMembers(count)
+	for _, member := range members {
+		s.Remove(member)
+	}
+	if len(members) > 0 {
+		db.notifyKeyspaceEvent(notifySet, "spop", key)
+	}
+	if s.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	// 随机的结果重放时不一样，和 Redis 一样以 SREM 的形式写入 aof
+	if len(members) > 0 {
+		cmdLine := utils.ToCmdLine("srem", key)
+		for _, member := range members {
+			cmdLine = append(cmdLine, []byte(member))
+		}
+		db.addAof(cmdLine)
+	}
+	if withCount {
+		return membersToReply(members)
+	}
+	return reply.MakeBulkReply([]byte(members[0]))
+}
+
+// SRANDMEMBER k1 [count]
+// count > 0 返回不重复的元素，count < 0 返回可重复的 -count 个元素
+func execSRandMember(db *DB, args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	var count int64 = 1
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count < -math.MaxInt32 || count > math.MaxInt32 {
+			return reply.MakeErrReply("ERR value is out of range")
+		}
+	}
+
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if withCount {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return reply.MakeNullBulkReply()
+	}
+	if !withCount {
+		return reply.MakeBulkReply([]byte(s.RandomMembers(1)[0]))
+	}
+	if count >= 0 {
+		return membersToReply(s.RandomDistinctMembers(int(count)))
+	}
+	return membersToReply(s.RandomMembers(int(-count)))
+}
+
+// SINTER k1 [k2 ...]
+func execSInter(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := db.getSets(args)
+	if errReply != nil {
+		return errReply
+	}
+	return membersToReply(set.Intersect(sets...).Members())
+}
+
+// SUNION k1 [k2 ...]
+func execSUnion(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := db.getSets(args)
+	if errReply != nil {
+		return errReply
+	}
+	return membersToReply(set.Union(sets...).Members())
+}
+
+// SDIFF k1 [k2 ...]
+func execSDiff(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := db.getSets(args)
+	if errReply != nil {
+		return errReply
+	}
+	return membersToReply(set.Diff(sets...).Members())
+}
+
+// storeSetResult 把集合运算的结果保存到 dest，结果为空则删除 dest
+func storeSetResult(db *DB, cmdName string, args [][]byte, result *set.Set) resp.Reply {
+	dest := string(args[0])
+	if result.Len() == 0 {
+		// 和 Redis 一样，结果为空时删除原来的 key 并发送 del 通知
+		if _, exists := db.GetEntity(dest); exists {
+			db.removeEmpty(dest)
+		}
+	} else {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		// 覆盖原来的 key，过期时间也一并清除
+		db.Persist(dest)
+		db.notifyKeyspaceEvent(notifySet, cmdName, dest)
+	}
+
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(int64(result.Len()))
+}
+
+// SINTERSTORE dest k1 [k2 ...]
+func execSInterStore(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return storeSetResult(db, "sinterstore", args, set.Intersect(sets...))
+}
+
+// SUNIONSTORE dest k1 [k2 ...]
+func execSUnionStore(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return storeSetResult(db, "sunionstore", args, set.Union(sets...))
+}
+
+// SDIFFSTORE dest k1 [k2 ...]
+func execSDiffStore(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := db.getSets(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return storeSetResult(db, "sdiffstore", args, set.Diff(sets...))
+}
+
+// SMOVE src dest m1
+func execSMove(db *DB, args [][]byte) resp.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return reply.MakeIntReply(0)
+	}
+	// 源和目标相同，不需要移动
+	if src == dest {
+		return reply.MakeIntReply(1)
+	}
+	srcSet.Remove(member)
+	db.notifyKeyspaceEvent(notifySet, "srem", src)
+	if srcSet.Len() == 0 {
+		db.removeEmpty(src)
+	}
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(dest)
+	}
+	if destSet.Add(member) > 0 {
+		db.notifyKeyspaceEvent(notifySet, "sadd", dest)
+	}
+
+	db.addAof(utils.ToCmdLine2("smove", args...))
+	return reply.MakeIntReply(1)
+}
+
+// SINTERCARD numkeys k1 [k2 ...] [LIMIT limit]
+func execSInterCard(db *DB, args [][]byte) resp.Reply {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys <= 0 {
+		return reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return reply.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[1 : numKeys+1]
+	rest := args[numKeys+1:]
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "LIMIT" {
+			return reply.MakeSyntaxErrReply()
+		}
+		l, err := strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil || l < 0 {
+			return reply.MakeErrReply("ERR LIMIT can't be negative")
+		}
+		if l > math.MaxInt32 {
+			l = math.MaxInt32
+		}
+		limit = int(l)
+	}
+
+	sets, errReply := db.getSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+	// 不需要求出完整的交集，计数到 limit 就可以停止
+	smallest := 0
+	for i, s := range sets {
+		if s.Len() < sets[smallest].Len() {
+			smallest = i
+		}
+	}
+	count := 0
+	sets[smallest].ForEach(func(member string) bool {
+		for i, s := range sets {
+			if i != smallest && !s.Has(member) {
+				return true
+			}
+		}
+		count++
+		return limit == 0 || count < limit
+	})
+	return reply.MakeIntReply(int64(count))
+}
+
+func init() {
+	// SADD k1 m1 [m2 ...]
+	RegisterCommand("SAdd", execSAdd, writeFirstKey, -3)
+	RegisterCommand("SRem", execSRem, writeFirstKey, -3)
+	RegisterCommand("SIsMember", execSIsMember, readFirstKey, 3)
+	RegisterCommand("SMIsMember", execSMIsMember, readFirstKey, -3)
+	RegisterCommand("SMembers", execSMembers, readFirstKey, 2)
+	RegisterCommand("SCard", execSCard, readFirstKey, 2)
+	// SPOP k1 [count]
+	RegisterCommand("SPop", execSPop, writeFirstKey, -2)
+	RegisterCommand("SRandMember", execSRandMember, readFirstKey, -2)
+	// 集合运算 SINTER k1 [k2 ...]
+	RegisterCommand("SInter", execSInter, readAllKeys, -2)
+	RegisterCommand("SUnion", execSUnion, readAllKeys, -2)
+	RegisterCommand("SDiff", execSDiff, readAllKeys, -2)
+	RegisterCommand("SInterStore", execSInterStore, writeFirstKeyReadRest, -3)
+	RegisterCommand("SUnionStore", execSUnionStore, writeFirstKeyReadRest, -3)
+	RegisterCommand("SDiffStore", execSDiffStore, writeFirstKeyReadRest, -3)
+	// SMOVE src dest m1
+	RegisterCommand("SMove", execSMove, writeTwoKeys, 4)
+	// SINTERCARD numkeys k1 [k2 ...] [LIMIT limit]
+	RegisterCommand("SInterCard", execSInterCard, readNumKeys, -3)
+}
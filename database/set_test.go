@@ -0,0 +1,152 @@
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sortedMembers 执行返回集合的命令，把结果排序之后用空格连接
+func sortedMembers(t *testing.T, db *DB, cmdLine ...string) string {
+	t.Helper()
+	result := db.Exec(nil, utils.ToCmdLine(cmdLine...))
+	multiBulk, ok := result.(*reply.MultiBulkReply)
+	if !ok {
+		if _, empty := result.(*reply.EmptyMultiBulkReply); empty {
+			return ""
+		}
+		t.Fatalf("%v: expected members, actually %q", cmdLine, result.ToBytes())
+	}
+	members := make([]string, 0, len(multiBulk.Args))
+	for _, arg := range multiBulk.Args {
+		members = append(members, string(arg))
+	}
+	sort.Strings(members)
+	return strings.Join(members, " ")
+}
+
+func TestSetCommands(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"sadd s a b c", reply.MakeIntReply(3)},
+		{"sadd s a d", reply.MakeIntReply(1)},
+		{"scard s", reply.MakeIntReply(4)},
+		{"sismember s a", reply.MakeIntReply(1)},
+		{"sismember s x", reply.MakeIntReply(0)},
+		{"sismember none a", reply.MakeIntReply(0)},
+		{"smismember s a x d", reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(1), reply.MakeIntReply(0), reply.MakeIntReply(1),
+		})},
+		{"srem s a x", reply.MakeIntReply(1)},
+		{"scard none", reply.MakeIntReply(0)},
+		{"smembers none", reply.MakeEmptyMultiBulkReply()},
+		// 整数集合是 intset 编码，按照从小到大的顺序返回
+		{"sadd ints 3 -1 2", reply.MakeIntReply(3)},
+		{"smembers ints", bulks("-1", "2", "3")},
+		// 加入非整数之后转换编码
+		{"sadd ints x", reply.MakeIntReply(1)},
+		{"sismember ints 2", reply.MakeIntReply(1)},
+		{"srem ints -1 2 3 x", reply.MakeIntReply(4)},
+		{"exists ints", reply.MakeIntReply(0)},
+		{"set str v", reply.MakeOkReply()},
+		{"sadd str a", &reply.WrongTypeErrReply{}},
+		{"sinter s str", &reply.WrongTypeErrReply{}},
+	})
+	if members := sortedMembers(t, db, "smembers", "s"); members != "b c d" {
+		t.Errorf("unexpected members %s", members)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("sadd", "s1", "a", "b", "c", "d"))
+	db.Exec(nil, utils.ToCmdLine("sadd", "s2", "c", "d", "e"))
+	db.Exec(nil, utils.ToCmdLine("sadd", "s3", "d", "f"))
+	for _, c := range []struct {
+		cmdLine  []string
+		expected string
+	}{
+		{[]string{"sinter", "s1", "s2", "s3"}, "d"},
+		{[]string{"sinter", "s1", "none"}, ""},
+		{[]string{"sunion", "s1", "s2", "none"}, "a b c d e"},
+		{[]string{"sdiff", "s1", "s2", "s3"}, "a b"},
+		{[]string{"sdiff", "none", "s1"}, ""},
+	} {
+		if members := sortedMembers(t, db, c.cmdLine...); members != c.expected {
+			t.Errorf("%v: expected %s, actually %s", c.cmdLine, c.expected, members)
+		}
+	}
+
+	execCases(t, db, []cmdCase{
+		{"sinterstore dest s1 s2", reply.MakeIntReply(2)},
+		{"sunionstore dest2 s2 s3", reply.MakeIntReply(4)},
+		{"sdiffstore dest3 s1 s2", reply.MakeIntReply(2)},
+		// 结果为空时删除目标 key
+		{"sinterstore dest3 s1 none", reply.MakeIntReply(0)},
+		{"exists dest3", reply.MakeIntReply(0)},
+		{"sintercard 2 s1 s2", reply.MakeIntReply(2)},
+		{"sintercard 2 s1 s2 limit 1", reply.MakeIntReply(1)},
+		{"sintercard 2 s1 s2 limit -1", reply.MakeErrReply("ERR LIMIT can't be negative")},
+		{"sintercard 0 s1", reply.MakeErrReply("ERR numkeys should be greater than 0")},
+		{"sintercard 3 s1 s2", reply.MakeErrReply("ERR Number of keys can't be greater than number of args")},
+		{"smove s1 s3 a", reply.MakeIntReply(1)},
+		{"smove s1 s3 a", reply.MakeIntReply(0)},
+		{"smove s1 new b", reply.MakeIntReply(1)},
+	})
+	if members := sortedMembers(t, db, "smembers", "dest"); members != "c d" {
+		t.Errorf("unexpected dest %s", members)
+	}
+	if members := sortedMembers(t, db, "smembers", "dest2"); members != "c d e f" {
+		t.Errorf("unexpected dest2 %s", members)
+	}
+	if members := sortedMembers(t, db, "smembers", "s3"); members != "a d f" {
+		t.Errorf("unexpected s3 %s", members)
+	}
+	if members := sortedMembers(t, db, "smembers", "new"); members != "b" {
+		t.Errorf("unexpected new %s", members)
+	}
+}
+
+func TestSetRandom(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 10; i++ {
+		db.Exec(nil, utils.ToCmdLine("sadd", "s", "m"+strconv.Itoa(i)))
+	}
+	execCases(t, db, []cmdCase{
+		{"spop none", reply.MakeNullBulkReply()},
+		{"srandmember none", reply.MakeNullBulkReply()},
+		{"srandmember none 3", reply.MakeEmptyMultiBulkReply()},
+		{"spop s -1", reply.MakeErrReply("ERR value is out of range, must be positive")},
+	})
+	// 正数不重复，负数可以重复并且正好返回 -count 个
+	if members := sortedMembers(t, db, "srandmember", "s", "20"); len(strings.Fields(members)) != 10 {
+		t.Errorf("expected all members, actually %s", members)
+	}
+	result := db.Exec(nil, utils.ToCmdLine("srandmember", "s", "-20"))
+	if multiBulk, ok := result.(*reply.MultiBulkReply); !ok || len(multiBulk.Args) != 20 {
+		t.Errorf("expected 20 members, actually %q", result.ToBytes())
+	}
+
+	popped := sortedMembers(t, db, "spop", "s", "4")
+	if len(strings.Fields(popped)) != 4 {
+		t.Fatalf("expected 4 members, actually %s", popped)
+	}
+	for _, member := range strings.Fields(popped) {
+		result = db.Exec(nil, utils.ToCmdLine("sismember", "s", member))
+		if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+			t.Errorf("expected %s removed", member)
+		}
+	}
+	execCases(t, db, []cmdCase{
+		{"scard s", reply.MakeIntReply(6)},
+	})
+	// 弹出所有元素之后删除 key
+	sortedMembers(t, db, "spop", "s", "100")
+	execCases(t, db, []cmdCase{
+		{"exists s", reply.MakeIntReply(0)},
+	})
+}
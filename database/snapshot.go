@@ -0,0 +1,132 @@
+// Package database -----------------------------
+// @file      : snapshot.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 16:40
+// -------------------------------------------
+package database
+
+import (
+	"bytes"
+	"redis-go/interface/database"
+	"redis-go/rdb"
+	"sync"
+	"time"
+)
+
+// 保存 RDB 时 db 的快照
+// Redis 用 fork 的写时复制让 BGSAVE 和写命令并发执行，这里在 key 的粒度上写时复制：
+// 1. 短暂地独占所有 db，在每个 db 上开始快照
+// 2. 后台对 key 逐个加锁写入文件，写过的 key 标记为已访问
+// 3. 快照期间第一次修改一个 key 之前先把它当前的值编码保存下来，同样标记为已访问，写文件时跳过
+// 这样文件中每个 key 都是快照开始时的值，快照开始时不存在的 key 也不会写入文件
+
+// snapshot 一个 db 的快照
+type snapshot struct {
+	// 保护下面的状态，需要在持有 db.mu 和 key 的锁之后获取
+	mu sync.Mutex
+	// 已经写入文件或者已经保存了旧值的 key
+	visited map[string]struct{}
+	// 修改之前保存的旧值，已经编码成 RDB 的格式
+	preserved *bytes.Buffer
+}
+
+// startSnapshot 在 db 上开始快照，调用方需要独占 db.mu
+func (db *DB) startSnapshot() *snapshot {
+	snap := &snapshot{
+		visited:   make(map[string]struct{}),
+		preserved: &bytes.Buffer{},
+	}
+	db.snapshot = snap
+	return snap
+}
+
+// endSnapshot 结束快照，之后修改 key 不再保存旧值
+func (db *DB) endSnapshot() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.snapshot = nil
+}
+
+// preserve 修改 key 之前调用，快照还没有写入这些 key 时先保存它们当前的值
+// 调用方需要持有 db.mu 和 key 的锁
+func (db *DB) preserve(keys ...string) {
+	snap := db.snapshot
+	if snap == nil {
+		return
+	}
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	for _, key := range keys {
+		// 编码失败的 key 在写文件时同样会失败，这里忽略
+		_ = snap.saveKey(db, key, snap.preserved)
+	}
+}
+
+// preserveAll 清空 db 之前保存所有 key 的旧值
+func (db *DB) preserveAll() {
+	if db.snapshot == nil {
+		return
+	}
+	db.preserve(db.data.Keys()...)
+}
+
+// saveKey 把 key 当前的值编码写入 buf 并标记为已访问，已经访问过、不存在或者已经过期时跳过
+// 调用方需要持有 snap.mu
+func (snap *snapshot) saveKey(db *DB, key string, buf *bytes.Buffer) error {
+	if _, ok := snap.visited[key]; ok {
+		return nil
+	}
+	snap.visited[key] = struct{}{}
+	raw, ok := db.data.Get(key)
+	if !ok {
+		return nil
+	}
+	var expiration *time.Time
+	if expireTime, ok := db.GetExpireTime(key); ok {
+		if time.Now().After(expireTime) {
+			return nil
+		}
+		expiration = &expireTime
+	}
+	return rdb.NewEncoder(buf).WriteEntry(key, raw.(*database.DataEntity), expiration)
+}
+
+// saveSnapshot 把 db 的快照写入 enc，写完之后结束快照
+func (db *DB) saveSnapshot(enc *rdb.Encoder, snap *snapshot) error {
+	selected := false
+	writeRecord := func(record []byte) error {
+		if len(record) == 0 {
+			return nil
+		}
+		// 空的 db 不写 SELECTDB
+		if !selected {
+			if err := enc.WriteSelectDB(db.index); err != nil {
+				return err
+			}
+			selected = true
+		}
+		return enc.WriteRaw(record)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, key := range db.data.Keys() {
+		buf.Reset()
+		db.mu.RLock()
+		db.locker.RLock(key)
+		snap.mu.Lock()
+		err := snap.saveKey(db, key, buf)
+		snap.mu.Unlock()
+		db.locker.RUnLock(key)
+		db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if err = writeRecord(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	// 结束快照之后不会再有新的旧值
+	db.endSnapshot()
+	return writeRecord(snap.preserved.Bytes())
+}
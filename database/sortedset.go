@@ -0,0 +1,1013 @@
+// Package database -----------------------------
+// @file      : sortedset.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/24 16:40
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// getAsSortedSet 取出 key 对应的有序集合，key 不存在时返回 nil
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+// getOrInitSortedSet 取出 key 对应的有序集合，key 不存在时新建一个
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, isNew bool, errReply reply.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	isNew = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: sortedSet,
+		})
+		isNew = true
+	}
+	return sortedSet, isNew, nil
+}
+
+// elementsToReply 把元素转换成回复，withScores 时 member 和 score 交替出现
+func elementsToReply(elements []*SortedSet.Element, withScores bool) resp.Reply {
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, []byte(utils.FormatScore(element.Score)))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// ZADD k1 [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	nx, xx, gt, lt, ch, incr := false, false, false, false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			incr = true
+		default:
+			goto parsePairs
+		}
+	}
+parsePairs:
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (gt && nx) || (lt && nx) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	// 先检查所有的分数，有一个不合法就都不执行
+	elements := make([]*SortedSet.Element, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := SortedSet.ParseScore(string(pairs[j]))
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		elements[j/2] = &SortedSet.Element{
+			Member: string(pairs[j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		// XX 不会新增元素，不需要创建 key
+		if xx {
+			if incr {
+				return reply.MakeNullBulkReply()
+			}
+			return reply.MakeIntReply(0)
+		}
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	added, changed := 0, 0
+	var incrResult *float64
+	for _, element := range elements {
+		score := element.Score
+		current, exists := sortedSet.Get(element.Member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if incr && exists {
+			score += current.Score
+			if math.IsNaN(score) {
+				return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		// GT LT 只影响已经存在的元素
+		if exists && ((gt && score <= current.Score) || (lt && score >= current.Score)) {
+			continue
+		}
+		if !exists {
+			added++
+		} else if score != current.Score {
+			changed++
+		}
+		sortedSet.Add(element.Member, score)
+		result := score
+		incrResult = &result
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+
+	if incr {
+		if incrResult == nil {
+			return reply.MakeNullBulkReply()
+		}
+		db.notifyKeyspaceEvent(notifyZSet, "zincr", key)
+		// 分数以 Redis 的格式写入，重放的时候可以精确还原
+		db.addAof(utils.ToCmdLine("zadd", key, utils.FormatScore(*incrResult), string(pairs[1])))
+		return reply.MakeBulkReply([]byte(utils.FormatScore(*incrResult)))
+	}
+	if added+changed > 0 {
+		db.notifyKeyspaceEvent(notifyZSet, "zadd", key)
+		db.addAof(utils.ToCmdLine2("zadd", args...))
+	}
+	if ch {
+		return reply.MakeIntReply(int64(added + changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// ZINCRBY k1 increment member
+func execZIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, err := SortedSet.ParseScore(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	member := string(args[2])
+
+	sortedSet, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	score := delta
+	if element, exists := sortedSet.Get(member); exists {
+		score += element.Score
+		if math.IsNaN(score) {
+			return reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	sortedSet.Add(member, score)
+	db.notifyKeyspaceEvent(notifyZSet, "zincr", key)
+
+	scoreBytes := []byte(utils.FormatScore(score))
+	db.addAof(utils.ToCmdLine2("zadd", args[0], scoreBytes, args[2]))
+	return reply.MakeBulkReply(scoreBytes)
+}
+
+// ZSCORE k1 member
+func execZScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeNullBulkReply()
+	}
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply([]byte(utils.FormatScore(element.Score)))
+}
+
+// ZMSCORE k1 member [member ...]
+func execZMScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, member := range args[1:] {
+		if element, exists := sortedSet.Get(string(member)); exists {
+			result[i] = []byte(utils.FormatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// ZCARD k1
+func execZCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+// rankGeneric ZRANK ZREVRANK 的公共逻辑
+func rankGeneric(db *DB, args [][]byte, desc bool) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+	withScore := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORE" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withScore = true
+	} else if len(args) > 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	nullReply := resp.Reply(reply.MakeNullBulkReply())
+	if withScore {
+		nullReply = reply.MakeNullMultiBulkReply()
+	}
+	if sortedSet == nil {
+		return nullReply
+	}
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return nullReply
+	}
+	if withScore {
+		element, _ := sortedSet.Get(member)
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(rank),
+			reply.MakeBulkReply([]byte(utils.FormatScore(element.Score))),
+		})
+	}
+	return reply.MakeIntReply(rank)
+}
+
+// ZRANK k1 member [WITHSCORE]
+func execZRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// ZREVRANK k1 member [WITHSCORE]
+func execZRevRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, true)
+}
+
+// ZREM k1 member [member ...]
+func execZRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	var removed int64 = 0
+	for _, member := range args[1:] {
+		if sortedSet.Remove(string(member)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		db.notifyKeyspaceEvent(notifyZSet, "zrem", key)
+	}
+	// 有序集合空了就删除这个 key
+	if sortedSet.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine2("zrem", args...))
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// ZCOUNT k1 min max
+func execZCount(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+// ZLEXCOUNT k1 min max
+func execZLexCount(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	min, err := SortedSet.ParseLexBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseLexBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+// 范围查询的方式
+const (
+	rangeByRank = iota
+	rangeByScore
+	rangeByLex
+)
+
+// rangeSpec ZRANGE 的参数
+type rangeSpec struct {
+	by         int
+	rev        bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	count      int64
+	start      []byte
+	stop       []byte
+}
+
+// parseRangeSpec 解析 ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func parseRangeSpec(args [][]byte) (*rangeSpec, resp.Reply) {
+	spec := &rangeSpec{
+		by:    rangeByRank,
+		count: -1,
+		start: args[0],
+		stop:  args[1],
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			spec.by = rangeByScore
+		case "BYLEX":
+			spec.by = rangeByLex
+		case "REV":
+			spec.rev = true
+		case "WITHSCORES":
+			spec.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			count, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			spec.hasLimit = true
+			spec.offset = offset
+			spec.count = count
+			i += 2
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	if spec.hasLimit && spec.by == rangeByRank {
+		return nil, reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == rangeByLex {
+		return nil, reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return spec, nil
+}
+
+// rangeGeneric 根据 rangeSpec 查询有序集合
+func rangeGeneric(db *DB, key string, spec *rangeSpec) resp.Reply {
+	switch spec.by {
+	case rangeByRank:
+		start, err := strconv.ParseInt(string(spec.start), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		stop, err := strconv.ParseInt(string(spec.stop), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		begin, end := normalizeRange(start, stop, int(sortedSet.Len()))
+		if begin >= end {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		return elementsToReply(sortedSet.RangeByRank(int64(begin), int64(end), spec.rev), spec.withScores)
+	default:
+		// REV 的时候先给出的是上界
+		minArg, maxArg := spec.start, spec.stop
+		if spec.rev {
+			minArg, maxArg = spec.stop, spec.start
+		}
+		var min, max SortedSet.Border
+		if spec.by == rangeByScore {
+			minBorder, err := SortedSet.ParseScoreBorder(string(minArg))
+			if err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			maxBorder, err := SortedSet.ParseScoreBorder(string(maxArg))
+			if err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			min, max = minBorder, maxBorder
+		} else {
+			minBorder, err := SortedSet.ParseLexBorder(string(minArg))
+			if err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			maxBorder, err := SortedSet.ParseLexBorder(string(maxArg))
+			if err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			min, max = minBorder, maxBorder
+		}
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			return reply.MakeEmptyMultiBulkReply()
+		}
+		elements := sortedSet.Range(min, max, spec.offset, spec.count, spec.rev)
+		return elementsToReply(elements, spec.withScores)
+	}
+}
+
+// ZRANGE k1 start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) resp.Reply {
+	spec, errReply := parseRangeSpec(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	return rangeGeneric(db, string(args[0]), spec)
+}
+
+// ZREVRANGE k1 start stop [WITHSCORES]
+func execZRevRange(db *DB, args [][]byte) resp.Reply {
+	spec, errReply := parseRangeSpec(args[1:])
+	if errReply != nil || spec.by != rangeByRank {
+		return reply.MakeSyntaxErrReply()
+	}
+	spec.rev = true
+	return rangeGeneric(db, string(args[0]), spec)
+}
+
+// ZRANGEBYSCORE k1 min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args [][]byte) resp.Reply {
+	spec, errReply := parseRangeSpec(append([][]byte{args[1], args[2], []byte("BYSCORE")}, args[3:]...))
+	if errReply != nil {
+		return errReply
+	}
+	if spec.by != rangeByScore || spec.rev {
+		return reply.MakeSyntaxErrReply()
+	}
+	return rangeGeneric(db, string(args[0]), spec)
+}
+
+// ZREVRANGEBYSCORE k1 max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args [][]byte) resp.Reply {
+	spec, errReply := parseRangeSpec(append([][]byte{args[1], args[2], []byte("BYSCORE")}, args[3:]...))
+	if errReply != nil {
+		return errReply
+	}
+	if spec.by != rangeByScore || spec.rev {
+		return reply.MakeSyntaxErrReply()
+	}
+	spec.rev = true
+	return rangeGeneric(db, string(args[0]), spec)
+}
+
+// popGenericZ ZPOPMIN ZPOPMAX 的公共逻辑
+func popGenericZ(db *DB, cmdName string, args [][]byte, max bool) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		c, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || c < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		if c > math.MaxInt32 {
+			c = math.MaxInt32
+		}
+		count = int(c)
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(count)
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if len(removed) > 0 {
+		db.notifyKeyspaceEvent(notifyZSet, cmdName, key)
+	}
+	if sortedSet.Len() == 0 {
+		db.removeEmpty(key)
+	}
+
+	if len(removed) > 0 {
+		db.addAof(utils.ToCmdLine2(cmdName, args...))
+	}
+	return elementsToReply(removed, true)
+}
+
+// ZPOPMIN k1 [count]
+func execZPopMin(db *DB, args [][]byte) resp.Reply {
+	return popGenericZ(db, "zpopmin", args, false)
+}
+
+// ZPOPMAX k1 [count]
+func execZPopMax(db *DB, args [][]byte) resp.Reply {
+	return popGenericZ(db, "zpopmax", args, true)
+}
+
+// zmpopGeneric 从第一个非空的有序集合中弹出最多 count 个元素，都为空时返回 nil
+// strict 为 false 时跳过类型不对的 key，用于阻塞之后的重试
+func zmpopGeneric(db *DB, keys []string, max bool, count int, strict bool) (string, []*SortedSet.Element, reply.ErrorReply) {
+	for _, key := range keys {
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			if strict {
+				return "", nil, errReply
+			}
+			continue
+		}
+		if sortedSet == nil {
+			continue
+		}
+		var removed []*SortedSet.Element
+		cmdName := "zpopmin"
+		if max {
+			removed = sortedSet.PopMax(count)
+			cmdName = "zpopmax"
+		} else {
+			removed = sortedSet.PopMin(count)
+		}
+		db.notifyKeyspaceEvent(notifyZSet, cmdName, key)
+		if sortedSet.Len() == 0 {
+			db.removeEmpty(key)
+		}
+		db.addAof(utils.ToCmdLine(cmdName, key, strconv.Itoa(len(removed))))
+		return key, removed, nil
+	}
+	return "", nil, nil
+}
+
+// zmpopReply [key, [[member, score] ...]]
+func zmpopReply(key string, elements []*SortedSet.Element) resp.Reply {
+	pairs := make([]resp.Reply, len(elements))
+	for i, element := range elements {
+		pairs[i] = reply.MakeMultiBulkReply([][]byte{
+			[]byte(element.Member),
+			[]byte(utils.FormatScore(element.Score)),
+		})
+	}
+	return mpopReply(key, reply.MakeMultiRawReply(pairs))
+}
+
+// ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+func execZMPop(db *DB, args [][]byte) resp.Reply {
+	keys, min, count, errReply := parseMPop(args, "MIN", "MAX")
+	if errReply != nil {
+		return errReply
+	}
+	key, removed, errReply := zmpopGeneric(db, keys, !min, count, true)
+	if errReply != nil {
+		return errReply
+	}
+	if removed == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return zmpopReply(key, removed)
+}
+
+// bzpopGeneric BZPOPMIN BZPOPMAX 的公共逻辑，返回 [key, member, score]
+func bzpopGeneric(db *DB, c resp.Connection, args [][]byte, max bool) resp.Reply {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := toKeys(args[:len(args)-1])
+	// 第一次尝试时类型不对返回错误，阻塞之后的重试跳过类型不对的 key
+	strict := true
+	try := func() resp.Reply {
+		defer func() { strict = false }()
+		key, removed, errReply := zmpopGeneric(db, keys, max, 1, strict)
+		if errReply != nil {
+			return errReply
+		}
+		if removed == nil {
+			return nil
+		}
+		return reply.MakeMultiBulkReply([][]byte{
+			[]byte(key),
+			[]byte(removed[0].Member),
+			[]byte(utils.FormatScore(removed[0].Score)),
+		})
+	}
+	result := db.block(c, &blockingWait{
+		keys:      keys,
+		writeKeys: keys,
+		timeout:   timeout,
+		consume:   true,
+	}, try)
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// BZPOPMIN key [key ...] timeout
+func execBZPopMin(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	return bzpopGeneric(db, c, args, false)
+}
+
+// BZPOPMAX key [key ...] timeout
+func execBZPopMax(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	return bzpopGeneric(db, c, args, true)
+}
+
+// BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+func execBZMPop(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	timeout, errReply := parseBlockTimeout(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	keys, min, count, errReply := parseMPop(args[1:], "MIN", "MAX")
+	if errReply != nil {
+		return errReply
+	}
+	// 第一次尝试时类型不对返回错误，阻塞之后的重试跳过类型不对的 key
+	strict := true
+	try := func() resp.Reply {
+		defer func() { strict = false }()
+		key, removed, errReply := zmpopGeneric(db, keys, !min, count, strict)
+		if errReply != nil {
+			return errReply
+		}
+		if removed == nil {
+			return nil
+		}
+		return zmpopReply(key, removed)
+	}
+	result := db.block(c, &blockingWait{
+		keys:      keys,
+		writeKeys: keys,
+		timeout:   timeout,
+		consume:   true,
+	}, try)
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// 集合运算时相同 member 分数的聚合方式
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+func aggregate(mode int, a, b float64) float64 {
+	switch mode {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	default:
+		sum := a + b
+		// inf + -inf 的结果和 Redis 一样记为 0
+		if math.IsNaN(sum) {
+			return 0
+		}
+		return sum
+	}
+}
+
+// zsetOperands 参与集合运算的 key 以及对应的元素，普通集合的元素分数视为 1
+type zsetOperand struct {
+	elements map[string]float64
+}
+
+// parseZSetOp 解析 numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+// allowWithScores 只有 ZUNION ZINTER 支持 WITHSCORES
+func (db *DB) parseZSetOp(args [][]byte, allowWithScores bool) (operands []*zsetOperand, mode int, withScores bool, errReply resp.Reply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, 0, false, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, 0, false, reply.MakeErrReply("ERR at least 1 input key is needed for this command")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, 0, false, reply.MakeSyntaxErrReply()
+	}
+	keys := args[1 : numKeys+1]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	mode = aggregateSum
+	rest := args[numKeys+1:]
+	for i := 0; i < len(rest); i++ {
+		switch strings.ToUpper(string(rest[i])) {
+		case "WEIGHTS":
+			if int64(len(rest)-i-1) < numKeys {
+				return nil, 0, false, reply.MakeSyntaxErrReply()
+			}
+			for j := int64(0); j < numKeys; j++ {
+				weight, err := SortedSet.ParseScore(string(rest[i+1+int(j)]))
+				if err != nil {
+					return nil, 0, false, reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if i+1 >= len(rest) {
+				return nil, 0, false, reply.MakeSyntaxErrReply()
+			}
+			switch strings.ToUpper(string(rest[i+1])) {
+			case "SUM":
+				mode = aggregateSum
+			case "MIN":
+				mode = aggregateMin
+			case "MAX":
+				mode = aggregateMax
+			default:
+				return nil, 0, false, reply.MakeSyntaxErrReply()
+			}
+			i++
+		case "WITHSCORES":
+			if !allowWithScores {
+				return nil, 0, false, reply.MakeSyntaxErrReply()
+			}
+			withScores = true
+		default:
+			return nil, 0, false, reply.MakeSyntaxErrReply()
+		}
+	}
+
+	operands = make([]*zsetOperand, 0, numKeys)
+	for i, key := range keys {
+		operand := &zsetOperand{elements: make(map[string]float64)}
+		entity, exists := db.GetEntity(string(key))
+		if exists {
+			switch data := entity.Data.(type) {
+			case *SortedSet.SortedSet:
+				data.ForEach(SortedSet.NegativeInfScoreBorder, SortedSet.PositiveInfScoreBorder, 0, -1, false,
+					func(element *SortedSet.Element) bool {
+						operand.elements[element.Member] = weightedScore(element.Score, weights[i])
+						return true
+					})
+			case *set.Set:
+				data.ForEach(func(member string) bool {
+					operand.elements[member] = weightedScore(1, weights[i])
+					return true
+				})
+			default:
+				return nil, 0, false, &reply.WrongTypeErrReply{}
+			}
+		}
+		operands = append(operands, operand)
+	}
+	return operands, mode, withScores, nil
+}
+
+// weightedScore 分数乘以权重，0 * inf 的结果记为 0
+func weightedScore(score float64, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+func zUnion(operands []*zsetOperand, mode int) *SortedSet.SortedSet {
+	result := make(map[string]float64)
+	for _, operand := range operands {
+		for member, score := range operand.elements {
+			if current, ok := result[member]; ok {
+				result[member] = aggregate(mode, current, score)
+			} else {
+				result[member] = score
+			}
+		}
+	}
+	sortedSet := SortedSet.Make()
+	for member, score := range result {
+		sortedSet.Add(member, score)
+	}
+	return sortedSet
+}
+
+func zInter(operands []*zsetOperand, mode int) *SortedSet.SortedSet {
+	sortedSet := SortedSet.Make()
+	if len(operands) == 0 {
+		return sortedSet
+	}
+	for member, score := range operands[0].elements {
+		result := score
+		inAll := true
+		for _, operand := range operands[1:] {
+			other, ok := operand.elements[member]
+			if !ok {
+				inAll = false
+				break
+			}
+			result = aggregate(mode, result, other)
+		}
+		if inAll {
+			sortedSet.Add(member, result)
+		}
+	}
+	return sortedSet
+}
+
+// zStore 把集合运算的结果保存到 dest，结果为空则删除 dest
+func zStore(db *DB, cmdName string, args [][]byte, result *SortedSet.SortedSet) resp.Reply {
+	dest := string(args[0])
+	if result.Len() == 0 {
+		// 和 Redis 一样，结果为空时删除原来的 key 并发送 del 通知
+		if _, exists := db.GetEntity(dest); exists {
+			db.removeEmpty(dest)
+		}
+	} else {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		db.Persist(dest)
+		db.notifyKeyspaceEvent(notifyZSet, cmdName, dest)
+	}
+
+	db.addAof(utils.ToCmdLine2(cmdName, args...))
+	return reply.MakeIntReply(result.Len())
+}
+
+// ZUNIONSTORE dest numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func execZUnionStore(db *DB, args [][]byte) resp.Reply {
+	operands, mode, _, errReply := db.parseZSetOp(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	return zStore(db, "zunionstore", args, zUnion(operands, mode))
+}
+
+// ZINTERSTORE dest numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func execZInterStore(db *DB, args [][]byte) resp.Reply {
+	operands, mode, _, errReply := db.parseZSetOp(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	return zStore(db, "zinterstore", args, zInter(operands, mode))
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZUnion(db *DB, args [][]byte) resp.Reply {
+	operands, mode, withScores, errReply := db.parseZSetOp(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	result := zUnion(operands, mode)
+	if result.Len() == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return elementsToReply(result.RangeByRank(0, result.Len(), false), withScores)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZInter(db *DB, args [][]byte) resp.Reply {
+	operands, mode, withScores, errReply := db.parseZSetOp(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	result := zInter(operands, mode)
+	if result.Len() == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return elementsToReply(result.RangeByRank(0, result.Len(), false), withScores)
+}
+
+func init() {
+	// ZADD k1 [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, -4)
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, 4)
+	RegisterCommand("ZScore", execZScore, readFirstKey, 3)
+	RegisterCommand("ZMScore", execZMScore, readFirstKey, -3)
+	RegisterCommand("ZCard", execZCard, readFirstKey, 2)
+	// ZRANK k1 member [WITHSCORE]
+	RegisterCommand("ZRank", execZRank, readFirstKey, -3)
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, -3)
+	RegisterCommand("ZRem", execZRem, writeFirstKey, -3)
+	RegisterCommand("ZCount", execZCount, readFirstKey, 4)
+	RegisterCommand("ZLexCount", execZLexCount, readFirstKey, 4)
+	// ZRANGE k1 start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+	RegisterCommand("ZRange", execZRange, readFirstKey, -4)
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, -4)
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, -4)
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, -4)
+	// ZPOPMIN k1 [count]
+	RegisterCommand("ZPopMin", execZPopMin, writeFirstKey, -2)
+	RegisterCommand("ZPopMax", execZPopMax, writeFirstKey, -2)
+	RegisterCommand("ZMPop", execZMPop, writeNumKeys, -4)
+	RegisterBlockingCommand("BZPopMin", execBZPopMin, writeKeysExceptLast, -3)
+	RegisterBlockingCommand("BZPopMax", execBZPopMax, writeKeysExceptLast, -3)
+	RegisterBlockingCommand("BZMPop", execBZMPop, writeNumKeysAfterTimeout, -5)
+	// ZUNIONSTORE dest numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+	RegisterCommand("ZUnionStore", execZUnionStore, writeFirstKeyReadNumKeys, -4)
+	RegisterCommand("ZInterStore", execZInterStore, writeFirstKeyReadNumKeys, -4)
+	RegisterCommand("ZUnion", execZUnion, readNumKeys, -3)
+	RegisterCommand("ZInter", execZInter, readNumKeys, -3)
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/resp/reply"
+	"testing"
+)
+
+func TestZAdd(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"zadd z 1 a 2 b 3 c", reply.MakeIntReply(3)},
+		{"zadd z nx 10 a 4 d", reply.MakeIntReply(1)},
+		{"zscore z a", reply.MakeBulkReply([]byte("1"))},
+		{"zadd z xx 5 e", reply.MakeIntReply(0)},
+		{"zscore z e", reply.MakeNullBulkReply()},
+		{"zadd z xx ch 1.5 a", reply.MakeIntReply(1)},
+		{"zadd z gt ch 1 b", reply.MakeIntReply(0)},
+		{"zadd z lt ch 1 b", reply.MakeIntReply(1)},
+		{"zadd z incr 2 a", reply.MakeBulkReply([]byte("3.5"))},
+		{"zadd z nx incr 1 a", reply.MakeNullBulkReply()},
+		{"zadd z xx nx 1 a", reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")},
+		{"zadd z gt nx 1 a", reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")},
+		{"zadd z incr 1 a 2 b", reply.MakeErrReply("ERR INCR option supports a single increment-element pair")},
+		{"zadd z 1 a 2", reply.MakeSyntaxErrReply()},
+		{"zcard z", reply.MakeIntReply(4)},
+		{"zmscore z a none d", reply.MakeMultiBulkReply([][]byte{[]byte("3.5"), nil, []byte("4")})},
+		{"zincrby z 1 b", reply.MakeBulkReply([]byte("2"))},
+		{"zincrby z 1 new", reply.MakeBulkReply([]byte("1"))},
+		{"zrem z new none", reply.MakeIntReply(1)},
+		{"set s v", reply.MakeOkReply()},
+		{"zadd s 1 a", &reply.WrongTypeErrReply{}},
+	})
+}
+
+func TestZRange(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"zadd z 2 b 3 c 3.5 a 4 d", reply.MakeIntReply(4)},
+		{"zrange z 0 -1", bulks("b", "c", "a", "d")},
+		{"zrange z 0 -1 withscores", bulks("b", "2", "c", "3", "a", "3.5", "d", "4")},
+		{"zrange z 0 1 rev", bulks("d", "a")},
+		{"zrange z (2 3.5 byscore", bulks("c", "a")},
+		{"zrange z +inf -inf byscore rev limit 1 2", bulks("a", "c")},
+		{"zrange z 0 -1 limit 0 1", reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")},
+		{"zrange none 0 -1", reply.MakeEmptyMultiBulkReply()},
+		{"zrevrange z 0 0", bulks("d")},
+		{"zrangebyscore z -inf 3 withscores", bulks("b", "2", "c", "3")},
+		{"zrevrangebyscore z 4 (3", bulks("d", "a")},
+		{"zcount z 2 (4", reply.MakeIntReply(3)},
+		{"zrank z a", reply.MakeIntReply(2)},
+		{"zrevrank z a", reply.MakeIntReply(1)},
+		{"zrank z a withscore", reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(2), reply.MakeBulkReply([]byte("3.5")),
+		})},
+		{"zrank z none", reply.MakeNullBulkReply()},
+		{"zrank z none withscore", reply.MakeNullMultiBulkReply()},
+
+		// 分数相同时按照成员的字典序
+		{"zadd l 0 a 0 b 0 c 0 d", reply.MakeIntReply(4)},
+		{"zrange l [b (d bylex", bulks("b", "c")},
+		{"zrange l (a + bylex limit 1 1", bulks("c")},
+		{"zrange l + [c bylex rev", bulks("d", "c")},
+		{"zlexcount l - +", reply.MakeIntReply(4)},
+		{"zrange l - + bylex withscores", reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")},
+	})
+}
+
+func TestZPop(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"zadd z 1 a 2 b 3 c 4 d", reply.MakeIntReply(4)},
+		{"zpopmin z", bulks("a", "1")},
+		{"zpopmax z 2", bulks("d", "4", "c", "3")},
+		{"zpopmin z -1", reply.MakeErrReply("ERR value is out of range, must be positive")},
+		{"zpopmin none", reply.MakeEmptyMultiBulkReply()},
+		{"zmpop 2 none z max count 5", reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("z")),
+			reply.MakeMultiRawReply([]resp.Reply{bulks("b", "2")}),
+		})},
+		// 弹出所有元素之后删除 key
+		{"exists z", reply.MakeIntReply(0)},
+		{"zmpop 1 z min", reply.MakeNullMultiBulkReply()},
+		// numkeys 很大时不会溢出
+		{"zmpop 9223372036854775807 z min", reply.MakeSyntaxErrReply()},
+	})
+}
+
+func TestZStore(t *testing.T) {
+	db := makeDB()
+	execCases(t, db, []cmdCase{
+		{"zadd z1 1 a 2 b 3 c", reply.MakeIntReply(3)},
+		{"zadd z2 10 b 20 c 30 d", reply.MakeIntReply(3)},
+		{"sadd s c d", reply.MakeIntReply(2)},
+		{"zunionstore dest 2 z1 z2", reply.MakeIntReply(4)},
+		{"zrange dest 0 -1 withscores", bulks("a", "1", "b", "12", "c", "23", "d", "30")},
+		{"zinterstore dest 2 z1 z2 weights 2 1 aggregate max", reply.MakeIntReply(2)},
+		{"zrange dest 0 -1 withscores", bulks("b", "10", "c", "20")},
+		// 集合的成员分数为 1
+		{"zinterstore dest 2 z1 s aggregate sum", reply.MakeIntReply(1)},
+		{"zrange dest 0 -1 withscores", bulks("c", "4")},
+		{"zunion 2 z1 z2 aggregate min withscores", bulks("a", "1", "b", "2", "c", "3", "d", "30")},
+		{"zinter 2 z1 z2", bulks("b", "c")},
+		// 结果为空时删除目标 key
+		{"zinterstore dest 2 z1 none", reply.MakeIntReply(0)},
+		{"exists dest", reply.MakeIntReply(0)},
+		{"zunionstore dest 0 z1", reply.MakeErrReply("ERR at least 1 input key is needed for this command")},
+		{"zunionstore dest 2 z1 z2 weights 1 x", reply.MakeErrReply("ERR weight value is not a float")},
+	})
+}
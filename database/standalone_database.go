@@ -7,67 +7,184 @@
 package database
 
 import (
+	"errors"
 	"redis-go/aof"
+	dbface "redis-go/interface/database"
 	"redis-go/interface/resp"
 	"redis-go/lib/config"
 	"redis-go/lib/logger"
+	"redis-go/pubsub"
 	"redis-go/resp/reply"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type StandaloneDatabase struct {
 	// 一组DB的指针
 	dbSet      []*DB
 	aofHandler *aof.AofHandler
+	// 关闭后停止定期删除
+	closed chan struct{}
+	// 正在加载 AOF，加载时不淘汰也不拒绝写入
+	loading bool
+	// 同一时间只有一个淘汰过程，保护淘汰池
+	evictMu      sync.Mutex
+	evictionPool []evictionCandidate
+	// random 策略轮流从每个 db 中淘汰
+	nextEvictDB int
+	// 发布订阅，和 db 无关
+	hub *pubsub.Hub
+	// 上次保存 RDB 之后的修改次数，原子操作
+	dirty int64
+	// RDB 持久化的状态和自动保存的规则
+	rdbState  rdbState
+	saveRules []saveRule
 }
 
 // NewStandaloneDatabase 创建 Redis 数据库的核心 默认为16个分数据库
 func NewStandaloneDatabase() *StandaloneDatabase {
-	database := &StandaloneDatabase{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16
-	}
-	// 初始化 DB
-	database.dbSet = make([]*DB, config.Properties.Databases)
-	for i := range database.dbSet {
-		db := makeDB()
-		db.index = i
-		database.dbSet[i] = db
+	database := makeStandaloneDatabase()
+	// 没有 AOF 文件时从 RDB 文件恢复数据
+	rdbLoaded := false
+	if !aofExists() {
+		database.loading = true
+		loaded, err := database.loadRdb()
+		database.loading = false
+		if err != nil {
+			logger.Error("RDB加载失败")
+			panic(err)
+		}
+		rdbLoaded = loaded
 	}
 	// 初始化 aofHandler 先查看有没有开启这个功能
 	if config.Properties.AppendOnly {
 		// 这边传递的是 database 指针
 		// 因为 database 实现的接口的方式是通过结构体指针（指针接收者）
 		// new 的时候就会恢复数据了
-		aofHandler, err := aof.NewAofHandler(database)
+		database.loading = true
+		aofHandler, err := aof.NewAofHandler(database, makeTmpDatabase)
+		database.loading = false
 		if err != nil {
-			panic(err)
 			logger.Error("AOF启动失败")
+			panic(err)
 		}
 		database.aofHandler = aofHandler
-		// 初始化 db 中的 addAof 方法
-		for _, db := range database.dbSet {
+		if rdbLoaded {
+			database.writeLoadedToAof()
+		}
+	}
+	// 初始化 db 中的 addAof 方法，每次写入都算一次修改
+	for _, db := range database.dbSet {
 
-			// for range 使用闭包 坑
-			// 在没有将变量 db 的拷贝值传进匿名函数之前，只能获取最后一次循环的值
+		// for range 使用闭包 坑
+		// 在没有将变量 db 的拷贝值传进匿名函数之前，只能获取最后一次循环的值
 
-			//db.addAof = func(line CmdLine) {
-			//	// 这个 db.index 都是15
-			//	fmt.Println(db.index)
-			//	database.aofHandler.AddAof(db.index, line)
-			//}
+		//db.addAof = func(line CmdLine) {
+		//	// 这个 db.index 都是15
+		//	fmt.Println(db.index)
+		//	database.aofHandler.AddAof(db.index, line)
+		//}
 
-			sdb := db
-			sdb.addAof = func(line CmdLine) {
+		sdb := db
+		sdb.addAof = func(line CmdLine) {
+			atomic.AddInt64(&database.dirty, 1)
+			if database.aofHandler != nil {
 				database.aofHandler.AddAof(sdb.index, line)
 			}
 		}
 	}
+	// 过期 key 的定期删除
+	go database.activeExpireLoop()
+	// 按照 save 规则自动保存 RDB
+	database.rdbState.lastSave = time.Now()
+	database.rdbState.lastBgsaveTime = -1
+	database.saveRules = parseSaveRules(config.Properties.Save)
+	if len(database.saveRules) > 0 {
+		go database.saveLoop()
+	}
 
 	return database
 }
 
+// aofExists 开启了 AOF 并且 AOF 文件已经存在，这时只从 AOF 恢复数据
+func aofExists() bool {
+	if !config.Properties.AppendOnly {
+		return false
+	}
+	return aof.Exists()
+}
+
+// makeStandaloneDatabase 创建只在内存中的数据库，不开启 aof 和定期删除
+func makeStandaloneDatabase() *StandaloneDatabase {
+	database := &StandaloneDatabase{
+		closed: make(chan struct{}),
+		hub:    pubsub.MakeHub(),
+	}
+	if config.Properties.Databases == 0 {
+		config.Properties.Databases = 16
+	}
+	// 键空间通知的配置只在启动时解析一次
+	notifyFlags := parseKeyspaceEvents(config.Properties.NotifyKeyspaceEvents)
+	if notifyFlags < 0 {
+		logger.Error("invalid notify-keyspace-events: " + config.Properties.NotifyKeyspaceEvents)
+		notifyFlags = 0
+	}
+	// 初始化 DB
+	database.dbSet = make([]*DB, config.Properties.Databases)
+	for i := range database.dbSet {
+		db := makeDB()
+		db.index = i
+		db.notifyFlags = notifyFlags
+		db.publish = func(channel []byte, message []byte) {
+			pubsub.Publish(database.hub, [][]byte{channel, message})
+		}
+		database.dbSet[i] = db
+	}
+	return database
+}
+
+// makeTmpDatabase AOF 重写时用来加载旧文件的临时数据库
+// 一直处于加载状态，加载时不会因为内存不足淘汰 key
+func makeTmpDatabase() dbface.DBEngine {
+	database := makeStandaloneDatabase()
+	database.loading = true
+	return database
+}
+
+// ForEach 遍历 db 中没有过期的 key
+func (database *StandaloneDatabase) ForEach(dbIndex int, cb func(key string, entity *dbface.DataEntity, expiration *time.Time) bool) {
+	db := database.dbSet[dbIndex]
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		var expiration *time.Time
+		if expireTime, ok := db.GetExpireTime(key); ok {
+			if time.Now().After(expireTime) {
+				return true
+			}
+			expiration = &expireTime
+		}
+		return cb(key, raw.(*dbface.DataEntity), expiration)
+	})
+}
+
+// activeExpireLoop 每隔一段时间对每个 DB 做一轮定期删除
+func (database *StandaloneDatabase) activeExpireLoop() {
+	ticker := time.NewTicker(activeExpireCycleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, db := range database.dbSet {
+				db.activeExpireCycle()
+			}
+		case <-database.closed:
+			return
+		}
+	}
+}
+
 // set k v
 // get k
 // select 2
@@ -81,30 +198,123 @@ func (database *StandaloneDatabase) Exec(client resp.Connection, args [][]byte)
 	}()
 
 	cmdName := strings.ToLower(string(args[0]))
+	dbIndex := client.GetDBIndex()
+	db := database.dbSet[dbIndex]
+	// 订阅模式下只能执行订阅相关的命令
+	if errReply := pubsub.CheckSubscribeMode(database.hub, client, cmdName); errReply != nil {
+		return errReply
+	}
+	if pubsub.IsPubSubCommand(cmdName) {
+		if client.InMultiState() {
+			errReply := reply.MakeErrReply("ERR Command not allowed inside a transaction")
+			client.AddTxError(errors.New(errReply.Error()))
+			return errReply
+		}
+		return pubsub.Exec(database.hub, client, args)
+	}
+	if isServerCommand(cmdName) {
+		if client.InMultiState() {
+			errReply := reply.MakeErrReply("ERR Command not allowed inside a transaction")
+			client.AddTxError(errors.New(errReply.Error()))
+			return errReply
+		}
+		return execServerCommand(database, cmdName, args)
+	}
+	// 事务相关的命令需要连接的状态
+	if isTxCommand(cmdName) {
+		if cmdName == "exec" && client.InMultiState() && hasWriteCommand(client.GetQueuedCmdLine()) {
+			return database.checkAofWritten(func() resp.Reply {
+				return execTxCommand(database, client, cmdName, args)
+			})
+		}
+		return execTxCommand(database, client, cmdName, args)
+	}
 	// select 是一个特例 他是操作数据库的 不是分db
 	if cmdName == "select" {
+		// 和 Redis 一样，事务中的 SELECT 排队之后按顺序执行
+		if client.InMultiState() {
+			return enqueueSelect(client, args)
+		}
 		if len(args) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
 		return execSelect(client, database, args[1:])
 	}
+	// MULTI 之后的命令先排队，内存不足或者 AOF 写入失败时和 Redis 一样在排队时拒绝
+	if client.InMultiState() {
+		if errReply := database.checkWrite(args); errReply != nil {
+			client.AddTxError(errors.New(errReply.Error()))
+			return errReply
+		}
+		return enqueueCmd(client, args)
+	}
+	if errReply := database.checkWrite(args); errReply != nil {
+		return errReply
+	}
 	//  require multi bulk reply to exec
 	//if cmdName == "ping" {
 	//	return reply.MakePongReply()
 	//}
 
-	dbIndex := client.GetDBIndex()
-	db := database.dbSet[dbIndex]
+	if isWriteCommand(args) {
+		return database.checkAofWritten(func() resp.Reply {
+			return db.Exec(client, args)
+		})
+	}
 	return db.Exec(client, args)
 
 }
 
-func (database *StandaloneDatabase) Close() {
+// checkWrite 执行写命令之前检查 AOF 和内存，返回 nil 表示可以执行
+func (database *StandaloneDatabase) checkWrite(cmdLine CmdLine) reply.ErrorReply {
+	// AOF 无法保证数据落盘之后拒绝写命令，和 Redis 的 MISCONF 一样
+	if database.aofHandler != nil {
+		if err := database.aofHandler.WriteErr(); err != nil && isWriteCommand(cmdLine) {
+			return reply.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+		}
+	}
+	return database.checkMemory(cmdLine)
+}
 
+// checkAofWritten 执行写命令，执行期间 AOF 写入失败时返回错误，不能告诉客户端写入成功
+func (database *StandaloneDatabase) checkAofWritten(exec func() resp.Reply) resp.Reply {
+	result := exec()
+	if database.aofHandler != nil {
+		if err := database.aofHandler.WriteErr(); err != nil {
+			return reply.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+		}
+	}
+	return result
 }
 
-func (database *StandaloneDatabase) AfterClientClose(c resp.Connection) {
+// hasWriteCommand 事务中是否有写命令
+func hasWriteCommand(cmdLines []CmdLine) bool {
+	for _, cmdLine := range cmdLines {
+		if isWriteCommand(cmdLine) {
+			return true
+		}
+	}
+	return false
+}
+
+func (database *StandaloneDatabase) Close() {
+	close(database.closed)
+	// 和 Redis 一样，配置了 save 规则时关闭之前保存一次
+	database.rdbState.wg.Wait()
+	if len(database.saveRules) > 0 {
+		_ = database.save()
+	}
+	if database.aofHandler != nil {
+		database.aofHandler.Close()
+	}
+}
 
+func (database *StandaloneDatabase) AfterClientClose(c resp.Connection) {
+	pubsub.UnsubscribeAll(database.hub, c)
+	database.unwatchAll(c)
+	for _, db := range database.dbSet {
+		db.unblockClient(c)
+	}
 }
 
 // select 2
@@ -116,7 +326,7 @@ func execSelect(c resp.Connection, database *StandaloneDatabase, args [][]byte)
 	if err != nil {
 		return reply.MakeErrReply("ERR invalid DB index")
 	}
-	if dbIndex >= len(database.dbSet) {
+	if dbIndex < 0 || dbIndex >= len(database.dbSet) {
 		return reply.MakeErrReply("ERR DB index is out of range")
 	}
 	c.SelectDB(dbIndex)
@@ -0,0 +1,622 @@
+// Package database -----------------------------
+// @file      : stream.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/26 16:10
+// -------------------------------------------
+package database
+
+import (
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 近似删除时默认最多删除的消息数量，100 * stream-node-max-entries
+const streamDefaultTrimLimit = 100 * 100
+
+// getAsStream 取出 key 对应的消息流，key 不存在时返回 nil
+func (db *DB) getAsStream(key string) (*stream.Stream, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*stream.Stream)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+// nowMs 返回当前的毫秒时间戳
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// msToTime 毫秒时间戳转换为 time.Time
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// timeToMs time.Time 转换为毫秒时间戳
+func timeToMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// streamEntryToReply 一条消息的回复 [id, [field value ...]]，fields 为 nil 时表示消息已经被删除
+func streamEntryToReply(id stream.ID, fields [][]byte) resp.Reply {
+	var fieldsReply resp.Reply
+	if fields == nil {
+		fieldsReply = reply.MakeNullMultiBulkReply()
+	} else {
+		fieldsReply = reply.MakeMultiBulkReply(fields)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(id.String())),
+		fieldsReply,
+	})
+}
+
+func streamEntriesToReply(entries []*stream.Entry) resp.Reply {
+	result := make([]resp.Reply, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, streamEntryToReply(entry.ID, entry.Fields))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func streamIDsToReply(ids []stream.ID) resp.Reply {
+	result := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, []byte(id.String()))
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// parseStreamID 解析完整的 ID，省略 seq 时补 0
+func parseStreamID(arg []byte) (stream.ID, reply.ErrorReply) {
+	id, err := stream.ParseID(string(arg), 0)
+	if err != nil {
+		return stream.ID{}, reply.MakeErrReply(err.Error())
+	}
+	return id, nil
+}
+
+// 消息流的删除策略
+const (
+	trimNone = iota
+	trimMaxLen
+	trimMinID
+)
+
+// streamTrimSpec XADD 和 XTRIM 的删除参数
+type streamTrimSpec struct {
+	strategy int
+	approx   bool
+	maxLen   int64
+	minID    stream.ID
+	limit    int64
+}
+
+// parseStreamTrim 从 args[i] 开始解析 MAXLEN|MINID [=|~] threshold [LIMIT count]
+// args[i] 不是 MAXLEN 或者 MINID 时直接返回 i
+func parseStreamTrim(args [][]byte, i int, spec *streamTrimSpec) (int, reply.ErrorReply) {
+	start := i
+	limitGiven := false
+	for i < len(args) {
+		option := strings.ToUpper(string(args[i]))
+		if option == "LIMIT" && spec.strategy != trimNone {
+			if i+1 >= len(args) {
+				return 0, reply.MakeSyntaxErrReply()
+			}
+			limit, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return 0, errReply
+			}
+			if limit < 0 {
+				return 0, reply.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+			}
+			spec.limit = limit
+			limitGiven = true
+			i += 2
+			continue
+		}
+		if option != "MAXLEN" && option != "MINID" {
+			break
+		}
+		if spec.strategy != trimNone {
+			return 0, reply.MakeErrReply("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+		}
+		i++
+		if i < len(args) && (string(args[i]) == "~" || string(args[i]) == "=") {
+			spec.approx = string(args[i]) == "~"
+			i++
+		}
+		if i >= len(args) {
+			return 0, reply.MakeSyntaxErrReply()
+		}
+		if option == "MAXLEN" {
+			maxLen, errReply := parseInt64(args[i])
+			if errReply != nil {
+				return 0, errReply
+			}
+			if maxLen < 0 {
+				return 0, reply.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+			}
+			spec.strategy = trimMaxLen
+			spec.maxLen = maxLen
+		} else {
+			minID, errReply := parseStreamID(args[i])
+			if errReply != nil {
+				return 0, errReply
+			}
+			spec.strategy = trimMinID
+			spec.minID = minID
+		}
+		i++
+	}
+	if i == start {
+		return i, nil
+	}
+	if limitGiven && !spec.approx {
+		return 0, reply.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if spec.approx && !limitGiven {
+		spec.limit = streamDefaultTrimLimit
+	}
+	return i, nil
+}
+
+// trimStream 按照删除参数删除消息，返回删除的数量
+func trimStream(s *stream.Stream, spec *streamTrimSpec) int64 {
+	switch spec.strategy {
+	case trimMaxLen:
+		return s.TrimByMaxLen(spec.maxLen, spec.approx, spec.limit)
+	case trimMinID:
+		return s.TrimByMinID(spec.minID, spec.approx, spec.limit)
+	}
+	return 0
+}
+
+// streamTrimAof 删除之后的状态用精确的 MAXLEN 记录，重放的时候不依赖近似删除的细节
+func streamTrimAof(key string, s *stream.Stream) CmdLine {
+	return utils.ToCmdLine("xtrim", key, "maxlen", "=", strconv.FormatInt(s.Len(), 10))
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func execXAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	noMkStream := false
+	spec := &streamTrimSpec{}
+	i := 1
+	for i < len(args) {
+		if strings.ToUpper(string(args[i])) == "NOMKSTREAM" {
+			noMkStream = true
+			i++
+			continue
+		}
+		next, errReply := parseStreamTrim(args, i, spec)
+		if errReply != nil {
+			return errReply
+		}
+		if next == i {
+			break
+		}
+		i = next
+	}
+	if i >= len(args) {
+		return reply.MakeSyntaxErrReply()
+	}
+	idArg := string(args[i])
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+	// 先检查 ID 的格式
+	var id stream.ID
+	autoSeq := false
+	if idArg != "*" {
+		if strings.HasSuffix(idArg, "-*") {
+			ms, err := strconv.ParseUint(idArg[:len(idArg)-2], 10, 64)
+			if err != nil {
+				return reply.MakeErrReply(stream.ErrInvalidID.Error())
+			}
+			id.Ms = ms
+			autoSeq = true
+		} else {
+			var errReply reply.ErrorReply
+			id, errReply = parseStreamID(args[i])
+			if errReply != nil {
+				return errReply
+			}
+			if id.IsZero() {
+				return reply.MakeErrReply(stream.ErrIDZero.Error())
+			}
+		}
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	isNew := false
+	if s == nil {
+		if noMkStream {
+			return reply.MakeNullBulkReply()
+		}
+		s = stream.Make()
+		isNew = true
+	}
+	var err error
+	if idArg == "*" {
+		id, err = s.NextID(uint64(nowMs()))
+	} else if autoSeq {
+		id, err = s.NextSeqID(id.Ms)
+	}
+	if err == nil {
+		err = s.Add(id, fields)
+	}
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	if isNew {
+		db.PutEntity(key, &database.DataEntity{
+			Data: s,
+		})
+	}
+	// 使用确定的 ID 记录，重放的时候得到相同的消息
+	db.addAof(utils.ToCmdLine2("xadd", append([][]byte{args[0], []byte(id.String())}, fields...)...))
+	db.notifyKeyspaceEvent(notifyStream, "xadd", key)
+	if trimStream(s, spec) > 0 {
+		db.addAof(streamTrimAof(key, s))
+		db.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+	return reply.MakeBulkReply([]byte(id.String()))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	spec := &streamTrimSpec{}
+	i, errReply := parseStreamTrim(args, 1, spec)
+	if errReply != nil {
+		return errReply
+	}
+	if spec.strategy == trimNone || i != len(args) {
+		return reply.MakeSyntaxErrReply()
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := trimStream(s, spec)
+	if removed > 0 {
+		db.addAof(streamTrimAof(key, s))
+		db.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// XLEN key
+func execXLen(db *DB, args [][]byte) resp.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(s.Len())
+}
+
+// XDEL key id [id ...]
+func execXDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ids := make([]stream.ID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	var deleted int64 = 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine2("xdel", args...))
+		db.notifyKeyspaceEvent(notifyStream, "xdel", string(args[0]))
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// execXRangeGeneric XRANGE key start end [COUNT count] 和 XREVRANGE key end start [COUNT count]
+func execXRangeGeneric(db *DB, args [][]byte, rev bool) resp.Reply {
+	key := string(args[0])
+	startArg, endArg := string(args[1]), string(args[2])
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := stream.ParseRangeID(startArg, false)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	end, err := stream.ParseRangeID(endArg, true)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	count := -1
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+			return reply.MakeSyntaxErrReply()
+		}
+		n, errReply := parseInt64(args[4])
+		if errReply != nil {
+			return errReply
+		}
+		count = int(n)
+		if count < 0 {
+			count = 0
+		}
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil || count == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	return streamEntriesToReply(s.Range(start, end, count, rev))
+}
+
+// XRANGE key start end [COUNT count]
+func execXRange(db *DB, args [][]byte) resp.Reply {
+	return execXRangeGeneric(db, args, false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args [][]byte) resp.Reply {
+	return execXRangeGeneric(db, args, true)
+}
+
+// streamReadArgs XREAD 和 XREADGROUP 共用的参数
+type streamReadArgs struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	group   string
+	// XREADGROUP GROUP group consumer
+	consumer string
+	keys     []string
+	ids      []string
+}
+
+// parseStreamRead 解析 [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func parseStreamRead(cmdName string, args [][]byte, readGroup bool) (*streamReadArgs, reply.ErrorReply) {
+	readArgs := &streamReadArgs{}
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "COUNT" && i+1 < len(args):
+			count, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			if count < 0 {
+				count = 0
+			}
+			readArgs.count = int(count)
+			i++
+		case option == "BLOCK" && i+1 < len(args):
+			timeout, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return nil, reply.MakeErrReply("ERR timeout is not an integer or out of range")
+			}
+			if timeout < 0 {
+				return nil, reply.MakeErrReply("ERR timeout is negative")
+			}
+			readArgs.block = true
+			readArgs.timeout = time.Duration(timeout) * time.Millisecond
+			i++
+		case option == "GROUP" && readGroup && i+2 < len(args):
+			readArgs.group = string(args[i+1])
+			readArgs.consumer = string(args[i+2])
+			i += 2
+		case option == "NOACK" && readGroup:
+			readArgs.noAck = true
+		case option == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, reply.MakeErrReply("ERR Unbalanced '" + cmdName +
+					"' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			half := len(rest) / 2
+			for j := 0; j < half; j++ {
+				readArgs.keys = append(readArgs.keys, string(rest[j]))
+				readArgs.ids = append(readArgs.ids, string(rest[half+j]))
+			}
+			i = len(args)
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	if readArgs.keys == nil {
+		return nil, reply.MakeSyntaxErrReply()
+	}
+	if readGroup && readArgs.group == "" {
+		return nil, reply.MakeErrReply("ERR Missing GROUP option for XREADGROUP")
+	}
+	return readArgs, nil
+}
+
+// prepareXRead 读 STREAMS 之后的 key
+func prepareXRead(args [][]byte) ([]string, []string) {
+	readArgs, errReply := parseStreamRead("xread", args, false)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, readArgs.keys
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func execXRead(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	readArgs, errReply := parseStreamRead("xread", args, false)
+	if errReply != nil {
+		return errReply
+	}
+	// 先确定每个 key 的起点，$ 表示当前最后一条消息之后
+	starts := make([]stream.ID, len(readArgs.keys))
+	for i, key := range readArgs.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		switch readArgs.ids[i] {
+		case "$":
+			if s != nil {
+				starts[i] = s.LastID()
+			}
+		case ">":
+			return reply.MakeErrReply("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		default:
+			id, err := stream.ParseID(readArgs.ids[i], 0)
+			if err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			starts[i] = id
+		}
+	}
+	read := func() resp.Reply {
+		result := make([]resp.Reply, 0)
+		for i, key := range readArgs.keys {
+			s, _ := db.getAsStream(key)
+			if s == nil {
+				continue
+			}
+			// 读取的是起点之后的消息
+			start, ok := starts[i].Incr()
+			if !ok {
+				continue
+			}
+			entries := s.Range(start, stream.MaxID, readArgs.count, false)
+			if len(entries) == 0 {
+				continue
+			}
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(key)),
+				streamEntriesToReply(entries),
+			}))
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		return reply.MakeMultiRawReply(result)
+	}
+	if !readArgs.block {
+		if result := read(); result != nil {
+			return result
+		}
+		return reply.MakeNullMultiBulkReply()
+	}
+	result := db.block(c, &blockingWait{
+		keys:     readArgs.keys,
+		readKeys: readArgs.keys,
+		timeout:  readArgs.timeout,
+	}, read)
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func execXSetID(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	lastID, errReply := parseStreamID(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	var entriesAdded int64 = -1
+	var maxDeletedID stream.ID
+	maxDeletedGiven := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "ENTRIESADDED":
+			entriesAdded, errReply = parseInt64(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			if entriesAdded < 0 {
+				return reply.MakeErrReply("ERR entries_added must be positive")
+			}
+		case "MAXDELETEDID":
+			maxDeletedID, errReply = parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			if lastID.Less(maxDeletedID) {
+				return reply.MakeErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			maxDeletedGiven = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	if entriesAdded != -1 && s.Len() > entriesAdded {
+		return reply.MakeErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if s.Len() > 0 && lastID.Less(s.Last().ID) {
+		return reply.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(lastID)
+	if entriesAdded != -1 {
+		s.SetEntriesAdded(entriesAdded)
+	}
+	if maxDeletedGiven {
+		s.SetMaxDeletedID(maxDeletedID)
+	}
+	db.addAof(utils.ToCmdLine2("xsetid", args...))
+	db.notifyKeyspaceEvent(notifyStream, "xsetid", string(args[0]))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("XAdd", execXAdd, writeFirstKey, -5)
+	RegisterCommand("XTrim", execXTrim, writeFirstKey, -4)
+	RegisterCommand("XLen", execXLen, readFirstKey, 2)
+	RegisterCommand("XDel", execXDel, writeFirstKey, -3)
+	RegisterCommand("XRange", execXRange, readFirstKey, -4)
+	RegisterCommand("XRevRange", execXRevRange, readFirstKey, -4)
+	RegisterBlockingCommand("XRead", execXRead, prepareXRead, -4)
+	RegisterCommand("XSetID", execXSetID, writeFirstKey, -3)
+}
@@ -0,0 +1,937 @@
+// Package database -----------------------------
+// @file      : stream_group.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/27 10:30
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XAUTOCLAIM 默认认领的消息数量
+const streamAutoClaimDefaultCount = 100
+
+func makeNoGroupErrReply(key string, group string) reply.ErrorReply {
+	return reply.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// getStreamGroup 取出 key 对应的消息流和消费组，任意一个不存在时返回 NOGROUP 错误
+func (db *DB) getStreamGroup(key string, groupName string) (*stream.Stream, *stream.Group, reply.ErrorReply) {
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil {
+		return nil, nil, makeNoGroupErrReply(key, groupName)
+	}
+	group := s.GetGroup(groupName)
+	if group == nil {
+		return nil, nil, makeNoGroupErrReply(key, groupName)
+	}
+	return s, group, nil
+}
+
+// streamClaimAof 把消息的投递状态记录为 XCLAIM，重放的时候得到相同的待确认列表
+// 参考 Redis 的 streamPropagateXCLAIM
+func streamClaimAof(key string, group *stream.Group, pe *stream.PendingEntry) CmdLine {
+	return utils.ToCmdLine("xclaim", key, group.Name, pe.Consumer.Name, "0", pe.ID.String(),
+		"TIME", strconv.FormatInt(timeToMs(pe.DeliveryTime), 10),
+		"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", group.LastID.String())
+}
+
+// streamGroupPositionAof 记录消费组最后投递的 ID 和已读数量
+func streamGroupPositionAof(key string, group *stream.Group) CmdLine {
+	return utils.ToCmdLine("xgroup", "setid", key, group.Name, group.LastID.String(),
+		"ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10))
+}
+
+// parseGroupStartID 解析 XGROUP CREATE 和 SETID 的 ID，$ 表示最后一条消息
+func parseGroupStartID(s *stream.Stream, arg []byte) (stream.ID, reply.ErrorReply) {
+	if string(arg) == "$" {
+		if s == nil {
+			return stream.MinID, nil
+		}
+		return s.LastID(), nil
+	}
+	return parseStreamID(arg)
+}
+
+// parseEntriesRead 解析 [ENTRIESREAD entries-read]
+func parseEntriesRead(args [][]byte) (int64, reply.ErrorReply) {
+	if len(args) == 0 {
+		return stream.InvalidEntriesRead, nil
+	}
+	if len(args) != 2 || strings.ToUpper(string(args[0])) != "ENTRIESREAD" {
+		return 0, reply.MakeSyntaxErrReply()
+	}
+	entriesRead, errReply := parseInt64(args[1])
+	if errReply != nil {
+		return 0, errReply
+	}
+	if entriesRead < 0 && entriesRead != stream.InvalidEntriesRead {
+		return 0, reply.MakeErrReply("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return entriesRead, nil
+}
+
+// prepareXGroup XGROUP subcommand key ...
+func prepareXGroup(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[1])}, nil
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func execXGroup(db *DB, args [][]byte) resp.Reply {
+	subCmd := strings.ToUpper(string(args[0]))
+	if len(args) < 3 {
+		return reply.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" +
+			string(args[0]) + "'. Try XGROUP HELP.")
+	}
+	key := string(args[1])
+	groupName := string(args[2])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	mkStream := false
+	var rest [][]byte
+	switch subCmd {
+	case "CREATE":
+		if len(args) < 4 {
+			return reply.MakeArgNumErrReply("xgroup|create")
+		}
+		rest = args[4:]
+		if len(rest) > 0 && strings.ToUpper(string(rest[0])) == "MKSTREAM" {
+			mkStream = true
+			rest = rest[1:]
+		}
+	case "SETID":
+		if len(args) < 4 {
+			return reply.MakeArgNumErrReply("xgroup|setid")
+		}
+		rest = args[4:]
+	case "DESTROY":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("xgroup|destroy")
+		}
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 4 {
+			return reply.MakeArgNumErrReply("xgroup|" + strings.ToLower(subCmd))
+		}
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XGROUP HELP.")
+	}
+	if s == nil && !mkStream {
+		return reply.MakeErrReply("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+
+	switch subCmd {
+	case "CREATE", "SETID":
+		id, errReply := parseGroupStartID(s, args[3])
+		if errReply != nil {
+			return errReply
+		}
+		entriesRead, errReply := parseEntriesRead(rest)
+		if errReply != nil {
+			return errReply
+		}
+		if subCmd == "CREATE" {
+			if s == nil {
+				s = stream.Make()
+				db.PutEntity(key, &database.DataEntity{
+					Data: s,
+				})
+			}
+			if _, ok := s.CreateGroup(groupName, id, entriesRead); !ok {
+				return reply.MakeErrReply("BUSYGROUP Consumer Group name already exists")
+			}
+			cmdLine := utils.ToCmdLine("xgroup", "create", key, groupName, id.String())
+			if mkStream {
+				cmdLine = append(cmdLine, []byte("MKSTREAM"))
+			}
+			db.addAof(append(cmdLine, []byte("ENTRIESREAD"), []byte(strconv.FormatInt(entriesRead, 10))))
+			db.notifyKeyspaceEvent(notifyStream, "xgroup-create", key)
+			return reply.MakeOkReply()
+		}
+		group := s.GetGroup(groupName)
+		if group == nil {
+			return reply.MakeErrReply("NOGROUP No such consumer group '" + groupName + "' for key name '" + key + "'")
+		}
+		group.LastID = id
+		group.EntriesRead = entriesRead
+		db.addAof(streamGroupPositionAof(key, group))
+		db.notifyKeyspaceEvent(notifyStream, "xgroup-setid", key)
+		return reply.MakeOkReply()
+	case "DESTROY":
+		if !s.DestroyGroup(groupName) {
+			return reply.MakeIntReply(0)
+		}
+		db.addAof(utils.ToCmdLine2("xgroup", args...))
+		db.notifyKeyspaceEvent(notifyStream, "xgroup-destroy", key)
+		return reply.MakeIntReply(1)
+	}
+
+	group := s.GetGroup(groupName)
+	if group == nil {
+		return reply.MakeErrReply("NOGROUP No such consumer group '" + groupName + "' for key name '" + key + "'")
+	}
+	consumerName := string(args[3])
+	if subCmd == "CREATECONSUMER" {
+		if _, created := group.CreateConsumer(consumerName, time.Now()); !created {
+			return reply.MakeIntReply(0)
+		}
+		db.addAof(utils.ToCmdLine2("xgroup", args...))
+		db.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+		return reply.MakeIntReply(1)
+	}
+	// DELCONSUMER 返回被删除的消费者待确认的消息数量
+	pending := group.DeleteConsumer(consumerName)
+	if pending < 0 {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine2("xgroup", args...))
+	db.notifyKeyspaceEvent(notifyStream, "xgroup-delconsumer", key)
+	return reply.MakeIntReply(int64(pending))
+}
+
+// prepareXReadGroup 读取时会修改消费者组的状态，STREAMS 之后的 key 都是要写的
+func prepareXReadGroup(args [][]byte) ([]string, []string) {
+	readArgs, errReply := parseStreamRead("xreadgroup", args, true)
+	if errReply != nil {
+		return nil, nil
+	}
+	return readArgs.keys, nil
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func execXReadGroup(db *DB, c resp.Connection, args [][]byte) resp.Reply {
+	readArgs, errReply := parseStreamRead("xreadgroup", args, true)
+	if errReply != nil {
+		return errReply
+	}
+	// 先检查所有的 key 和 ID，> 表示读取新消息，其他 ID 表示读取该消费者的历史消息
+	historyIDs := make([]*stream.ID, len(readArgs.keys))
+	onlyNew := true
+	for i, key := range readArgs.keys {
+		if _, _, errReply := db.getStreamGroup(key, readArgs.group); errReply != nil {
+			if _, ok := errReply.(*reply.WrongTypeErrReply); ok {
+				return errReply
+			}
+			return reply.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" +
+				readArgs.group + "' in XREADGROUP with GROUP option")
+		}
+		switch readArgs.ids[i] {
+		case ">":
+		case "$":
+			return reply.MakeErrReply("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, or use the > ID " +
+				"to get new messages. The $ ID would just return an empty result set.")
+		default:
+			id, err := stream.ParseID(readArgs.ids[i], 0)
+			if err != nil {
+				return reply.MakeErrReply(err.Error())
+			}
+			historyIDs[i] = &id
+			onlyNew = false
+		}
+	}
+
+	read := func() resp.Reply {
+		now := time.Now()
+		result := make([]resp.Reply, 0)
+		for i, key := range readArgs.keys {
+			s, group, errReply := db.getStreamGroup(key, readArgs.group)
+			if errReply != nil {
+				// 阻塞期间 key 或者消费组被删除了
+				return reply.MakeErrReply("NOGROUP the consumer group this client was blocked on no longer exists")
+			}
+			consumer, created := group.CreateConsumer(readArgs.consumer, now)
+			consumer.SeenTime = now
+			if created {
+				db.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+			}
+			var entriesReply resp.Reply
+			delivered := 0
+			if historyIDs[i] != nil {
+				entriesReply = readConsumerHistory(s, consumer, *historyIDs[i], readArgs.count)
+			} else {
+				start, ok := group.LastID.Incr()
+				entries := make([]*stream.Entry, 0)
+				if ok {
+					entries = s.Range(start, stream.MaxID, readArgs.count, false)
+				}
+				for _, entry := range entries {
+					s.AdvanceGroup(group, entry.ID)
+					if readArgs.noAck {
+						continue
+					}
+					pe := group.Deliver(entry.ID, consumer, now)
+					db.addAof(streamClaimAof(key, group, pe))
+				}
+				delivered = len(entries)
+				if delivered > 0 {
+					consumer.ActiveTime = now
+					db.addAof(streamGroupPositionAof(key, group))
+				}
+				entriesReply = streamEntriesToReply(entries)
+			}
+			if created && (delivered == 0 || readArgs.noAck) {
+				db.addAof(utils.ToCmdLine("xgroup", "createconsumer", key, group.Name, consumer.Name))
+			}
+			// 读取新消息时没有数据的 key 不返回
+			if historyIDs[i] == nil && delivered == 0 {
+				continue
+			}
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(key)),
+				entriesReply,
+			}))
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		return reply.MakeMultiRawReply(result)
+	}
+	// 读取历史消息时不会阻塞
+	if !readArgs.block || !onlyNew {
+		if result := read(); result != nil {
+			return result
+		}
+		return reply.MakeNullMultiBulkReply()
+	}
+	result := db.block(c, &blockingWait{
+		keys:      readArgs.keys,
+		writeKeys: readArgs.keys,
+		timeout:   readArgs.timeout,
+		consume:   true,
+	}, read)
+	if result == nil {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// readConsumerHistory 返回消费者 ID 大于 start 的待确认消息，已经被删除的消息只返回 ID
+func readConsumerHistory(s *stream.Stream, consumer *stream.Consumer, start stream.ID, count int) resp.Reply {
+	result := make([]resp.Reply, 0)
+	for _, pe := range consumer.Pending() {
+		if !start.Less(pe.ID) {
+			continue
+		}
+		if count > 0 && len(result) >= count {
+			break
+		}
+		var fields [][]byte
+		if entry := s.Get(pe.ID); entry != nil {
+			fields = entry.Fields
+		}
+		result = append(result, streamEntryToReply(pe.ID, fields))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// XACK key group id [id ...]
+func execXAck(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ids := make([]stream.ID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	group := s.GetGroup(string(args[1]))
+	if group == nil {
+		return reply.MakeIntReply(0)
+	}
+	var acked int64 = 0
+	for _, id := range ids {
+		if group.Ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		db.addAof(utils.ToCmdLine2("xack", args...))
+	}
+	return reply.MakeIntReply(acked)
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func execXPending(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	groupName := string(args[1])
+	extended := len(args) > 2
+	var minIdle int64 = 0
+	var start, end stream.ID
+	count := 0
+	consumerName := ""
+	if extended {
+		rest := args[2:]
+		if strings.ToUpper(string(rest[0])) == "IDLE" && len(rest) >= 2 {
+			var errReply reply.ErrorReply
+			minIdle, errReply = parseInt64(rest[1])
+			if errReply != nil {
+				return errReply
+			}
+			rest = rest[2:]
+		}
+		if len(rest) != 3 && len(rest) != 4 {
+			return reply.MakeSyntaxErrReply()
+		}
+		var err error
+		start, err = stream.ParseRangeID(string(rest[0]), false)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		end, err = stream.ParseRangeID(string(rest[1]), true)
+		if err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		n, errReply := parseInt64(rest[2])
+		if errReply != nil {
+			return errReply
+		}
+		if n < 0 {
+			n = 0
+		}
+		count = int(n)
+		if len(rest) == 4 {
+			consumerName = string(rest[3])
+		}
+	}
+	_, group, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+
+	if !extended {
+		// 汇总信息 [总数, 最小 ID, 最大 ID, [[消费者, 数量] ...]]
+		if group.PendingLen() == 0 {
+			return reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(0),
+				reply.MakeNullBulkReply(),
+				reply.MakeNullBulkReply(),
+				reply.MakeNullMultiBulkReply(),
+			})
+		}
+		pel := group.PendingRange(stream.MinID, stream.MaxID, 0)
+		consumers := make([]resp.Reply, 0)
+		for _, consumer := range group.Consumers() {
+			if consumer.PendingLen() == 0 {
+				continue
+			}
+			consumers = append(consumers, reply.MakeMultiBulkReply([][]byte{
+				[]byte(consumer.Name),
+				[]byte(strconv.Itoa(consumer.PendingLen())),
+			}))
+		}
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(len(pel))),
+			reply.MakeBulkReply([]byte(pel[0].ID.String())),
+			reply.MakeBulkReply([]byte(pel[len(pel)-1].ID.String())),
+			reply.MakeMultiRawReply(consumers),
+		})
+	}
+
+	result := make([]resp.Reply, 0)
+	if count == 0 {
+		return reply.MakeMultiRawReply(result)
+	}
+	now := time.Now()
+	for _, pe := range group.PendingRange(start, end, 0) {
+		if len(result) >= count {
+			break
+		}
+		if consumerName != "" && pe.Consumer.Name != consumerName {
+			continue
+		}
+		idle := int64(now.Sub(pe.DeliveryTime) / time.Millisecond)
+		if idle < minIdle {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(pe.ID.String())),
+			reply.MakeBulkReply([]byte(pe.Consumer.Name)),
+			reply.MakeIntReply(idle),
+			reply.MakeIntReply(pe.DeliveryCount),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// parseMinIdleTime 解析 XCLAIM 和 XAUTOCLAIM 的 min-idle-time
+func parseMinIdleTime(arg []byte, cmdName string) (int64, reply.ErrorReply) {
+	minIdle, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR Invalid min-idle-time argument for " + cmdName)
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	return minIdle, nil
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func execXClaim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	minIdle, errReply := parseMinIdleTime(args[3], "XCLAIM")
+	if errReply != nil {
+		return errReply
+	}
+	// ID 后面跟着可选参数，遇到第一个不是 ID 的参数就开始解析可选参数
+	ids := make([]stream.ID, 0)
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := stream.ParseID(string(args[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return reply.MakeErrReply(stream.ErrInvalidID.Error())
+	}
+	now := time.Now()
+	deliveryTime := now
+	var retryCount int64 = -1
+	force, justID := false, false
+	var lastID *stream.ID
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case option == "IDLE" && i+1 < len(args):
+			idle, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return reply.MakeErrReply("ERR Invalid IDLE option argument for XCLAIM")
+			}
+			deliveryTime = now.Add(-time.Duration(idle) * time.Millisecond)
+			i++
+		case option == "TIME" && i+1 < len(args):
+			ms, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return reply.MakeErrReply("ERR Invalid TIME option argument for XCLAIM")
+			}
+			deliveryTime = msToTime(ms)
+			i++
+		case option == "RETRYCOUNT" && i+1 < len(args):
+			n, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return reply.MakeErrReply("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			retryCount = n
+			i++
+		case option == "LASTID" && i+1 < len(args):
+			id, errReply := parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			lastID = &id
+			i++
+		default:
+			return reply.MakeErrReply("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+	}
+	// 投递时间不能晚于当前时间
+	if deliveryTime.After(now) {
+		deliveryTime = now
+	}
+
+	s, group, errReply := db.getStreamGroup(key, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if lastID != nil && group.LastID.Less(*lastID) {
+		group.LastID = *lastID
+	}
+	consumer, created := group.CreateConsumer(string(args[2]), now)
+	consumer.SeenTime = now
+	if created {
+		db.addAof(utils.ToCmdLine("xgroup", "createconsumer", key, group.Name, consumer.Name))
+		db.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+	}
+	if lastID != nil {
+		db.addAof(streamGroupPositionAof(key, group))
+	}
+
+	result := make([]resp.Reply, 0)
+	for _, id := range ids {
+		pe := group.GetPending(id)
+		entry := s.Get(id)
+		forced := false
+		if pe == nil {
+			// FORCE 时把还在消息流中但不在待确认列表中的消息加入待确认列表
+			if !force || entry == nil {
+				continue
+			}
+			pe = group.Deliver(id, consumer, deliveryTime)
+			forced = true
+		}
+		if entry == nil {
+			// 消息已经被删除了，从待确认列表中移除
+			group.Ack(id)
+			db.addAof(utils.ToCmdLine("xack", key, group.Name, id.String()))
+			continue
+		}
+		if !forced && minIdle > 0 && int64(now.Sub(pe.DeliveryTime)/time.Millisecond) < minIdle {
+			continue
+		}
+		group.Claim(pe, consumer)
+		pe.DeliveryTime = deliveryTime
+		if retryCount >= 0 {
+			pe.DeliveryCount = retryCount
+		} else if !justID {
+			pe.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+		db.addAof(streamClaimAof(key, group, pe))
+		if justID {
+			result = append(result, reply.MakeBulkReply([]byte(id.String())))
+		} else {
+			result = append(result, streamEntryToReply(entry.ID, entry.Fields))
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func execXAutoClaim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	minIdle, errReply := parseMinIdleTime(args[3], "XAUTOCLAIM")
+	if errReply != nil {
+		return errReply
+	}
+	start, err := stream.ParseRangeID(string(args[4]), false)
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	count := streamAutoClaimDefaultCount
+	justID := false
+	for i := 5; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "JUSTID":
+			justID = true
+		case option == "COUNT" && i+1 < len(args):
+			n, errReply := parseInt64(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			if n < 1 || n > math.MaxInt32/10 {
+				return reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			count = int(n)
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	s, group, errReply := db.getStreamGroup(key, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now()
+	consumer, created := group.CreateConsumer(string(args[2]), now)
+	consumer.SeenTime = now
+	if created {
+		db.addAof(utils.ToCmdLine("xgroup", "createconsumer", key, group.Name, consumer.Name))
+		db.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+	}
+
+	// 最多检查 count * 10 条待确认的消息，避免一次扫描太久
+	attempts := count * 10
+	claimed := make([]resp.Reply, 0)
+	deleted := make([]stream.ID, 0)
+	next := stream.MinID
+	pel := group.PendingRange(start, stream.MaxID, 0)
+	i := 0
+	for ; i < len(pel) && attempts > 0 && count > 0; i++ {
+		attempts--
+		pe := pel[i]
+		if minIdle > 0 && int64(now.Sub(pe.DeliveryTime)/time.Millisecond) < minIdle {
+			continue
+		}
+		entry := s.Get(pe.ID)
+		if entry == nil {
+			group.Ack(pe.ID)
+			db.addAof(utils.ToCmdLine("xack", key, group.Name, pe.ID.String()))
+			deleted = append(deleted, pe.ID)
+			count--
+			continue
+		}
+		group.Claim(pe, consumer)
+		pe.DeliveryTime = now
+		if !justID {
+			pe.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+		db.addAof(streamClaimAof(key, group, pe))
+		if justID {
+			claimed = append(claimed, reply.MakeBulkReply([]byte(pe.ID.String())))
+		} else {
+			claimed = append(claimed, streamEntryToReply(entry.ID, entry.Fields))
+		}
+		count--
+	}
+	// 返回下一次扫描的起点，扫描完了返回 0-0
+	if i < len(pel) {
+		next = pel[i].ID
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(next.String())),
+		reply.MakeMultiRawReply(claimed),
+		streamIDsToReply(deleted),
+	})
+}
+
+// makeInfoReply 把 field value 交替的列表转换成回复
+func makeInfoReply(pairs ...interface{}) resp.Reply {
+	result := make([]resp.Reply, 0, len(pairs))
+	for _, v := range pairs {
+		switch val := v.(type) {
+		case string:
+			result = append(result, reply.MakeBulkReply([]byte(val)))
+		case int64:
+			result = append(result, reply.MakeIntReply(val))
+		case int:
+			result = append(result, reply.MakeIntReply(int64(val)))
+		case resp.Reply:
+			result = append(result, val)
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// streamLagReply 无法计算 lag 时返回 nil
+func streamLagReply(s *stream.Stream, group *stream.Group) resp.Reply {
+	lag, ok := s.Lag(group)
+	if !ok {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(lag)
+}
+
+func entriesReadReply(group *stream.Group) resp.Reply {
+	if group.EntriesRead == stream.InvalidEntriesRead {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(group.EntriesRead)
+}
+
+// prepareXInfo XINFO subcommand key ...
+func prepareXInfo(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+// XINFO STREAM key [FULL [COUNT count]]
+// XINFO GROUPS key
+// XINFO CONSUMERS key group
+func execXInfo(db *DB, args [][]byte) resp.Reply {
+	subCmd := strings.ToUpper(string(args[0]))
+	switch subCmd {
+	case "STREAM":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply("xinfo|stream")
+		}
+	case "GROUPS":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("xinfo|groups")
+		}
+	case "CONSUMERS":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("xinfo|consumers")
+		}
+	default:
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XINFO HELP.")
+	}
+	key := string(args[1])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	now := time.Now()
+
+	switch subCmd {
+	case "GROUPS":
+		result := make([]resp.Reply, 0, s.GroupCount())
+		for _, group := range s.Groups() {
+			result = append(result, makeInfoReply(
+				"name", group.Name,
+				"consumers", group.ConsumerCount(),
+				"pending", group.PendingLen(),
+				"last-delivered-id", group.LastID.String(),
+				"entries-read", entriesReadReply(group),
+				"lag", streamLagReply(s, group),
+			))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "CONSUMERS":
+		group := s.GetGroup(string(args[2]))
+		if group == nil {
+			return reply.MakeErrReply("NOGROUP No such consumer group '" + string(args[2]) + "' for key name '" + key + "'")
+		}
+		result := make([]resp.Reply, 0, group.ConsumerCount())
+		for _, consumer := range group.Consumers() {
+			var inactive int64 = -1
+			if !consumer.ActiveTime.IsZero() {
+				inactive = int64(now.Sub(consumer.ActiveTime) / time.Millisecond)
+			}
+			result = append(result, makeInfoReply(
+				"name", consumer.Name,
+				"pending", consumer.PendingLen(),
+				"idle", int64(now.Sub(consumer.SeenTime)/time.Millisecond),
+				"inactive", inactive,
+			))
+		}
+		return reply.MakeMultiRawReply(result)
+	}
+
+	// XINFO STREAM
+	full := false
+	count := 10
+	if len(args) > 2 {
+		if strings.ToUpper(string(args[2])) != "FULL" {
+			return reply.MakeSyntaxErrReply()
+		}
+		full = true
+		if len(args) > 3 {
+			if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+				return reply.MakeSyntaxErrReply()
+			}
+			n, errReply := parseInt64(args[4])
+			if errReply != nil {
+				return errReply
+			}
+			count = int(n)
+			if count < 0 {
+				count = 0
+			}
+		}
+	}
+	if !full {
+		var firstEntry, lastEntry resp.Reply = reply.MakeNullBulkReply(), reply.MakeNullBulkReply()
+		if first := s.First(); first != nil {
+			firstEntry = streamEntryToReply(first.ID, first.Fields)
+			last := s.Last()
+			lastEntry = streamEntryToReply(last.ID, last.Fields)
+		}
+		return makeInfoReply(
+			"length", s.Len(),
+			"radix-tree-keys", s.BlockCount(),
+			"radix-tree-nodes", s.BlockCount(),
+			"last-generated-id", s.LastID().String(),
+			"max-deleted-entry-id", s.MaxDeletedID().String(),
+			"entries-added", s.EntriesAdded(),
+			"recorded-first-entry-id", s.FirstID().String(),
+			"groups", s.GroupCount(),
+			"first-entry", firstEntry,
+			"last-entry", lastEntry,
+		)
+	}
+
+	// FULL 返回消息、消费组、待确认列表和消费者的详细信息，COUNT 为 0 表示全部返回
+	groups := make([]resp.Reply, 0, s.GroupCount())
+	for _, group := range s.Groups() {
+		pel := make([]resp.Reply, 0)
+		for _, pe := range group.PendingRange(stream.MinID, stream.MaxID, count) {
+			pel = append(pel, makeInfoReply(
+				pe.ID.String(),
+				pe.Consumer.Name,
+				timeToMs(pe.DeliveryTime),
+				pe.DeliveryCount,
+			))
+		}
+		consumers := make([]resp.Reply, 0, group.ConsumerCount())
+		for _, consumer := range group.Consumers() {
+			consumerPel := make([]resp.Reply, 0)
+			for _, pe := range consumer.Pending() {
+				if count > 0 && len(consumerPel) >= count {
+					break
+				}
+				consumerPel = append(consumerPel, makeInfoReply(
+					pe.ID.String(),
+					timeToMs(pe.DeliveryTime),
+					pe.DeliveryCount,
+				))
+			}
+			var activeTime int64 = -1
+			if !consumer.ActiveTime.IsZero() {
+				activeTime = timeToMs(consumer.ActiveTime)
+			}
+			consumers = append(consumers, makeInfoReply(
+				"name", consumer.Name,
+				"seen-time", timeToMs(consumer.SeenTime),
+				"active-time", activeTime,
+				"pel-count", consumer.PendingLen(),
+				"pending", reply.MakeMultiRawReply(consumerPel),
+			))
+		}
+		groups = append(groups, makeInfoReply(
+			"name", group.Name,
+			"last-delivered-id", group.LastID.String(),
+			"entries-read", entriesReadReply(group),
+			"lag", streamLagReply(s, group),
+			"pel-count", group.PendingLen(),
+			"pending", reply.MakeMultiRawReply(pel),
+			"consumers", reply.MakeMultiRawReply(consumers),
+		))
+	}
+	return makeInfoReply(
+		"length", s.Len(),
+		"radix-tree-keys", s.BlockCount(),
+		"radix-tree-nodes", s.BlockCount(),
+		"last-generated-id", s.LastID().String(),
+		"max-deleted-entry-id", s.MaxDeletedID().String(),
+		"entries-added", s.EntriesAdded(),
+		"recorded-first-entry-id", s.FirstID().String(),
+		"entries", streamEntriesToReply(s.Range(stream.MinID, stream.MaxID, count, false)),
+		"groups", reply.MakeMultiRawReply(groups),
+	)
+}
+
+func init() {
+	RegisterCommand("XGroup", execXGroup, prepareXGroup, -2)
+	RegisterBlockingCommand("XReadGroup", execXReadGroup, prepareXReadGroup, -7)
+	RegisterCommand("XAck", execXAck, writeFirstKey, -4)
+	RegisterCommand("XPending", execXPending, readFirstKey, -3)
+	RegisterCommand("XClaim", execXClaim, writeFirstKey, -6)
+	RegisterCommand("XAutoClaim", execXAutoClaim, writeFirstKey, -6)
+	RegisterCommand("XInfo", execXInfo, prepareXInfo, -2)
+}
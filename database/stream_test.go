@@ -0,0 +1,83 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"testing"
+)
+
+func TestXAdd(t *testing.T) {
+	db := makeDB()
+	result := db.Exec(nil, utils.ToCmdLine("xadd", "s", "1-1", "f", "v"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "1-1" {
+		t.Errorf("expected 1-1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("xadd", "s", "1-*", "f", "v"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "1-2" {
+		t.Errorf("expected 1-2, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("xadd", "s", "1-2", "f", "v"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("xadd", "s", "maxlen", "1", "3", "f", "v"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "3-0" {
+		t.Errorf("expected 3-0, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("xlen", "s"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("xadd", "s2", "nomkstream", "*", "f", "v"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Errorf("expected nil, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("type", "s"))
+	if string(result.ToBytes()) != "+stream\r\n" {
+		t.Errorf("expected stream, actually %s", string(result.ToBytes()))
+	}
+}
+
+// 消费组的状态通过 AOF 重放之后保持一致
+func TestStreamGroupAof(t *testing.T) {
+	db := makeDB()
+	cmdLines := make([]CmdLine, 0)
+	db.addAof = func(line CmdLine) {
+		cmdLines = append(cmdLines, line)
+	}
+	for _, cmdLine := range [][]string{
+		{"xadd", "s", "*", "f", "1"},
+		{"xadd", "s", "*", "f", "2"},
+		{"xadd", "s", "*", "f", "3"},
+		{"xgroup", "create", "s", "g", "0"},
+		{"xreadgroup", "group", "g", "c1", "count", "2", "streams", "s", ">"},
+		{"xclaim", "s", "g", "c2", "0", "0-1"},
+		{"xautoclaim", "s", "g", "c3", "0", "0", "count", "1"},
+		{"xadd", "s", "maxlen", "2", "*", "f", "4"},
+	} {
+		result := db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		if reply.IsErrReply(result) {
+			t.Fatalf("%v: %s", cmdLine, string(result.ToBytes()))
+		}
+	}
+
+	replayed := makeDB()
+	for _, cmdLine := range cmdLines {
+		result := replayed.Exec(nil, cmdLine)
+		if reply.IsErrReply(result) {
+			t.Fatalf("replay %q: %s", cmdLine, string(result.ToBytes()))
+		}
+	}
+	for _, cmdLine := range [][]string{
+		{"xrange", "s", "-", "+"},
+		{"xpending", "s", "g"},
+		{"xinfo", "groups", "s"},
+		{"xreadgroup", "group", "g", "c3", "streams", "s", "0"},
+	} {
+		expected := db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		actual := replayed.Exec(nil, utils.ToCmdLine(cmdLine...))
+		if !utils.BytesEquals(expected.ToBytes(), actual.ToBytes()) {
+			t.Errorf("%v: expected %q, actually %q", cmdLine, expected.ToBytes(), actual.ToBytes())
+		}
+	}
+}
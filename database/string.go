@@ -7,36 +7,154 @@
 package database
 
 import (
+	"math"
 	"redis-go/interface/database"
 	"redis-go/interface/resp"
 	"redis-go/lib/utils"
 	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// 字符串的最大长度，和 Redis 的 proto-max-bulk-len 默认值一致
+const maxStringLen = 512 * 1024 * 1024
+
+// getAsString 取出 key 对应的字符串，key 不存在时返回 nil
+func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	// 其他类型需要判断转化是否成功
+	bytes, ok := entity.Data.([]byte)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return bytes, nil
+}
+
 // GET k1
 func execGet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, exists := db.GetEntity(key)
-	if !exists {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
 		return reply.MakeNullBulkReply()
 	}
-	// 这边只有string 如果是其他类型需要判断转化是否成功
-	bytes := entity.Data.([]byte)
 	return reply.MakeBulkReply(bytes)
 }
 
-// SET k1 v
+// SET 的写入条件
+const (
+	upsertPolicy = iota // 默认
+	insertPolicy        // NX
+	updatePolicy        // XX
+)
+
+// SET k1 v [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func execSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	value := args[1]
+	policy := upsertPolicy
+	get, keepTTL, hasExpire := false, false, false
+	var expireTime time.Time
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "NX":
+			if policy == updatePolicy {
+				return reply.MakeSyntaxErrReply()
+			}
+			policy = insertPolicy
+		case "XX":
+			if policy == insertPolicy {
+				return reply.MakeSyntaxErrReply()
+			}
+			policy = updatePolicy
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			if hasExpire {
+				return reply.MakeSyntaxErrReply()
+			}
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			// 过期时间只能设置一次，并且不能和 KEEPTTL 同时出现
+			if hasExpire || keepTTL || i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			t, errReply := parseExpireTime("set", option, args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			expireTime = t
+			hasExpire = true
+			i++
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	// GET 需要返回原来的值，原来的值不是字符串时不做任何修改
+	var old []byte
+	if get {
+		var errReply reply.ErrorReply
+		old, errReply = db.getAsString(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
 	entity := &database.DataEntity{
 		Data: value,
 	}
-	db.PutEntity(key, entity)
+	var result int
+	switch policy {
+	case upsertPolicy:
+		db.PutEntity(key, entity)
+		result = 1
+	case insertPolicy:
+		result = db.PutIfAbsent(key, entity)
+	case updatePolicy:
+		result = db.PutIfExists(key, entity)
+	}
 
-	// 往文件里面写指令
-	db.addAof(utils.ToCmdLine2("set", args...))
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+		if hasExpire {
+			if !expireTime.After(time.Now()) {
+				// 过期时间已经过去了，等同于写入之后立即删除
+				db.Remove(key)
+				db.addAof(utils.ToCmdLine("del", key))
+				db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+			} else {
+				db.Expire(key, expireTime)
+				db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+				db.addAof(toTTLCmd(key, expireTime))
+				db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+			}
+		} else if keepTTL {
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1], []byte("keepttl")))
+		} else {
+			// SET 会清除原有的过期时间
+			db.Persist(key)
+			// 往文件里面写指令
+			db.addAof(utils.ToCmdLine2("set", args[0], args[1]))
+		}
+	}
 
+	if get {
+		if old == nil {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply(old)
+	}
+	if result == 0 {
+		return reply.MakeNullBulkReply()
+	}
 	return reply.MakeOkReply()
 }
 
@@ -48,6 +166,9 @@ func execSetnx(db *DB, args [][]byte) resp.Reply {
 		Data: value,
 	}
 	result := db.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+	}
 
 	db.addAof(utils.ToCmdLine2("setnx", args...))
 
@@ -57,37 +178,445 @@ func execSetnx(db *DB, args [][]byte) resp.Reply {
 // GETSET k1 v1
 func execGetSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	value := string(args[1])
+	value := args[1]
 	// 读取原来的值 返回用
-	entity, exists := db.GetEntity(key)
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
 	// 设置新的值
 	db.PutEntity(key, &database.DataEntity{
 		Data: value,
 	})
+	db.Persist(key)
+	db.notifyKeyspaceEvent(notifyString, "set", key)
 
 	db.addAof(utils.ToCmdLine2("getset", args...))
 
-	if !exists {
+	if old == nil {
 		return reply.MakeNullBulkReply()
 	}
-	return reply.MakeBulkReply(entity.Data.([]byte))
+	return reply.MakeBulkReply(old)
 }
 
 // STRLEN
 func execStrLen(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, exists := db.GetEntity(key)
-	if !exists {
-		return reply.MakeNullBulkReply()
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
 	}
-	bytes := entity.Data.([]byte)
+	if bytes == nil {
+		db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+	}
+	// 不存在的 key 长度为 0
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
+// parseInt64 解析整数参数，失败时返回和 Redis 一致的错误
+func parseInt64(arg []byte) (int64, reply.ErrorReply) {
+	value, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return value, nil
+}
+
+// incrGeneric INCR DECR INCRBY DECRBY 的公共逻辑，cmdLine 为原样写入 aof 的指令
+func incrGeneric(db *DB, key string, delta int64, cmdLine CmdLine) resp.Reply {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	var current int64
+	if bytes != nil {
+		value, err := strconv.ParseInt(string(bytes), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		current = value
+	}
+	// 溢出检测
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	result := current + delta
+	// 修改值不影响原有的过期时间
+	db.PutEntity(key, &database.DataEntity{
+		Data: []byte(strconv.FormatInt(result, 10)),
+	})
+	// INCR DECR DECRBY 都是 incrby 事件
+	db.notifyKeyspaceEvent(notifyString, "incrby", key)
+
+	db.addAof(cmdLine)
+	return reply.MakeIntReply(result)
+}
+
+// INCR k1
+func execIncr(db *DB, args [][]byte) resp.Reply {
+	return incrGeneric(db, string(args[0]), 1, utils.ToCmdLine2("incr", args...))
+}
+
+// DECR k1
+func execDecr(db *DB, args [][]byte) resp.Reply {
+	return incrGeneric(db, string(args[0]), -1, utils.ToCmdLine2("decr", args...))
+}
+
+// INCRBY k1 increment
+func execIncrBy(db *DB, args [][]byte) resp.Reply {
+	delta, errReply := parseInt64(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	return incrGeneric(db, string(args[0]), delta, utils.ToCmdLine2("incrby", args...))
+}
+
+// DECRBY k1 decrement
+func execDecrBy(db *DB, args [][]byte) resp.Reply {
+	delta, errReply := parseInt64(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	// -MinInt64 无法表示
+	if delta == math.MinInt64 {
+		return reply.MakeErrReply("ERR decrement would overflow")
+	}
+	return incrGeneric(db, string(args[0]), -delta, utils.ToCmdLine2("decrby", args...))
+}
+
+// INCRBYFLOAT k1 increment
+func execIncrByFloat(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	var current float64
+	if bytes != nil {
+		current, err = strconv.ParseFloat(string(bytes), 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return reply.MakeErrReply("ERR value is not a valid float")
+		}
+	}
+	result := current + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return reply.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+	resultBytes := []byte(utils.FormatFloat(result))
+	db.PutEntity(key, &database.DataEntity{
+		Data: resultBytes,
+	})
+	db.notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
+
+	// 浮点运算的结果与平台有关，和 Redis 一样以 SET KEEPTTL 的形式写入 aof
+	db.addAof(utils.ToCmdLine2("set", args[0], resultBytes, []byte("keepttl")))
+	return reply.MakeBulkReply(resultBytes)
+}
+
+// APPEND k1 value
+func execAppend(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(bytes)+len(args[1]) > maxStringLen {
+		return reply.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	// 拷贝一份，避免和参数共用底层数组
+	value := make([]byte, 0, len(bytes)+len(args[1]))
+	value = append(value, bytes...)
+	value = append(value, args[1]...)
+	db.PutEntity(key, &database.DataEntity{
+		Data: value,
+	})
+
+	db.notifyKeyspaceEvent(notifyString, "append", key)
+
+	db.addAof(utils.ToCmdLine2("append", args...))
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// SETRANGE k1 offset value
+func execSetRange(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, errReply := parseInt64(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if offset < 0 {
+		return reply.MakeErrReply("ERR offset is out of range")
+	}
+	value := args[2]
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	// 空的 value 不修改字符串，也不会创建 key
+	if len(value) == 0 {
+		return reply.MakeIntReply(int64(len(bytes)))
+	}
+	// 先减再比较，offset 很大时相加会溢出
+	if offset > maxStringLen-int64(len(value)) {
+		return reply.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+	size := len(bytes)
+	if end := int(offset) + len(value); end > size {
+		size = end
+	}
+	// 超出原长度的部分用 0 填充
+	result := make([]byte, size)
+	copy(result, bytes)
+	copy(result[offset:], value)
+	db.PutEntity(key, &database.DataEntity{
+		Data: result,
+	})
+
+	db.notifyKeyspaceEvent(notifyString, "setrange", key)
+
+	db.addAof(utils.ToCmdLine2("setrange", args...))
+	return reply.MakeIntReply(int64(len(result)))
+}
+
+// GETRANGE k1 start end
+func execGetRange(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, errReply := parseInt64(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseInt64(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+	}
+	size := int64(len(bytes))
+	// 负数表示从末尾开始计算，end 是闭区间
+	if start < 0 && end < 0 && start > end {
+		return reply.MakeBulkReply([]byte{})
+	}
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if size == 0 || start > end {
+		return reply.MakeBulkReply([]byte{})
+	}
+	return reply.MakeBulkReply(bytes[start : end+1])
+}
+
+// writePairKeys k1 v1 [k2 v2 ...] 中的 key
+func writePairKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	return keys, nil
+}
+
+// MSET k1 v1 [k2 v2 ...]
+func execMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.PutEntity(key, &database.DataEntity{
+			Data: args[i+1],
+		})
+		db.Persist(key)
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+	}
+
+	db.addAof(utils.ToCmdLine2("mset", args...))
+	return reply.MakeOkReply()
+}
+
+// MSETNX k1 v1 [k2 v2 ...]
+func execMSetNX(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	// 只要有一个 key 存在就都不设置
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.GetEntity(string(args[i])); exists {
+			return reply.MakeIntReply(0)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.PutEntity(string(args[i]), &database.DataEntity{
+			Data: args[i+1],
+		})
+		db.notifyKeyspaceEvent(notifyString, "set", string(args[i]))
+	}
+
+	db.addAof(utils.ToCmdLine2("msetnx", args...))
+	return reply.MakeIntReply(1)
+}
+
+// MGET k1 [k2 ...]
+func execMGet(db *DB, args [][]byte) resp.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		// 不存在或者类型不对的 key 都返回 nil
+		bytes, errReply := db.getAsString(string(arg))
+		if errReply != nil {
+			continue
+		}
+		if bytes == nil {
+			db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", string(arg))
+		}
+		result[i] = bytes
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// GETDEL k1
+func execGetDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+		return reply.MakeNullBulkReply()
+	}
+	db.Remove(key)
+	db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+
+	db.addAof(utils.ToCmdLine2("del", args...))
+	return reply.MakeBulkReply(bytes)
+}
+
+// parseExpireTime 解析 EX PX EXAT PXAT 后面的时间，返回过期的绝对时间
+func parseExpireTime(cmdName string, option string, arg []byte) (time.Time, reply.ErrorReply) {
+	raw, errReply := parseInt64(arg)
+	if errReply != nil {
+		return time.Time{}, errReply
+	}
+	invalid := reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	if raw <= 0 {
+		return time.Time{}, invalid
+	}
+	ms := raw
+	if option == "EX" || option == "EXAT" {
+		if raw > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		ms = raw * 1000
+	}
+	if option == "EX" || option == "PX" {
+		now := time.Now().UnixMilli()
+		if now > math.MaxInt64-ms {
+			return time.Time{}, invalid
+		}
+		ms += now
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// GETEX k1 [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func execGetEx(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var expireTime time.Time
+	hasExpire, persist := false, false
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || persist || i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			t, errReply := parseExpireTime("getex", option, args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			expireTime = t
+			hasExpire = true
+			i++
+		case "PERSIST":
+			if hasExpire || persist {
+				return reply.MakeSyntaxErrReply()
+			}
+			persist = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		db.notifyKeyspaceEvent(notifyKeyMiss, "keymiss", key)
+		return reply.MakeNullBulkReply()
+	}
+	if hasExpire {
+		// 过期时间已经过去了，直接删除
+		if !expireTime.After(time.Now()) {
+			db.Remove(key)
+			db.addAof(utils.ToCmdLine("del", key))
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		} else {
+			db.Expire(key, expireTime)
+			db.addAof(toTTLCmd(key, expireTime))
+			db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+		}
+	} else if persist {
+		if db.Persist(key) > 0 {
+			db.addAof(utils.ToCmdLine("persist", key))
+			db.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+		}
+	}
+	return reply.MakeBulkReply(bytes)
+}
+
 func init() {
-	RegisterCommand("Get", execGet, 2)
-	RegisterCommand("Set", execSet, 3)
-	RegisterCommand("SetNx", execSetnx, 3)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("StrLen", execStrLen, 2)
+	RegisterCommand("Get", execGet, readFirstKey, 2)
+	// SET k1 v [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+	RegisterCommand("Set", execSet, writeFirstKey, -3)
+	RegisterCommand("SetNx", execSetnx, writeFirstKey, 3)
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, 3)
+	RegisterCommand("StrLen", execStrLen, readFirstKey, 2)
+	RegisterCommand("Incr", execIncr, writeFirstKey, 2)
+	RegisterCommand("Decr", execDecr, writeFirstKey, 2)
+	RegisterCommand("IncrBy", execIncrBy, writeFirstKey, 3)
+	RegisterCommand("DecrBy", execDecrBy, writeFirstKey, 3)
+	RegisterCommand("IncrByFloat", execIncrByFloat, writeFirstKey, 3)
+	RegisterCommand("Append", execAppend, writeFirstKey, 3)
+	RegisterCommand("SetRange", execSetRange, writeFirstKey, 4)
+	RegisterCommand("GetRange", execGetRange, readFirstKey, 4)
+	// MSET k1 v1 [k2 v2 ...]
+	RegisterCommand("MSet", execMSet, writePairKeys, -3)
+	RegisterCommand("MSetNX", execMSetNX, writePairKeys, -3)
+	RegisterCommand("MGet", execMGet, readAllKeys, -2)
+	RegisterCommand("GetDel", execGetDel, writeFirstKey, 2)
+	// GETEX k1 [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+	RegisterCommand("GetEx", execGetEx, writeFirstKey, -2)
 }
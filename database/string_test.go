@@ -0,0 +1,136 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+)
+
+func TestIncr(t *testing.T) {
+	db := makeDB()
+	for i := 1; i <= 10; i++ {
+		result := db.Exec(nil, utils.ToCmdLine("incr", "k1"))
+		if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != int64(i) {
+			t.Fatalf("expected %d, actually %s", i, string(result.ToBytes()))
+		}
+	}
+	db.Exec(nil, utils.ToCmdLine("set", "k1", strconv.FormatInt(1<<62, 10)))
+	result := db.Exec(nil, utils.ToCmdLine("incrby", "k1", strconv.FormatInt(1<<62, 10)))
+	if string(result.ToBytes()) != "-ERR increment or decrement would overflow\r\n" {
+		t.Errorf("expected overflow, actually %s", string(result.ToBytes()))
+	}
+	db.Exec(nil, utils.ToCmdLine("set", "k1", "abc"))
+	result = db.Exec(nil, utils.ToCmdLine("decr", "k1"))
+	if string(result.ToBytes()) != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+
+	db.Exec(nil, utils.ToCmdLine("set", "k2", "10.5"))
+	result = db.Exec(nil, utils.ToCmdLine("incrbyfloat", "k2", "0.1"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "10.6" {
+		t.Errorf("expected 10.6, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestStringRange(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("append", "k1", "hello"))
+	db.Exec(nil, utils.ToCmdLine("append", "k1", " world"))
+	result := db.Exec(nil, utils.ToCmdLine("getrange", "k1", "-5", "-1"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "world" {
+		t.Errorf("expected world, actually %s", string(result.ToBytes()))
+	}
+	// 超出原长度的部分用 0 填充
+	result = db.Exec(nil, utils.ToCmdLine("setrange", "k2", "3", "ab"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 5 {
+		t.Errorf("expected 5, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("get", "k2"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "\x00\x00\x00ab" {
+		t.Errorf("unexpected value %q", result.ToBytes())
+	}
+	// 很大的偏移量不会溢出
+	execCases(t, db, []cmdCase{
+		{"setrange k2 9223372036854775807 x", reply.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")},
+		{"setrange k2 536870912 x", reply.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")},
+		{"get k2", reply.MakeBulkReply([]byte("\x00\x00\x00ab"))},
+	})
+}
+
+func TestMSet(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("mset", "k1", "v1", "k2", "v2"))
+	result := db.Exec(nil, utils.ToCmdLine("msetnx", "k2", "v", "k3", "v3"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("mget", "k1", "k2", "k3"))
+	expected := reply.MakeMultiBulkReply([][]byte{[]byte("v1"), []byte("v2"), nil})
+	if !utils.BytesEquals(result.ToBytes(), expected.ToBytes()) {
+		t.Errorf("unexpected mget result %q", result.ToBytes())
+	}
+	result = db.Exec(nil, utils.ToCmdLine("getdel", "k1"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "v1" {
+		t.Errorf("expected v1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("getex", "k2", "ex", "100"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "v2" {
+		t.Errorf("expected v2, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("ttl", "k2"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 100 {
+		t.Errorf("expected 100, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	db := makeDB()
+	result := db.Exec(nil, utils.ToCmdLine("set", "k1", "v1", "ex", "10", "nx"))
+	if _, ok := result.(*reply.OkReply); !ok {
+		t.Errorf("expected ok, actually %s", string(result.ToBytes()))
+	}
+	// 分布式锁：已经存在的时候 NX 失败
+	result = db.Exec(nil, utils.ToCmdLine("set", "k1", "v2", "ex", "10", "nx"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Errorf("expected nil, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("ttl", "k1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 10 {
+		t.Errorf("expected 10, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("set", "k1", "v3", "keepttl", "get"))
+	if bulkResult, ok := result.(*reply.BulkReply); !ok || string(bulkResult.Arg) != "v1" {
+		t.Errorf("expected v1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("ttl", "k1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 10 {
+		t.Errorf("expected 10, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("set", "k2", "v", "xx"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Errorf("expected nil, actually %s", string(result.ToBytes()))
+	}
+
+	for _, cmdLine := range [][]string{
+		{"set", "k1", "v", "nx", "xx"},
+		{"set", "k1", "v", "ex", "10", "px", "100"},
+		{"set", "k1", "v", "ex", "10", "keepttl"},
+		{"set", "k1", "v", "ex"},
+		{"set", "k1", "v", "foo"},
+	} {
+		result = db.Exec(nil, utils.ToCmdLine(cmdLine...))
+		if _, ok := result.(*reply.SyntaxErrReply); !ok {
+			t.Errorf("expected syntax error, actually %s", string(result.ToBytes()))
+		}
+	}
+	result = db.Exec(nil, utils.ToCmdLine("set", "k1", "v", "px", "0"))
+	if string(result.ToBytes()) != "-ERR invalid expire time in 'set' command\r\n" {
+		t.Errorf("unexpected reply %s", string(result.ToBytes()))
+	}
+	db.Exec(nil, utils.ToCmdLine("rpush", "l", "a"))
+	result = db.Exec(nil, utils.ToCmdLine("set", "l", "v", "get"))
+	if _, ok := result.(*reply.WrongTypeErrReply); !ok {
+		t.Errorf("expected wrong type, actually %s", string(result.ToBytes()))
+	}
+}
@@ -0,0 +1,209 @@
+// Package database -----------------------------
+// @file      : transaction.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/30 14:20
+// -------------------------------------------
+package database
+
+import (
+	"errors"
+	"redis-go/interface/resp"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// 事务相关的命令需要访问连接的状态，不在 cmdTable 中，由 StandaloneDatabase 直接处理
+// 参考 Redis 的 multi.c：MULTI 之后的命令只排队，EXEC 的时候一次性执行
+// WATCH 记录 key 的版本号，EXEC 之前版本号变化说明 key 被修改过，放弃整个事务
+
+// isTxCommand 是否是事务相关的命令
+func isTxCommand(cmdName string) bool {
+	switch cmdName {
+	case "multi", "exec", "discard", "watch", "unwatch":
+		return true
+	}
+	return false
+}
+
+// execTxCommand 执行事务相关的命令
+func execTxCommand(database *StandaloneDatabase, c resp.Connection, cmdName string, cmdLine CmdLine) resp.Reply {
+	switch cmdName {
+	case "multi":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return startMulti(c)
+	case "exec":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return database.execMulti(c)
+	case "discard":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return database.discardMulti(c)
+	case "watch":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return database.watch(c, cmdLine[1:])
+	case "unwatch":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		database.unwatchAll(c)
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
+}
+
+// MULTI
+func startMulti(c resp.Connection) resp.Reply {
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// DISCARD
+func (database *StandaloneDatabase) discardMulti(c resp.Connection) resp.Reply {
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	database.unwatchAll(c)
+	c.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// WATCH key [key ...]
+func (database *StandaloneDatabase) watch(c resp.Connection, args [][]byte) resp.Reply {
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	dbIndex := c.GetDBIndex()
+	db := database.dbSet[dbIndex]
+	watching := c.GetWatching()
+	for _, arg := range args {
+		watched := resp.WatchedKey{DBIndex: dbIndex, Key: string(arg)}
+		// 重复 WATCH 保留最早的版本号
+		if _, ok := watching[watched]; ok {
+			continue
+		}
+		// 已经过期的 key 先删除，避免 EXEC 的时候因为过期删除而失败
+		db.mu.RLock()
+		db.locker.Lock(watched.Key)
+		db.IsExpired(watched.Key)
+		watching[watched] = db.watchKey(watched.Key)
+		db.locker.UnLock(watched.Key)
+		db.mu.RUnlock()
+	}
+	return reply.MakeOkReply()
+}
+
+// unwatchAll 取消连接 WATCH 的所有 key，不能在持有 db.mu 的时候调用
+func (database *StandaloneDatabase) unwatchAll(c resp.Connection) {
+	for watched := range c.GetWatching() {
+		database.dbSet[watched.DBIndex].unwatchKey(watched.Key)
+	}
+	c.ClearWatching()
+}
+
+// enqueueCmd MULTI 之后的命令排队，命令不存在或者参数数量错误时整个事务会被放弃
+func enqueueCmd(c resp.Connection, cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		errReply := reply.MakeErrReply("ERR unknown command " + cmdName)
+		c.AddTxError(errors.New(errReply.Error()))
+		return errReply
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		errReply := reply.MakeArgNumErrReply(cmdName)
+		c.AddTxError(errors.New(errReply.Error()))
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// enqueueSelect MULTI 之后的 SELECT 也排队，EXEC 时按顺序切换 db
+func enqueueSelect(c resp.Connection, cmdLine CmdLine) resp.Reply {
+	if len(cmdLine) != 2 {
+		errReply := reply.MakeArgNumErrReply("select")
+		c.AddTxError(errors.New(errReply.Error()))
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// EXEC
+func (database *StandaloneDatabase) execMulti(c resp.Connection) resp.Reply {
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	// 无论执行结果如何都要退出事务并取消 WATCH，取消 WATCH 需要在释放 db.mu 之后
+	defer c.SetMultiState(false)
+	defer database.unwatchAll(c)
+	if len(c.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return database.execQueued(c)
+}
+
+// execQueued 独占事务涉及的所有 db 之后检查 WATCH 并执行排队的命令
+func (database *StandaloneDatabase) execQueued(c resp.Connection) resp.Reply {
+	// WATCH 的 key 所在的 db、当前的 db 和 SELECT 切换到的 db 都需要独占
+	involved := make([]bool, len(database.dbSet))
+	involved[c.GetDBIndex()] = true
+	for watched := range c.GetWatching() {
+		involved[watched.DBIndex] = true
+	}
+	for _, cmdLine := range c.GetQueuedCmdLine() {
+		if strings.ToLower(string(cmdLine[0])) != "select" {
+			continue
+		}
+		if dbIndex, err := strconv.Atoi(string(cmdLine[1])); err == nil && dbIndex >= 0 && dbIndex < len(database.dbSet) {
+			involved[dbIndex] = true
+		}
+	}
+	// 和 BGSAVE 一样按照下标从小到大加锁，避免死锁
+	// 事务中的命令执行期间不会插入其他客户端的命令，不需要再对 key 加锁
+	for i, db := range database.dbSet {
+		if involved[i] {
+			db.mu.Lock()
+			db.inMulti = true
+		}
+	}
+	defer func() {
+		for i, db := range database.dbSet {
+			if involved[i] {
+				db.inMulti = false
+				db.mu.Unlock()
+			}
+		}
+	}()
+	for watched, version := range c.GetWatching() {
+		db := database.dbSet[watched.DBIndex]
+		db.IsExpired(watched.Key)
+		if db.GetVersion(watched.Key) != version {
+			return reply.MakeNullMultiBulkReply()
+		}
+	}
+	// 和 Redis 一样，执行时出错的命令不会回滚，继续执行后面的命令
+	results := make([]resp.Reply, 0, len(c.GetQueuedCmdLine()))
+	for _, cmdLine := range c.GetQueuedCmdLine() {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		if cmdName == "select" {
+			results = append(results, execSelect(c, database, cmdLine[1:]))
+			continue
+		}
+		db := database.dbSet[c.GetDBIndex()]
+		results = append(results, db.execCommand(c, cmdTable[cmdName], cmdLine))
+	}
+	return reply.MakeMultiRawReply(results)
+}
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMulti(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	result := database.Exec(conn, utils.ToCmdLine("multi"))
+	if string(result.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("expected OK, actually %s", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("multi"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected nested error, actually %s", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	if string(result.ToBytes()) != "+QUEUED\r\n" {
+		t.Fatalf("expected QUEUED, actually %s", string(result.ToBytes()))
+	}
+	database.Exec(conn, utils.ToCmdLine("incr", "a"))
+	// 执行时出错不影响其他命令
+	database.Exec(conn, utils.ToCmdLine("lpush", "a", "x"))
+	database.Exec(conn, utils.ToCmdLine("get", "a"))
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	expected := "*4\r\n+OK\r\n:2\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n$1\r\n2\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, string(result.ToBytes()))
+	}
+	if conn.InMultiState() {
+		t.Error("expected multi state cleared")
+	}
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected exec without multi error, actually %s", string(result.ToBytes()))
+	}
+
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	database.Exec(conn, utils.ToCmdLine("discard"))
+	result = database.Exec(conn, utils.ToCmdLine("exists", "b"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestExecAbort(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	result := database.Exec(conn, utils.ToCmdLine("set", "a"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected arity error, actually %s", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("notexist", "a"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected unknown command error, actually %s", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if string(result.ToBytes()) != "-EXECABORT Transaction discarded because of previous errors.\r\n" {
+		t.Errorf("expected EXECABORT, actually %s", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("exists", "a"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestWatch(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	other := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("watch", "a"))
+	database.Exec(other, utils.ToCmdLine("hset", "a", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	result := database.Exec(conn, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Fatalf("expected nil, actually %s", string(result.ToBytes()))
+	}
+	result = database.Exec(conn, utils.ToCmdLine("exists", "b"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+
+	// 读命令不影响 WATCH
+	database.Exec(conn, utils.ToCmdLine("watch", "a"))
+	database.Exec(other, utils.ToCmdLine("hget", "a", "f"))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if string(result.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("expected OK, actually %s", string(result.ToBytes()))
+	}
+
+	// UNWATCH 之后的修改不影响事务
+	database.Exec(conn, utils.ToCmdLine("watch", "b"))
+	database.Exec(conn, utils.ToCmdLine("unwatch"))
+	database.Exec(other, utils.ToCmdLine("del", "b"))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("get", "b"))
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if string(result.ToBytes()) != "*1\r\n$-1\r\n" {
+		t.Errorf("expected nil element, actually %s", string(result.ToBytes()))
+	}
+
+	// 过期删除也算修改
+	database.Exec(conn, utils.ToCmdLine("set", "c", "1", "px", "20"))
+	database.Exec(conn, utils.ToCmdLine("watch", "c"))
+	time.Sleep(30 * time.Millisecond)
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("get", "c"))
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected nil, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestMultiBlockingRead(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("xread", "block", "0", "streams", "s", "$"))
+	// 事务中的阻塞读取立即返回
+	result := database.Exec(conn, utils.ToCmdLine("exec"))
+	if string(result.ToBytes()) != "*1\r\n*-1\r\n" {
+		t.Errorf("expected nil element, actually %s", string(result.ToBytes()))
+	}
+
+	// 阻塞读取等待期间其他客户端可以写入
+	done := make(chan string)
+	go func() {
+		result := database.Exec(&connection.Connection{}, utils.ToCmdLine("xread", "block", "1000", "streams", "s", "$"))
+		done <- string(result.ToBytes())
+	}()
+	time.Sleep(30 * time.Millisecond)
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("xadd", "s", "1-1", "f", "v"))
+	database.Exec(conn, utils.ToCmdLine("exec"))
+	if result := <-done; result == "*-1\r\n" {
+		t.Errorf("expected entries, actually %s", result)
+	}
+}
+
+func TestWatchAcrossDB(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	other := &connection.Connection{}
+	// WATCH 之后切换 db，EXEC 时仍然检查 db0 中的 key
+	database.Exec(conn, utils.ToCmdLine("watch", "k"))
+	database.Exec(conn, utils.ToCmdLine("select", "1"))
+	database.Exec(other, utils.ToCmdLine("set", "k", "v"))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	result := database.Exec(conn, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Fatalf("expected nil, actually %s", string(result.ToBytes()))
+	}
+
+	// 其他 db 中同名的 key 被修改不影响事务
+	database.Exec(conn, utils.ToCmdLine("watch", "k"))
+	database.Exec(other, utils.ToCmdLine("set", "k", "v"))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	if string(result.ToBytes()) != "*1\r\n+OK\r\n" {
+		t.Errorf("expected OK, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestSelectInMulti(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("set", "a", "0"))
+	result := database.Exec(conn, utils.ToCmdLine("select", "1"))
+	if string(result.ToBytes()) != "+QUEUED\r\n" {
+		t.Fatalf("expected QUEUED, actually %s", string(result.ToBytes()))
+	}
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	database.Exec(conn, utils.ToCmdLine("select", "100"))
+	database.Exec(conn, utils.ToCmdLine("get", "a"))
+	result = database.Exec(conn, utils.ToCmdLine("exec"))
+	expected := "*5\r\n+OK\r\n+OK\r\n+OK\r\n-ERR DB index is out of range\r\n$1\r\n1\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, string(result.ToBytes()))
+	}
+	// EXEC 之后仍然在事务中切换到的 db
+	if conn.GetDBIndex() != 1 {
+		t.Errorf("expected db 1, actually %d", conn.GetDBIndex())
+	}
+	database.Exec(conn, utils.ToCmdLine("select", "0"))
+	result = database.Exec(conn, utils.ToCmdLine("get", "a"))
+	if string(result.ToBytes()) != "$1\r\n0\r\n" {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	result = database.Exec(conn, utils.ToCmdLine("select"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected arity error, actually %s", string(result.ToBytes()))
+	}
+	database.Exec(conn, utils.ToCmdLine("discard"))
+}
+
+// 只记录正在被 WATCH 的 key 的版本号
+func TestVersionMapBounded(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	other := &connection.Connection{}
+	db := database.dbSet[0]
+	for i := 0; i < 100; i++ {
+		database.Exec(other, utils.ToCmdLine("set", strconv.Itoa(i), "v"))
+	}
+	database.Exec(other, utils.ToCmdLine("del", "1"))
+	database.Exec(other, utils.ToCmdLine("flushdb"))
+	if n := db.versionMap.Len(); n != 0 {
+		t.Fatalf("expected no versions, actually %d", n)
+	}
+
+	// 两个连接 WATCH 同一个 key，全部取消之后删除
+	database.Exec(conn, utils.ToCmdLine("watch", "a", "b"))
+	database.Exec(other, utils.ToCmdLine("watch", "a"))
+	if n := db.versionMap.Len(); n != 2 {
+		t.Fatalf("expected 2 versions, actually %d", n)
+	}
+	database.Exec(conn, utils.ToCmdLine("unwatch"))
+	if n := db.versionMap.Len(); n != 1 {
+		t.Fatalf("expected 1 version, actually %d", n)
+	}
+	// FLUSHDB 让 WATCH 的 key 失效
+	database.Exec(conn, utils.ToCmdLine("flushdb"))
+	database.Exec(other, utils.ToCmdLine("multi"))
+	database.Exec(other, utils.ToCmdLine("set", "a", "1"))
+	result := database.Exec(other, utils.ToCmdLine("exec"))
+	if _, ok := result.(*reply.NullMultiBulkReply); !ok {
+		t.Errorf("expected nil, actually %s", string(result.ToBytes()))
+	}
+	if n := db.versionMap.Len(); n != 0 {
+		t.Errorf("expected no versions, actually %d", n)
+	}
+
+	// 断开连接时取消 WATCH
+	database.Exec(conn, utils.ToCmdLine("watch", "a"))
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	database.Exec(conn, utils.ToCmdLine("discard"))
+	database.Exec(other, utils.ToCmdLine("watch", "a"))
+	database.AfterClientClose(other)
+	if n := db.versionMap.Len(); n != 0 {
+		t.Errorf("expected no versions, actually %d", n)
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	database := NewStandaloneDatabase()
+	defer database.Close()
+	conn := &connection.Connection{}
+	database.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	database.Exec(conn, utils.ToCmdLine("select", "1"))
+	database.Exec(conn, utils.ToCmdLine("set", "b", "1"))
+	result := database.Exec(conn, utils.ToCmdLine("flushall"))
+	if string(result.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("expected OK, actually %s", string(result.ToBytes()))
+	}
+	for _, db := range database.dbSet[:2] {
+		if n := db.data.Len(); n != 0 {
+			t.Errorf("expected db %d empty, actually %d keys", db.index, n)
+		}
+	}
+	// 事务中不能执行
+	database.Exec(conn, utils.ToCmdLine("multi"))
+	result = database.Exec(conn, utils.ToCmdLine("flushall"))
+	if !reply.IsErrReply(result) {
+		t.Errorf("expected error, actually %s", string(result.ToBytes()))
+	}
+	database.Exec(conn, utils.ToCmdLine("discard"))
+}
@@ -0,0 +1,268 @@
+// Package database -----------------------------
+// @file      : ttl.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/20 15:12
+// -------------------------------------------
+package database
+
+import (
+	"math"
+	"redis-go/interface/resp"
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 定期删除的参数，参考 Redis 的 activeExpireCycle
+const (
+	// 每轮定期删除的间隔，对应 Redis 的 hz 10
+	activeExpireCycleInterval = 100 * time.Millisecond
+	// 每次从设置了过期时间的 key 中抽样的数量
+	activeExpireCycleKeysPerLoop = 20
+	// 抽样中过期 key 的比例超过 25% 就继续抽样
+	activeExpireCycleAcceptableStale = 25
+	// 每个 db 单轮定期删除的最长耗时，避免长时间占用
+	activeExpireCycleTimeLimit = 25 * time.Millisecond
+)
+
+// 过期时间条件 EXPIRE key seconds [NX | XX | GT | LT]
+const (
+	expireAlways = iota
+	expireNX     // 没有过期时间才设置
+	expireXX     // 已有过期时间才设置
+	expireGT     // 新的过期时间更大才设置
+	expireLT     // 新的过期时间更小才设置
+)
+
+// toTTLCmd 过期时间统一以绝对时间 PEXPIREAT 写入 aof，重放的时候不会复活已经过期的 key
+func toTTLCmd(key string, expireTime time.Time) CmdLine {
+	return utils.ToCmdLine("pexpireat", key, strconv.FormatInt(expireTime.UnixMilli(), 10))
+}
+
+// activeExpireCycle 定期删除：随机抽样设置了过期时间的 key，删除其中过期的
+// 过期比例较高说明还有很多过期的 key，继续抽样直到比例降下来或者超时
+func (db *DB) activeExpireCycle() {
+	// 不能打断正在执行的事务
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	deadline := time.Now().Add(activeExpireCycleTimeLimit)
+	for {
+		keys := db.ttlMap.RandomDistinctKeys(activeExpireCycleKeysPerLoop)
+		if len(keys) == 0 {
+			return
+		}
+		expired := 0
+		for _, key := range keys {
+			db.locker.Lock(key)
+			if db.IsExpired(key) {
+				expired++
+			}
+			db.locker.UnLock(key)
+		}
+		if expired*100 <= len(keys)*activeExpireCycleAcceptableStale || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+// parseExpireFlags 解析 NX | XX | GT | LT
+func parseExpireFlags(args [][]byte) (int, resp.Reply) {
+	flag := expireAlways
+	nx, xx, gt, lt := false, false, false, false
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			nx, flag = true, expireNX
+		case "XX":
+			xx, flag = true, expireXX
+		case "GT":
+			gt, flag = true, expireGT
+		case "LT":
+			lt, flag = true, expireLT
+		default:
+			return 0, reply.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return 0, reply.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return 0, reply.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flag, nil
+}
+
+// expireGeneric EXPIRE PEXPIRE EXPIREAT PEXPIREAT 的公共逻辑
+// unit 为时间的单位，absolute 表示给的是时间戳还是相对时间
+func expireGeneric(db *DB, cmdName string, args [][]byte, unit time.Duration, absolute bool) resp.Reply {
+	key := string(args[0])
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	flag, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	// 换算成毫秒，注意溢出
+	ms := raw
+	if unit == time.Second {
+		if raw > math.MaxInt64/1000 || raw < math.MinInt64/1000 {
+			return reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		ms = raw * 1000
+	}
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if (ms > 0 && now > math.MaxInt64-ms) || (ms < 0 && now < math.MinInt64-ms) {
+			return reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		ms += now
+	}
+	expireTime := time.UnixMilli(ms)
+
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	// 没有过期时间的 key 视为永不过期
+	current, hasTTL := db.GetExpireTime(key)
+	switch flag {
+	case expireNX:
+		if hasTTL {
+			return reply.MakeIntReply(0)
+		}
+	case expireXX:
+		if !hasTTL {
+			return reply.MakeIntReply(0)
+		}
+	case expireGT:
+		if !hasTTL || !expireTime.After(current) {
+			return reply.MakeIntReply(0)
+		}
+	case expireLT:
+		if hasTTL && !expireTime.Before(current) {
+			return reply.MakeIntReply(0)
+		}
+	}
+	// 过期时间已经过去了，直接删除
+	if !expireTime.After(time.Now()) {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.addAof(toTTLCmd(key, expireTime))
+	db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	return reply.MakeIntReply(1)
+}
+
+// EXPIRE k1 seconds [NX | XX | GT | LT]
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, "expire", args, time.Second, false)
+}
+
+// PEXPIRE k1 milliseconds [NX | XX | GT | LT]
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, "pexpire", args, time.Millisecond, false)
+}
+
+// EXPIREAT k1 unix-time-seconds [NX | XX | GT | LT]
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, "expireat", args, time.Second, true)
+}
+
+// PEXPIREAT k1 unix-time-milliseconds [NX | XX | GT | LT]
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	return expireGeneric(db, "pexpireat", args, time.Millisecond, true)
+}
+
+// ttlGeneric TTL PTTL 的公共逻辑
+// -2 表示 key 不存在，-1 表示没有设置过期时间
+func ttlGeneric(db *DB, args [][]byte, unit time.Duration) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.GetExpireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	ttl := time.Until(expireTime)
+	if ttl < 0 {
+		ttl = 0
+	}
+	// 和 Redis 一样秒级结果四舍五入
+	return reply.MakeIntReply(int64((ttl + unit/2) / unit))
+}
+
+// TTL k1
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	return ttlGeneric(db, args, time.Second)
+}
+
+// PTTL k1
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	return ttlGeneric(db, args, time.Millisecond)
+}
+
+// expireTimeGeneric EXPIRETIME PEXPIRETIME 的公共逻辑，返回绝对时间戳
+func expireTimeGeneric(db *DB, args [][]byte, unit time.Duration) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.GetExpireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	if unit == time.Second {
+		return reply.MakeIntReply(expireTime.Unix())
+	}
+	return reply.MakeIntReply(expireTime.UnixMilli())
+}
+
+// EXPIRETIME k1
+func execExpireTime(db *DB, args [][]byte) resp.Reply {
+	return expireTimeGeneric(db, args, time.Second)
+}
+
+// PEXPIRETIME k1
+func execPExpireTime(db *DB, args [][]byte) resp.Reply {
+	return expireTimeGeneric(db, args, time.Millisecond)
+}
+
+// PERSIST k1
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if db.Persist(key) == 0 {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine2("persist", args...))
+	db.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+	return reply.MakeIntReply(1)
+}
+
+func init() {
+	// EXPIRE k1 10 [NX | XX | GT | LT]
+	RegisterCommand("Expire", execExpire, writeFirstKey, -3)
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, -3)
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, -3)
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, -3)
+	// TTL k1
+	RegisterCommand("TTL", execTTL, readFirstKey, 2)
+	RegisterCommand("PTTL", execPTTL, readFirstKey, 2)
+	RegisterCommand("ExpireTime", execExpireTime, readFirstKey, 2)
+	RegisterCommand("PExpireTime", execPExpireTime, readFirstKey, 2)
+	RegisterCommand("Persist", execPersist, writeFirstKey, 2)
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"redis-go/lib/utils"
+	"redis-go/resp/reply"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	db := makeDB()
+	db.Exec(nil, utils.ToCmdLine("set", "k1", "v1"))
+
+	result := db.Exec(nil, utils.ToCmdLine("ttl", "k1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != -1 {
+		t.Errorf("expected -1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("expire", "k1", "100"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("ttl", "k1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 100 {
+		t.Errorf("expected 100, actually %s", string(result.ToBytes()))
+	}
+	// GT 要求新的过期时间更大
+	result = db.Exec(nil, utils.ToCmdLine("expire", "k1", "50", "GT"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 0 {
+		t.Errorf("expected 0, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("persist", "k1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != 1 {
+		t.Errorf("expected 1, actually %s", string(result.ToBytes()))
+	}
+	result = db.Exec(nil, utils.ToCmdLine("ttl", "k1"))
+	if intResult, ok := result.(*reply.IntReply); !ok || intResult.Code != -1 {
+		t.Errorf("expected -1, actually %s", string(result.ToBytes()))
+	}
+
+	// 过去的时间戳直接删除
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	db.Exec(nil, utils.ToCmdLine("pexpireat", "k1", past))
+	result = db.Exec(nil, utils.ToCmdLine("get", "k1"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Errorf("expected nil, actually %s", string(result.ToBytes()))
+	}
+}
+
+func TestActiveExpire(t *testing.T) {
+	db := makeDB()
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		db.Exec(nil, utils.ToCmdLine("set", key, key))
+		db.Exec(nil, utils.ToCmdLine("pexpire", key, "10"))
+	}
+	time.Sleep(20 * time.Millisecond)
+	db.activeExpireCycle()
+	// 定期删除之后，过期 key 的比例应该降到阈值以下
+	if db.data.Len() > 100*activeExpireCycleAcceptableStale/100 {
+		t.Errorf("expected most keys removed, remaining %d", db.data.Len())
+	}
+}
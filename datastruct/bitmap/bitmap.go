@@ -0,0 +1,166 @@
+// Package bitmap -----------------------------
+// @file      : bitmap.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/25 10:30
+// -------------------------------------------
+package bitmap
+
+import "math/bits"
+
+// BitMap 位图，直接使用字符串的字节存储
+// 和 Redis 一致，第 0 位是第一个字节的最高位
+type BitMap []byte
+
+// FromBytes 把字符串当作位图使用，不会拷贝
+func FromBytes(bytes []byte) *BitMap {
+	bm := BitMap(bytes)
+	return &bm
+}
+
+// ToBytes 返回位图底层的字节
+func (b *BitMap) ToBytes() []byte {
+	return *b
+}
+
+// BitSize 返回位图的位数
+func (b *BitMap) BitSize() int64 {
+	return int64(len(*b)) * 8
+}
+
+// grow 扩展到至少能容纳 bitSize 位，新增的字节填充 0
+func (b *BitMap) grow(bitSize int64) {
+	byteSize := int((bitSize + 7) / 8)
+	if byteSize <= len(*b) {
+		return
+	}
+	// 拷贝一份，避免修改和其他地方共用的底层数组
+	grown := make([]byte, byteSize)
+	copy(grown, *b)
+	*b = grown
+}
+
+// GetBit 返回 offset 位的值，超出长度的位视为 0
+func (b *BitMap) GetBit(offset int64) byte {
+	byteIndex := offset / 8
+	if byteIndex >= int64(len(*b)) {
+		return 0
+	}
+	return ((*b)[byteIndex] >> (7 - offset%8)) & 1
+}
+
+// SetBit 设置 offset 位的值，必要时自动扩展，返回原来的值
+func (b *BitMap) SetBit(offset int64, val byte) byte {
+	b.grow(offset + 1)
+	byteIndex := offset / 8
+	mask := byte(1) << (7 - offset%8)
+	old := ((*b)[byteIndex] & mask) >> (7 - offset%8)
+	if val > 0 {
+		(*b)[byteIndex] |= mask
+	} else {
+		(*b)[byteIndex] &^= mask
+	}
+	return old
+}
+
+// BitCount 统计 [start, end] 位之间 1 的个数，调用方需要保证范围合法
+func (b *BitMap) BitCount(start int64, end int64) int64 {
+	var count int64 = 0
+	for i := start; i <= end; {
+		// 整个字节都在范围内的时候按字节统计
+		if i%8 == 0 && i+7 <= end {
+			count += int64(bits.OnesCount8((*b)[i/8]))
+			i += 8
+			continue
+		}
+		count += int64(b.GetBit(i))
+		i++
+	}
+	return count
+}
+
+// BitPos 返回 [start, end] 位之间第一个值为 bit 的位置，找不到返回 -1
+func (b *BitMap) BitPos(bit byte, start int64, end int64) int64 {
+	// 查找 1 时跳过全 0 的字节，查找 0 时跳过全 1 的字节
+	var skip byte = 0
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := start; i <= end; {
+		if i%8 == 0 && i+7 <= end && (*b)[i/8] == skip {
+			i += 8
+			continue
+		}
+		if b.GetBit(i) == bit {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// GetBits 读取从 offset 开始的 width 位，作为无符号整数返回，高位在前
+func (b *BitMap) GetBits(offset int64, width int) uint64 {
+	var value uint64 = 0
+	for i := 0; i < width; i++ {
+		value = (value << 1) | uint64(b.GetBit(offset+int64(i)))
+	}
+	return value
+}
+
+// SetBits 把 value 的低 width 位写入从 offset 开始的位置，高位在前
+func (b *BitMap) SetBits(offset int64, width int, value uint64) {
+	b.grow(offset + int64(width))
+	for i := 0; i < width; i++ {
+		bit := byte((value >> (width - 1 - i)) & 1)
+		b.SetBit(offset+int64(i), bit)
+	}
+}
+
+// 位运算的类型
+const (
+	OpAnd = iota
+	OpOr
+	OpXor
+	OpNot
+)
+
+// BitOp 对多个位图做位运算，较短的位图视为用 0 补齐，NOT 只使用第一个位图
+func BitOp(op int, bitmaps ...[]byte) []byte {
+	maxLen := 0
+	for _, bm := range bitmaps {
+		if len(bm) > maxLen {
+			maxLen = len(bm)
+		}
+	}
+	result := make([]byte, maxLen)
+	if op == OpNot {
+		for i, v := range bitmaps[0] {
+			result[i] = ^v
+		}
+		return result
+	}
+	for i := 0; i < maxLen; i++ {
+		var v byte
+		for j, bm := range bitmaps {
+			var cur byte
+			if i < len(bm) {
+				cur = bm[i]
+			}
+			if j == 0 {
+				v = cur
+				continue
+			}
+			switch op {
+			case OpAnd:
+				v &= cur
+			case OpOr:
+				v |= cur
+			case OpXor:
+				v ^= cur
+			}
+		}
+		result[i] = v
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package bitmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSetBit(t *testing.T) {
+	bm := FromBytes(nil)
+	expected := make(map[int64]bool)
+	for i := 0; i < 1000; i++ {
+		offset := int64(rand.Intn(10000))
+		expected[offset] = true
+		bm.SetBit(offset, 1)
+	}
+	var count int64 = 0
+	for i := int64(0); i < bm.BitSize(); i++ {
+		if (bm.GetBit(i) == 1) != expected[i] {
+			t.Fatalf("wrong bit at %d", i)
+		}
+		if expected[i] {
+			count++
+		}
+	}
+	if c := bm.BitCount(0, bm.BitSize()-1); c != count {
+		t.Fatalf("expected count %d, actually %d", count, c)
+	}
+	// 第 0 位是第一个字节的最高位
+	bm = FromBytes(nil)
+	bm.SetBit(1, 1)
+	if bm.ToBytes()[0] != 0x40 {
+		t.Fatalf("expected 0x40, actually %x", bm.ToBytes()[0])
+	}
+}
+
+func TestBitPos(t *testing.T) {
+	bm := FromBytes([]byte{0x00, 0xff, 0xf0})
+	if pos := bm.BitPos(1, 0, bm.BitSize()-1); pos != 8 {
+		t.Fatalf("expected 8, actually %d", pos)
+	}
+	if pos := bm.BitPos(0, 8, bm.BitSize()-1); pos != 20 {
+		t.Fatalf("expected 20, actually %d", pos)
+	}
+	if pos := bm.BitPos(1, 20, 23); pos != -1 {
+		t.Fatalf("expected -1, actually %d", pos)
+	}
+}
+
+func TestBits(t *testing.T) {
+	bm := FromBytes(nil)
+	bm.SetBits(5, 12, 0xabc)
+	if v := bm.GetBits(5, 12); v != 0xabc {
+		t.Fatalf("expected 0xabc, actually %x", v)
+	}
+	if v := bm.GetBits(5, 4); v != 0xa {
+		t.Fatalf("expected 0xa, actually %x", v)
+	}
+	result := BitOp(OpXor, []byte{0x0f, 0xff}, []byte{0xff})
+	if len(result) != 2 || result[0] != 0xf0 || result[1] != 0xff {
+		t.Fatalf("unexpected xor result %v", result)
+	}
+}
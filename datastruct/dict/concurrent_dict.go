@@ -0,0 +1,371 @@
+// Package dict -----------------------------
+// @file      : concurrent_dict.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/2 15:20
+// -------------------------------------------
+package dict
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentDict 分段加锁的并发安全 map
+// key 哈希到固定数量的 shard 上，每个 shard 有自己的读写锁，不同 shard 的读写互不影响
+// 和 sync.Map 相比可以 O(1) 地获取长度、均匀地随机抽样，shard 的数量固定，可以按 shard 的下标作为游标遍历
+type ConcurrentDict struct {
+	table []*shard
+	count int32
+	// shard 元素个数的上限，添加时增大，加权抽样统计时更新为实际的最大值，随机抽样时用来拒绝采样
+	maxShardSize int32
+}
+
+// shard 中的 key 和 value 存放在数组里，map 记录 key 的下标，随机抽样时直接按下标取，不需要遍历
+// 删除时把最后一个元素移到空出来的位置，数组保持紧凑
+type shard struct {
+	index  map[string]int
+	keys   []string
+	values []interface{}
+	// 元素个数，修改时持有锁，读取时不需要加锁
+	size  int32
+	mutex sync.RWMutex
+}
+
+const prime32 = uint32(16777619)
+
+// fnv32 FNV-1a 哈希
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+// MakeConcurrent 创建分段的 map，shardCount 会向上取整为 2 的幂
+func MakeConcurrent(shardCount int) *ConcurrentDict {
+	size := 1
+	for size < shardCount {
+		size <<= 1
+	}
+	table := make([]*shard, size)
+	for i := range table {
+		table[i] = makeShard()
+	}
+	return &ConcurrentDict{
+		table: table,
+	}
+}
+
+// spread 计算 key 所在的 shard，shard 的数量是 2 的幂，取模可以用位运算
+func (dict *ConcurrentDict) spread(key string) int {
+	return int(fnv32(key) & uint32(len(dict.table)-1))
+}
+
+func (dict *ConcurrentDict) getShard(key string) *shard {
+	return dict.table[dict.spread(key)]
+}
+
+func makeShard() *shard {
+	return &shard{
+		index: make(map[string]int),
+	}
+}
+
+// get 需要持有锁
+func (s *shard) get(key string) (interface{}, bool) {
+	i, ok := s.index[key]
+	if !ok {
+		return nil, false
+	}
+	return s.values[i], true
+}
+
+// add 添加不存在的 key，需要持有写锁，返回 shard 的元素个数
+func (s *shard) add(key string, val interface{}) int32 {
+	s.index[key] = len(s.keys)
+	s.keys = append(s.keys, key)
+	s.values = append(s.values, val)
+	return atomic.AddInt32(&s.size, 1)
+}
+
+// remove 删除存在的 key，最后一个元素移到被删除的位置，需要持有写锁
+func (s *shard) remove(key string) {
+	i := s.index[key]
+	last := len(s.keys) - 1
+	if i != last {
+		s.keys[i] = s.keys[last]
+		s.values[i] = s.values[last]
+		s.index[s.keys[i]] = i
+	}
+	s.keys[last] = ""
+	s.values[last] = nil
+	s.keys = s.keys[:last]
+	s.values = s.values[:last]
+	delete(s.index, key)
+	atomic.AddInt32(&s.size, -1)
+}
+
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	s := dict.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.get(key)
+}
+
+// Len 使用原子计数，不需要遍历
+func (dict *ConcurrentDict) Len() int {
+	return int(atomic.LoadInt32(&dict.count))
+}
+
+func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i, ok := s.index[key]; ok {
+		s.values[i] = val
+		return 0
+	}
+	dict.raiseMaxShardSize(s.add(key, val))
+	atomic.AddInt32(&dict.count, 1)
+	return 1
+}
+
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index[key]; ok {
+		return 0
+	}
+	dict.raiseMaxShardSize(s.add(key, val))
+	atomic.AddInt32(&dict.count, 1)
+	return 1
+}
+
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i, ok := s.index[key]; ok {
+		s.values[i] = val
+		return 1
+	}
+	return 0
+}
+
+func (dict *ConcurrentDict) Remove(key string) (result int) {
+	s := dict.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index[key]; ok {
+		s.remove(key)
+		atomic.AddInt32(&dict.count, -1)
+		return 1
+	}
+	return 0
+}
+
+// snapshot 复制 shard 中的所有元素
+// 遍历的时候不持有锁，consumer 中可以修改 dict，如删除过期的 key
+func (s *shard) snapshot() ([]string, []interface{}) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]string, len(s.keys))
+	values := make([]interface{}, len(s.values))
+	copy(keys, s.keys)
+	copy(values, s.values)
+	return keys, values
+}
+
+// ForEach consumer 返回 false 时停止遍历
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	for _, s := range dict.table {
+		keys, values := s.snapshot()
+		for i, key := range keys {
+			if !consumer(key, values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Scan 从下标为 cursor 的 shard 开始遍历，遍历完整的 shard 直到访问了至少 count 个元素
+// 返回下一次遍历的游标，遍历完所有的 shard 时返回 0
+// shard 的数量固定，key 不会在 shard 之间移动，遍历期间一直存在的 key 一定会被访问到
+func (dict *ConcurrentDict) Scan(cursor int, count int, consumer Consumer) int {
+	if cursor < 0 || cursor >= len(dict.table) {
+		return 0
+	}
+	visited := 0
+	for cursor < len(dict.table) {
+		keys, values := dict.table[cursor].snapshot()
+		cursor++
+		for i, key := range keys {
+			if !consumer(key, values[i]) {
+				return cursor % len(dict.table)
+			}
+		}
+		visited += len(keys)
+		if visited >= count {
+			break
+		}
+	}
+	// 回到 0 表示遍历结束
+	return cursor % len(dict.table)
+}
+
+func (dict *ConcurrentDict) Keys() []string {
+	result := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		result = append(result, key)
+		return true
+	})
+	return result
+}
+
+// raiseMaxShardSize shard 的元素个数超过上限时增大上限
+func (dict *ConcurrentDict) raiseMaxShardSize(size int32) {
+	for {
+		bound := atomic.LoadInt32(&dict.maxShardSize)
+		if size <= bound || atomic.CompareAndSwapInt32(&dict.maxShardSize, bound, size) {
+			return
+		}
+	}
+}
+
+// shardWeights 返回每个 shard 及之前所有 shard 的元素个数之和，读取原子计数，不需要加锁
+// 同时把 shard 元素个数的上限更新为当前的最大值
+func (dict *ConcurrentDict) shardWeights() []int {
+	weights := make([]int, len(dict.table))
+	total := 0
+	var maxSize int32
+	for i, s := range dict.table {
+		size := atomic.LoadInt32(&s.size)
+		if size > maxSize {
+			maxSize = size
+		}
+		total += int(size)
+		weights[i] = total
+	}
+	atomic.StoreInt32(&dict.maxShardSize, maxSize)
+	return weights
+}
+
+// 拒绝采样的最大次数，超过之后按照元素个数加权选择 shard
+const maxRandomTries = 32
+
+// randomKey 随机选择一个 key，每个 key 被选中的概率相同
+// 随机选择一个 shard 和一个小于 shard 元素个数上限的下标，下标超出 shard 的元素个数时重新选择，不需要遍历所有的 shard
+// key 比 shard 少或者删除较多导致上限偏大时，改为按照前缀和加权选择 shard，weights 在抽样多个 key 时只统计一次
+func (dict *ConcurrentDict) randomKey(weights *[]int) (string, bool) {
+	if dict.Len() >= len(dict.table) {
+		for i := 0; i < maxRandomTries; i++ {
+			s := dict.table[rand.Intn(len(dict.table))]
+			size := int(atomic.LoadInt32(&s.size))
+			bound := int(atomic.LoadInt32(&dict.maxShardSize))
+			if size > bound {
+				// 上限被 shardWeights 并发地改小了
+				dict.raiseMaxShardSize(int32(size))
+				continue
+			}
+			if size == 0 {
+				continue
+			}
+			n := rand.Intn(bound)
+			if n >= size {
+				continue
+			}
+			s.mutex.RLock()
+			if n < len(s.keys) {
+				key := s.keys[n]
+				s.mutex.RUnlock()
+				return key, true
+			}
+			s.mutex.RUnlock()
+		}
+	}
+	if *weights == nil {
+		*weights = dict.shardWeights()
+	}
+	for {
+		w := *weights
+		if w[len(w)-1] == 0 {
+			return "", false
+		}
+		n := rand.Intn(w[len(w)-1])
+		s := dict.table[sort.SearchInts(w, n+1)]
+		s.mutex.RLock()
+		if size := len(s.keys); size > 0 {
+			key := s.keys[rand.Intn(size)]
+			s.mutex.RUnlock()
+			return key, true
+		}
+		s.mutex.RUnlock()
+		// 统计之后 shard 被清空了，重新统计
+		*weights = dict.shardWeights()
+	}
+}
+
+// RandomKeys 返回 limit 个可重复的 key
+func (dict *ConcurrentDict) RandomKeys(limit int) []string {
+	result := make([]string, 0, limit)
+	var weights []int
+	for i := 0; i < limit; i++ {
+		key, ok := dict.randomKey(&weights)
+		if !ok {
+			break
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+// RandomDistinctKeys 返回 limit 个不重复的 key
+func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
+	size := dict.Len()
+	if limit <= 0 || size == 0 {
+		return []string{}
+	}
+	// 需要的 key 接近全部的时候，随机抽样很难抽到剩下的 key，直接返回全部
+	if limit*2 >= size {
+		keys := dict.Keys()
+		rand.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+		if limit < len(keys) {
+			keys = keys[:limit]
+		}
+		return keys
+	}
+	result := make(map[string]struct{}, limit)
+	var weights []int
+	for len(result) < limit {
+		key, ok := dict.randomKey(&weights)
+		if !ok {
+			break
+		}
+		result[key] = struct{}{}
+	}
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (dict *ConcurrentDict) Clear() {
+	for _, s := range dict.table {
+		s.mutex.Lock()
+		atomic.AddInt32(&dict.count, -int32(len(s.keys)))
+		s.index = make(map[string]int)
+		s.keys = nil
+		s.values = nil
+		atomic.StoreInt32(&s.size, 0)
+		s.mutex.Unlock()
+	}
+}
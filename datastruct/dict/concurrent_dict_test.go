@@ -0,0 +1,287 @@
+package dict
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentPut(t *testing.T) {
+	d := MakeConcurrent(10)
+	if len(d.table) != 16 {
+		t.Fatalf("expected 16 shards, actually %d", len(d.table))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := "k" + strconv.Itoa(i*100+j)
+				if ret := d.Put(key, j); ret != 1 {
+					t.Errorf("expected 1, actually %d", ret)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if d.Len() != 1000 {
+		t.Fatalf("expected 1000, actually %d", d.Len())
+	}
+	if ret := d.Put("k0", "v"); ret != 0 {
+		t.Errorf("expected 0, actually %d", ret)
+	}
+	if ret := d.PutIfAbsent("k0", "x"); ret != 0 {
+		t.Errorf("expected 0, actually %d", ret)
+	}
+	if ret := d.PutIfExists("k0", "x"); ret != 1 {
+		t.Errorf("expected 1, actually %d", ret)
+	}
+	if ret := d.PutIfExists("none", "x"); ret != 0 {
+		t.Errorf("expected 0, actually %d", ret)
+	}
+	if val, ok := d.Get("k0"); !ok || val != "x" {
+		t.Errorf("expected x, actually %v", val)
+	}
+	if ret := d.Remove("k0"); ret != 1 || d.Len() != 999 {
+		t.Errorf("expected removed, actually %d %d", ret, d.Len())
+	}
+	if ret := d.Remove("k0"); ret != 0 {
+		t.Errorf("expected 0, actually %d", ret)
+	}
+	d.Clear()
+	if d.Len() != 0 || len(d.Keys()) != 0 {
+		t.Errorf("expected empty, actually %d", d.Len())
+	}
+}
+
+func TestConcurrentForEach(t *testing.T) {
+	d := MakeConcurrent(4)
+	for i := 0; i < 100; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	visited := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		visited++
+		// 遍历的时候可以修改 dict
+		d.Remove(key)
+		return visited < 10
+	})
+	if visited != 10 || d.Len() != 90 {
+		t.Errorf("expected 10 visited and 90 left, actually %d %d", visited, d.Len())
+	}
+}
+
+func TestConcurrentScan(t *testing.T) {
+	d := MakeConcurrent(64)
+	for i := 0; i < 1000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	seen := make(map[string]int)
+	cursor, rounds := 0, 0
+	for {
+		cursor = d.Scan(cursor, 10, func(key string, val interface{}) bool {
+			seen[key]++
+			return true
+		})
+		rounds++
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 1000 {
+		t.Fatalf("expected 1000, actually %d", len(seen))
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Fatalf("key %s visited %d times", key, count)
+		}
+	}
+	if rounds < 2 {
+		t.Errorf("expected multiple rounds, actually %d", rounds)
+	}
+}
+
+func TestConcurrentRandomKeys(t *testing.T) {
+	d := MakeConcurrent(16)
+	if keys := d.RandomKeys(5); len(keys) != 0 {
+		t.Errorf("expected empty, actually %d", len(keys))
+	}
+	for i := 0; i < 100; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	if keys := d.RandomKeys(200); len(keys) != 200 {
+		t.Errorf("expected 200, actually %d", len(keys))
+	}
+	for _, limit := range []int{10, 60, 100, 200} {
+		keys := d.RandomDistinctKeys(limit)
+		expected := limit
+		if expected > 100 {
+			expected = 100
+		}
+		if len(keys) != expected {
+			t.Fatalf("expected %d, actually %d", expected, len(keys))
+		}
+		distinct := make(map[string]struct{})
+		for _, key := range keys {
+			if _, ok := d.Get(key); !ok {
+				t.Fatalf("key %s not exists", key)
+			}
+			distinct[key] = struct{}{}
+		}
+		if len(distinct) != expected {
+			t.Fatalf("expected distinct %d, actually %d", expected, len(distinct))
+		}
+	}
+}
+
+// 元素在 shard 之间分布不均匀时，每个 key 被抽到的概率仍然相同
+func TestRandomKeysUniform(t *testing.T) {
+	d := MakeConcurrent(2)
+	// 一个 shard 只有 1 个 key，另一个有 9 个
+	counts := [2]int{}
+	for i := 0; counts[0]+counts[1] < 10; i++ {
+		key := strconv.Itoa(i)
+		shard := d.spread(key)
+		if (shard == 0 && counts[0] < 1) || (shard == 1 && counts[1] < 9) {
+			d.Put(key, i)
+			counts[shard]++
+		}
+	}
+	const samples = 100000
+	hits := make(map[string]int)
+	for _, key := range d.RandomKeys(samples) {
+		hits[key]++
+	}
+	if len(hits) != 10 {
+		t.Fatalf("expected 10 keys, actually %d", len(hits))
+	}
+	for key, hit := range hits {
+		if hit < samples/10*85/100 || hit > samples/10*115/100 {
+			t.Errorf("key %s sampled %d times", key, hit)
+		}
+	}
+}
+
+// 删除时移动 shard 中的最后一个元素，之后读取和抽样的结果仍然正确
+func TestConcurrentRemove(t *testing.T) {
+	d := MakeConcurrent(4)
+	expected := make(map[string]int)
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(rand.Intn(300))
+		if rand.Intn(3) == 0 {
+			_, ok := expected[key]
+			if ret := d.Remove(key); (ret == 1) != ok {
+				t.Fatalf("remove %s: expected %v, actually %d", key, ok, ret)
+			}
+			delete(expected, key)
+			continue
+		}
+		d.Put(key, i)
+		expected[key] = i
+	}
+	if d.Len() != len(expected) {
+		t.Fatalf("expected %d, actually %d", len(expected), d.Len())
+	}
+	for key, i := range expected {
+		if val, ok := d.Get(key); !ok || val != i {
+			t.Fatalf("%s: expected %d, actually %v", key, i, val)
+		}
+	}
+	for _, key := range d.RandomKeys(1000) {
+		if _, ok := expected[key]; !ok {
+			t.Fatalf("removed key %s sampled", key)
+		}
+	}
+}
+
+// 对比 ConcurrentDict 和 SyncDict
+
+func benchmarkPut(b *testing.B, d Dict) {
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			d.Put(strconv.Itoa(i%100000), i)
+			i++
+		}
+	})
+}
+
+func benchmarkGet(b *testing.B, d Dict) {
+	for i := 0; i < 100000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			d.Get(strconv.Itoa(i % 100000))
+			i++
+		}
+	})
+}
+
+func benchmarkLen(b *testing.B, d Dict) {
+	for i := 0; i < 100000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Len()
+	}
+}
+
+func benchmarkRandomDistinctKeys(b *testing.B, d Dict) {
+	for i := 0; i < 100000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.RandomDistinctKeys(20)
+	}
+}
+
+func BenchmarkConcurrentDictPut(b *testing.B) {
+	benchmarkPut(b, MakeConcurrent(1024))
+}
+
+func BenchmarkSyncDictPut(b *testing.B) {
+	benchmarkPut(b, MakeSyncDict())
+}
+
+func BenchmarkConcurrentDictGet(b *testing.B) {
+	benchmarkGet(b, MakeConcurrent(1024))
+}
+
+func BenchmarkSyncDictGet(b *testing.B) {
+	benchmarkGet(b, MakeSyncDict())
+}
+
+func BenchmarkConcurrentDictLen(b *testing.B) {
+	benchmarkLen(b, MakeConcurrent(1024))
+}
+
+func BenchmarkSyncDictLen(b *testing.B) {
+	benchmarkLen(b, MakeSyncDict())
+}
+
+func BenchmarkConcurrentDictRandomDistinctKeys(b *testing.B) {
+	benchmarkRandomDistinctKeys(b, MakeConcurrent(1024))
+}
+
+func BenchmarkSyncDictRandomDistinctKeys(b *testing.B) {
+	benchmarkRandomDistinctKeys(b, MakeSyncDict())
+}
+
+// 淘汰和定期删除每次抽样几个 key
+func BenchmarkConcurrentDictRandomKeys(b *testing.B) {
+	d := MakeConcurrent(1024)
+	for i := 0; i < 1000000; i++ {
+		d.Put(strconv.Itoa(i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.RandomKeys(5)
+	}
+}
@@ -18,6 +18,8 @@ type Dict interface {
 	Remove(key string) (result int)
 	// ForEach 方法施加到所有的 kv 元素
 	ForEach(consumer Consumer)
+	// Scan 从 cursor 开始遍历大约 count 个元素，返回下一次遍历的游标，遍历结束时返回 0
+	Scan(cursor int, count int, consumer Consumer) int
 	Keys() []string
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
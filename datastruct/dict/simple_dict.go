@@ -0,0 +1,130 @@
+// Package dict -----------------------------
+// @file      : simple_dict.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/22 14:10
+// -------------------------------------------
+package dict
+
+// SimpleDict 非并发安全的 map 封装
+// 用于 hash、set 等 value 内部的数据结构，并发控制由上层负责
+type SimpleDict struct {
+	m map[string]interface{}
+}
+
+func MakeSimpleDict() *SimpleDict {
+	return &SimpleDict{
+		m: make(map[string]interface{}),
+	}
+}
+
+func (dict *SimpleDict) Get(key string) (val interface{}, exists bool) {
+	val, ok := dict.m[key]
+	return val, ok
+}
+
+func (dict *SimpleDict) Len() int {
+	if dict.m == nil {
+		panic("m is nil")
+	}
+	return len(dict.m)
+}
+
+func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
+	_, existed := dict.m[key]
+	dict.m[key] = val
+	if existed {
+		return 0
+	}
+	return 1
+}
+
+func (dict *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
+	_, existed := dict.m[key]
+	if existed {
+		return 0
+	}
+	dict.m[key] = val
+	return 1
+}
+
+func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
+	_, existed := dict.m[key]
+	if existed {
+		dict.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+func (dict *SimpleDict) Remove(key string) (result int) {
+	_, existed := dict.m[key]
+	delete(dict.m, key)
+	if existed {
+		return 1
+	}
+	return 0
+}
+
+func (dict *SimpleDict) ForEach(consumer Consumer) {
+	for k, v := range dict.m {
+		if !consumer(k, v) {
+			break
+		}
+	}
+}
+
+// Scan map 的遍历顺序不固定，只能一次遍历完
+func (dict *SimpleDict) Scan(cursor int, count int, consumer Consumer) int {
+	dict.ForEach(consumer)
+	return 0
+}
+
+func (dict *SimpleDict) Keys() []string {
+	result := make([]string, len(dict.m))
+	i := 0
+	for k := range dict.m {
+		result[i] = k
+		i++
+	}
+	return result
+}
+
+func (dict *SimpleDict) RandomKeys(limit int) []string {
+	if limit <= 0 || len(dict.m) == 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
+	for i := 0; i < limit; i++ {
+		// map 每次遍历的起点是随机的
+		for k := range dict.m {
+			result[i] = k
+			break
+		}
+	}
+	return result
+}
+
+func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
+	size := limit
+	if size > len(dict.m) {
+		size = len(dict.m)
+	}
+	if size <= 0 {
+		return []string{}
+	}
+	result := make([]string, size)
+	i := 0
+	for k := range dict.m {
+		if i == size {
+			break
+		}
+		result[i] = k
+		i++
+	}
+	return result
+}
+
+func (dict *SimpleDict) Clear() {
+	*dict = *MakeSimpleDict()
+}
@@ -80,6 +80,14 @@ func (dict *SyncDict) ForEach(consumer Consumer) {
 	})
 }
 
+// Scan sync.Map 的遍历顺序不固定，只能一次遍历完
+func (dict *SyncDict) Scan(cursor int, count int, consumer Consumer) int {
+	dict.m.Range(func(key, value interface{}) bool {
+		return consumer(key.(string), value)
+	})
+	return 0
+}
+
 func (dict *SyncDict) Keys() []string {
 	result := make([]string, dict.Len())
 	i := 0
@@ -93,10 +101,14 @@ func (dict *SyncDict) Keys() []string {
 }
 
 func (dict *SyncDict) RandomKeys(limit int) []string {
-	result := make([]string, dict.Len())
+	if limit <= 0 || dict.Len() == 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
 	for i := 0; i < limit; i++ {
 		dict.m.Range(func(key, value interface{}) bool {
 			// limit 个可重复的 key
+			// sync.Map 底层的 map 每次遍历的起点是随机的
 			result[i] = key.(string)
 			// 随机作用到一个元素上就停止
 			return false
@@ -106,7 +118,14 @@ func (dict *SyncDict) RandomKeys(limit int) []string {
 }
 
 func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
-	result := make([]string, dict.Len())
+	size := dict.Len()
+	if limit > size {
+		limit = size
+	}
+	if limit <= 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
 	i := 0
 	dict.m.Range(func(key, value interface{}) bool {
 		// limit 个不重复的 key
@@ -117,7 +136,8 @@ func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
 		}
 		return true
 	})
-	return result
+	// 遍历期间可能有 key 被并发删除
+	return result[:i]
 }
 
 func (dict *SyncDict) Clear() {
@@ -0,0 +1,252 @@
+// Package geohash -----------------------------
+// @file      : geohash.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/28 10:15
+// -------------------------------------------
+package geohash
+
+import "math"
+
+// 和 Redis 的 geohash.c 一致，经纬度各用 26 位，交错组成 52 位的整数，可以无损地保存在 float64 的分数中
+const (
+	StepMax = 26
+	LatMin  = -85.05112878
+	LatMax  = 85.05112878
+	LongMin = -180.0
+	LongMax = 180.0
+)
+
+// Bits 一个 geohash 格子，step 表示经纬度各用了多少位
+type Bits struct {
+	Bits uint64
+	Step uint8
+}
+
+// IsZero 是否是被排除的格子
+func (b Bits) IsZero() bool {
+	return b.Bits == 0 && b.Step == 0
+}
+
+// Range 经度或者纬度的范围
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Area 一个格子覆盖的经纬度范围
+type Area struct {
+	Hash      Bits
+	Longitude Range
+	Latitude  Range
+}
+
+// Neighbors 周围的 8 个格子
+type Neighbors struct {
+	North     Bits
+	East      Bits
+	West      Bits
+	South     Bits
+	NorthEast Bits
+	SouthEast Bits
+	NorthWest Bits
+	SouthWest Bits
+}
+
+var (
+	// 内部编码使用的范围，纬度限制在墨卡托投影的范围内
+	coordLongRange = Range{Min: LongMin, Max: LongMax}
+	coordLatRange  = Range{Min: LatMin, Max: LatMax}
+	// 标准 geohash 字符串使用的范围
+	standardLatRange = Range{Min: -90, Max: 90}
+)
+
+// interleave64 交错 x 和 y 的低 32 位，x 在偶数位，y 在奇数位
+func interleave64(x uint32, y uint32) uint64 {
+	b := []uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := []uint{1, 2, 4, 8, 16}
+	xx, yy := uint64(x), uint64(y)
+	for i := 4; i >= 0; i-- {
+		xx = (xx | (xx << s[i])) & b[i]
+		yy = (yy | (yy << s[i])) & b[i]
+	}
+	return xx | (yy << 1)
+}
+
+// deinterleave64 interleave64 的逆运算，低 32 位是偶数位，高 32 位是奇数位
+func deinterleave64(interleaved uint64) uint64 {
+	b := []uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F,
+		0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := []uint{0, 1, 2, 4, 8, 16}
+	x := interleaved
+	y := interleaved >> 1
+	for i := 0; i < 6; i++ {
+		x = (x | (x >> s[i])) & b[i]
+		y = (y | (y >> s[i])) & b[i]
+	}
+	return x | (y << 32)
+}
+
+// encode 按照给定的范围编码，坐标超出范围时返回 false
+func encode(longRange Range, latRange Range, longitude float64, latitude float64, step uint8) (Bits, bool) {
+	if step > 32 || step == 0 {
+		return Bits{}, false
+	}
+	if !ValidCoord(longitude, latitude) {
+		return Bits{}, false
+	}
+	if latitude < latRange.Min || latitude > latRange.Max ||
+		longitude < longRange.Min || longitude > longRange.Max {
+		return Bits{}, false
+	}
+	latOffset := (latitude - latRange.Min) / (latRange.Max - latRange.Min)
+	longOffset := (longitude - longRange.Min) / (longRange.Max - longRange.Min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return Bits{
+		Bits: interleave64(uint32(latOffset), uint32(longOffset)),
+		Step: step,
+	}, true
+}
+
+// Encode 编码经纬度，坐标超出范围时返回 false
+func Encode(longitude float64, latitude float64, step uint8) (Bits, bool) {
+	return encode(coordLongRange, coordLatRange, longitude, latitude, step)
+}
+
+// ValidCoord 经纬度是否可以编码
+func ValidCoord(longitude float64, latitude float64) bool {
+	if math.IsNaN(longitude) || math.IsNaN(latitude) {
+		return false
+	}
+	return longitude >= LongMin && longitude <= LongMax && latitude >= LatMin && latitude <= LatMax
+}
+
+// decode 解码出格子的经纬度范围
+func decode(longRange Range, latRange Range, hash Bits) Area {
+	sep := deinterleave64(hash.Bits)
+	latScale := latRange.Max - latRange.Min
+	longScale := longRange.Max - longRange.Min
+	ilato := uint32(sep)
+	ilono := uint32(sep >> 32)
+	cells := float64(uint64(1) << hash.Step)
+	return Area{
+		Hash: hash,
+		Latitude: Range{
+			Min: latRange.Min + (float64(ilato)/cells)*latScale,
+			Max: latRange.Min + ((float64(ilato)+1)/cells)*latScale,
+		},
+		Longitude: Range{
+			Min: longRange.Min + (float64(ilono)/cells)*longScale,
+			Max: longRange.Min + ((float64(ilono)+1)/cells)*longScale,
+		},
+	}
+}
+
+// Decode 解码出格子的经纬度范围
+func Decode(hash Bits) Area {
+	return decode(coordLongRange, coordLatRange, hash)
+}
+
+// DecodeToLongLat 返回格子中心的经纬度
+func DecodeToLongLat(hash Bits) (longitude float64, latitude float64) {
+	area := Decode(hash)
+	longitude = (area.Longitude.Min + area.Longitude.Max) / 2
+	if longitude > LongMax {
+		longitude = LongMax
+	}
+	if longitude < LongMin {
+		longitude = LongMin
+	}
+	latitude = (area.Latitude.Min + area.Latitude.Max) / 2
+	if latitude > LatMax {
+		latitude = LatMax
+	}
+	if latitude < LatMin {
+		latitude = LatMin
+	}
+	return longitude, latitude
+}
+
+// DecodeScore 把有序集合中的分数解码为经纬度
+func DecodeScore(score float64) (longitude float64, latitude float64) {
+	return DecodeToLongLat(Bits{Bits: uint64(score), Step: StepMax})
+}
+
+// Align52Bits 把格子对齐到 52 位，得到有序集合中分数的下界
+func Align52Bits(hash Bits) uint64 {
+	return hash.Bits << (52 - uint(hash.Step)*2)
+}
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// ToString 转换成标准的 11 位 geohash 字符串
+// 内部编码的纬度范围是 [-85, 85]，需要先解码再用标准的 [-90, 90] 重新编码
+func ToString(score float64) string {
+	longitude, latitude := DecodeScore(score)
+	hash, _ := encode(coordLongRange, standardLatRange, longitude, latitude, StepMax)
+	buf := make([]byte, 11)
+	for i := 0; i < 11; i++ {
+		idx := 0
+		// 只有 52 位，最后一个字符按 0 处理
+		if i < 10 {
+			idx = int((hash.Bits >> (52 - uint(i+1)*5)) & 0x1f)
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
+func moveX(hash *Bits, d int) {
+	if d == 0 {
+		return
+	}
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	hash.Bits = x | y
+}
+
+func moveY(hash *Bits, d int) {
+	if d == 0 {
+		return
+	}
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	hash.Bits = x | y
+}
+
+func move(hash Bits, dx int, dy int) Bits {
+	moveX(&hash, dx)
+	moveY(&hash, dy)
+	return hash
+}
+
+// GetNeighbors 返回周围的 8 个格子
+func GetNeighbors(hash Bits) Neighbors {
+	return Neighbors{
+		East:      move(hash, 1, 0),
+		West:      move(hash, -1, 0),
+		South:     move(hash, 0, -1),
+		North:     move(hash, 0, 1),
+		NorthWest: move(hash, -1, 1),
+		SouthWest: move(hash, -1, -1),
+		NorthEast: move(hash, 1, 1),
+		SouthEast: move(hash, 1, -1),
+	}
+}
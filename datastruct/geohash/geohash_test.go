@@ -0,0 +1,72 @@
+package geohash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	hash, ok := Encode(13.361389, 38.115556, StepMax)
+	if !ok {
+		t.Fatal("expected valid coord")
+	}
+	score := Align52Bits(hash)
+	if score != 3479099956230698 {
+		t.Fatalf("expected 3479099956230698, actually %d", score)
+	}
+	longitude, latitude := DecodeScore(float64(score))
+	if math.Abs(longitude-13.361389) > 1e-5 || math.Abs(latitude-38.115556) > 1e-5 {
+		t.Fatalf("decode mismatch: %f %f", longitude, latitude)
+	}
+	if str := ToString(float64(score)); str != "sqc8b49rny0" {
+		t.Fatalf("expected sqc8b49rny0, actually %s", str)
+	}
+	if _, ok := Encode(200, 100, StepMax); ok {
+		t.Fatal("expected invalid coord")
+	}
+	if _, ok := Encode(0, math.NaN(), StepMax); ok {
+		t.Fatal("expected invalid coord")
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	hash, _ := Encode(15, 37, 10)
+	area := Decode(hash)
+	neighbors := GetNeighbors(hash)
+	north := Decode(neighbors.North)
+	if north.Latitude.Min != area.Latitude.Max || north.Longitude != area.Longitude {
+		t.Fatal("north neighbor mismatch")
+	}
+	east := Decode(neighbors.East)
+	if east.Longitude.Min != area.Longitude.Max || east.Latitude != area.Latitude {
+		t.Fatal("east neighbor mismatch")
+	}
+	southWest := Decode(neighbors.SouthWest)
+	if southWest.Longitude.Max != area.Longitude.Min || southWest.Latitude.Max != area.Latitude.Min {
+		t.Fatal("south west neighbor mismatch")
+	}
+}
+
+func TestDistance(t *testing.T) {
+	distance := Distance(13.361389, 38.115556, 15.087269, 37.502669)
+	if math.Abs(distance-166274.15) > 1 {
+		t.Fatalf("expected about 166274, actually %f", distance)
+	}
+	shape := &Shape{Longitude: 15, Latitude: 37, Radius: 200, Conversion: 1000}
+	if _, ok := DistanceIfInShape(shape, 13.361389, 38.115556); !ok {
+		t.Fatal("expected in shape")
+	}
+	shape.Radius = 100
+	if _, ok := DistanceIfInShape(shape, 13.361389, 38.115556); ok {
+		t.Fatal("expected not in shape")
+	}
+	box := &Shape{Longitude: 15, Latitude: 37, IsBox: true, Width: 400, Height: 400, Conversion: 1000}
+	if _, ok := DistanceIfInShape(box, 17.241510, 38.788135); !ok {
+		t.Fatal("expected in box")
+	}
+	// 搜索区域至少包含中心格子
+	areas := SearchAreas(shape)
+	if len(areas) != 9 || areas[0].IsZero() {
+		t.Fatal("expected center area")
+	}
+}
@@ -0,0 +1,194 @@
+// Package geohash -----------------------------
+// @file      : helper.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/28 11:05
+// -------------------------------------------
+package geohash
+
+import "math"
+
+// 和 Redis 的 geohash_helper.c 使用相同的常量，保证距离的计算结果完全一致
+const (
+	mercatorMax = 20037726.37
+	// EarthRadius 地球半径，单位米
+	EarthRadius = 6372797.560856
+)
+
+func degRad(ang float64) float64 {
+	return ang * (math.Pi / 180.0)
+}
+
+func radDeg(ang float64) float64 {
+	return ang / (math.Pi / 180.0)
+}
+
+// Shape 搜索的范围，圆形或者矩形
+// 长度使用命令中的单位，乘以 Conversion 得到米，和 Redis 的计算顺序保持一致
+type Shape struct {
+	Longitude float64
+	Latitude  float64
+	// 圆形
+	Radius float64
+	// 矩形，IsBox 为 true 时有效
+	IsBox      bool
+	Width      float64
+	Height     float64
+	Conversion float64
+}
+
+// EstimateStepsByRadius 根据半径估算格子的精度
+func EstimateStepsByRadius(rangeMeters float64, latitude float64) uint8 {
+	if rangeMeters == 0 {
+		return StepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// 保证大多数情况下范围都能被覆盖
+	step -= 2
+	// 靠近两极的时候经线更密，需要更大的格子
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > StepMax {
+		step = StepMax
+	}
+	return uint8(step)
+}
+
+// boundingBox 返回包含搜索范围的经纬度矩形 [minLon, minLat, maxLon, maxLat]
+func boundingBox(shape *Shape) [4]float64 {
+	height, width := shape.Conversion*shape.Radius, shape.Conversion*shape.Radius
+	if shape.IsBox {
+		height, width = shape.Conversion*(shape.Height/2), shape.Conversion*(shape.Width/2)
+	}
+	latDelta := radDeg(height / EarthRadius)
+	longDeltaTop := radDeg(width / EarthRadius / math.Cos(degRad(shape.Latitude+latDelta)))
+	longDeltaBottom := radDeg(width / EarthRadius / math.Cos(degRad(shape.Latitude-latDelta)))
+	// 南北半球的方向相反，取不同的点作为经度的边界
+	var bounds [4]float64
+	if shape.Latitude < 0 {
+		bounds[0] = shape.Longitude - longDeltaBottom
+		bounds[2] = shape.Longitude + longDeltaBottom
+	} else {
+		bounds[0] = shape.Longitude - longDeltaTop
+		bounds[2] = shape.Longitude + longDeltaTop
+	}
+	bounds[1] = shape.Latitude - latDelta
+	bounds[3] = shape.Latitude + latDelta
+	return bounds
+}
+
+// SearchAreas 返回需要搜索的 9 个格子：中心和周围的 8 个，不需要搜索的格子为零值
+// 参考 Redis 的 geohashCalculateAreasByShapeWGS84
+func SearchAreas(shape *Shape) []Bits {
+	bounds := boundingBox(shape)
+	minLon, minLat, maxLon, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+	radius := shape.Radius
+	if shape.IsBox {
+		// 矩形使用中心到角的距离
+		radius = math.Sqrt((shape.Width/2)*(shape.Width/2) + (shape.Height/2)*(shape.Height/2))
+	}
+	radius *= shape.Conversion
+	steps := EstimateStepsByRadius(radius, shape.Latitude)
+	hash, _ := Encode(shape.Longitude, shape.Latitude, steps)
+	neighbors := GetNeighbors(hash)
+	area := Decode(hash)
+
+	// 搜索范围靠近格子边缘的时候，估算的精度可能不够，周围的格子覆盖不了整个范围
+	north := Decode(neighbors.North)
+	south := Decode(neighbors.South)
+	east := Decode(neighbors.East)
+	west := Decode(neighbors.West)
+	if north.Latitude.Max < maxLat || south.Latitude.Min > minLat ||
+		east.Longitude.Max < maxLon || west.Longitude.Min > minLon {
+		if steps > 1 {
+			steps--
+			hash, _ = Encode(shape.Longitude, shape.Latitude, steps)
+			neighbors = GetNeighbors(hash)
+			area = Decode(hash)
+		}
+	}
+
+	// 排除不需要搜索的格子
+	if steps >= 2 {
+		if area.Latitude.Min < minLat {
+			neighbors.South = Bits{}
+			neighbors.SouthWest = Bits{}
+			neighbors.SouthEast = Bits{}
+		}
+		if area.Latitude.Max > maxLat {
+			neighbors.North = Bits{}
+			neighbors.NorthEast = Bits{}
+			neighbors.NorthWest = Bits{}
+		}
+		if area.Longitude.Min < minLon {
+			neighbors.West = Bits{}
+			neighbors.SouthWest = Bits{}
+			neighbors.NorthWest = Bits{}
+		}
+		if area.Longitude.Max > maxLon {
+			neighbors.East = Bits{}
+			neighbors.SouthEast = Bits{}
+			neighbors.NorthEast = Bits{}
+		}
+	}
+	return []Bits{
+		hash,
+		neighbors.North,
+		neighbors.South,
+		neighbors.East,
+		neighbors.West,
+		neighbors.NorthEast,
+		neighbors.NorthWest,
+		neighbors.SouthEast,
+		neighbors.SouthWest,
+	}
+}
+
+// latDistance 两个纬度之间的距离
+func latDistance(lat1 float64, lat2 float64) float64 {
+	return EarthRadius * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// Distance 使用 haversine 公式计算两点之间的距离，单位米
+func Distance(lon1 float64, lat1 float64, lon2 float64, lat2 float64) float64 {
+	lon1r := degRad(lon1)
+	lon2r := degRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// 经度相同的时候只需要计算纬度的距离
+	if v == 0.0 {
+		return latDistance(lat1, lat2)
+	}
+	lat1r := degRad(lat1)
+	lat2r := degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	// 显式转换避免编译器合并乘加运算（FMA），保证和 Redis 的结果逐位一致
+	a := float64(u*u) + float64(math.Cos(lat1r)*math.Cos(lat2r)*v*v)
+	return 2.0 * EarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// DistanceIfInShape 点在搜索范围内时返回到中心的距离，单位米
+func DistanceIfInShape(shape *Shape, longitude float64, latitude float64) (float64, bool) {
+	if !shape.IsBox {
+		distance := Distance(shape.Longitude, shape.Latitude, longitude, latitude)
+		return distance, distance <= shape.Radius*shape.Conversion
+	}
+	// 先检查计算量更小的纬度距离
+	if latDistance(latitude, shape.Latitude) > shape.Height*shape.Conversion/2 {
+		return 0, false
+	}
+	if Distance(longitude, latitude, shape.Longitude, latitude) > shape.Width*shape.Conversion/2 {
+		return 0, false
+	}
+	return Distance(shape.Longitude, shape.Latitude, longitude, latitude), true
+}
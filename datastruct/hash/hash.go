@@ -0,0 +1,197 @@
+// Package hash -----------------------------
+// @file      : hash.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/22 14:35
+// -------------------------------------------
+package hash
+
+import (
+	"math/rand"
+	"redis-go/datastruct/dict"
+)
+
+// 参考 Redis 的 hash-max-listpack-entries 和 hash-max-listpack-value
+// 超过任意一个阈值就从紧凑编码转换为哈希表
+const (
+	maxListpackEntries = 128
+	maxListpackValue   = 64
+	// 哈希表编码的 shard 数量，HSCAN 按照 shard 的下标作为游标
+	hashtableShards = 128
+)
+
+// 编码方式，与 OBJECT ENCODING 的结果一致
+const (
+	EncodingListpack  = "listpack"
+	EncodingHashtable = "hashtable"
+)
+
+// Consumer 遍历 field 和 value，返回 false 停止遍历
+type Consumer func(field string, value []byte) bool
+
+// Hash 哈希类型的值
+// 元素较少时 field 和 value 依次紧凑地存放在一个数组里 [f1, v1, f2, v2 ...]，查找是 O(n) 的，
+// 但是数据量小的时候比哈希表更省内存，超过阈值后转换成分段的 dict.ConcurrentDict，以便 HSCAN 分批遍历
+type Hash struct {
+	pairs [][]byte
+	dict  dict.Dict
+}
+
+func MakeHash() *Hash {
+	return &Hash{
+		pairs: make([][]byte, 0),
+	}
+}
+
+// Encoding 返回当前的编码方式
+func (h *Hash) Encoding() string {
+	if h.dict != nil {
+		return EncodingHashtable
+	}
+	return EncodingListpack
+}
+
+// indexOf 在紧凑编码中找到 field 的下标，没有则为 -1
+func (h *Hash) indexOf(field string) int {
+	for i := 0; i < len(h.pairs); i += 2 {
+		if string(h.pairs[i]) == field {
+			return i
+		}
+	}
+	return -1
+}
+
+// convert 紧凑编码转换为哈希表，只会转换一次
+func (h *Hash) convert() {
+	d := dict.MakeConcurrent(hashtableShards)
+	for i := 0; i < len(h.pairs); i += 2 {
+		d.Put(string(h.pairs[i]), h.pairs[i+1])
+	}
+	h.dict = d
+	h.pairs = nil
+}
+
+func (h *Hash) Len() int {
+	if h.dict != nil {
+		return h.dict.Len()
+	}
+	return len(h.pairs) / 2
+}
+
+func (h *Hash) Get(field string) (value []byte, exists bool) {
+	if h.dict != nil {
+		raw, ok := h.dict.Get(field)
+		if !ok {
+			return nil, false
+		}
+		return raw.([]byte), true
+	}
+	i := h.indexOf(field)
+	if i < 0 {
+		return nil, false
+	}
+	return h.pairs[i+1], true
+}
+
+// Set 设置 field 的值，返回新增的 field 数量
+func (h *Hash) Set(field string, value []byte) int {
+	if h.dict == nil && (len(field) > maxListpackValue || len(value) > maxListpackValue) {
+		h.convert()
+	}
+	if h.dict != nil {
+		return h.dict.Put(field, value)
+	}
+	i := h.indexOf(field)
+	if i >= 0 {
+		h.pairs[i+1] = value
+		return 0
+	}
+	h.pairs = append(h.pairs, []byte(field), value)
+	if len(h.pairs)/2 > maxListpackEntries {
+		h.convert()
+	}
+	return 1
+}
+
+// SetIfAbsent field 不存在时才设置
+func (h *Hash) SetIfAbsent(field string, value []byte) int {
+	if _, exists := h.Get(field); exists {
+		return 0
+	}
+	return h.Set(field, value)
+}
+
+// Remove 删除 field，返回删除的数量
+func (h *Hash) Remove(field string) int {
+	if h.dict != nil {
+		return h.dict.Remove(field)
+	}
+	i := h.indexOf(field)
+	if i < 0 {
+		return 0
+	}
+	h.pairs = append(h.pairs[:i], h.pairs[i+2:]...)
+	return 1
+}
+
+// ForEach 遍历所有的 field 和 value
+func (h *Hash) ForEach(consumer Consumer) {
+	if h.dict != nil {
+		h.dict.ForEach(func(key string, val interface{}) bool {
+			return consumer(key, val.([]byte))
+		})
+		return
+	}
+	for i := 0; i < len(h.pairs); i += 2 {
+		if !consumer(string(h.pairs[i]), h.pairs[i+1]) {
+			break
+		}
+	}
+}
+
+// Scan 从 cursor 开始遍历大约 count 个 field，返回下一次遍历的游标，遍历结束时返回 0
+// 和 Redis 一样，紧凑编码一次遍历完，哈希表编码按照 shard 的下标分批遍历
+func (h *Hash) Scan(cursor int, count int, consumer Consumer) int {
+	if h.dict != nil {
+		return h.dict.Scan(cursor, count, func(key string, val interface{}) bool {
+			return consumer(key, val.([]byte))
+		})
+	}
+	h.ForEach(consumer)
+	return 0
+}
+
+// RandomFields 随机返回 limit 个可重复的 field
+func (h *Hash) RandomFields(limit int) []string {
+	if h.dict != nil {
+		return h.dict.RandomKeys(limit)
+	}
+	size := h.Len()
+	if limit <= 0 || size == 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = string(h.pairs[rand.Intn(size)*2])
+	}
+	return result
+}
+
+// RandomDistinctFields 随机返回 limit 个不重复的 field
+func (h *Hash) RandomDistinctFields(limit int) []string {
+	if h.dict != nil {
+		return h.dict.RandomDistinctKeys(limit)
+	}
+	size := h.Len()
+	if limit > size {
+		limit = size
+	}
+	if limit <= 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
+	for i, j := range rand.Perm(size)[:limit] {
+		result[i] = string(h.pairs[j*2])
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package hash
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashConvert(t *testing.T) {
+	h := MakeHash()
+	for i := 0; i < maxListpackEntries; i++ {
+		field := strconv.Itoa(i)
+		if h.Set(field, []byte(field)) != 1 {
+			t.Fatalf("expected new field %s", field)
+		}
+	}
+	if h.Encoding() != EncodingListpack {
+		t.Fatalf("expected listpack, actually %s", h.Encoding())
+	}
+	// 超过元素个数的阈值
+	h.Set("overflow", []byte("v"))
+	if h.Encoding() != EncodingHashtable {
+		t.Fatalf("expected hashtable, actually %s", h.Encoding())
+	}
+	if h.Len() != maxListpackEntries+1 {
+		t.Fatalf("expected len %d, actually %d", maxListpackEntries+1, h.Len())
+	}
+	for i := 0; i < maxListpackEntries; i++ {
+		field := strconv.Itoa(i)
+		value, ok := h.Get(field)
+		if !ok || string(value) != field {
+			t.Fatalf("field %s lost after convert", field)
+		}
+	}
+
+	// 超过 value 长度的阈值
+	h = MakeHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte(strings.Repeat("x", maxListpackValue+1)))
+	if h.Encoding() != EncodingHashtable {
+		t.Fatalf("expected hashtable, actually %s", h.Encoding())
+	}
+}
+
+func TestHashRemove(t *testing.T) {
+	h := MakeHash()
+	h.Set("a", []byte("1"))
+	h.Set("b", []byte("2"))
+	h.Set("c", []byte("3"))
+	if h.Remove("b") != 1 || h.Remove("b") != 0 {
+		t.Fatal("remove failed")
+	}
+	if _, ok := h.Get("b"); ok {
+		t.Fatal("b should be removed")
+	}
+	if value, _ := h.Get("c"); string(value) != "3" {
+		t.Fatal("c should be kept")
+	}
+	fields := h.RandomDistinctFields(10)
+	if len(fields) != 2 {
+		t.Fatalf("expected 2 fields, actually %d", len(fields))
+	}
+	if len(h.RandomFields(5)) != 5 {
+		t.Fatal("expected 5 random fields")
+	}
+}
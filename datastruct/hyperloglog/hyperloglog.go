@@ -0,0 +1,444 @@
+// Package hyperloglog -----------------------------
+// @file      : hyperloglog.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/25 15:10
+// -------------------------------------------
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// 参考 Redis 的 hyperloglog.c，序列化格式和 Redis 完全一致，直接作为字符串存储
+// +------+---+-----+----------+
+// | HYLL | E | N/U | Cardin.  |
+// +------+---+-----+----------+
+// 4 字节魔数，1 字节编码，3 字节保留，8 字节小端序的基数缓存，缓存最高位为 1 表示缓存失效
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllPMask     = hllRegisters - 1
+	hllBits      = 6
+	hllRegMax    = (1 << hllBits) - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllAlphaInf  = 0.721347520444481703680
+
+	// 稀疏表示超过这个长度时转换成密集表示，和 Redis 的 hll-sparse-max-bytes 默认值一致
+	hllSparseMaxBytes = 3000
+)
+
+// 编码方式
+const (
+	hllDense  = 0
+	hllSparse = 1
+)
+
+// 稀疏表示的三种操作码
+// ZERO:  00xxxxxx          连续 xxxxxx+1 个寄存器为 0
+// XZERO: 01xxxxxx yyyyyyyy 连续 xxxxxxyyyyyyyy+1 个寄存器为 0
+// VAL:   1vvvvvxx          连续 xx+1 个寄存器的值为 vvvvv+1
+const (
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+)
+
+var (
+	// ErrInvalid 不是 HyperLogLog
+	ErrInvalid = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	// ErrCorrupted 稀疏表示的数据损坏
+	ErrCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// Make 创建空的 HyperLogLog，使用稀疏表示
+func Make() []byte {
+	data := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(data, "HYLL")
+	data[4] = hllSparse
+	// 一个 XZERO 覆盖所有的寄存器
+	zeros := sparseXZeroMaxLen - 1
+	data = append(data, byte(0x40|(zeros>>8)), byte(zeros&0xff))
+	return data
+}
+
+// Validate 检查字符串是否为合法的 HyperLogLog
+func Validate(data []byte) error {
+	if len(data) < hllHdrSize {
+		return ErrInvalid
+	}
+	if string(data[:4]) != "HYLL" || data[4] > hllSparse {
+		return ErrInvalid
+	}
+	if data[4] == hllDense && len(data) != hllDenseSize {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func invalidateCache(data []byte) {
+	data[15] |= 1 << 7
+}
+
+func validCache(data []byte) bool {
+	return data[15]&(1<<7) == 0
+}
+
+// murmurHash64A Redis 使用的哈希函数
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m uint64 = 0xc6a4a7935bd1e995
+	const r = 47
+	length := len(key)
+	h := seed ^ (uint64(length) * m)
+	i := 0
+	for ; i+8 <= length; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	rest := key[i:]
+	switch len(rest) {
+	case 7:
+		h ^= uint64(rest[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(rest[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(rest[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(rest[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(rest[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(rest[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(rest[0])
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// patLen 返回元素对应的寄存器以及从低位开始第一个 1 的位置
+func patLen(element []byte) (index int, count uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index = int(hash & hllPMask)
+	hash >>= hllP
+	// 保证循环能够结束
+	hash |= 1 << hllQ
+	var bit uint64 = 1
+	count = 1
+	for hash&bit == 0 {
+		count++
+		bit <<= 1
+	}
+	return index, count
+}
+
+func denseGetRegister(registers []byte, index int) uint8 {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	fb8 := 8 - fb
+	b0 := uint(registers[byteIndex])
+	var b1 uint
+	if byteIndex+1 < len(registers) {
+		b1 = uint(registers[byteIndex+1])
+	}
+	return uint8(((b0 >> fb) | (b1 << fb8)) & hllRegMax)
+}
+
+func denseSetRegister(registers []byte, index int, value uint8) {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	fb8 := 8 - fb
+	v := uint(value)
+	registers[byteIndex] &^= byte(hllRegMax << fb)
+	registers[byteIndex] |= byte(v << fb)
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(hllRegMax >> fb8)
+		registers[byteIndex+1] |= byte(v >> fb8)
+	}
+}
+
+// decodeSparse 把稀疏表示展开成每个寄存器一个字节
+func decodeSparse(data []byte, registers []uint8) error {
+	index := 0
+	for i := 0; i < len(data); {
+		op := data[i]
+		switch {
+		case op&0xc0 == 0x00:
+			// ZERO
+			index += int(op&0x3f) + 1
+			i++
+		case op&0xc0 == 0x40:
+			// XZERO
+			if i+1 >= len(data) {
+				return ErrCorrupted
+			}
+			index += (int(op&0x3f)<<8 | int(data[i+1])) + 1
+			i += 2
+		default:
+			// VAL
+			runLen := int(op&0x3) + 1
+			value := (op>>2)&0x1f + 1
+			if index+runLen > hllRegisters {
+				return ErrCorrupted
+			}
+			for j := 0; j < runLen; j++ {
+				registers[index+j] = value
+			}
+			index += runLen
+			i++
+		}
+		if index > hllRegisters {
+			return ErrCorrupted
+		}
+	}
+	if index != hllRegisters {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// encodeSparse 把寄存器编码成稀疏表示，有寄存器的值超过 32 时无法编码
+func encodeSparse(registers []uint8) ([]byte, bool) {
+	result := make([]byte, 0)
+	for i := 0; i < hllRegisters; {
+		value := registers[i]
+		runLen := 1
+		for i+runLen < hllRegisters && registers[i+runLen] == value {
+			runLen++
+		}
+		i += runLen
+		if value == 0 {
+			for runLen > 0 {
+				if runLen > sparseZeroMaxLen {
+					n := runLen
+					if n > sparseXZeroMaxLen {
+						n = sparseXZeroMaxLen
+					}
+					result = append(result, byte(0x40|((n-1)>>8)), byte((n-1)&0xff))
+					runLen -= n
+				} else {
+					result = append(result, byte(runLen-1))
+					runLen = 0
+				}
+			}
+			continue
+		}
+		if value > sparseValMaxValue {
+			return nil, false
+		}
+		for runLen > 0 {
+			n := runLen
+			if n > sparseValMaxLen {
+				n = sparseValMaxLen
+			}
+			result = append(result, 0x80|(value-1)<<2|byte(n-1))
+			runLen -= n
+		}
+	}
+	return result, true
+}
+
+// registersOf 取出所有寄存器的值，调用方需要先检查 Validate
+func registersOf(data []byte) ([]uint8, error) {
+	registers := make([]uint8, hllRegisters)
+	if err := mergeRegisters(registers, data); err != nil {
+		return nil, err
+	}
+	return registers, nil
+}
+
+// mergeRegisters 把 data 的寄存器合并到 max 中，每个寄存器取最大值
+func mergeRegisters(max []uint8, data []byte) error {
+	if data[4] == hllDense {
+		dense := data[hllHdrSize:]
+		for i := 0; i < hllRegisters; i++ {
+			if v := denseGetRegister(dense, i); v > max[i] {
+				max[i] = v
+			}
+		}
+		return nil
+	}
+	registers := make([]uint8, hllRegisters)
+	if err := decodeSparse(data[hllHdrSize:], registers); err != nil {
+		return err
+	}
+	for i, v := range registers {
+		if v > max[i] {
+			max[i] = v
+		}
+	}
+	return nil
+}
+
+// MakeDense 根据寄存器的值创建密集表示的 HyperLogLog
+func MakeDense(registers []uint8) []byte {
+	data := make([]byte, hllDenseSize)
+	copy(data, "HYLL")
+	data[4] = hllDense
+	dense := data[hllHdrSize:]
+	for i, v := range registers {
+		denseSetRegister(dense, i, v)
+	}
+	invalidateCache(data)
+	return data
+}
+
+// IsDense 是否为密集表示
+func IsDense(data []byte) bool {
+	return data[4] == hllDense
+}
+
+// FromRegisters 根据寄存器的值创建 HyperLogLog，dense 为 false 时优先使用稀疏表示
+func FromRegisters(registers []uint8, dense bool) []byte {
+	if !dense {
+		sparse, ok := encodeSparse(registers)
+		if ok && hllHdrSize+len(sparse) <= hllSparseMaxBytes {
+			data := make([]byte, hllHdrSize, hllHdrSize+len(sparse))
+			copy(data, "HYLL")
+			data[4] = hllSparse
+			data = append(data, sparse...)
+			invalidateCache(data)
+			return data
+		}
+	}
+	return MakeDense(registers)
+}
+
+// Add 添加元素，返回新的 HyperLogLog 以及是否有寄存器发生了变化
+// 稀疏表示放不下的时候会转换成密集表示，data 本身不会被修改
+func Add(data []byte, elements ...[]byte) ([]byte, bool, error) {
+	if data[4] == hllDense {
+		updated := false
+		for _, element := range elements {
+			index, count := patLen(element)
+			if count > denseGetRegister(data[hllHdrSize:], index) {
+				// 第一次修改时复制，旧的字节可能还在被读取
+				if !updated {
+					data = append([]byte(nil), data...)
+					updated = true
+				}
+				denseSetRegister(data[hllHdrSize:], index, count)
+			}
+		}
+		if updated {
+			invalidateCache(data)
+		}
+		return data, updated, nil
+	}
+
+	registers, err := registersOf(data)
+	if err != nil {
+		return nil, false, err
+	}
+	updated := false
+	for _, element := range elements {
+		index, count := patLen(element)
+		if count > registers[index] {
+			registers[index] = count
+			updated = true
+		}
+	}
+	if !updated {
+		return data, false, nil
+	}
+	return FromRegisters(registers, false), true, nil
+}
+
+// Count 返回估算的基数，缓存有效时直接使用缓存
+// 缓存失效时重新计算，cached 是写入了缓存的副本，调用方用它替换原来的值；缓存有效时 cached 为 nil
+// data 本身不会被修改，旧的字节可能还在被其他读命令的回复引用
+func Count(data []byte) (count int64, cached []byte, err error) {
+	if validCache(data) {
+		return int64(binary.LittleEndian.Uint64(data[8:16])), nil, nil
+	}
+	registers, err := registersOf(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	count = CountRegisters(registers)
+	cached = append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(cached[8:16], uint64(count))
+	return count, cached, nil
+}
+
+// Merge 合并多个 HyperLogLog 的寄存器，每个寄存器取最大值
+func Merge(hlls ...[]byte) ([]uint8, error) {
+	max := make([]uint8, hllRegisters)
+	for _, data := range hlls {
+		if err := mergeRegisters(max, data); err != nil {
+			return nil, err
+		}
+	}
+	return max, nil
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			break
+		}
+	}
+	return z
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			break
+		}
+	}
+	return z / 3
+}
+
+// CountRegisters 根据寄存器估算基数，使用 Redis 的改进估算方法（Otmar Ertl）
+func CountRegisters(registers []uint8) int64 {
+	m := float64(hllRegisters)
+	// 密集表示的寄存器最大为 63，损坏的数据也不会越界
+	histogram := make([]int, 1<<hllBits)
+	for _, v := range registers {
+		histogram[v]++
+	}
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return int64(math.Round(hllAlphaInf * m * m / z))
+}
@@ -0,0 +1,95 @@
+package hyperloglog
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	hll := Make()
+	if err := Validate(hll); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, _ := Count(hll); count != 0 {
+		t.Fatalf("expected 0, actually %d", count)
+	}
+	for _, n := range []int{10, 1000, 100000} {
+		hll = Make()
+		for i := 0; i < n; i++ {
+			hll, _, _ = Add(hll, []byte("element:"+strconv.Itoa(i)))
+		}
+		count, cached, err := Count(hll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached == nil || !validCache(cached) || validCache(hll) {
+			t.Fatal("expected cached copy")
+		}
+		// 标准误差为 0.81%
+		if math.Abs(float64(count)-float64(n))/float64(n) > 0.03 {
+			t.Fatalf("expected about %d, actually %d", n, count)
+		}
+		if n >= 100000 && !IsDense(hll) {
+			t.Fatal("expected dense encoding")
+		}
+		// 第二次使用缓存
+		if cachedCount, updated, _ := Count(cached); cachedCount != count || updated != nil {
+			t.Fatalf("expected cached %d, actually %d", count, cachedCount)
+		}
+	}
+}
+
+func TestSparse(t *testing.T) {
+	hll := Make()
+	hll, updated, _ := Add(hll, []byte("a"), []byte("b"), []byte("c"))
+	if !updated || IsDense(hll) {
+		t.Fatal("expected updated sparse hll")
+	}
+	_, updated, _ = Add(hll, []byte("a"))
+	if updated {
+		t.Fatal("expected no update")
+	}
+	registers, err := registersOf(hll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 稀疏和密集表示的寄存器一致
+	dense := MakeDense(registers)
+	denseRegisters, _ := registersOf(dense)
+	for i := range registers {
+		if registers[i] != denseRegisters[i] {
+			t.Fatalf("register %d mismatch", i)
+		}
+	}
+	sparse := FromRegisters(denseRegisters, false)
+	if IsDense(sparse) || string(sparse[hllHdrSize:]) != string(hll[hllHdrSize:]) {
+		t.Fatal("sparse encoding mismatch")
+	}
+
+	// 损坏的稀疏表示
+	corrupted := append([]byte{}, hll...)
+	corrupted = append(corrupted, 0x00)
+	if _, _, err := Add(corrupted, []byte("d")); err != ErrCorrupted {
+		t.Fatalf("expected corrupted error, actually %v", err)
+	}
+	if err := Validate([]byte("HYLL")); err != ErrInvalid {
+		t.Fatalf("expected invalid error, actually %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := Make(), Make()
+	for i := 0; i < 1000; i++ {
+		a, _, _ = Add(a, []byte(strconv.Itoa(i)))
+		b, _, _ = Add(b, []byte(strconv.Itoa(i+500)))
+	}
+	registers, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := CountRegisters(registers)
+	if math.Abs(float64(count)-1500)/1500 > 0.03 {
+		t.Fatalf("expected about 1500, actually %d", count)
+	}
+}
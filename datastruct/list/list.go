@@ -0,0 +1,41 @@
+// Package list -----------------------------
+// @file      : list.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/21 10:32
+// -------------------------------------------
+package list
+
+// Expected 判断元素是否符合条件
+type Expected func(val []byte) bool
+
+// Consumer 遍历元素，返回 false 停止遍历
+type Consumer func(i int, val []byte) bool
+
+// List 列表结构的接口，下标从 0 开始
+type List interface {
+	// PushBack 尾部插入
+	PushBack(val []byte)
+	// PushFront 头部插入
+	PushFront(val []byte)
+	Get(index int) (val []byte)
+	Set(index int, val []byte)
+	// Insert 插入到 index 的位置，原来的元素后移
+	Insert(index int, val []byte)
+	Remove(index int) (val []byte)
+	RemoveFirst() (val []byte)
+	RemoveLast() (val []byte)
+	// RemoveByVal 从头开始删除最多 count 个符合条件的元素
+	RemoveByVal(expected Expected, count int) int
+	// ReverseRemoveByVal 从尾开始删除最多 count 个符合条件的元素
+	ReverseRemoveByVal(expected Expected, count int) int
+	RemoveAllByVal(expected Expected) int
+	// Trim 只保留 [start, stop) 之间的元素
+	Trim(start int, stop int)
+	Len() int
+	ForEach(consumer Consumer)
+	// IndexOf 返回第一个符合条件的元素的下标，没有则为 -1
+	IndexOf(expected Expected) int
+	// Range 返回 [start, stop) 之间的元素
+	Range(start int, stop int) [][]byte
+}
@@ -0,0 +1,438 @@
+// Package list -----------------------------
+// @file      : quicklist.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/21 11:05
+// -------------------------------------------
+package list
+
+import "container/list"
+
+// pageSize 每个节点最多保存的元素个数
+const pageSize = 1024
+
+// QuickList 参考 Redis 的 quicklist：由小数组组成的双向链表
+// 相比每个元素一个链表节点，指针的开销更小，内存也更连续
+type QuickList struct {
+	// 每个节点保存一个 [][]byte 的页
+	data *list.List
+	size int
+}
+
+// iterator 指向 quicklist 中的某个元素
+type iterator struct {
+	node   *list.Element
+	offset int
+	ql     *QuickList
+}
+
+func NewQuickList() *QuickList {
+	return &QuickList{
+		data: list.New(),
+	}
+}
+
+func (ql *QuickList) Len() int {
+	return ql.size
+}
+
+// PushBack 尾部插入
+func (ql *QuickList) PushBack(val []byte) {
+	ql.size++
+	if ql.data.Len() == 0 {
+		page := make([][]byte, 0, pageSize)
+		page = append(page, val)
+		ql.data.PushBack(page)
+		return
+	}
+	backNode := ql.data.Back()
+	backPage := backNode.Value.([][]byte)
+	// 最后一页满了就新开一页
+	if len(backPage) == cap(backPage) {
+		page := make([][]byte, 0, pageSize)
+		page = append(page, val)
+		ql.data.PushBack(page)
+		return
+	}
+	backNode.Value = append(backPage, val)
+}
+
+// PushFront 头部插入
+func (ql *QuickList) PushFront(val []byte) {
+	if ql.size == 0 {
+		ql.PushBack(val)
+		return
+	}
+	ql.Insert(0, val)
+}
+
+// find 找到下标对应的元素，从离得近的一端开始找
+func (ql *QuickList) find(index int) *iterator {
+	if ql == nil {
+		panic("list is nil")
+	}
+	if index < 0 || index >= ql.size {
+		panic("index out of bound")
+	}
+	var n *list.Element
+	var page [][]byte
+	var pageBeg int
+	if index < ql.size/2 {
+		// 从头开始
+		n = ql.data.Front()
+		pageBeg = 0
+		for {
+			page = n.Value.([][]byte)
+			if pageBeg+len(page) > index {
+				break
+			}
+			pageBeg += len(page)
+			n = n.Next()
+		}
+	} else {
+		// 从尾开始
+		n = ql.data.Back()
+		pageBeg = ql.size
+		for {
+			page = n.Value.([][]byte)
+			pageBeg -= len(page)
+			if pageBeg <= index {
+				break
+			}
+			n = n.Prev()
+		}
+	}
+	pageOffset := index - pageBeg
+	return &iterator{
+		node:   n,
+		offset: pageOffset,
+		ql:     ql,
+	}
+}
+
+func (iter *iterator) get() []byte {
+	return iter.page()[iter.offset]
+}
+
+func (iter *iterator) page() [][]byte {
+	return iter.node.Value.([][]byte)
+}
+
+// next 移动到下一个元素，已经是最后一个元素则返回 false
+func (iter *iterator) next() bool {
+	page := iter.page()
+	if iter.offset < len(page)-1 {
+		iter.offset++
+		return true
+	}
+	// 移动到下一页
+	if iter.node == iter.ql.data.Back() {
+		// 已经是最后一个元素，指向末尾之后
+		iter.offset = len(page)
+		return false
+	}
+	iter.offset = 0
+	iter.node = iter.node.Next()
+	return true
+}
+
+// prev 移动到上一个元素，已经是第一个元素则返回 false
+func (iter *iterator) prev() bool {
+	if iter.offset > 0 {
+		iter.offset--
+		return true
+	}
+	// 移动到上一页
+	if iter.node == iter.ql.data.Front() {
+		// 已经是第一个元素，指向开头之前
+		iter.offset = -1
+		return false
+	}
+	iter.node = iter.node.Prev()
+	prevPage := iter.node.Value.([][]byte)
+	iter.offset = len(prevPage) - 1
+	return true
+}
+
+func (iter *iterator) atEnd() bool {
+	if iter.ql.data.Len() == 0 {
+		return true
+	}
+	if iter.node != iter.ql.data.Back() {
+		return false
+	}
+	page := iter.page()
+	return iter.offset == len(page)
+}
+
+func (iter *iterator) atBegin() bool {
+	if iter.ql.data.Len() == 0 {
+		return true
+	}
+	if iter.node != iter.ql.data.Front() {
+		return false
+	}
+	return iter.offset == -1
+}
+
+func (iter *iterator) set(val []byte) {
+	page := iter.page()
+	page[iter.offset] = val
+}
+
+// remove 删除当前元素，之后迭代器指向下一个元素
+func (iter *iterator) remove() []byte {
+	page := iter.page()
+	val := page[iter.offset]
+	page = append(page[:iter.offset], page[iter.offset+1:]...)
+	if len(page) > 0 {
+		iter.node.Value = page
+		if iter.offset == len(page) {
+			// 删除的是页中的最后一个元素
+			if iter.node != iter.ql.data.Back() {
+				iter.node = iter.node.Next()
+				iter.offset = 0
+			}
+			// 否则已经是整个列表的最后一个，指向末尾之后
+		}
+	} else {
+		// 这一页空了，删除整页
+		if iter.node == iter.ql.data.Back() {
+			if prevNode := iter.node.Prev(); prevNode != nil {
+				iter.ql.data.Remove(iter.node)
+				iter.node = prevNode
+				iter.offset = len(prevNode.Value.([][]byte))
+			} else {
+				// 删除的是最后一个元素
+				iter.ql.data.Remove(iter.node)
+				iter.node = nil
+				iter.offset = 0
+			}
+		} else {
+			nextNode := iter.node.Next()
+			iter.ql.data.Remove(iter.node)
+			iter.node = nextNode
+			iter.offset = 0
+		}
+	}
+	iter.ql.size--
+	return val
+}
+
+func (ql *QuickList) Get(index int) (val []byte) {
+	iter := ql.find(index)
+	return iter.get()
+}
+
+func (ql *QuickList) Set(index int, val []byte) {
+	iter := ql.find(index)
+	iter.set(val)
+}
+
+// Insert 插入到 index 的位置，页满了则对半拆分
+func (ql *QuickList) Insert(index int, val []byte) {
+	if index == ql.size {
+		ql.PushBack(val)
+		return
+	}
+	iter := ql.find(index)
+	page := iter.node.Value.([][]byte)
+	if len(page) < pageSize {
+		// 页没满直接插入
+		page = append(page[:iter.offset+1], page[iter.offset:]...)
+		page[iter.offset] = val
+		iter.node.Value = page
+		ql.size++
+		return
+	}
+	// 页满了，拆分成两页
+	var nextPage [][]byte
+	nextPage = append(nextPage, page[pageSize/2:]...)
+	page = page[:pageSize/2]
+	if iter.offset < len(page) {
+		page = append(page[:iter.offset+1], page[iter.offset:]...)
+		page[iter.offset] = val
+	} else {
+		i := iter.offset - pageSize/2
+		nextPage = append(nextPage[:i+1], nextPage[i:]...)
+		nextPage[i] = val
+	}
+	// 保证每页都有 pageSize 的容量
+	iter.node.Value = append(make([][]byte, 0, pageSize), page...)
+	ql.data.InsertAfter(append(make([][]byte, 0, pageSize), nextPage...), iter.node)
+	ql.size++
+}
+
+func (ql *QuickList) Remove(index int) (val []byte) {
+	iter := ql.find(index)
+	return iter.remove()
+}
+
+func (ql *QuickList) RemoveFirst() (val []byte) {
+	if ql.size == 0 {
+		return nil
+	}
+	return ql.Remove(0)
+}
+
+func (ql *QuickList) RemoveLast() (val []byte) {
+	if ql.size == 0 {
+		return nil
+	}
+	lastNode := ql.data.Back()
+	lastPage := lastNode.Value.([][]byte)
+	if len(lastPage) == 1 {
+		ql.data.Remove(lastNode)
+		ql.size--
+		return lastPage[0]
+	}
+	val = lastPage[len(lastPage)-1]
+	lastNode.Value = lastPage[:len(lastPage)-1]
+	ql.size--
+	return val
+}
+
+func (ql *QuickList) RemoveAllByVal(expected Expected) int {
+	return ql.RemoveByVal(expected, ql.size)
+}
+
+func (ql *QuickList) RemoveByVal(expected Expected, count int) int {
+	if ql.size == 0 || count <= 0 {
+		return 0
+	}
+	iter := ql.find(0)
+	removed := 0
+	for !iter.atEnd() && removed < count {
+		if expected(iter.get()) {
+			iter.remove()
+			removed++
+			if ql.size == 0 {
+				break
+			}
+		} else {
+			iter.next()
+		}
+	}
+	return removed
+}
+
+func (ql *QuickList) ReverseRemoveByVal(expected Expected, count int) int {
+	if ql.size == 0 || count <= 0 {
+		return 0
+	}
+	iter := ql.find(ql.size - 1)
+	removed := 0
+	for !iter.atBegin() && removed < count {
+		if expected(iter.get()) {
+			iter.remove()
+			removed++
+			if ql.size == 0 {
+				break
+			}
+			// 删除后指向下一个元素，需要退回来
+			if iter.atEnd() {
+				iter.offset--
+				continue
+			}
+		}
+		iter.prev()
+	}
+	return removed
+}
+
+// Trim 只保留 [start, stop) 之间的元素，整页的删除不需要逐个移动元素
+func (ql *QuickList) Trim(start int, stop int) {
+	if start < 0 {
+		start = 0
+	}
+	if stop > ql.size {
+		stop = ql.size
+	}
+	if start >= stop {
+		ql.data.Init()
+		ql.size = 0
+		return
+	}
+	// 删除尾部
+	removeTail := ql.size - stop
+	for removeTail > 0 {
+		backNode := ql.data.Back()
+		backPage := backNode.Value.([][]byte)
+		if len(backPage) <= removeTail {
+			ql.data.Remove(backNode)
+			removeTail -= len(backPage)
+			ql.size -= len(backPage)
+			continue
+		}
+		backNode.Value = backPage[:len(backPage)-removeTail]
+		ql.size -= removeTail
+		removeTail = 0
+	}
+	// 删除头部
+	removeHead := start
+	for removeHead > 0 {
+		frontNode := ql.data.Front()
+		frontPage := frontNode.Value.([][]byte)
+		if len(frontPage) <= removeHead {
+			ql.data.Remove(frontNode)
+			removeHead -= len(frontPage)
+			ql.size -= len(frontPage)
+			continue
+		}
+		frontNode.Value = append(make([][]byte, 0, pageSize), frontPage[removeHead:]...)
+		ql.size -= removeHead
+		removeHead = 0
+	}
+}
+
+func (ql *QuickList) ForEach(consumer Consumer) {
+	if ql == nil {
+		panic("list is nil")
+	}
+	if ql.Len() == 0 {
+		return
+	}
+	iter := ql.find(0)
+	i := 0
+	for {
+		goNext := consumer(i, iter.get())
+		if !goNext {
+			break
+		}
+		i++
+		if !iter.next() {
+			break
+		}
+	}
+}
+
+func (ql *QuickList) IndexOf(expected Expected) int {
+	result := -1
+	ql.ForEach(func(i int, val []byte) bool {
+		if expected(val) {
+			result = i
+			return false
+		}
+		return true
+	})
+	return result
+}
+
+func (ql *QuickList) Range(start int, stop int) [][]byte {
+	if start < 0 || start >= ql.Len() {
+		panic("`start` out of range")
+	}
+	if stop < start || stop > ql.Len() {
+		panic("`stop` out of range")
+	}
+	sliceSize := stop - start
+	slice := make([][]byte, 0, sliceSize)
+	iter := ql.find(start)
+	i := 0
+	for i < sliceSize {
+		slice = append(slice, iter.get())
+		iter.next()
+		i++
+	}
+	return slice
+}
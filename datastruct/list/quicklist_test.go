@@ -0,0 +1,138 @@
+package list
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func toStrings(vals [][]byte) []string {
+	result := make([]string, len(vals))
+	for i, v := range vals {
+		result[i] = string(v)
+	}
+	return result
+}
+
+func assertEqual(t *testing.T, ql *QuickList, expected []string) {
+	if ql.Len() != len(expected) {
+		t.Fatalf("expected len %d, actually %d", len(expected), ql.Len())
+	}
+	if len(expected) == 0 {
+		return
+	}
+	actual := toStrings(ql.Range(0, ql.Len()))
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("index %d: expected %s, actually %s", i, expected[i], actual[i])
+		}
+	}
+	ql.ForEach(func(i int, val []byte) bool {
+		if string(val) != expected[i] {
+			t.Fatalf("foreach index %d: expected %s, actually %s", i, expected[i], string(val))
+		}
+		return true
+	})
+}
+
+// 随机操作，与切片的结果对比
+func TestQuickListRandomOps(t *testing.T) {
+	ql := NewQuickList()
+	var expected []string
+	for i := 0; i < 20000; i++ {
+		val := strconv.Itoa(rand.Intn(50))
+		switch op := rand.Intn(10); {
+		case op < 3:
+			ql.PushBack([]byte(val))
+			expected = append(expected, val)
+		case op < 5:
+			ql.PushFront([]byte(val))
+			expected = append([]string{val}, expected...)
+		case op < 6:
+			index := rand.Intn(len(expected) + 1)
+			ql.Insert(index, []byte(val))
+			expected = append(expected[:index], append([]string{val}, expected[index:]...)...)
+		case op < 7 && len(expected) > 0:
+			index := rand.Intn(len(expected))
+			ql.Remove(index)
+			expected = append(expected[:index], expected[index+1:]...)
+		case op < 8 && len(expected) > 0:
+			ql.RemoveLast()
+			expected = expected[:len(expected)-1]
+		case op < 9 && len(expected) > 0:
+			index := rand.Intn(len(expected))
+			ql.Set(index, []byte(val))
+			expected[index] = val
+			if string(ql.Get(index)) != val {
+				t.Fatalf("get after set failed")
+			}
+		}
+	}
+	assertEqual(t, ql, expected)
+}
+
+func TestQuickListRemoveByVal(t *testing.T) {
+	ql := NewQuickList()
+	var expected []string
+	for i := 0; i < 5000; i++ {
+		val := strconv.Itoa(i % 3)
+		ql.PushBack([]byte(val))
+		expected = append(expected, val)
+	}
+	isZero := func(val []byte) bool { return string(val) == "0" }
+	removed := ql.RemoveByVal(isZero, 10)
+	if removed != 10 {
+		t.Fatalf("expected 10 removed, actually %d", removed)
+	}
+	count := 0
+	var tmp []string
+	for _, v := range expected {
+		if v == "0" && count < 10 {
+			count++
+			continue
+		}
+		tmp = append(tmp, v)
+	}
+	expected = tmp
+	assertEqual(t, ql, expected)
+
+	isOne := func(val []byte) bool { return string(val) == "1" }
+	removed = ql.ReverseRemoveByVal(isOne, 10)
+	if removed != 10 {
+		t.Fatalf("expected 10 removed, actually %d", removed)
+	}
+	count = 0
+	tmp = nil
+	for i := len(expected) - 1; i >= 0; i-- {
+		if expected[i] == "1" && count < 10 {
+			count++
+			continue
+		}
+		tmp = append([]string{expected[i]}, tmp...)
+	}
+	expected = tmp
+	assertEqual(t, ql, expected)
+
+	ql.RemoveAllByVal(isZero)
+	ql.RemoveAllByVal(isOne)
+	tmp = nil
+	for _, v := range expected {
+		if v == "2" {
+			tmp = append(tmp, v)
+		}
+	}
+	assertEqual(t, ql, tmp)
+}
+
+func TestQuickListTrim(t *testing.T) {
+	ql := NewQuickList()
+	var expected []string
+	for i := 0; i < 5000; i++ {
+		ql.PushBack([]byte(strconv.Itoa(i)))
+		expected = append(expected, strconv.Itoa(i))
+	}
+	ql.Trim(1500, 3600)
+	assertEqual(t, ql, expected[1500:3600])
+	ql.Trim(10, 5)
+	assertEqual(t, ql, nil)
+}
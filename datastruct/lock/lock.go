@@ -0,0 +1,152 @@
+// Package lock -----------------------------
+// @file      : lock.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/1 10:30
+// -------------------------------------------
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+// Locks 分段锁，key 哈希到固定数量的读写锁上
+// 为每个 key 单独创建锁会占用大量内存，而且需要额外的锁保护锁表
+// 多个 key 加锁的时候按照锁的下标排序，避免不同的命令互相等待造成死锁
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+const prime32 = uint32(16777619)
+
+// Make 创建分段锁，tableSize 会向上取整为 2 的幂
+func Make(tableSize int) *Locks {
+	size := 1
+	for size < tableSize {
+		size <<= 1
+	}
+	table := make([]*sync.RWMutex, size)
+	for i := 0; i < size; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+// fnv32 FNV-1a 哈希
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	return hashCode & uint32(len(locks.table)-1)
+}
+
+// Lock 对单个 key 加写锁
+func (locks *Locks) Lock(key string) {
+	locks.table[locks.spread(fnv32(key))].Lock()
+}
+
+// RLock 对单个 key 加读锁
+func (locks *Locks) RLock(key string) {
+	locks.table[locks.spread(fnv32(key))].RLock()
+}
+
+// UnLock 释放单个 key 的写锁
+func (locks *Locks) UnLock(key string) {
+	locks.table[locks.spread(fnv32(key))].Unlock()
+}
+
+// RUnLock 释放单个 key 的读锁
+func (locks *Locks) RUnLock(key string) {
+	locks.table[locks.spread(fnv32(key))].RUnlock()
+}
+
+// toLockIndices 计算 key 对应的锁的下标，去重之后排序
+func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
+	indexMap := make(map[uint32]struct{})
+	for _, key := range keys {
+		indexMap[locks.spread(fnv32(key))] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if !reverse {
+			return indices[i] < indices[j]
+		}
+		return indices[i] > indices[j]
+	})
+	return indices
+}
+
+// Locks 对多个 key 加写锁
+func (locks *Locks) Locks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, false) {
+		locks.table[index].Lock()
+	}
+}
+
+// RLocks 对多个 key 加读锁
+func (locks *Locks) RLocks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, false) {
+		locks.table[index].RLock()
+	}
+}
+
+// UnLocks 释放多个 key 的写锁
+func (locks *Locks) UnLocks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, true) {
+		locks.table[index].Unlock()
+	}
+}
+
+// RUnLocks 释放多个 key 的读锁
+func (locks *Locks) RUnLocks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, true) {
+		locks.table[index].RUnlock()
+	}
+}
+
+// toRWIndices 计算读写锁的下标，同一个锁既要读又要写的时候只加写锁
+func (locks *Locks) toRWIndices(writeKeys []string, readKeys []string, reverse bool) ([]uint32, map[uint32]bool) {
+	keys := append(append([]string{}, writeKeys...), readKeys...)
+	indices := locks.toLockIndices(keys, reverse)
+	writeIndices := make(map[uint32]bool, len(writeKeys))
+	for _, key := range writeKeys {
+		writeIndices[locks.spread(fnv32(key))] = true
+	}
+	return indices, writeIndices
+}
+
+// RWLocks 对 writeKeys 加写锁，对 readKeys 加读锁
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	indices, writeIndices := locks.toRWIndices(writeKeys, readKeys, false)
+	for _, index := range indices {
+		if writeIndices[index] {
+			locks.table[index].Lock()
+		} else {
+			locks.table[index].RLock()
+		}
+	}
+}
+
+// RWUnLocks 释放 RWLocks 加的锁
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	indices, writeIndices := locks.toRWIndices(writeKeys, readKeys, true)
+	for _, index := range indices {
+		if writeIndices[index] {
+			locks.table[index].Unlock()
+		} else {
+			locks.table[index].RUnlock()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package lock
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRWLocks(t *testing.T) {
+	locks := Make(10)
+	if len(locks.table) != 16 {
+		t.Fatalf("expected 16, actually %d", len(locks.table))
+	}
+	// 同一个 key 既读又写，重复的 key 只加一次锁
+	locks.RWLocks([]string{"a", "b", "a"}, []string{"b", "c"})
+	locks.RWUnLocks([]string{"a", "b", "a"}, []string{"b", "c"})
+	locks.RLocks("a", "a", "b")
+	locks.RLocks("a", "b")
+	locks.RUnLocks("a", "b")
+	locks.RUnLocks("a", "a", "b")
+	locks.Locks("a", "b")
+	locks.UnLocks("a", "b")
+}
+
+func TestConcurrentLocks(t *testing.T) {
+	locks := Make(4)
+	keys := []string{"k1", "k2", "k3", "k4", "k5"}
+	// map 只读，每个 key 的计数器由锁保护
+	counters := make(map[string]*int)
+	for _, key := range keys {
+		counters[key] = new(int)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// 加锁的顺序不同也不会死锁
+			a, b := keys[i%len(keys)], keys[(i+2)%len(keys)]
+			for j := 0; j < 100; j++ {
+				locks.Locks(a, b)
+				*counters[a]++
+				*counters[b]++
+				locks.UnLocks(a, b)
+				locks.RWLocks([]string{b}, []string{a})
+				*counters[b]++
+				locks.RWUnLocks([]string{b}, []string{a})
+			}
+		}(i)
+	}
+	wg.Wait()
+	total := 0
+	for _, count := range counters {
+		total += *count
+	}
+	if total != 50*100*3 {
+		t.Fatalf("expected %d, actually %d", 50*100*3, total)
+	}
+}
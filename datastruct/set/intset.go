@@ -0,0 +1,58 @@
+// Package set -----------------------------
+// @file      : intset.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/23 10:15
+// -------------------------------------------
+package set
+
+import "sort"
+
+// intSet 参考 Redis 的 intset：有序的整数数组，通过二分查找定位元素
+// 全是整数的小集合用它存储比哈希表省内存得多
+type intSet struct {
+	contents []int64
+}
+
+func makeIntSet() *intSet {
+	return &intSet{
+		contents: make([]int64, 0),
+	}
+}
+
+// search 返回 value 应该在的位置，以及是否已经存在
+func (is *intSet) search(value int64) (int, bool) {
+	i := sort.Search(len(is.contents), func(i int) bool {
+		return is.contents[i] >= value
+	})
+	return i, i < len(is.contents) && is.contents[i] == value
+}
+
+func (is *intSet) add(value int64) int {
+	i, exists := is.search(value)
+	if exists {
+		return 0
+	}
+	is.contents = append(is.contents, 0)
+	copy(is.contents[i+1:], is.contents[i:])
+	is.contents[i] = value
+	return 1
+}
+
+func (is *intSet) remove(value int64) int {
+	i, exists := is.search(value)
+	if !exists {
+		return 0
+	}
+	is.contents = append(is.contents[:i], is.contents[i+1:]...)
+	return 1
+}
+
+func (is *intSet) has(value int64) bool {
+	_, exists := is.search(value)
+	return exists
+}
+
+func (is *intSet) len() int {
+	return len(is.contents)
+}
@@ -0,0 +1,249 @@
+// Package set -----------------------------
+// @file      : set.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/23 10:40
+// -------------------------------------------
+package set
+
+import (
+	"math/rand"
+	"redis-go/datastruct/dict"
+	"strconv"
+)
+
+// 参考 Redis 的 set-max-intset-entries，超过后转换为哈希表
+const maxIntsetEntries = 512
+
+// 哈希表编码的 shard 数量，SSCAN 按照 shard 的下标作为游标
+const hashtableShards = 128
+
+// 编码方式，与 OBJECT ENCODING 的结果一致
+const (
+	EncodingIntset    = "intset"
+	EncodingHashtable = "hashtable"
+)
+
+// Consumer 遍历集合中的元素，返回 false 停止遍历
+type Consumer func(member string) bool
+
+// Set 集合类型的值
+// 元素全部是整数并且数量较少时使用 intset 编码，否则使用分段的 dict.ConcurrentDict，以便 SSCAN 分批遍历
+type Set struct {
+	intset *intSet
+	dict   dict.Dict
+}
+
+// Make 创建集合，members 为初始的元素
+func Make(members ...string) *Set {
+	set := &Set{
+		intset: makeIntSet(),
+	}
+	for _, member := range members {
+		set.Add(member)
+	}
+	return set
+}
+
+// toInt64 判断元素是否可以用整数表示，"01"、"+1" 这种转换后会变样的不算
+func toInt64(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > 20 {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(member, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, strconv.FormatInt(value, 10) == member
+}
+
+// Encoding 返回当前的编码方式
+func (set *Set) Encoding() string {
+	if set.intset != nil {
+		return EncodingIntset
+	}
+	return EncodingHashtable
+}
+
+// convert intset 转换为哈希表，只会转换一次
+func (set *Set) convert() {
+	d := dict.MakeConcurrent(hashtableShards)
+	for _, value := range set.intset.contents {
+		d.Put(strconv.FormatInt(value, 10), nil)
+	}
+	set.dict = d
+	set.intset = nil
+}
+
+// Add 添加元素，返回新增的数量
+func (set *Set) Add(member string) int {
+	if set.intset != nil {
+		if value, ok := toInt64(member); ok {
+			result := set.intset.add(value)
+			if set.intset.len() > maxIntsetEntries {
+				set.convert()
+			}
+			return result
+		}
+		set.convert()
+	}
+	return set.dict.Put(member, nil)
+}
+
+// Remove 删除元素，返回删除的数量
+func (set *Set) Remove(member string) int {
+	if set.intset != nil {
+		value, ok := toInt64(member)
+		if !ok {
+			return 0
+		}
+		return set.intset.remove(value)
+	}
+	return set.dict.Remove(member)
+}
+
+// Has 判断元素是否存在
+func (set *Set) Has(member string) bool {
+	if set.intset != nil {
+		value, ok := toInt64(member)
+		if !ok {
+			return false
+		}
+		return set.intset.has(value)
+	}
+	_, exists := set.dict.Get(member)
+	return exists
+}
+
+func (set *Set) Len() int {
+	if set.intset != nil {
+		return set.intset.len()
+	}
+	return set.dict.Len()
+}
+
+// ForEach 遍历所有元素，intset 编码的集合按照从小到大的顺序遍历
+func (set *Set) ForEach(consumer Consumer) {
+	if set.intset != nil {
+		for _, value := range set.intset.contents {
+			if !consumer(strconv.FormatInt(value, 10)) {
+				break
+			}
+		}
+		return
+	}
+	set.dict.ForEach(func(key string, val interface{}) bool {
+		return consumer(key)
+	})
+}
+
+// Scan 从 cursor 开始遍历大约 count 个元素，返回下一次遍历的游标，遍历结束时返回 0
+// 和 Redis 一样，intset 编码一次遍历完，哈希表编码按照 shard 的下标分批遍历
+func (set *Set) Scan(cursor int, count int, consumer Consumer) int {
+	if set.dict != nil {
+		return set.dict.Scan(cursor, count, func(key string, val interface{}) bool {
+			return consumer(key)
+		})
+	}
+	set.ForEach(consumer)
+	return 0
+}
+
+// Members 返回所有元素
+func (set *Set) Members() []string {
+	result := make([]string, 0, set.Len())
+	set.ForEach(func(member string) bool {
+		result = append(result, member)
+		return true
+	})
+	return result
+}
+
+// RandomMembers 随机返回 limit 个可重复的元素
+func (set *Set) RandomMembers(limit int) []string {
+	if set.intset != nil {
+		size := set.intset.len()
+		if limit <= 0 || size == 0 {
+			return []string{}
+		}
+		result := make([]string, limit)
+		for i := range result {
+			result[i] = strconv.FormatInt(set.intset.contents[rand.Intn(size)], 10)
+		}
+		return result
+	}
+	return set.dict.RandomKeys(limit)
+}
+
+// RandomDistinctMembers 随机返回 limit 个不重复的元素
+func (set *Set) RandomDistinctMembers(limit int) []string {
+	if set.intset != nil {
+		size := set.intset.len()
+		if limit > size {
+			limit = size
+		}
+		if limit <= 0 {
+			return []string{}
+		}
+		result := make([]string, limit)
+		for i, j := range rand.Perm(size)[:limit] {
+			result[i] = strconv.FormatInt(set.intset.contents[j], 10)
+		}
+		return result
+	}
+	return set.dict.RandomDistinctKeys(limit)
+}
+
+// Intersect 求交集，从最小的集合开始遍历
+func Intersect(sets ...*Set) *Set {
+	result := Make()
+	if len(sets) == 0 {
+		return result
+	}
+	smallest := 0
+	for i, set := range sets {
+		if set.Len() < sets[smallest].Len() {
+			smallest = i
+		}
+	}
+	sets[smallest].ForEach(func(member string) bool {
+		for i, set := range sets {
+			if i != smallest && !set.Has(member) {
+				return true
+			}
+		}
+		result.Add(member)
+		return true
+	})
+	return result
+}
+
+// Union 求并集
+func Union(sets ...*Set) *Set {
+	result := Make()
+	for _, set := range sets {
+		set.ForEach(func(member string) bool {
+			result.Add(member)
+			return true
+		})
+	}
+	return result
+}
+
+// Diff 求差集，第一个集合减去其余的集合
+func Diff(sets ...*Set) *Set {
+	result := Make()
+	if len(sets) == 0 {
+		return result
+	}
+	sets[0].ForEach(func(member string) bool {
+		for _, set := range sets[1:] {
+			if set.Has(member) {
+				return true
+			}
+		}
+		result.Add(member)
+		return true
+	})
+	return result
+}
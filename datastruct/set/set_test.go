@@ -0,0 +1,63 @@
+package set
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSetEncoding(t *testing.T) {
+	s := Make()
+	for i := maxIntsetEntries; i > 0; i-- {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != EncodingIntset {
+		t.Fatalf("expected intset, actually %s", s.Encoding())
+	}
+	// intset 是有序的
+	members := s.Members()
+	for i, member := range members {
+		if member != strconv.Itoa(i+1) {
+			t.Fatalf("expected %d, actually %s", i+1, member)
+		}
+	}
+	// "01" 不是规范的整数
+	if s.Has("01") {
+		t.Fatal("01 should not be a member")
+	}
+	s.Add("01")
+	if s.Encoding() != EncodingHashtable {
+		t.Fatalf("expected hashtable, actually %s", s.Encoding())
+	}
+	if s.Len() != maxIntsetEntries+1 || !s.Has("1") || !s.Has("01") {
+		t.Fatal("members lost after convert")
+	}
+
+	s = Make()
+	for i := 0; i <= maxIntsetEntries; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != EncodingHashtable {
+		t.Fatalf("expected hashtable, actually %s", s.Encoding())
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	a := Make("1", "2", "3", "a")
+	b := Make("2", "3", "4", "a")
+	c := Make("3", "a", "b")
+	if inter := Intersect(a, b, c); inter.Len() != 2 || !inter.Has("3") || !inter.Has("a") {
+		t.Fatalf("wrong intersect %v", inter.Members())
+	}
+	if union := Union(a, b, c); union.Len() != 6 {
+		t.Fatalf("wrong union %v", union.Members())
+	}
+	if diff := Diff(a, b); diff.Len() != 1 || !diff.Has("1") {
+		t.Fatalf("wrong diff %v", diff.Members())
+	}
+	if members := a.RandomDistinctMembers(10); len(members) != 4 {
+		t.Fatalf("expected 4 members, actually %d", len(members))
+	}
+	if members := a.RandomMembers(10); len(members) != 10 {
+		t.Fatalf("expected 10 members, actually %d", len(members))
+	}
+}
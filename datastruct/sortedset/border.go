@@ -0,0 +1,172 @@
+// Package sortedset -----------------------------
+// @file      : border.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/24 11:30
+// -------------------------------------------
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Border 范围查询的边界
+// ZRANGEBYSCORE 的 (1 5 和 ZRANGEBYLEX 的 [a (c 都是 Border
+type Border interface {
+	// satisfyMin 作为下界时，元素是否满足
+	satisfyMin(e *Element) bool
+	// satisfyMax 作为上界时，元素是否满足
+	satisfyMax(e *Element) bool
+	// emptyWith 以自己为下界、max 为上界的区间是否为空
+	emptyWith(max Border) bool
+}
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// ScoreBorder 分数的边界，如 -inf、(1.5、3
+// ±inf 直接用 math.Inf 表示，这样 [+inf 也能匹配分数为 +inf 的元素
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool
+}
+
+var (
+	// NegativeInfScoreBorder -inf
+	NegativeInfScoreBorder = &ScoreBorder{Value: math.Inf(-1)}
+	// PositiveInfScoreBorder +inf
+	PositiveInfScoreBorder = &ScoreBorder{Value: math.Inf(1)}
+)
+
+func (border *ScoreBorder) satisfyMin(e *Element) bool {
+	if border.Exclude {
+		return e.Score > border.Value
+	}
+	return e.Score >= border.Value
+}
+
+func (border *ScoreBorder) satisfyMax(e *Element) bool {
+	if border.Exclude {
+		return e.Score < border.Value
+	}
+	return e.Score <= border.Value
+}
+
+func (border *ScoreBorder) emptyWith(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+// ParseScoreBorder 解析分数的边界，( 开头表示不包含
+func ParseScoreBorder(s string) (*ScoreBorder, error) {
+	exclude := false
+	if len(s) > 0 && s[0] == '(' {
+		exclude = true
+		s = s[1:]
+	}
+	value, err := ParseScore(s)
+	if err != nil {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	return &ScoreBorder{
+		Value:   value,
+		Exclude: exclude,
+	}, nil
+}
+
+// ParseScore 解析分数，不允许 NaN
+func ParseScore(s string) (float64, error) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		// 超出范围的时候 ParseFloat 返回 ±Inf 和 ErrRange，和 Redis 一样视为非法
+		return 0, errors.New("ERR value is not a valid float")
+	}
+	if math.IsNaN(value) {
+		return 0, errors.New("ERR value is not a valid float")
+	}
+	return value, nil
+}
+
+// LexBorder 字典序的边界，如 -、+、[a、(b
+// 只有在所有元素分数相同时字典序的范围查询才有意义
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+var (
+	// NegativeInfLexBorder -
+	NegativeInfLexBorder = &LexBorder{Inf: negativeInf}
+	// PositiveInfLexBorder +
+	PositiveInfLexBorder = &LexBorder{Inf: positiveInf}
+)
+
+func (border *LexBorder) satisfyMin(e *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return e.Member > border.Value
+	}
+	return e.Member >= border.Value
+}
+
+func (border *LexBorder) satisfyMax(e *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return e.Member < border.Value
+	}
+	return e.Member <= border.Value
+}
+
+func (border *LexBorder) emptyWith(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+// ParseLexBorder 解析字典序的边界，必须以 - + [ ( 开头
+func ParseLexBorder(s string) (*LexBorder, error) {
+	if s == "-" {
+		return NegativeInfLexBorder, nil
+	}
+	if s == "+" {
+		return PositiveInfLexBorder, nil
+	}
+	if strings.HasPrefix(s, "(") {
+		return &LexBorder{Value: s[1:], Exclude: true}, nil
+	}
+	if strings.HasPrefix(s, "[") {
+		return &LexBorder{Value: s[1:], Exclude: false}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
+
+func isEmptyRange(min Border, max Border) bool {
+	return min.emptyWith(max)
+}
@@ -0,0 +1,321 @@
+// Package sortedset -----------------------------
+// @file      : skiplist.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/24 10:20
+// -------------------------------------------
+package sortedset
+
+import "math/rand"
+
+// 参考 Redis 的 zskiplist
+const (
+	maxLevel = 32
+	// 每一层晋升到上一层的概率
+	levelProbability = 0.25
+)
+
+// Element 有序集合中的元素
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// Level 节点在某一层的信息
+type Level struct {
+	// 同一层的下一个节点
+	forward *node
+	// 到下一个节点跨越的元素个数，用于计算排名
+	span int64
+}
+
+type node struct {
+	Element
+	// 第 0 层的上一个节点，用于逆序遍历
+	backward *node
+	level    []*Level
+}
+
+type skiplist struct {
+	header *node
+	tail   *node
+	length int64
+	// 当前的最高层数
+	level int16
+}
+
+func makeNode(level int16, score float64, member string) *node {
+	n := &node{
+		Element: Element{
+			Score:  score,
+			Member: member,
+		},
+		level: make([]*Level, level),
+	}
+	for i := range n.level {
+		n.level[i] = new(Level)
+	}
+	return n
+}
+
+func makeSkiplist() *skiplist {
+	return &skiplist{
+		level:  1,
+		header: makeNode(maxLevel, 0, ""),
+	}
+}
+
+// randomLevel 层数越高的概率越小
+func randomLevel() int16 {
+	level := int16(1)
+	for level < maxLevel && rand.Float64() < levelProbability {
+		level++
+	}
+	return level
+}
+
+// less 元素按照 score 升序排列，score 相同时按照 member 的字典序排列
+func less(score float64, member string, e *Element) bool {
+	return e.Score < score || (e.Score == score && e.Member < member)
+}
+
+func (sl *skiplist) insert(member string, score float64) *node {
+	// 每一层需要修改的节点
+	update := make([]*node, maxLevel)
+	// 每一层 update 节点的排名
+	rank := make([]int64, maxLevel)
+
+	n := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i == sl.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1]
+		}
+		for n.level[i].forward != nil && less(score, member, &n.level[i].forward.Element) {
+			rank[i] += n.level[i].span
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+
+	level := randomLevel()
+	// 新增的层由头节点指向新节点
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	n = makeNode(level, score, member)
+	for i := int16(0); i < level; i++ {
+		n.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = n
+		// 重新计算跨度
+		n.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	// 没有指向新节点的更高层，跨度加一
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] == sl.header {
+		n.backward = nil
+	} else {
+		n.backward = update[0]
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n
+	} else {
+		sl.tail = n
+	}
+	sl.length++
+	return n
+}
+
+// removeNode 删除节点，update 为每一层指向该节点之前的节点
+func (sl *skiplist) removeNode(n *node, update []*node) {
+	for i := int16(0); i < sl.level; i++ {
+		if update[i].level[i].forward == n {
+			update[i].level[i].span += n.level[i].span - 1
+			update[i].level[i].forward = n.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n.backward
+	} else {
+		sl.tail = n.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+func (sl *skiplist) remove(member string, score float64) bool {
+	update := make([]*node, maxLevel)
+	n := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && less(score, member, &n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+	n = n.level[0].forward
+	if n != nil && score == n.Score && n.Member == member {
+		sl.removeNode(n, update)
+		return true
+	}
+	return false
+}
+
+// getRank 返回元素的排名，从 1 开始，不存在返回 0
+func (sl *skiplist) getRank(member string, score float64) int64 {
+	var rank int64 = 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.Score < score ||
+				(x.level[i].forward.Score == score && x.level[i].forward.Member <= member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getByRank 根据排名找到节点，排名从 1 开始
+func (sl *skiplist) getByRank(rank int64) *node {
+	var i int64 = 0
+	n := sl.header
+	for level := sl.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) <= rank {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		if i == rank {
+			return n
+		}
+	}
+	return nil
+}
+
+// hasInRange 判断 [min, max] 之间是否有元素
+func (sl *skiplist) hasInRange(min Border, max Border) bool {
+	if isEmptyRange(min, max) {
+		return false
+	}
+	// 最大的元素都小于下界
+	n := sl.tail
+	if n == nil || !min.satisfyMin(&n.Element) {
+		return false
+	}
+	// 最小的元素都大于上界
+	n = sl.header.level[0].forward
+	if n == nil || !max.satisfyMax(&n.Element) {
+		return false
+	}
+	return true
+}
+
+// getFirstInRange 找到 [min, max] 之间的第一个节点
+func (sl *skiplist) getFirstInRange(min Border, max Border) *node {
+	if !sl.hasInRange(min, max) {
+		return nil
+	}
+	n := sl.header
+	for level := sl.level - 1; level >= 0; level-- {
+		// 跳过不满足下界的节点
+		for n.level[level].forward != nil && !min.satisfyMin(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	n = n.level[0].forward
+	if !max.satisfyMax(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// getLastInRange 找到 [min, max] 之间的最后一个节点
+func (sl *skiplist) getLastInRange(min Border, max Border) *node {
+	if !sl.hasInRange(min, max) {
+		return nil
+	}
+	n := sl.header
+	for level := sl.level - 1; level >= 0; level-- {
+		// 前进到最后一个满足上界的节点
+		for n.level[level].forward != nil && max.satisfyMax(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	if n == sl.header || !min.satisfyMin(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// removeRange 删除 [min, max] 之间的元素，limit 为 0 表示不限制数量
+func (sl *skiplist) removeRange(min Border, max Border, limit int) (removed []*Element) {
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+	n := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && !min.satisfyMin(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+	n = n.level[0].forward
+	for n != nil {
+		if !max.satisfyMax(&n.Element) {
+			break
+		}
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		sl.removeNode(n, update)
+		if limit > 0 && len(removed) == limit {
+			break
+		}
+		n = next
+	}
+	return removed
+}
+
+// removeRangeByRank 删除排名在 [start, stop) 之间的元素，排名从 1 开始
+func (sl *skiplist) removeRangeByRank(start int64, stop int64) (removed []*Element) {
+	var i int64 = 0
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+
+	n := sl.header
+	for level := sl.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) < start {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		update[level] = n
+	}
+
+	i++
+	n = n.level[0].forward
+	for n != nil && i < stop {
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		sl.removeNode(n, update)
+		n = next
+		i++
+	}
+	return removed
+}
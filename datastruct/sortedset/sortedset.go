@@ -0,0 +1,298 @@
+// Package sortedset -----------------------------
+// @file      : sortedset.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/24 14:05
+// -------------------------------------------
+package sortedset
+
+import (
+	"redis-go/datastruct/dict"
+	"strconv"
+)
+
+const (
+	// 参考 Redis 的 zset-max-listpack-entries，元素超过这个数量时字典转换为分段的 dict.ConcurrentDict
+	maxListpackEntries = 128
+	// 分段字典的 shard 数量，ZSCAN 按照 shard 的下标作为游标
+	hashtableShards = 128
+)
+
+// Consumer 遍历元素，返回 false 停止遍历
+type Consumer func(element *Element) bool
+
+// SortedSet 有序集合
+// 跳表按照分数排序，支持 O(logN) 的排名和范围查询；字典支持 O(1) 地根据 member 找到分数
+type SortedSet struct {
+	dict     dict.Dict
+	skiplist *skiplist
+}
+
+// Make 创建有序集合
+func Make() *SortedSet {
+	return &SortedSet{
+		dict:     dict.MakeSimpleDict(),
+		skiplist: makeSkiplist(),
+	}
+}
+
+// getElement 从字典中找到元素
+func (sortedSet *SortedSet) getElement(member string) (*Element, bool) {
+	raw, ok := sortedSet.dict.Get(member)
+	if !ok {
+		return nil, false
+	}
+	return raw.(*Element), true
+}
+
+// convert 元素较多时转换为分段的字典，以便 ZSCAN 分批遍历，只会转换一次
+func (sortedSet *SortedSet) convert() {
+	d := dict.MakeConcurrent(hashtableShards)
+	sortedSet.dict.ForEach(func(key string, val interface{}) bool {
+		d.Put(key, val)
+		return true
+	})
+	sortedSet.dict = d
+}
+
+// Add 添加或者更新元素，返回是否为新增的元素
+func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	element, ok := sortedSet.getElement(member)
+	sortedSet.dict.Put(member, &Element{
+		Member: member,
+		Score:  score,
+	})
+	if ok {
+		if score != element.Score {
+			sortedSet.skiplist.remove(member, element.Score)
+			sortedSet.skiplist.insert(member, score)
+		}
+		return false
+	}
+	sortedSet.skiplist.insert(member, score)
+	if _, simple := sortedSet.dict.(*dict.SimpleDict); simple && sortedSet.dict.Len() > maxListpackEntries {
+		sortedSet.convert()
+	}
+	return true
+}
+
+// Len 返回元素个数
+func (sortedSet *SortedSet) Len() int64 {
+	return int64(sortedSet.dict.Len())
+}
+
+// Get 根据 member 找到元素
+func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
+	return sortedSet.getElement(member)
+}
+
+// Remove 删除元素，返回是否删除成功
+func (sortedSet *SortedSet) Remove(member string) bool {
+	v, ok := sortedSet.getElement(member)
+	if ok {
+		sortedSet.skiplist.remove(member, v.Score)
+		sortedSet.dict.Remove(member)
+		return true
+	}
+	return false
+}
+
+// Scan 从 cursor 开始遍历大约 count 个元素，返回下一次遍历的游标，遍历结束时返回 0
+// 和 Redis 一样，元素较少时按照分数一次遍历完，否则按照 shard 的下标分批遍历，遍历期间一直存在的元素一定会被访问到
+func (sortedSet *SortedSet) Scan(cursor int, count int, consumer Consumer) int {
+	if _, simple := sortedSet.dict.(*dict.SimpleDict); simple {
+		if sortedSet.Len() > 0 {
+			sortedSet.ForEachByRank(0, sortedSet.Len(), false, consumer)
+		}
+		return 0
+	}
+	return sortedSet.dict.Scan(cursor, count, func(key string, val interface{}) bool {
+		return consumer(val.(*Element))
+	})
+}
+
+// GetRank 返回元素的排名，从 0 开始，desc 表示从大到小，不存在返回 -1
+func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	element, ok := sortedSet.getElement(member)
+	if !ok {
+		return -1
+	}
+	r := sortedSet.skiplist.getRank(member, element.Score)
+	if desc {
+		r = sortedSet.skiplist.length - r
+	} else {
+		r--
+	}
+	return r
+}
+
+// ForEachByRank 遍历排名在 [start, stop) 之间的元素，排名从 0 开始
+func (sortedSet *SortedSet) ForEachByRank(start int64, stop int64, desc bool, consumer Consumer) {
+	size := sortedSet.Len()
+	if start < 0 || start >= size {
+		panic("illegal start " + strconv.FormatInt(start, 10))
+	}
+	if stop < start || stop > size {
+		panic("illegal end " + strconv.FormatInt(stop, 10))
+	}
+
+	// 找到起始的节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.tail
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(size - start)
+		}
+	} else {
+		n = sortedSet.skiplist.header.level[0].forward
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(start + 1)
+		}
+	}
+
+	sliceSize := int(stop - start)
+	for i := 0; i < sliceSize; i++ {
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// RangeByRank 返回排名在 [start, stop) 之间的元素，排名从 0 开始
+func (sortedSet *SortedSet) RangeByRank(start int64, stop int64, desc bool) []*Element {
+	sliceSize := int(stop - start)
+	slice := make([]*Element, sliceSize)
+	i := 0
+	sortedSet.ForEachByRank(start, stop, desc, func(element *Element) bool {
+		slice[i] = element
+		i++
+		return true
+	})
+	return slice
+}
+
+// RangeCount 返回 [min, max] 之间的元素个数
+func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
+	first := sortedSet.skiplist.getFirstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := sortedSet.skiplist.getLastInRange(min, max)
+	if last == nil {
+		return 0
+	}
+	firstRank := sortedSet.skiplist.getRank(first.Member, first.Score)
+	lastRank := sortedSet.skiplist.getRank(last.Member, last.Score)
+	return lastRank - firstRank + 1
+}
+
+// ForEach 遍历 [min, max] 之间的元素，跳过 offset 个，最多 limit 个，limit 小于 0 表示不限制
+func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer Consumer) {
+	// 找到起始的节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.getLastInRange(min, max)
+	} else {
+		n = sortedSet.skiplist.getFirstInRange(min, max)
+	}
+
+	for n != nil && offset > 0 {
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+		offset--
+	}
+
+	for i := 0; (i < int(limit) || limit < 0) && n != nil; i++ {
+		if desc {
+			if !min.satisfyMin(&n.Element) {
+				break
+			}
+		} else {
+			if !max.satisfyMax(&n.Element) {
+				break
+			}
+		}
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// Range 返回 [min, max] 之间的元素，跳过 offset 个，最多 limit 个，limit 小于 0 表示不限制
+func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
+	if limit == 0 || offset < 0 {
+		return make([]*Element, 0)
+	}
+	slice := make([]*Element, 0)
+	sortedSet.ForEach(min, max, offset, limit, desc, func(element *Element) bool {
+		slice = append(slice, element)
+		return true
+	})
+	return slice
+}
+
+// RemoveRange 删除 [min, max] 之间的元素，返回删除的个数
+func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
+	removed := sortedSet.skiplist.removeRange(min, max, 0)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return int64(len(removed))
+}
+
+// RemoveByRank 删除排名在 [start, stop) 之间的元素，排名从 0 开始
+func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	removed := sortedSet.skiplist.removeRangeByRank(start+1, stop+1)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return int64(len(removed))
+}
+
+// PopMin 弹出分数最小的 count 个元素
+func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	first := sortedSet.skiplist.header.level[0].forward
+	if first == nil || count <= 0 {
+		return make([]*Element, 0)
+	}
+	border := &ScoreBorder{
+		Value:   first.Score,
+		Exclude: false,
+	}
+	removed := sortedSet.skiplist.removeRange(border, PositiveInfScoreBorder, count)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// PopMax 弹出分数最大的 count 个元素
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	if count <= 0 {
+		return make([]*Element, 0)
+	}
+	if int64(count) > sortedSet.Len() {
+		count = int(sortedSet.Len())
+	}
+	removed := make([]*Element, 0, count)
+	for i := 0; i < count; i++ {
+		last := sortedSet.skiplist.tail
+		element := last.Element
+		sortedSet.Remove(last.Member)
+		removed = append(removed, &element)
+	}
+	return removed
+}
@@ -0,0 +1,96 @@
+package sortedset
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestRandomOps(t *testing.T) {
+	z := Make()
+	expected := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := strconv.Itoa(rand.Intn(500))
+		if rand.Intn(3) == 0 {
+			if z.Remove(member) != (expected[member] != 0) {
+				t.Fatalf("remove %s mismatch", member)
+			}
+			delete(expected, member)
+			continue
+		}
+		// 分数从 1 开始，0 表示不存在
+		score := float64(rand.Intn(100) + 1)
+		z.Add(member, score)
+		expected[member] = score
+	}
+	if z.Len() != int64(len(expected)) {
+		t.Fatalf("expected len %d, actually %d", len(expected), z.Len())
+	}
+
+	members := make([]string, 0, len(expected))
+	for member := range expected {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := expected[members[i]], expected[members[j]]
+		return a < b || (a == b && members[i] < members[j])
+	})
+	elements := z.RangeByRank(0, z.Len(), false)
+	for i, element := range elements {
+		if element.Member != members[i] || element.Score != expected[members[i]] {
+			t.Fatalf("rank %d: expected %s, actually %s", i, members[i], element.Member)
+		}
+		if rank := z.GetRank(element.Member, false); rank != int64(i) {
+			t.Fatalf("expected rank %d, actually %d", i, rank)
+		}
+		if rank := z.GetRank(element.Member, true); rank != z.Len()-1-int64(i) {
+			t.Fatalf("expected rev rank %d, actually %d", z.Len()-1-int64(i), rank)
+		}
+	}
+}
+
+func TestRangeByBorder(t *testing.T) {
+	z := Make()
+	for i := 1; i <= 10; i++ {
+		z.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	min, _ := ParseScoreBorder("(3")
+	max, _ := ParseScoreBorder("7")
+	if count := z.RangeCount(min, max); count != 4 {
+		t.Fatalf("expected 4, actually %d", count)
+	}
+	elements := z.Range(min, max, 1, 2, true)
+	if len(elements) != 2 || elements[0].Member != "m6" || elements[1].Member != "m5" {
+		t.Fatalf("unexpected range result %v", elements)
+	}
+	if removed := z.RemoveRange(min, max); removed != 4 {
+		t.Fatalf("expected 4 removed, actually %d", removed)
+	}
+	if z.Len() != 6 {
+		t.Fatalf("expected len 6, actually %d", z.Len())
+	}
+
+	popped := z.PopMin(2)
+	if len(popped) != 2 || popped[0].Member != "m1" || popped[1].Member != "m2" {
+		t.Fatalf("unexpected pop min result %v", popped)
+	}
+	popped = z.PopMax(1)
+	if len(popped) != 1 || popped[0].Member != "m10" {
+		t.Fatalf("unexpected pop max result %v", popped)
+	}
+
+	// 字典序的范围查询，分数都相同
+	z = Make()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		z.Add(member, 0)
+	}
+	lexMin, _ := ParseLexBorder("(a")
+	lexMax, _ := ParseLexBorder("[c")
+	if count := z.RangeCount(lexMin, lexMax); count != 2 {
+		t.Fatalf("expected 2, actually %d", count)
+	}
+	if _, err := ParseLexBorder("a"); err == nil {
+		t.Fatal("expected error for lex border without prefix")
+	}
+}
@@ -0,0 +1,265 @@
+// Package stream -----------------------------
+// @file      : group.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/26 14:20
+// -------------------------------------------
+package stream
+
+import (
+	"sort"
+	"time"
+)
+
+// InvalidEntriesRead 消费组已读数量未知
+const InvalidEntriesRead int64 = -1
+
+// PendingEntry 已经投递但还没有确认的消息
+type PendingEntry struct {
+	ID            ID
+	Consumer      *Consumer
+	DeliveryTime  time.Time
+	DeliveryCount int64
+}
+
+// Consumer 消费组中的消费者
+type Consumer struct {
+	Name string
+	// 最后一次尝试交互的时间
+	SeenTime time.Time
+	// 最后一次成功读取或者认领消息的时间，零值表示从来没有过
+	ActiveTime time.Time
+	pending    map[ID]*PendingEntry
+}
+
+// PendingLen 返回消费者待确认的消息数量
+func (c *Consumer) PendingLen() int {
+	return len(c.pending)
+}
+
+// Pending 返回消费者待确认的消息，按 ID 从小到大排序
+func (c *Consumer) Pending() []*PendingEntry {
+	result := make([]*PendingEntry, 0, len(c.pending))
+	for _, pe := range c.pending {
+		result = append(result, pe)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID.Less(result[j].ID)
+	})
+	return result
+}
+
+// Group 消费组
+type Group struct {
+	Name string
+	// 最后投递的消息 ID
+	LastID ID
+	// 已经读取的消息数量，用于计算 lag
+	EntriesRead int64
+	// 待确认列表（PEL），按 ID 有序
+	pel       []*PendingEntry
+	consumers map[string]*Consumer
+}
+
+// CreateGroup 创建消费组，已经存在时返回 false
+func (s *Stream) CreateGroup(name string, lastID ID, entriesRead int64) (*Group, bool) {
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	group := &Group{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		pel:         make([]*PendingEntry, 0),
+		consumers:   make(map[string]*Consumer),
+	}
+	s.groups[name] = group
+	return group, true
+}
+
+// GetGroup 返回消费组，不存在时返回 nil
+func (s *Stream) GetGroup(name string) *Group {
+	return s.groups[name]
+}
+
+// DestroyGroup 删除消费组
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups 返回所有的消费组，按名称排序
+func (s *Stream) Groups() []*Group {
+	result := make([]*Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// GroupCount 返回消费组的数量
+func (s *Stream) GroupCount() int {
+	return len(s.groups)
+}
+
+// AdvanceGroup 消费组读取了新消息 id 之后更新最后投递的 ID 和已读数量
+// 参考 Redis 的 streamReplyWithRange
+func (s *Stream) AdvanceGroup(g *Group, id ID) {
+	if !g.LastID.Less(id) {
+		return
+	}
+	if g.EntriesRead != InvalidEntriesRead && !s.RangeHasTombstones(id, MaxID) {
+		// 计数有效且后面没有被删除的消息，直接加一
+		g.EntriesRead++
+	} else if s.entriesAdded > 0 {
+		g.EntriesRead = s.EstimateDistanceFromFirstEverEntry(id)
+	}
+	g.LastID = id
+}
+
+// Lag 返回消费组还没有读取的消息数量，无法计算时 ok 为 false
+// 参考 Redis 的 streamReplyWithCGLag
+func (s *Stream) Lag(g *Group) (lag int64, ok bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.EntriesRead != InvalidEntriesRead && !s.RangeHasTombstones(g.LastID, MaxID) {
+		return s.entriesAdded - g.EntriesRead, true
+	}
+	entriesRead := s.EstimateDistanceFromFirstEverEntry(g.LastID)
+	if entriesRead == InvalidEntriesRead {
+		return 0, false
+	}
+	return s.entriesAdded - entriesRead, true
+}
+
+// GetConsumer 返回消费者，不存在时返回 nil
+func (g *Group) GetConsumer(name string) *Consumer {
+	return g.consumers[name]
+}
+
+// CreateConsumer 创建消费者，已经存在时返回 false
+func (g *Group) CreateConsumer(name string, now time.Time) (*Consumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &Consumer{
+		Name:     name,
+		SeenTime: now,
+		pending:  make(map[ID]*PendingEntry),
+	}
+	g.consumers[name] = c
+	return c, true
+}
+
+// DeleteConsumer 删除消费者以及它所有待确认的消息，返回待确认消息的数量，消费者不存在时返回 -1
+func (g *Group) DeleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return -1
+	}
+	count := len(c.pending)
+	for id := range c.pending {
+		g.removePending(id)
+	}
+	delete(g.consumers, name)
+	return count
+}
+
+// Consumers 返回所有的消费者，按名称排序
+func (g *Group) Consumers() []*Consumer {
+	result := make([]*Consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// ConsumerCount 返回消费者的数量
+func (g *Group) ConsumerCount() int {
+	return len(g.consumers)
+}
+
+// PendingLen 返回待确认消息的数量
+func (g *Group) PendingLen() int {
+	return len(g.pel)
+}
+
+// searchPending 返回第一个 ID 大于等于 id 的待确认消息的下标
+func (g *Group) searchPending(id ID) int {
+	return sort.Search(len(g.pel), func(i int) bool {
+		return !g.pel[i].ID.Less(id)
+	})
+}
+
+// GetPending 返回待确认的消息，不存在时返回 nil
+func (g *Group) GetPending(id ID) *PendingEntry {
+	i := g.searchPending(id)
+	if i < len(g.pel) && g.pel[i].ID == id {
+		return g.pel[i]
+	}
+	return nil
+}
+
+// Deliver 把消息投递给消费者，已经在 PEL 中的消息会转移给该消费者并重置投递次数
+func (g *Group) Deliver(id ID, consumer *Consumer, now time.Time) *PendingEntry {
+	pe := g.GetPending(id)
+	if pe == nil {
+		pe = &PendingEntry{ID: id}
+		i := g.searchPending(id)
+		g.pel = append(g.pel, nil)
+		copy(g.pel[i+1:], g.pel[i:])
+		g.pel[i] = pe
+	} else {
+		delete(pe.Consumer.pending, id)
+	}
+	pe.Consumer = consumer
+	pe.DeliveryTime = now
+	pe.DeliveryCount = 1
+	consumer.pending[id] = pe
+	return pe
+}
+
+// Claim 把待确认的消息转移给消费者
+func (g *Group) Claim(pe *PendingEntry, consumer *Consumer) {
+	delete(pe.Consumer.pending, pe.ID)
+	pe.Consumer = consumer
+	consumer.pending[pe.ID] = pe
+}
+
+// Ack 确认消息，把它从 PEL 中删除
+func (g *Group) Ack(id ID) bool {
+	return g.removePending(id)
+}
+
+func (g *Group) removePending(id ID) bool {
+	i := g.searchPending(id)
+	if i == len(g.pel) || g.pel[i].ID != id {
+		return false
+	}
+	pe := g.pel[i]
+	delete(pe.Consumer.pending, id)
+	g.pel = append(g.pel[:i], g.pel[i+1:]...)
+	return true
+}
+
+// PendingRange 返回 [start, end] 之间的待确认消息，count 小于等于 0 表示不限制
+func (g *Group) PendingRange(start ID, end ID, count int) []*PendingEntry {
+	result := make([]*PendingEntry, 0)
+	for i := g.searchPending(start); i < len(g.pel); i++ {
+		if end.Less(g.pel[i].ID) || (count > 0 && len(result) >= count) {
+			break
+		}
+		result = append(result, g.pel[i])
+	}
+	return result
+}
@@ -0,0 +1,143 @@
+// Package stream -----------------------------
+// @file      : id.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/26 10:05
+// -------------------------------------------
+package stream
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ID 消息的 ID，由毫秒时间戳和同一毫秒内的序号组成，如 1526919030474-55
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinID 0-0
+	MinID = ID{}
+	// MaxID 最大的 ID
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+	// ErrInvalidID ID 的格式不正确
+	ErrInvalidID = errors.New("ERR Invalid stream ID specified as stream command argument")
+)
+
+// String 格式化为 ms-seq
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare 比较两个 ID，小于返回 -1，等于返回 0，大于返回 1
+func (id ID) Compare(other ID) int {
+	if id.Ms != other.Ms {
+		if id.Ms < other.Ms {
+			return -1
+		}
+		return 1
+	}
+	if id.Seq != other.Seq {
+		if id.Seq < other.Seq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// Less 是否小于 other
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+// IsZero 是否为 0-0
+func (id ID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Incr 返回下一个 ID，已经是最大值的时候返回 false
+func (id ID) Incr() (ID, bool) {
+	if id.Seq == math.MaxUint64 {
+		if id.Ms == math.MaxUint64 {
+			return id, false
+		}
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+}
+
+// Decr 返回上一个 ID，已经是最小值的时候返回 false
+func (id ID) Decr() (ID, bool) {
+	if id.Seq == 0 {
+		if id.Ms == 0 {
+			return id, false
+		}
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+}
+
+// ParseID 解析 ms-seq 或者 ms，省略 seq 时使用 missingSeq
+func ParseID(s string, missingSeq uint64) (ID, error) {
+	dash := strings.IndexByte(s, '-')
+	if dash < 0 {
+		ms, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return ID{}, ErrInvalidID
+		}
+		return ID{Ms: ms, Seq: missingSeq}, nil
+	}
+	ms, err := strconv.ParseUint(s[:dash], 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	seq, err := strconv.ParseUint(s[dash+1:], 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, nil
+}
+
+// ParseRangeID 解析范围查询的边界，- 和 + 表示最小和最大的 ID，( 开头表示不包含
+// 省略 seq 时作为起点补 0，作为终点补最大值
+func ParseRangeID(s string, isEnd bool) (ID, error) {
+	if s == "-" {
+		return MinID, nil
+	}
+	if s == "+" {
+		return MaxID, nil
+	}
+	exclude := strings.HasPrefix(s, "(")
+	if exclude {
+		s = s[1:]
+	}
+	var missingSeq uint64 = 0
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+	id, err := ParseID(s, missingSeq)
+	if err != nil {
+		return ID{}, err
+	}
+	if exclude {
+		var ok bool
+		if isEnd {
+			id, ok = id.Decr()
+		} else {
+			id, ok = id.Incr()
+		}
+		if !ok {
+			if isEnd {
+				return ID{}, errors.New("ERR invalid end ID for the interval")
+			}
+			return ID{}, errors.New("ERR invalid start ID for the interval")
+		}
+	}
+	return id, nil
+}
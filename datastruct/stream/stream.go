@@ -0,0 +1,385 @@
+// Package stream -----------------------------
+// @file      : stream.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/1/26 10:40
+// -------------------------------------------
+package stream
+
+import (
+	"errors"
+	"sort"
+)
+
+// 每个 block 最多存放的消息数量，和 Redis 的 stream-node-max-entries 默认值一致
+const blockMaxEntries = 100
+
+var (
+	// ErrIDTooSmall 新消息的 ID 必须大于最后一条消息的 ID
+	ErrIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	// ErrIDZero 新消息的 ID 不能为 0-0
+	ErrIDZero = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	// ErrIDExhausted 已经用完了所有的 ID
+	ErrIDExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// Entry 一条消息
+type Entry struct {
+	ID ID
+	// field value 交替出现
+	Fields [][]byte
+}
+
+// block 按 ID 有序的一组消息，参考 Redis 的 listpack 节点
+// 删除的消息直接从 block 中移除，block 空了就移除整个 block
+type block struct {
+	entries []*Entry
+}
+
+func (b *block) firstID() ID {
+	return b.entries[0].ID
+}
+
+func (b *block) lastID() ID {
+	return b.entries[len(b.entries)-1].ID
+}
+
+// Stream 消息流
+// 参考 Redis 用 rax 组织 listpack 的方式，blocks 按 ID 有序，先二分查找 block，再在 block 内二分查找消息
+type Stream struct {
+	blocks []*block
+	length int64
+	// 最后生成的 ID，删除消息之后也不会变小
+	lastID ID
+	// 被 XDEL 删除的最大的 ID
+	maxDeletedID ID
+	// 添加过的消息总数，包括已经删除的
+	entriesAdded int64
+	groups       map[string]*Group
+}
+
+// Make 创建空的消息流
+func Make() *Stream {
+	return &Stream{
+		blocks: make([]*block, 0),
+		groups: make(map[string]*Group),
+	}
+}
+
+// Len 返回消息的数量
+func (s *Stream) Len() int64 {
+	return s.length
+}
+
+// LastID 返回最后生成的 ID
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID 设置最后生成的 ID，用于 XSETID 和重放
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+// MaxDeletedID 返回被删除的最大的 ID
+func (s *Stream) MaxDeletedID() ID {
+	return s.maxDeletedID
+}
+
+// EntriesAdded 返回添加过的消息总数
+func (s *Stream) EntriesAdded() int64 {
+	return s.entriesAdded
+}
+
+// BlockCount 返回 block 的数量
+func (s *Stream) BlockCount() int {
+	return len(s.blocks)
+}
+
+// FirstID 返回第一条消息的 ID，消息流为空时返回 0-0
+func (s *Stream) FirstID() ID {
+	if len(s.blocks) == 0 {
+		return MinID
+	}
+	return s.blocks[0].firstID()
+}
+
+// First 返回第一条消息
+func (s *Stream) First() *Entry {
+	if len(s.blocks) == 0 {
+		return nil
+	}
+	return s.blocks[0].entries[0]
+}
+
+// Last 返回最后一条消息
+func (s *Stream) Last() *Entry {
+	if len(s.blocks) == 0 {
+		return nil
+	}
+	b := s.blocks[len(s.blocks)-1]
+	return b.entries[len(b.entries)-1]
+}
+
+// NextID 根据最后生成的 ID 计算新的 ID，ms 为当前时间
+func (s *Stream) NextID(ms uint64) (ID, error) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, nil
+	}
+	id, ok := s.lastID.Incr()
+	if !ok {
+		return ID{}, ErrIDExhausted
+	}
+	return id, nil
+}
+
+// NextSeqID 指定了毫秒时间戳，序号自动生成，如 XADD k 1526919030474-*
+func (s *Stream) NextSeqID(ms uint64) (ID, error) {
+	if ms < s.lastID.Ms {
+		return ID{}, ErrIDTooSmall
+	}
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, nil
+	}
+	id, ok := s.lastID.Incr()
+	if !ok || id.Ms != ms {
+		return ID{}, ErrIDTooSmall
+	}
+	return id, nil
+}
+
+// Add 添加消息，ID 必须大于最后生成的 ID
+func (s *Stream) Add(id ID, fields [][]byte) error {
+	if id.IsZero() {
+		return ErrIDZero
+	}
+	if id.Compare(s.lastID) <= 0 {
+		return ErrIDTooSmall
+	}
+	entry := &Entry{
+		ID:     id,
+		Fields: fields,
+	}
+	// 新的消息总是追加到最后一个 block
+	if len(s.blocks) == 0 || len(s.blocks[len(s.blocks)-1].entries) >= blockMaxEntries {
+		s.blocks = append(s.blocks, &block{
+			entries: make([]*Entry, 0, blockMaxEntries),
+		})
+	}
+	last := s.blocks[len(s.blocks)-1]
+	last.entries = append(last.entries, entry)
+	s.length++
+	s.entriesAdded++
+	s.lastID = id
+	return nil
+}
+
+// locate 找到第一个 ID 大于等于 id 的消息的位置
+func (s *Stream) locate(id ID) (blockIndex int, entryIndex int) {
+	blockIndex = sort.Search(len(s.blocks), func(i int) bool {
+		return !s.blocks[i].lastID().Less(id)
+	})
+	if blockIndex == len(s.blocks) {
+		return blockIndex, 0
+	}
+	entries := s.blocks[blockIndex].entries
+	entryIndex = sort.Search(len(entries), func(i int) bool {
+		return !entries[i].ID.Less(id)
+	})
+	return blockIndex, entryIndex
+}
+
+// Get 根据 ID 找到消息
+func (s *Stream) Get(id ID) *Entry {
+	blockIndex, entryIndex := s.locate(id)
+	if blockIndex == len(s.blocks) {
+		return nil
+	}
+	entry := s.blocks[blockIndex].entries[entryIndex]
+	if entry.ID != id {
+		return nil
+	}
+	return entry
+}
+
+// Range 返回 [start, end] 之间的消息，count 小于等于 0 表示不限制，rev 表示从大到小
+func (s *Stream) Range(start ID, end ID, count int, rev bool) []*Entry {
+	result := make([]*Entry, 0)
+	if end.Less(start) {
+		return result
+	}
+	if !rev {
+		blockIndex, entryIndex := s.locate(start)
+		for ; blockIndex < len(s.blocks); blockIndex++ {
+			entries := s.blocks[blockIndex].entries
+			for ; entryIndex < len(entries); entryIndex++ {
+				if end.Less(entries[entryIndex].ID) || (count > 0 && len(result) >= count) {
+					return result
+				}
+				result = append(result, entries[entryIndex])
+			}
+			entryIndex = 0
+		}
+		return result
+	}
+
+	// 逆序从第一个大于 end 的消息的前一条开始
+	blockIndex, entryIndex := s.locate(end)
+	if blockIndex < len(s.blocks) && s.blocks[blockIndex].entries[entryIndex].ID == end {
+		entryIndex++
+	}
+	entryIndex--
+	for blockIndex >= 0 {
+		if blockIndex < len(s.blocks) {
+			entries := s.blocks[blockIndex].entries
+			if entryIndex >= len(entries) {
+				entryIndex = len(entries) - 1
+			}
+			for ; entryIndex >= 0; entryIndex-- {
+				if entries[entryIndex].ID.Less(start) || (count > 0 && len(result) >= count) {
+					return result
+				}
+				result = append(result, entries[entryIndex])
+			}
+		}
+		blockIndex--
+		if blockIndex >= 0 {
+			entryIndex = len(s.blocks[blockIndex].entries) - 1
+		}
+	}
+	return result
+}
+
+// Delete 删除消息，返回是否删除成功
+func (s *Stream) Delete(id ID) bool {
+	blockIndex, entryIndex := s.locate(id)
+	if blockIndex == len(s.blocks) {
+		return false
+	}
+	b := s.blocks[blockIndex]
+	if b.entries[entryIndex].ID != id {
+		return false
+	}
+	b.entries = append(b.entries[:entryIndex], b.entries[entryIndex+1:]...)
+	if len(b.entries) == 0 {
+		s.blocks = append(s.blocks[:blockIndex], s.blocks[blockIndex+1:]...)
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// TrimByMaxLen 删除最早的消息直到只剩下 maxLen 条，返回删除的数量
+// approx 时只删除整个 block，limit 大于 0 时最多删除 limit 条
+func (s *Stream) TrimByMaxLen(maxLen int64, approx bool, limit int64) int64 {
+	return s.trim(func(e *Entry, remaining int64) bool {
+		return remaining > maxLen
+	}, func(b *block, remaining int64) bool {
+		return remaining-int64(len(b.entries)) >= maxLen
+	}, approx, limit)
+}
+
+// TrimByMinID 删除 ID 小于 minID 的消息，返回删除的数量
+func (s *Stream) TrimByMinID(minID ID, approx bool, limit int64) int64 {
+	return s.trim(func(e *Entry, remaining int64) bool {
+		return e.ID.Less(minID)
+	}, func(b *block, remaining int64) bool {
+		return b.lastID().Less(minID)
+	}, approx, limit)
+}
+
+// trim 从头开始删除消息，shouldRemove 判断单条消息，shouldRemoveBlock 判断整个 block
+func (s *Stream) trim(shouldRemove func(e *Entry, remaining int64) bool,
+	shouldRemoveBlock func(b *block, remaining int64) bool, approx bool, limit int64) int64 {
+	var removed int64 = 0
+	for len(s.blocks) > 0 {
+		b := s.blocks[0]
+		if shouldRemoveBlock(b, s.length) {
+			if limit > 0 && removed+int64(len(b.entries)) > limit {
+				break
+			}
+			s.blocks = s.blocks[1:]
+			s.length -= int64(len(b.entries))
+			removed += int64(len(b.entries))
+			continue
+		}
+		// 近似删除不会拆开 block
+		if approx {
+			break
+		}
+		i := 0
+		for i < len(b.entries) && shouldRemove(b.entries[i], s.length) {
+			if limit > 0 && removed >= limit {
+				break
+			}
+			i++
+			s.length--
+			removed++
+		}
+		b.entries = b.entries[i:]
+		if len(b.entries) == 0 {
+			s.blocks = s.blocks[1:]
+		}
+		break
+	}
+	return removed
+}
+
+// ForEach 按 ID 从小到大遍历所有的消息，返回 false 停止遍历
+func (s *Stream) ForEach(consumer func(entry *Entry) bool) {
+	for _, b := range s.blocks {
+		for _, entry := range b.entries {
+			if !consumer(entry) {
+				return
+			}
+		}
+	}
+}
+
+// RangeHasTombstones 判断 [start, end] 之间是否有被 XDEL 删除的消息
+func (s *Stream) RangeHasTombstones(start ID, end ID) bool {
+	if s.length == 0 || s.maxDeletedID.IsZero() {
+		return false
+	}
+	return !s.maxDeletedID.Less(start) && !end.Less(s.maxDeletedID)
+}
+
+// EstimateDistanceFromFirstEverEntry 估算 id 是第几条添加的消息，无法估算时返回 -1
+// 参考 Redis 的 streamEstimateDistanceFromFirstEverEntry
+func (s *Stream) EstimateDistanceFromFirstEverEntry(id ID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && id.Compare(s.lastID) < 1 {
+		return s.entriesAdded
+	}
+	cmpLast := id.Compare(s.lastID)
+	if cmpLast == 0 {
+		return s.entriesAdded
+	} else if cmpLast > 0 {
+		return -1
+	}
+	firstID := s.FirstID()
+	cmpFirst := id.Compare(firstID)
+	// 没有删除过中间的消息，可以直接计算
+	if s.maxDeletedID.IsZero() || s.maxDeletedID.Less(firstID) {
+		if cmpFirst < 0 {
+			return s.entriesAdded - s.length
+		} else if cmpFirst == 0 {
+			return s.entriesAdded - s.length + 1
+		}
+	}
+	return -1
+}
+
+// SetEntriesAdded 设置添加过的消息总数，用于 XSETID 和重放
+func (s *Stream) SetEntriesAdded(entriesAdded int64) {
+	s.entriesAdded = entriesAdded
+}
+
+// SetMaxDeletedID 设置被删除的最大的 ID，用于 XSETID 和重放
+func (s *Stream) SetMaxDeletedID(id ID) {
+	s.maxDeletedID = id
+}
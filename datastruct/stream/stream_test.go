@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseRangeID(t *testing.T) {
+	id, err := ParseRangeID("(5-0", false)
+	if err != nil || id != (ID{Ms: 5, Seq: 1}) {
+		t.Errorf("unexpected %v %v", id, err)
+	}
+	id, err = ParseRangeID("(5", true)
+	if err != nil || id != (ID{Ms: 5, Seq: MaxID.Seq - 1}) {
+		t.Errorf("unexpected %v %v", id, err)
+	}
+	if _, err = ParseRangeID("(0-0", true); err == nil {
+		t.Error("expected error")
+	}
+	if _, err = ParseID("1-a", 0); err != ErrInvalidID {
+		t.Errorf("expected invalid id, actually %v", err)
+	}
+}
+
+func TestRangeAndDelete(t *testing.T) {
+	s := Make()
+	ids := make([]ID, 0)
+	for i := 1; i <= 1000; i++ {
+		id := ID{Ms: uint64(i / 3), Seq: uint64(i % 3)}
+		if err := s.Add(id, [][]byte{[]byte("f"), []byte("v")}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := s.Add(ID{Ms: 1}, nil); err != ErrIDTooSmall {
+		t.Errorf("expected id too small, actually %v", err)
+	}
+	// 随机删除一部分
+	for i := 0; i < 300; i++ {
+		j := rand.Intn(len(ids))
+		if !s.Delete(ids[j]) {
+			t.Fatalf("delete %s failed", ids[j])
+		}
+		ids = append(ids[:j], ids[j+1:]...)
+	}
+	if s.Len() != int64(len(ids)) {
+		t.Fatalf("expected len %d, actually %d", len(ids), s.Len())
+	}
+	for i := 0; i < 100; i++ {
+		a, b := rand.Intn(len(ids)), rand.Intn(len(ids))
+		if a > b {
+			a, b = b, a
+		}
+		entries := s.Range(ids[a], ids[b], 0, false)
+		if len(entries) != b-a+1 {
+			t.Fatalf("expected %d entries, actually %d", b-a+1, len(entries))
+		}
+		for k, entry := range entries {
+			if entry.ID != ids[a+k] {
+				t.Fatalf("expected %s, actually %s", ids[a+k], entry.ID)
+			}
+		}
+		entries = s.Range(ids[a], ids[b], 5, true)
+		for k, entry := range entries {
+			if entry.ID != ids[b-k] {
+				t.Fatalf("expected %s, actually %s", ids[b-k], entry.ID)
+			}
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	s := Make()
+	for i := 1; i <= 250; i++ {
+		_ = s.Add(ID{Ms: uint64(i)}, nil)
+	}
+	// 近似删除只删除整个 block
+	if removed := s.TrimByMaxLen(120, true, 0); removed != 100 || s.Len() != 150 {
+		t.Errorf("expected 100 removed, actually %d, len %d", removed, s.Len())
+	}
+	if removed := s.TrimByMaxLen(120, false, 0); removed != 30 || s.FirstID() != (ID{Ms: 131}) {
+		t.Errorf("expected 30 removed, actually %d, first %s", removed, s.FirstID())
+	}
+	if removed := s.TrimByMinID(ID{Ms: 200}, false, 0); removed != 69 || s.FirstID() != (ID{Ms: 200}) {
+		t.Errorf("expected 69 removed, actually %d, first %s", removed, s.FirstID())
+	}
+	if s.EntriesAdded() != 250 || s.LastID() != (ID{Ms: 250}) {
+		t.Errorf("unexpected entries added %d, last id %s", s.EntriesAdded(), s.LastID())
+	}
+}
+
+func TestGroupLag(t *testing.T) {
+	s := Make()
+	for i := 1; i <= 10; i++ {
+		_ = s.Add(ID{Ms: uint64(i)}, nil)
+	}
+	g, _ := s.CreateGroup("g", MinID, InvalidEntriesRead)
+	if lag, ok := s.Lag(g); !ok || lag != 10 {
+		t.Errorf("expected lag 10, actually %d %v", lag, ok)
+	}
+	c, _ := g.CreateConsumer("c", time.Now())
+	for _, entry := range s.Range(MinID, MaxID, 3, false) {
+		s.AdvanceGroup(g, entry.ID)
+		g.Deliver(entry.ID, c, time.Now())
+	}
+	if lag, ok := s.Lag(g); !ok || lag != 7 || g.EntriesRead != 3 {
+		t.Errorf("expected lag 7, actually %d %v, entries read %d", lag, ok, g.EntriesRead)
+	}
+	if g.PendingLen() != 3 || c.PendingLen() != 3 {
+		t.Errorf("expected 3 pending, actually %d", g.PendingLen())
+	}
+	// 删除了还没读取的消息之后 lag 无法计算
+	s.Delete(ID{Ms: 5})
+	if _, ok := s.Lag(g); ok {
+		t.Error("expected invalid lag")
+	}
+	if !g.Ack(ID{Ms: 2}) || g.Ack(ID{Ms: 2}) || c.PendingLen() != 2 {
+		t.Error("unexpected ack result")
+	}
+	if g.DeleteConsumer("c") != 2 || g.PendingLen() != 0 {
+		t.Error("unexpected delete consumer result")
+	}
+}
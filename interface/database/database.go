@@ -6,7 +6,10 @@
 // -------------------------------------------
 package database
 
-import "redis-go/interface/resp"
+import (
+	"redis-go/interface/resp"
+	"time"
+)
 
 type CmdLine = [][]byte
 
@@ -18,7 +21,18 @@ type Database interface {
 	AfterClientClose(c resp.Connection)
 }
 
+// DBEngine 可以遍历数据的数据库，AOF 重写时用来生成命令
+type DBEngine interface {
+	Database
+	// ForEach 遍历 db 中没有过期的 key，expiration 为 nil 表示没有过期时间
+	ForEach(dbIndex int, cb func(key string, entity *DataEntity, expiration *time.Time) bool)
+}
+
 // DataEntity 指代 Redis 所有数据结构
 type DataEntity struct {
 	Data interface{}
+	// 内存淘汰使用的访问信息，和 Redis 的 robj.lru 一样只有 24 位
+	// LRU 策略下是最近一次访问的时钟（秒），LFU 策略下高 16 位是最近一次衰减的时间（分钟），低 8 位是对数计数器
+	// 读命令并发访问，需要用原子操作读写
+	LRU uint32
 }
@@ -6,9 +6,47 @@
 // -------------------------------------------
 package resp
 
+import "time"
+
 // Connection 代表协议层的一个客户端的连接
 type Connection interface {
 	Write([]byte) error
+	// WriteTimeout 和 Write 一样，超过 timeout 还没有写完时返回错误
+	WriteTimeout(b []byte, timeout time.Duration) error
+	// Disconnect 服务端主动断开连接，读取的协程随之退出并清理连接的状态
+	Disconnect()
 	GetDBIndex() int
 	SelectDB(int)
+
+	// 事务相关的状态
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	// GetWatching WATCH 的 key 和当时的版本号
+	GetWatching() map[WatchedKey]uint32
+	ClearWatching()
+	AddTxError(err error)
+	GetTxErrors() []error
+
+	// 发布订阅相关的状态
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	GetChannels() []string
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	GetPatterns() []string
+	// SubsCount 订阅的频道和模式的总数，大于 0 时处于订阅模式
+	SubsCount() int
+
+	// Block 执行阻塞命令之前调用，客户端已经断开时返回 false
+	Block() bool
+	Unblock()
+}
+
+// WatchedKey WATCH 的 key 和它所在的 db，EXEC 时到 WATCH 时的 db 中检查版本号
+type WatchedKey struct {
+	DBIndex int
+	Key     string
 }
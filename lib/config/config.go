@@ -21,6 +21,28 @@ type ServerProperties struct {
 	MaxClients     int    `cfg:"maxclients"`
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
+	// aof 文件比上次重写之后增长的百分比达到这个值时自动重写，0 表示不自动重写
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"`
+	// 自动重写时 aof 文件的最小大小，支持 64mb 这样的单位
+	AutoAofRewriteMinSize int `cfg:"auto-aof-rewrite-min-size"`
+	// aof 的 fsync 策略 always / everysec / no，默认 everysec
+	AppendFsync string `cfg:"appendfsync"`
+	// aof 文件末尾的命令不完整时是否截断之后继续启动 yes / no，默认 yes
+	AofLoadTruncated string `cfg:"aof-load-truncated"`
+	// 保存 base、incr 文件和清单的目录，相对路径时和 appendfilename 在同一个目录下，默认 appendonlydir
+	AppendDirname string `cfg:"appenddirname"`
+	// RDB 文件名，默认 dump.rdb
+	DBFilename string `cfg:"dbfilename"`
+	// 自动保存 RDB 的规则，如 900 1 300 10 表示 900 秒内至少 1 次修改或者 300 秒内至少 10 次修改，为空时不自动保存
+	Save string `cfg:"save"`
+	// 内存上限，支持 100mb、1gb 这样的单位，0 表示不限制
+	MaxMemory int `cfg:"maxmemory"`
+	// 超过内存上限时的淘汰策略，默认 noeviction
+	MaxMemoryPolicy string `cfg:"maxmemory-policy"`
+	// 近似 LRU/LFU 每次抽样的 key 的数量，默认 5
+	MaxMemorySamples int `cfg:"maxmemory-samples"`
+	// 键空间通知的事件类型，如 KEA，默认为空不发送通知
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
 
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
@@ -78,7 +100,7 @@ func parse(src io.Reader) *ServerProperties {
 			case reflect.String:
 				fieldVal.SetString(value)
 			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
+				intValue, err := parseMemory(value)
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
@@ -98,6 +120,28 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// parseMemory 解析整数，和 Redis 一样支持 k kb m mb g gb 的单位，不区分大小写
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	lower := strings.ToLower(value)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			num, err := strconv.ParseInt(strings.TrimSuffix(lower, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return num * unit.factor, nil
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
@@ -6,6 +6,12 @@
 // -------------------------------------------
 package utils
 
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
 // BytesEquals check whether the given bytes is equal
 func BytesEquals(a []byte, b []byte) bool {
 	if (a == nil && b != nil) || (a != nil && b == nil) {
@@ -43,3 +49,83 @@ func ToCmdLine2(commandName string, args ...[]byte) [][]byte {
 	}
 	return result
 }
+
+// FormatFloat 以人类可读的形式输出浮点数，不使用科学计数法，与 Redis INCRBYFLOAT 的结果一致
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// FormatScore 按照 Redis fpconv_dtoa 的规则输出有序集合的分数
+// 使用能够精确还原的最短表示，指数较大或较小时使用科学计数法，如 0.1、1e+21、1.5e-07
+func FormatScore(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	}
+	if math.IsInf(f, -1) {
+		return "-inf"
+	}
+	if f == 0 {
+		if math.Signbit(f) {
+			return "-0"
+		}
+		return "0"
+	}
+	// 最短的有效数字以及指数，value = 0.digits * 10^(point)
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	neg := s[0] == '-'
+	if neg {
+		s = s[1:]
+	}
+	mantissa, expPart := s, "0"
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, expPart = s[:i], s[i+1:]
+	}
+	exp10, _ := strconv.Atoi(expPart)
+	digits := strings.Replace(mantissa, ".", "", 1)
+	ndigits := len(digits)
+	// value = digits * 10^k
+	k := exp10 - ndigits + 1
+
+	var buf strings.Builder
+	if neg {
+		buf.WriteByte('-')
+	}
+	absExp := k + ndigits - 1
+	if absExp < 0 {
+		absExp = -absExp
+	}
+	if k >= 0 && absExp < ndigits+7 {
+		// 整数
+		buf.WriteString(digits)
+		buf.WriteString(strings.Repeat("0", k))
+		return buf.String()
+	}
+	if k < 0 && (k > -7 || absExp < 4) {
+		// 不使用科学计数法的小数
+		offset := ndigits + k
+		if offset <= 0 {
+			buf.WriteString("0.")
+			buf.WriteString(strings.Repeat("0", -offset))
+			buf.WriteString(digits)
+		} else {
+			buf.WriteString(digits[:offset])
+			buf.WriteByte('.')
+			buf.WriteString(digits[offset:])
+		}
+		return buf.String()
+	}
+	// 科学计数法
+	buf.WriteByte(digits[0])
+	if ndigits > 1 {
+		buf.WriteByte('.')
+		buf.WriteString(digits[1:])
+	}
+	buf.WriteByte('e')
+	if k+ndigits-1 < 0 {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteByte('+')
+	}
+	buf.WriteString(strconv.Itoa(absExp))
+	return buf.String()
+}
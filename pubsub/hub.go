@@ -0,0 +1,182 @@
+// Package pubsub -----------------------------
+// @file      : hub.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/8 14:10
+// -------------------------------------------
+package pubsub
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/logger"
+	"redis-go/lib/wildcard"
+	"redis-go/resp/reply"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 每个订阅者待发送的消息的数量
+	// 和 Redis 的 client-output-buffer-limit pubsub 一样，写满说明客户端读取得太慢，直接断开订阅者
+	queueSize = 1 << 10
+	// 写回一条消息的超时时间，超时之后断开订阅者
+	writeTimeout = 10 * time.Second
+)
+
+// Hub 记录所有的订阅关系
+type Hub struct {
+	// 订阅和发布都在持有锁的时候把消息放进订阅者的队列，保证同一个订阅者收到的回复是有序的
+	mu sync.RWMutex
+	// channel → 订阅的连接
+	channels map[string]map[resp.Connection]struct{}
+	// pattern → 订阅的连接
+	patterns map[string]*patternSubs
+	// 处于订阅模式的连接
+	subscribers map[resp.Connection]*subscriber
+}
+
+type patternSubs struct {
+	pattern *wildcard.Pattern
+	conns   map[resp.Connection]struct{}
+}
+
+// subscriber 订阅者的消息队列，由单独的协程异步写回客户端
+// 订阅模式下所有的回复都经过这个队列，避免消息和回复乱序
+type subscriber struct {
+	conn  resp.Connection
+	queue chan []byte
+	// 写回协程退出时关闭
+	done chan struct{}
+	// 已经断开时为 1，之后的消息直接丢弃
+	closed int32
+}
+
+// MakeHub 创建一个 Hub
+func MakeHub() *Hub {
+	return &Hub{
+		channels:    make(map[string]map[resp.Connection]struct{}),
+		patterns:    make(map[string]*patternSubs),
+		subscribers: make(map[resp.Connection]*subscriber),
+	}
+}
+
+// push 把消息放进连接的队列，第一次订阅的时候启动写回协程，调用方需要持有锁
+func (hub *Hub) push(c resp.Connection, msg []byte) {
+	s, ok := hub.subscribers[c]
+	if !ok {
+		s = &subscriber{
+			conn:  c,
+			queue: make(chan []byte, queueSize),
+			done:  make(chan struct{}),
+		}
+		hub.subscribers[c] = s
+		go s.serve()
+	}
+	s.send(msg)
+}
+
+// send 把消息放进队列，持有 Hub 的锁时不能阻塞，队列满了就断开订阅者
+func (s *subscriber) send(msg []byte) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return
+	}
+	select {
+	case s.queue <- msg:
+	default:
+		logger.Info("pubsub: subscriber queue overflow, disconnecting")
+		s.disconnect()
+	}
+}
+
+// disconnect 断开订阅者，连接关闭之后由 UnsubscribeAll 退订并结束写回协程
+func (s *subscriber) disconnect() {
+	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		s.conn.Disconnect()
+	}
+}
+
+// release 连接退订了所有的频道和模式，队列中剩余的消息发送完之后协程退出，调用方需要持有写锁
+// 返回协程退出时关闭的 channel，没有退出订阅模式时返回 nil
+func (hub *Hub) release(c resp.Connection) chan struct{} {
+	if c.SubsCount() > 0 {
+		return nil
+	}
+	s, ok := hub.subscribers[c]
+	if !ok {
+		return nil
+	}
+	delete(hub.subscribers, c)
+	close(s.queue)
+	return s.done
+}
+
+// replyTo 连接处于订阅模式时把回复放进队列，返回空回复，否则原样返回由调用方直接写回
+func (hub *Hub) replyTo(c resp.Connection, r resp.Reply) resp.Reply {
+	if _, ok := r.(*reply.NoReply); ok {
+		return r
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	s, ok := hub.subscribers[c]
+	if !ok {
+		return r
+	}
+	s.send(r.ToBytes())
+	return reply.MakeNoReply()
+}
+
+func (s *subscriber) serve() {
+	defer close(s.done)
+	for msg := range s.queue {
+		if atomic.LoadInt32(&s.closed) == 1 {
+			continue
+		}
+		if err := s.conn.WriteTimeout(msg, writeTimeout); err != nil {
+			s.disconnect()
+		}
+	}
+}
+
+func (hub *Hub) subscribe(c resp.Connection, channel string) {
+	conns, ok := hub.channels[channel]
+	if !ok {
+		conns = make(map[resp.Connection]struct{})
+		hub.channels[channel] = conns
+	}
+	conns[c] = struct{}{}
+	c.Subscribe(channel)
+}
+
+func (hub *Hub) unsubscribe(c resp.Connection, channel string) {
+	if conns, ok := hub.channels[channel]; ok {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(hub.channels, channel)
+		}
+	}
+	c.UnSubscribe(channel)
+}
+
+func (hub *Hub) psubscribe(c resp.Connection, pattern string) {
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		subs = &patternSubs{
+			pattern: wildcard.CompilePattern(pattern),
+			conns:   make(map[resp.Connection]struct{}),
+		}
+		hub.patterns[pattern] = subs
+	}
+	subs.conns[c] = struct{}{}
+	c.PSubscribe(pattern)
+}
+
+func (hub *Hub) punsubscribe(c resp.Connection, pattern string) {
+	if subs, ok := hub.patterns[pattern]; ok {
+		delete(subs.conns, c)
+		if len(subs.conns) == 0 {
+			delete(hub.patterns, pattern)
+		}
+	}
+	c.PUnSubscribe(pattern)
+}
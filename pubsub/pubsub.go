@@ -0,0 +1,252 @@
+// Package pubsub -----------------------------
+// @file      : pubsub.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/8 14:40
+// -------------------------------------------
+package pubsub
+
+import (
+	"redis-go/interface/resp"
+	"redis-go/lib/wildcard"
+	"redis-go/resp/reply"
+	"sort"
+	"strings"
+)
+
+// 发布订阅，参考 Redis 的 pubsub.c
+// 订阅的回复和推送的消息都是数组：[subscribe, channel, 订阅数]、[message, channel, 消息]、[pmessage, pattern, channel, 消息]
+
+// IsPubSubCommand 是否是发布订阅相关的命令
+func IsPubSubCommand(cmdName string) bool {
+	switch cmdName {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "publish", "pubsub":
+		return true
+	}
+	return false
+}
+
+// CheckSubscribeMode 订阅模式下只能执行订阅相关的命令和 PING，返回 nil 表示可以执行
+// PING 和拒绝执行的错误也放进订阅者的队列，不会先于之前的订阅回复写回
+func CheckSubscribeMode(hub *Hub, c resp.Connection, cmdName string) resp.Reply {
+	if c == nil || c.SubsCount() == 0 {
+		return nil
+	}
+	switch cmdName {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "quit":
+		return nil
+	case "ping":
+		// 订阅模式下 PING 的回复也是数组
+		return hub.replyTo(c, reply.MakeMultiBulkReply([][]byte{[]byte("pong"), {}}))
+	}
+	return hub.replyTo(c, reply.MakeErrReply("ERR Can't execute '"+cmdName+
+		"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+}
+
+// Exec 执行发布订阅相关的命令
+func Exec(hub *Hub, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	// 订阅模式下参数错误等回复同样放进队列
+	return hub.replyTo(c, execCommand(hub, c, cmdLine))
+}
+
+func execCommand(hub *Hub, c resp.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	args := cmdLine[1:]
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return Subscribe(hub, c, args)
+	case "unsubscribe":
+		return UnSubscribe(hub, c, args)
+	case "psubscribe":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return PSubscribe(hub, c, args)
+	case "punsubscribe":
+		return PUnSubscribe(hub, c, args)
+	case "publish":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return Publish(hub, args)
+	case "pubsub":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return PubSub(hub, args)
+	}
+	return reply.MakeErrReply("ERR unknown command " + cmdName)
+}
+
+// makeSubsMsg 订阅和退订的回复，channel 为 nil 表示没有订阅任何频道
+func makeSubsMsg(kind string, channel []byte, count int) []byte {
+	var channelReply resp.Reply = reply.MakeNullBulkReply()
+	if channel != nil {
+		channelReply = reply.MakeBulkReply(channel)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(kind)),
+		channelReply,
+		reply.MakeIntReply(int64(count)),
+	}).ToBytes()
+}
+
+// SUBSCRIBE channel [channel ...]
+func Subscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, arg := range args {
+		hub.subscribe(c, string(arg))
+		hub.push(c, makeSubsMsg("subscribe", arg, c.SubsCount()))
+	}
+	// 回复已经放进队列
+	return reply.MakeNoReply()
+}
+
+// UNSUBSCRIBE [channel ...] 没有参数时退订所有的频道
+func UnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	channels := args
+	if len(channels) == 0 {
+		for _, channel := range c.GetChannels() {
+			channels = append(channels, []byte(channel))
+		}
+	}
+	if len(channels) == 0 {
+		// 没有订阅任何频道
+		hub.push(c, makeSubsMsg("unsubscribe", nil, c.SubsCount()))
+	}
+	for _, channel := range channels {
+		hub.unsubscribe(c, string(channel))
+		hub.push(c, makeSubsMsg("unsubscribe", channel, c.SubsCount()))
+	}
+	done := hub.release(c)
+	hub.mu.Unlock()
+	waitDrained(done)
+	return reply.MakeNoReply()
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func PSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, arg := range args {
+		hub.psubscribe(c, string(arg))
+		hub.push(c, makeSubsMsg("psubscribe", arg, c.SubsCount()))
+	}
+	return reply.MakeNoReply()
+}
+
+// PUNSUBSCRIBE [pattern ...] 没有参数时退订所有的模式
+func PUnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	patterns := args
+	if len(patterns) == 0 {
+		for _, pattern := range c.GetPatterns() {
+			patterns = append(patterns, []byte(pattern))
+		}
+	}
+	if len(patterns) == 0 {
+		hub.push(c, makeSubsMsg("punsubscribe", nil, c.SubsCount()))
+	}
+	for _, pattern := range patterns {
+		hub.punsubscribe(c, string(pattern))
+		hub.push(c, makeSubsMsg("punsubscribe", pattern, c.SubsCount()))
+	}
+	done := hub.release(c)
+	hub.mu.Unlock()
+	waitDrained(done)
+	return reply.MakeNoReply()
+}
+
+// waitDrained 退出订阅模式之后的回复直接写回，先等待队列中剩余的消息写回，done 为 nil 表示仍然处于订阅模式
+func waitDrained(done chan struct{}) {
+	if done != nil {
+		<-done
+	}
+}
+
+// UnsubscribeAll 连接关闭时退订所有的频道和模式，不发送回复
+func UnsubscribeAll(hub *Hub, c resp.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, channel := range c.GetChannels() {
+		hub.unsubscribe(c, channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		hub.punsubscribe(c, pattern)
+	}
+	hub.release(c)
+}
+
+// PUBLISH channel message 返回收到消息的订阅者的数量
+func Publish(hub *Hub, args [][]byte) resp.Reply {
+	channel := string(args[0])
+	message := args[1]
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	count := 0
+	if conns, ok := hub.channels[channel]; ok {
+		msg := reply.MakeMultiBulkReply([][]byte{[]byte("message"), args[0], message}).ToBytes()
+		for c := range conns {
+			hub.subscribers[c].send(msg)
+			count++
+		}
+	}
+	for pattern, subs := range hub.patterns {
+		if !subs.pattern.IsMatch(channel) {
+			continue
+		}
+		msg := reply.MakeMultiBulkReply([][]byte{[]byte("pmessage"), []byte(pattern), args[0], message}).ToBytes()
+		for c := range subs.conns {
+			hub.subscribers[c].send(msg)
+			count++
+		}
+	}
+	return reply.MakeIntReply(int64(count))
+}
+
+// PUBSUB CHANNELS [pattern] / NUMSUB [channel ...] / NUMPAT
+func PubSub(hub *Hub, args [][]byte) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	switch subCmd {
+	case "channels":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("pubsub|channels")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			pattern = wildcard.CompilePattern(string(args[1]))
+		}
+		channels := make([]string, 0, len(hub.channels))
+		for channel := range hub.channels {
+			if pattern == nil || pattern.IsMatch(channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "numsub":
+		result := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			result = append(result, reply.MakeBulkReply(channel),
+				reply.MakeIntReply(int64(len(hub.channels[string(channel)]))))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return reply.MakeIntReply(int64(len(hub.patterns)))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try PUBSUB HELP.")
+}
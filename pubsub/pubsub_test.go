@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"errors"
+	"redis-go/lib/utils"
+	"redis-go/resp/connection"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn 记录写回客户端的数据
+type fakeConn struct {
+	connection.Connection
+	written chan []byte
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{written: make(chan []byte, 100)}
+}
+
+func (c *fakeConn) Write(b []byte) error {
+	c.written <- b
+	return nil
+}
+
+func (c *fakeConn) WriteTimeout(b []byte, timeout time.Duration) error {
+	return c.Write(b)
+}
+
+func (c *fakeConn) expect(t *testing.T, expected string) {
+	t.Helper()
+	select {
+	case b := <-c.written:
+		if string(b) != expected {
+			t.Fatalf("expected %q, actually %q", expected, string(b))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected %q, actually nothing", expected)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	hub := MakeHub()
+	sub := newFakeConn()
+	psub := newFakeConn()
+	result := Exec(hub, sub, utils.ToCmdLine("subscribe", "a", "b"))
+	if len(result.ToBytes()) != 0 {
+		t.Fatalf("expected no reply, actually %q", string(result.ToBytes()))
+	}
+	sub.expect(t, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n")
+	sub.expect(t, "*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n")
+	Exec(hub, psub, utils.ToCmdLine("psubscribe", "a*"))
+	psub.expect(t, "*3\r\n$10\r\npsubscribe\r\n$2\r\na*\r\n:1\r\n")
+
+	result = Exec(hub, nil, utils.ToCmdLine("publish", "a", "hello"))
+	if string(result.ToBytes()) != ":2\r\n" {
+		t.Fatalf("expected 2, actually %q", string(result.ToBytes()))
+	}
+	sub.expect(t, "*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$5\r\nhello\r\n")
+	psub.expect(t, "*4\r\n$8\r\npmessage\r\n$2\r\na*\r\n$1\r\na\r\n$5\r\nhello\r\n")
+
+	result = Exec(hub, nil, utils.ToCmdLine("pubsub", "channels"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+		t.Errorf("unexpected channels %q", string(result.ToBytes()))
+	}
+	result = Exec(hub, nil, utils.ToCmdLine("pubsub", "numsub", "a", "c"))
+	if string(result.ToBytes()) != "*4\r\n$1\r\na\r\n:1\r\n$1\r\nc\r\n:0\r\n" {
+		t.Errorf("unexpected numsub %q", string(result.ToBytes()))
+	}
+	result = Exec(hub, nil, utils.ToCmdLine("pubsub", "numpat"))
+	if string(result.ToBytes()) != ":1\r\n" {
+		t.Errorf("unexpected numpat %q", string(result.ToBytes()))
+	}
+
+	// 订阅模式下只能执行订阅相关的命令，回复也经过队列
+	if result := CheckSubscribeMode(hub, sub, "get"); result == nil || len(result.ToBytes()) != 0 {
+		t.Errorf("expected reply queued, actually %v", result)
+	}
+	sub.expect(t, "-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n")
+	if result := CheckSubscribeMode(hub, sub, "ping"); result == nil || len(result.ToBytes()) != 0 {
+		t.Errorf("expected reply queued, actually %v", result)
+	}
+	sub.expect(t, "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+	Exec(hub, sub, utils.ToCmdLine("subscribe"))
+	sub.expect(t, "-ERR wrong number of arguments for 'subscribe' command\r\n")
+
+	Exec(hub, sub, utils.ToCmdLine("unsubscribe", "a"))
+	sub.expect(t, "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n")
+	Exec(hub, sub, utils.ToCmdLine("unsubscribe"))
+	sub.expect(t, "*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:0\r\n")
+	if sub.SubsCount() != 0 || CheckSubscribeMode(hub, sub, "get") != nil {
+		t.Error("expected subscribe mode exited")
+	}
+	Exec(hub, sub, utils.ToCmdLine("unsubscribe"))
+	sub.expect(t, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
+
+	// 连接关闭后清理订阅关系
+	UnsubscribeAll(hub, psub)
+	result = Exec(hub, nil, utils.ToCmdLine("publish", "a", "hello"))
+	if string(result.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected 0, actually %q", string(result.ToBytes()))
+	}
+	if len(hub.channels) != 0 || len(hub.patterns) != 0 || len(hub.subscribers) != 0 {
+		t.Error("expected hub cleared")
+	}
+}
+
+// stuckConn 模拟不读取数据的客户端，写回一直阻塞直到断开
+type stuckConn struct {
+	connection.Connection
+	writing      chan struct{}
+	disconnected chan struct{}
+	once         sync.Once
+}
+
+func (c *stuckConn) WriteTimeout(b []byte, timeout time.Duration) error {
+	c.writing <- struct{}{}
+	<-c.disconnected
+	return errors.New("use of closed network connection")
+}
+
+func (c *stuckConn) Disconnect() {
+	c.once.Do(func() {
+		close(c.disconnected)
+	})
+}
+
+// 订阅者读取得太慢时发布者不会阻塞，队列写满之后断开订阅者
+func TestSlowSubscriber(t *testing.T) {
+	hub := MakeHub()
+	slow := &stuckConn{writing: make(chan struct{}, 1), disconnected: make(chan struct{})}
+	Exec(hub, slow, utils.ToCmdLine("subscribe", "a"))
+	// 等待订阅的回复阻塞在写回上
+	<-slow.writing
+	sub := newFakeConn()
+	Exec(hub, sub, utils.ToCmdLine("subscribe", "a"))
+	sub.expect(t, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n")
+
+	message := "*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$5\r\nhello\r\n"
+	// 正好写满慢的订阅者的队列
+	for i := 0; i < queueSize; i++ {
+		result := Exec(hub, nil, utils.ToCmdLine("publish", "a", "hello"))
+		if string(result.ToBytes()) != ":2\r\n" {
+			t.Fatalf("expected 2, actually %q", string(result.ToBytes()))
+		}
+		sub.expect(t, message)
+	}
+	select {
+	case <-slow.disconnected:
+		t.Fatal("expected slow subscriber connected")
+	default:
+	}
+	// 队列满了之后发布不会阻塞，直接断开慢的订阅者
+	done := make(chan struct{})
+	go func() {
+		Exec(hub, nil, utils.ToCmdLine("publish", "a", "hello"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by slow subscriber")
+	}
+	select {
+	case <-slow.disconnected:
+	default:
+		t.Fatal("expected slow subscriber disconnected")
+	}
+	// 其他订阅者不受影响
+	sub.expect(t, message)
+
+	// 连接关闭之后清理订阅关系
+	UnsubscribeAll(hub, slow)
+	result := Exec(hub, nil, utils.ToCmdLine("pubsub", "numsub", "a"))
+	if string(result.ToBytes()) != "*2\r\n$1\r\na\r\n:1\r\n" {
+		t.Errorf("unexpected numsub %q", string(result.ToBytes()))
+	}
+}
@@ -0,0 +1,18 @@
+// Package rdb -----------------------------
+// @file      : crc64.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 10:35
+// -------------------------------------------
+package rdb
+
+import "hash/crc64"
+
+// Redis 使用的 CRC-64/Jones，反射的多项式 0x95AC9329AC4BC9B5，初始值和结果异或值都是 0
+// 标准库的 crc64 在计算前后都会取反，这里抵消掉
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// crc64Update 用 p 更新校验和
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
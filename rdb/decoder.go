@@ -0,0 +1,608 @@
+// Package rdb -----------------------------
+// @file      : decoder.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 15:20
+// -------------------------------------------
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	Hash "redis-go/datastruct/hash"
+	List "redis-go/datastruct/list"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/interface/database"
+	"strconv"
+	"time"
+)
+
+// Decoder 读取 RDB 文件，同时计算校验和
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+	buf     [8]byte
+}
+
+// NewDecoder 创建 Decoder
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode 读取整个文件，每读到一个 key 调用一次 handler，handler 返回错误时停止
+func (dec *Decoder) Decode(handler EntryHandler) error {
+	header, err := dec.readFull(9)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return ErrInvalidFormat
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return ErrInvalidFormat
+	}
+	if version < minVersion || version > Version {
+		return fmt.Errorf("can't handle RDB format version %d", version)
+	}
+	dec.version = version
+
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opcode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case opcodeEOF:
+			return dec.verifyChecksum()
+		case opcodeSelectDB:
+			index, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(index)
+		case opcodeResizeDB:
+			// db 和过期字典的大小，只是提示
+			if _, err = dec.readLength(); err != nil {
+				return err
+			}
+			if _, err = dec.readLength(); err != nil {
+				return err
+			}
+		case opcodeAux:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opcodeExpireTimeMs:
+			ms, err := dec.readMillisecondTime()
+			if err != nil {
+				return err
+			}
+			expiration = &ms
+		case opcodeExpireTime:
+			buf, err := dec.readFull(4)
+			if err != nil {
+				return err
+			}
+			expireTime := time.Unix(int64(int32(binary.LittleEndian.Uint32(buf))), 0)
+			expiration = &expireTime
+		case opcodeIdle:
+			// 淘汰用的访问信息不恢复
+			if _, err = dec.readLength(); err != nil {
+				return err
+			}
+		case opcodeFreq:
+			if _, err = dec.readByte(); err != nil {
+				return err
+			}
+		case opcodeFunction2:
+			// 函数库的代码，不支持函数，跳过
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opcodeModuleAux:
+			if err = dec.skipModuleAux(); err != nil {
+				return err
+			}
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			entity, err := dec.readObject(opcode)
+			if err != nil {
+				return err
+			}
+			if err = handler(dbIndex, string(key), entity, expiration); err != nil {
+				return err
+			}
+			expiration = nil
+		}
+	}
+}
+
+// 模块数据中每个值前面的类型
+const (
+	moduleOpcodeEOF    = 0
+	moduleOpcodeSInt   = 1
+	moduleOpcodeUInt   = 2
+	moduleOpcodeFloat  = 3
+	moduleOpcodeDouble = 4
+	moduleOpcodeString = 5
+)
+
+// skipModuleAux 跳过模块的辅助数据：模块 ID | when_opcode | when | 值 ... | EOF
+func (dec *Decoder) skipModuleAux() error {
+	for i := 0; i < 3; i++ {
+		if _, err := dec.readLength(); err != nil {
+			return err
+		}
+	}
+	for {
+		opcode, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case moduleOpcodeEOF:
+			return nil
+		case moduleOpcodeSInt, moduleOpcodeUInt:
+			_, err = dec.readLength()
+		case moduleOpcodeFloat:
+			_, err = dec.readFull(4)
+		case moduleOpcodeDouble:
+			_, err = dec.readFull(8)
+		case moduleOpcodeString:
+			_, err = dec.readString()
+		default:
+			return ErrInvalidFormat
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// verifyChecksum 版本 5 开始文件末尾有 8 字节的校验和，为 0 表示没有计算校验和
+func (dec *Decoder) verifyChecksum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	expected := dec.crc
+	buf, err := dec.readFull(8)
+	if err != nil {
+		return err
+	}
+	checksum := binary.LittleEndian.Uint64(buf)
+	if checksum != 0 && checksum != expected {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func (dec *Decoder) readObject(valueType byte) (*database.DataEntity, error) {
+	var data interface{}
+	var err error
+	switch valueType {
+	case typeString:
+		data, err = dec.readString()
+	case typeList:
+		data, err = dec.readList()
+	case typeListZiplist:
+		data, err = dec.readZiplistList()
+	case typeListQuicklist, typeListQuicklist2:
+		data, err = dec.readQuicklist(valueType)
+	case typeSet:
+		data, err = dec.readSet()
+	case typeSetIntset, typeSetListpack:
+		data, err = dec.readPackedSet(valueType)
+	case typeZSet, typeZSet2:
+		data, err = dec.readZSet(valueType)
+	case typeZSetZiplist, typeZSetListpack:
+		data, err = dec.readPackedZSet(valueType)
+	case typeHash:
+		data, err = dec.readHash()
+	case typeHashZiplist, typeHashListpack:
+		data, err = dec.readPackedHash(valueType)
+	case typeStreamListpacks, typeStreamListpk2, typeStreamListpk3:
+		data, err = dec.readStream(valueType)
+	default:
+		return nil, fmt.Errorf("unknown RDB type %d", valueType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &database.DataEntity{Data: data}, nil
+}
+
+func (dec *Decoder) readList() (List.List, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for i := uint64(0); i < size; i++ {
+		val, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		list.PushBack(val)
+	}
+	return list, nil
+}
+
+func (dec *Decoder) readSet() (*set.Set, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	s := set.Make()
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		s.Add(string(member))
+	}
+	return s, nil
+}
+
+func (dec *Decoder) readZSet(valueType byte) (*SortedSet.SortedSet, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	zset := SortedSet.Make()
+	for i := uint64(0); i < size; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if valueType == typeZSet2 {
+			score, err = dec.readBinaryDouble()
+		} else {
+			score, err = dec.readStringDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		zset.Add(string(member), score)
+	}
+	return zset, nil
+}
+
+func (dec *Decoder) readHash() (*Hash.Hash, error) {
+	size, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := Hash.MakeHash()
+	for i := uint64(0); i < size; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Set(string(field), value)
+	}
+	return hash, nil
+}
+
+func (dec *Decoder) readZiplistList() (List.List, error) {
+	elements, err := dec.readPacked(false)
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for _, element := range elements {
+		list.PushBack(element)
+	}
+	return list, nil
+}
+
+// readQuicklist 读取 quicklist，每个节点是一个 ziplist
+// quicklist 2 的每个节点前面有节点的类型，PACKED 节点是 listpack，PLAIN 节点是单个元素
+func (dec *Decoder) readQuicklist(valueType byte) (List.List, error) {
+	nodes, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList()
+	for i := uint64(0); i < nodes; i++ {
+		container := uint64(quicklistNodePacked)
+		if valueType == typeListQuicklist2 {
+			if container, err = dec.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var elements [][]byte
+		switch {
+		case container == quicklistNodePlain:
+			elements = [][]byte{buf}
+		case container != quicklistNodePacked:
+			return nil, ErrInvalidFormat
+		case valueType == typeListQuicklist2:
+			elements, err = parseListpack(buf)
+		default:
+			elements, err = parseZiplist(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			list.PushBack(element)
+		}
+	}
+	return list, nil
+}
+
+func (dec *Decoder) readPackedSet(valueType byte) (*set.Set, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	var members [][]byte
+	if valueType == typeSetIntset {
+		members, err = parseIntset(buf)
+	} else {
+		members, err = parseListpack(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := set.Make()
+	for _, member := range members {
+		s.Add(string(member))
+	}
+	return s, nil
+}
+
+// readPackedZSet 有序集合的 ziplist 和 listpack 中成员和分数交替保存，分数是字符串或者整数
+func (dec *Decoder) readPackedZSet(valueType byte) (*SortedSet.SortedSet, error) {
+	elements, err := dec.readPacked(valueType == typeZSetListpack)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements)%2 != 0 {
+		return nil, ErrInvalidFormat
+	}
+	zset := SortedSet.Make()
+	for i := 0; i < len(elements); i += 2 {
+		score, err := strconv.ParseFloat(string(elements[i+1]), 64)
+		if err != nil {
+			return nil, ErrInvalidFormat
+		}
+		zset.Add(string(elements[i]), score)
+	}
+	return zset, nil
+}
+
+// readPackedHash 哈希表的 ziplist 和 listpack 中字段和值交替保存
+func (dec *Decoder) readPackedHash(valueType byte) (*Hash.Hash, error) {
+	elements, err := dec.readPacked(valueType == typeHashListpack)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements)%2 != 0 {
+		return nil, ErrInvalidFormat
+	}
+	hash := Hash.MakeHash()
+	for i := 0; i < len(elements); i += 2 {
+		hash.Set(string(elements[i]), elements[i+1])
+	}
+	return hash, nil
+}
+
+// readPacked 读取一个字符串并解析成 listpack 或者 ziplist
+func (dec *Decoder) readPacked(isListpack bool) ([][]byte, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	if isListpack {
+		return parseListpack(buf)
+	}
+	return parseZiplist(buf)
+}
+
+// 一次分配的最大内存，损坏的文件可能给出很大的长度，更长的字符串边读边分配
+const maxPreallocSize = 1 << 20
+
+func (dec *Decoder) readFull(n int) ([]byte, error) {
+	var buf []byte
+	var err error
+	if n <= maxPreallocSize {
+		buf = make([]byte, n)
+		_, err = io.ReadFull(dec.r, buf)
+	} else {
+		b := &bytes.Buffer{}
+		_, err = io.CopyN(b, dec.r, int64(n))
+		buf = b.Bytes()
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	dec.crc = crc64Update(dec.crc, buf)
+	return buf, nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	b, err := dec.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	dec.buf[0] = b
+	dec.crc = crc64Update(dec.crc, dec.buf[:1])
+	return b, nil
+}
+
+func (dec *Decoder) readUint64() (uint64, error) {
+	buf, err := dec.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// readLengthOrEncoding 读取长度，encoded 为 true 时返回的是特殊编码的字符串的编码方式
+func (dec *Decoder) readLengthOrEncoding() (length uint64, encoded bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3F), false, nil
+	case len14Bit:
+		second, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(second), false, nil
+	case lenEncVal:
+		return uint64(first & 0x3F), true, nil
+	}
+	switch first {
+	case len32Bit:
+		buf, err := dec.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case len64Bit:
+		buf, err := dec.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, ErrInvalidFormat
+}
+
+func (dec *Decoder) readLength() (uint64, error) {
+	length, encoded, err := dec.readLengthOrEncoding()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, ErrInvalidFormat
+	}
+	return length, nil
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, encoded, err := dec.readLengthOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if length > math.MaxInt32 {
+			return nil, ErrInvalidFormat
+		}
+		return dec.readFull(int(length))
+	}
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return formatInt(int64(int8(b))), nil
+	case encInt16:
+		buf, err := dec.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		return formatInt(int64(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case encInt32:
+		buf, err := dec.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		return formatInt(int64(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case encLZF:
+		compressedLen, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if compressedLen > math.MaxInt32 || rawLen > math.MaxInt32 {
+			return nil, ErrInvalidFormat
+		}
+		compressed, err := dec.readFull(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("unknown RDB string encoding %d", length)
+}
+
+func (dec *Decoder) readBinaryDouble() (float64, error) {
+	u, err := dec.readUint64()
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(u), nil
+}
+
+// readStringDouble 旧版本的有序集合用字符串保存分数，253、254、255 分别表示 nan、+inf、-inf
+func (dec *Decoder) readStringDouble() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := dec.readFull(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (dec *Decoder) readMillisecondTime() (time.Time, error) {
+	ms, err := dec.readUint64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return msToTime(int64(ms)), nil
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+}
@@ -0,0 +1,236 @@
+// Package rdb -----------------------------
+// @file      : encoder.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 10:50
+// -------------------------------------------
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	Hash "redis-go/datastruct/hash"
+	List "redis-go/datastruct/list"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
+	"strconv"
+	"time"
+)
+
+// 长度的编码：
+// 00xxxxxx 6 位长度
+// 01xxxxxx xxxxxxxx 14 位长度，大端
+// 10000000 后面 4 个字节的大端长度
+// 10000001 后面 8 个字节的大端长度
+// 11xxxxxx 特殊编码的字符串，后 6 位是编码方式，整数都是小端
+// 11000011 LZF 压缩的字符串，后面是压缩之后的长度、原始长度和压缩的数据
+// 值的类型都用 Redis 能够直接加载的最简单的编码：列表、集合、哈希表逐个写入元素，有序集合的分数是 8 字节的小端浮点数
+
+// ErrUnknownType 不支持持久化的数据类型
+var ErrUnknownType = errors.New("unknown data type")
+
+// Encoder 写入 RDB 文件，同时计算校验和
+// 写入出错之后忽略后续的写入，每个方法都返回第一次出错的错误
+type Encoder struct {
+	w   io.Writer
+	crc uint64
+	err error
+	buf [9]byte
+}
+
+// NewEncoder 创建 Encoder
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (enc *Encoder) write(p []byte) {
+	if enc.err != nil {
+		return
+	}
+	enc.crc = crc64Update(enc.crc, p)
+	_, enc.err = enc.w.Write(p)
+}
+
+func (enc *Encoder) writeByte(b byte) {
+	enc.buf[0] = b
+	enc.write(enc.buf[:1])
+}
+
+// WriteRaw 写入另一个 Encoder 已经编码好的数据
+func (enc *Encoder) WriteRaw(p []byte) error {
+	enc.write(p)
+	return enc.err
+}
+
+// WriteHeader 写入文件头 REDIS + 4 位版本号
+func (enc *Encoder) WriteHeader() error {
+	enc.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+	return enc.err
+}
+
+// WriteAux 写入辅助字段，如 redis-ver、ctime
+func (enc *Encoder) WriteAux(key string, value string) error {
+	enc.writeByte(opcodeAux)
+	enc.writeString([]byte(key))
+	enc.writeString([]byte(value))
+	return enc.err
+}
+
+// WriteSelectDB 之后的 key 都属于这个 db
+func (enc *Encoder) WriteSelectDB(index int) error {
+	enc.writeByte(opcodeSelectDB)
+	enc.writeLength(uint64(index))
+	return enc.err
+}
+
+// WriteEnd 写入结束标记和校验和，校验和本身不参与计算
+func (enc *Encoder) WriteEnd() error {
+	enc.writeByte(opcodeEOF)
+	if enc.err != nil {
+		return enc.err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:8], enc.crc)
+	_, enc.err = enc.w.Write(enc.buf[:8])
+	return enc.err
+}
+
+// WriteEntry 写入一个 key，expiration 为 nil 表示没有过期时间
+func (enc *Encoder) WriteEntry(key string, entity *database.DataEntity, expiration *time.Time) error {
+	if expiration != nil {
+		enc.writeByte(opcodeExpireTimeMs)
+		enc.writeMillisecondTime(*expiration)
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		enc.writeByte(typeString)
+		enc.writeString([]byte(key))
+		enc.writeString(val)
+	case List.List:
+		enc.writeByte(typeList)
+		enc.writeString([]byte(key))
+		enc.writeLength(uint64(val.Len()))
+		val.ForEach(func(i int, v []byte) bool {
+			enc.writeString(v)
+			return enc.err == nil
+		})
+	case *set.Set:
+		enc.writeByte(typeSet)
+		enc.writeString([]byte(key))
+		enc.writeLength(uint64(val.Len()))
+		val.ForEach(func(member string) bool {
+			enc.writeString([]byte(member))
+			return enc.err == nil
+		})
+	case *Hash.Hash:
+		enc.writeByte(typeHash)
+		enc.writeString([]byte(key))
+		enc.writeLength(uint64(val.Len()))
+		val.ForEach(func(field string, value []byte) bool {
+			enc.writeString([]byte(field))
+			enc.writeString(value)
+			return enc.err == nil
+		})
+	case *SortedSet.SortedSet:
+		enc.writeByte(typeZSet2)
+		enc.writeString([]byte(key))
+		enc.writeLength(uint64(val.Len()))
+		if val.Len() > 0 {
+			val.ForEachByRank(0, val.Len(), false, func(element *SortedSet.Element) bool {
+				enc.writeString([]byte(element.Member))
+				enc.writeBinaryDouble(element.Score)
+				return enc.err == nil
+			})
+		}
+	case *stream.Stream:
+		enc.writeByte(typeStreamListpk3)
+		enc.writeString([]byte(key))
+		enc.writeStream(val)
+	default:
+		return ErrUnknownType
+	}
+	return enc.err
+}
+
+func (enc *Encoder) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		enc.writeByte(byte(length))
+	case length < 1<<14:
+		enc.buf[0] = byte(length>>8) | len14Bit<<6
+		enc.buf[1] = byte(length)
+		enc.write(enc.buf[:2])
+	case length <= math.MaxUint32:
+		enc.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(enc.buf[1:5], uint32(length))
+		enc.write(enc.buf[:5])
+	default:
+		enc.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(enc.buf[1:9], length)
+		enc.write(enc.buf[:9])
+	}
+}
+
+// writeString 和 Redis 一样，能表示为 32 位整数的短字符串用整数编码
+func (enc *Encoder) writeString(s []byte) {
+	if len(s) <= 11 && enc.writeIntString(s) {
+		return
+	}
+	enc.writeRawString(s)
+}
+
+// writeRawString 不尝试整数编码的字符串，和 Redis 开启 rdbcompression 时一样，超过 20 个字节时尝试 LZF 压缩
+func (enc *Encoder) writeRawString(s []byte) {
+	if len(s) > 20 {
+		// 至少节省 4 个字节才压缩
+		if compressed := lzfCompress(s, len(s)-4); compressed != nil {
+			enc.writeByte(lenEncVal<<6 | encLZF)
+			enc.writeLength(uint64(len(compressed)))
+			enc.writeLength(uint64(len(s)))
+			enc.write(compressed)
+			return
+		}
+	}
+	enc.writeLength(uint64(len(s)))
+	enc.write(s)
+}
+
+func (enc *Encoder) writeIntString(s []byte) bool {
+	value, err := strconv.ParseInt(string(s), 10, 64)
+	// 不能还原成原来的字符串时不能用整数编码，如 "01"、"+1"
+	if err != nil || strconv.FormatInt(value, 10) != string(s) {
+		return false
+	}
+	switch {
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		enc.buf[0] = lenEncVal<<6 | encInt8
+		enc.buf[1] = byte(value)
+		enc.write(enc.buf[:2])
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		enc.buf[0] = lenEncVal<<6 | encInt16
+		binary.LittleEndian.PutUint16(enc.buf[1:3], uint16(value))
+		enc.write(enc.buf[:3])
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		enc.buf[0] = lenEncVal<<6 | encInt32
+		binary.LittleEndian.PutUint32(enc.buf[1:5], uint32(value))
+		enc.write(enc.buf[:5])
+	default:
+		return false
+	}
+	return true
+}
+
+func (enc *Encoder) writeBinaryDouble(f float64) {
+	binary.LittleEndian.PutUint64(enc.buf[:8], math.Float64bits(f))
+	enc.write(enc.buf[:8])
+}
+
+// writeMillisecondTime 8 字节小端的毫秒时间戳
+func (enc *Encoder) writeMillisecondTime(t time.Time) {
+	binary.LittleEndian.PutUint64(enc.buf[:8], uint64(t.UnixNano()/int64(time.Millisecond)))
+	enc.write(enc.buf[:8])
+}
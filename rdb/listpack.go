@@ -0,0 +1,220 @@
+// Package rdb -----------------------------
+// @file      : listpack.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 11:30
+// -------------------------------------------
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// listpack，Redis 用来紧凑地存储一组字符串或者整数，stream 的节点保存为 listpack
+// 总字节数（4 字节小端）| 元素个数（2 字节小端）| 元素 ... | 0xFF
+// 每个元素是 编码 + 数据 + backlen，backlen 是前两部分的长度，用于从后往前遍历
+// 参考 Redis 的 listpack.c
+
+const (
+	lpHeaderSize = 6
+	lpEOF        = 0xFF
+	// 元素个数超过 65535 时只能遍历得到
+	lpNumElementsUnknown = 65535
+)
+
+// listpackBuilder 逐个追加元素生成 listpack
+type listpackBuilder struct {
+	buf   []byte
+	count int
+}
+
+func newListpackBuilder() *listpackBuilder {
+	return &listpackBuilder{
+		buf: make([]byte, lpHeaderSize, 64),
+	}
+}
+
+// appendString 和 Redis 的 lpAppend 一样，能表示为整数的字符串用整数编码
+func (lp *listpackBuilder) appendString(s []byte) {
+	if len(s) <= 20 {
+		if value, err := strconv.ParseInt(string(s), 10, 64); err == nil && strconv.FormatInt(value, 10) == string(s) {
+			lp.appendInt(value)
+			return
+		}
+	}
+	start := len(lp.buf)
+	size := len(s)
+	switch {
+	case size < 1<<6:
+		lp.buf = append(lp.buf, 0x80|byte(size))
+	case size < 1<<12:
+		lp.buf = append(lp.buf, 0xE0|byte(size>>8), byte(size))
+	default:
+		lp.buf = append(lp.buf, 0xF0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(lp.buf[len(lp.buf)-4:], uint32(size))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.finishElement(start)
+}
+
+func (lp *listpackBuilder) appendInt(value int64) {
+	start := len(lp.buf)
+	switch {
+	case value >= 0 && value <= 127:
+		lp.buf = append(lp.buf, byte(value))
+	case value >= -4096 && value <= 4095:
+		u := uint64(value) & (1<<13 - 1)
+		lp.buf = append(lp.buf, 0xC0|byte(u>>8), byte(u))
+	case value >= -32768 && value <= 32767:
+		lp.buf = append(lp.buf, 0xF1, byte(value), byte(value>>8))
+	case value >= -8388608 && value <= 8388607:
+		lp.buf = append(lp.buf, 0xF2, byte(value), byte(value>>8), byte(value>>16))
+	case value >= -2147483648 && value <= 2147483647:
+		lp.buf = append(lp.buf, 0xF3, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(lp.buf[len(lp.buf)-4:], uint32(value))
+	default:
+		lp.buf = append(lp.buf, 0xF4, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(lp.buf[len(lp.buf)-8:], uint64(value))
+	}
+	lp.finishElement(start)
+}
+
+// finishElement 追加 backlen
+func (lp *listpackBuilder) finishElement(start int) {
+	lp.buf = append(lp.buf, encodeBacklen(len(lp.buf)-start)...)
+	lp.count++
+}
+
+// bytes 写入头部和结束标记，返回完整的 listpack
+func (lp *listpackBuilder) bytes() []byte {
+	lp.buf = append(lp.buf, lpEOF)
+	binary.LittleEndian.PutUint32(lp.buf[0:4], uint32(len(lp.buf)))
+	count := lp.count
+	if count > lpNumElementsUnknown {
+		count = lpNumElementsUnknown
+	}
+	binary.LittleEndian.PutUint16(lp.buf[4:6], uint16(count))
+	return lp.buf
+}
+
+// encodeBacklen 每个字节存 7 位，第一个字节之外的字节最高位为 1，从后往前读时知道是否还有更多的字节
+func encodeBacklen(size int) []byte {
+	n := backlenSize(size)
+	result := make([]byte, n)
+	for i := 0; i < n; i++ {
+		b := byte(size>>(7*uint(n-1-i))) & 127
+		if i > 0 {
+			b |= 128
+		}
+		result[i] = b
+	}
+	return result
+}
+
+// backlenSize backlen 占用的字节数，边界和 Redis 的 lpEncodeBacklen 保持一致
+func backlenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseListpack 读取 listpack 中所有的元素，整数转换成十进制字符串
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < lpHeaderSize+1 || int(binary.LittleEndian.Uint32(buf[0:4])) != len(buf) {
+		return nil, ErrInvalidFormat
+	}
+	result := make([][]byte, 0, binary.LittleEndian.Uint16(buf[4:6]))
+	pos := lpHeaderSize
+	for {
+		if pos >= len(buf) {
+			return nil, ErrInvalidFormat
+		}
+		if buf[pos] == lpEOF {
+			break
+		}
+		element, size, ok := parseListpackElement(buf[pos:])
+		if !ok {
+			return nil, ErrInvalidFormat
+		}
+		result = append(result, element)
+		// 跳过 backlen
+		pos += size + backlenSize(size)
+	}
+	return result, nil
+}
+
+// parseListpackElement 解析一个元素，返回元素和编码加数据的长度
+func parseListpackElement(buf []byte) ([]byte, int, bool) {
+	first := buf[0]
+	var value int64
+	var size int
+	switch {
+	case first&0x80 == 0:
+		return formatInt(int64(first & 0x7F)), 1, true
+	case first&0xC0 == 0x80:
+		return lpString(buf, 1, int(first&0x3F))
+	case first&0xE0 == 0xC0:
+		if len(buf) < 2 {
+			return nil, 0, false
+		}
+		u := uint64(first&0x1F)<<8 | uint64(buf[1])
+		value, size = signExtend(u, 13), 2
+	case first&0xF0 == 0xE0:
+		if len(buf) < 2 {
+			return nil, 0, false
+		}
+		return lpString(buf, 2, int(first&0x0F)<<8|int(buf[1]))
+	case first == 0xF0:
+		if len(buf) < 5 {
+			return nil, 0, false
+		}
+		return lpString(buf, 5, int(binary.LittleEndian.Uint32(buf[1:5])))
+	case first >= 0xF1 && first <= 0xF4:
+		width := int(first-0xF1) + 2
+		if first == 0xF4 {
+			width = 8
+		}
+		if len(buf) < 1+width {
+			return nil, 0, false
+		}
+		var u uint64
+		for i := width; i >= 1; i-- {
+			u = u<<8 | uint64(buf[i])
+		}
+		value, size = signExtend(u, uint(width*8)), 1+width
+	default:
+		return nil, 0, false
+	}
+	return formatInt(value), size, true
+}
+
+// lpString 编码占 headerSize 个字节，之后是 length 个字节的字符串
+func lpString(buf []byte, headerSize int, length int) ([]byte, int, bool) {
+	if length < 0 || len(buf) < headerSize+length {
+		return nil, 0, false
+	}
+	s := make([]byte, length)
+	copy(s, buf[headerSize:headerSize+length])
+	return s, headerSize + length, true
+}
+
+// signExtend 把 bits 位的补码扩展成 int64
+func signExtend(u uint64, bits uint) int64 {
+	if bits < 64 && u&(1<<(bits-1)) != 0 {
+		return int64(u) - int64(1)<<bits
+	}
+	return int64(u)
+}
+
+func formatInt(value int64) []byte {
+	return []byte(strconv.FormatInt(value, 10))
+}
@@ -0,0 +1,129 @@
+// Package rdb -----------------------------
+// @file      : lzf.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/15 10:10
+// -------------------------------------------
+package rdb
+
+// LZF 压缩，和 Redis 使用的 liblzf 格式一样
+// 控制字节小于 32 时后面是 ctrl+1 个字面字节
+// 否则是回溯引用：高 3 位是长度减 2（为 7 时再读一个字节加上去），低 5 位和下一个字节是距离减 1
+
+const (
+	lzfMaxLiteral = 1 << 5
+	lzfMaxOffset  = 1 << 13
+	lzfMaxRef     = (1 << 8) + (1 << 3)
+	lzfHashLog    = 14
+)
+
+// lzfCompress 压缩 in，压缩之后不小于 maxSize 时返回 nil
+func lzfCompress(in []byte, maxSize int) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+	table := make([]int, 1<<lzfHashLog)
+	out := make([]byte, 0, maxSize)
+	// 当前字面量段的控制字节的位置
+	literalStart := -1
+	emitLiteral := func(b byte) {
+		if literalStart < 0 {
+			literalStart = len(out)
+			out = append(out, 0)
+		} else {
+			out[literalStart]++
+		}
+		out = append(out, b)
+		if out[literalStart] == lzfMaxLiteral-1 {
+			literalStart = -1
+		}
+	}
+	hash := func(i int) int {
+		v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+		return int((v * 2654435761) >> (32 - lzfHashLog))
+	}
+
+	i := 0
+	for i < len(in)-2 {
+		if len(out) >= maxSize {
+			return nil
+		}
+		h := hash(i)
+		// table 中存的是位置加 1，0 表示没有
+		ref := table[h] - 1
+		table[h] = i + 1
+		offset := i - ref - 1
+		if ref < 0 || offset >= lzfMaxOffset || in[ref] != in[i] || in[ref+1] != in[i+1] || in[ref+2] != in[i+2] {
+			emitLiteral(in[i])
+			i++
+			continue
+		}
+		length := 3
+		maxLength := len(in) - i
+		if maxLength > lzfMaxRef {
+			maxLength = lzfMaxRef
+		}
+		for length < maxLength && in[ref+length] == in[i+length] {
+			length++
+		}
+		literalStart = -1
+		length -= 2
+		if length < 7 {
+			out = append(out, byte(offset>>8)|byte(length<<5))
+		} else {
+			out = append(out, byte(offset>>8)|7<<5, byte(length-7))
+		}
+		out = append(out, byte(offset))
+		i += length + 2
+	}
+	for ; i < len(in); i++ {
+		emitLiteral(in[i])
+	}
+	if len(out) >= maxSize {
+		return nil
+	}
+	return out
+}
+
+// lzfDecompress 解压缩，解压之后的长度必须是 size
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLiteral {
+			length := ctrl + 1
+			if i+length > len(in) || len(out)+length > size {
+				return nil, ErrInvalidFormat
+			}
+			out = append(out, in[i:i+length]...)
+			i += length
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, ErrInvalidFormat
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, ErrInvalidFormat
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > size {
+			return nil, ErrInvalidFormat
+		}
+		// 引用的区域可能和要写入的区域重叠，逐个字节复制
+		for j := 0; j < length; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, ErrInvalidFormat
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+// Package rdb -----------------------------
+// @file      : rdb.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 10:20
+// -------------------------------------------
+package rdb
+
+import (
+	"errors"
+	"redis-go/interface/database"
+	"time"
+)
+
+// RDB 快照文件，格式和 Redis 7.2 一样，参考 Redis 的 rdb.h
+// "REDIS0011" | AUX ... | SELECTDB db | [EXPIRETIME_MS ms] type key value ... | EOF | CRC64
+// 长度、字符串、浮点数的编码见 encoder.go
+// 写入的文件 Redis 7.2 可以直接加载，读取时支持 Redis 6.x 到 7.2 生成的版本 9 到 11 的文件
+
+// Version 写入的 RDB 版本
+const Version = 11
+
+// 读取时支持的最低版本
+const minVersion = 1
+
+// 操作码，类型之外的特殊记录，模块和函数的数据读取时跳过
+const (
+	opcodeFunction2    = 245
+	opcodeModuleAux    = 247
+	opcodeIdle         = 248
+	opcodeFreq         = 249
+	opcodeAux          = 250
+	opcodeResizeDB     = 251
+	opcodeExpireTimeMs = 252
+	opcodeExpireTime   = 253
+	opcodeSelectDB     = 254
+	opcodeEOF          = 255
+)
+
+// 值的类型，写入时只用 string、list、set、hash、zset2 和 stream_listpacks_3
+// 其他的是 Redis 的紧凑编码，读取时转换成对应的数据结构
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZSet            = 3
+	typeHash            = 4
+	typeZSet2           = 5
+	typeListZiplist     = 10
+	typeSetIntset       = 11
+	typeZSetZiplist     = 12
+	typeHashZiplist     = 13
+	typeListQuicklist   = 14
+	typeStreamListpacks = 15
+	typeHashListpack    = 16
+	typeZSetListpack    = 17
+	typeListQuicklist2  = 18
+	typeStreamListpk2   = 19
+	typeSetListpack     = 20
+	typeStreamListpk3   = 21
+)
+
+// quicklist 2 中节点的类型，PLAIN 节点是一个很大的元素，PACKED 节点是一个 listpack
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// 长度编码的前两位
+const (
+	len6Bit   = 0
+	len14Bit  = 1
+	len32Bit  = 0x80
+	len64Bit  = 0x81
+	lenEncVal = 3
+)
+
+// 特殊编码的字符串，长度的前两位为 11 时后六位表示编码方式
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+var (
+	// ErrInvalidFormat 不是 RDB 文件或者文件已经损坏
+	ErrInvalidFormat = errors.New("invalid rdb format")
+	// ErrChecksum 校验和不一致
+	ErrChecksum = errors.New("wrong rdb checksum")
+)
+
+// EntryHandler 读取到一个 key 时调用，expiration 为 nil 表示没有过期时间
+type EntryHandler func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) error
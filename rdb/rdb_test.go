@@ -0,0 +1,235 @@
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	Hash "redis-go/datastruct/hash"
+	List "redis-go/datastruct/list"
+	"redis-go/datastruct/set"
+	SortedSet "redis-go/datastruct/sortedset"
+	"redis-go/datastruct/stream"
+	"redis-go/interface/database"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC64(t *testing.T) {
+	// Redis 的 crc64 测试用例
+	if crc := crc64Update(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("unexpected crc %x", crc)
+	}
+}
+
+func TestListpack(t *testing.T) {
+	values := []string{"0", "127", "128", "-1", "-4096", "4095", "4096", "-32768", "32767", "32768",
+		"-8388608", "8388607", "8388608", "-2147483648", "2147483647", "2147483648",
+		strconv.FormatInt(math.MaxInt64, 10), strconv.FormatInt(math.MinInt64, 10),
+		"", "01", "+1", "a", strings.Repeat("b", 63), strings.Repeat("c", 64), strings.Repeat("d", 4095),
+		strings.Repeat("e", 4096), strings.Repeat("f", 20000)}
+	lp := newListpackBuilder()
+	for _, value := range values {
+		lp.appendString([]byte(value))
+	}
+	elements, err := parseListpack(lp.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != len(values) {
+		t.Fatalf("expected %d elements, actually %d", len(values), len(elements))
+	}
+	for i, value := range values {
+		if string(elements[i]) != value {
+			t.Errorf("expected %q, actually %q", value, elements[i])
+		}
+	}
+}
+
+// entry 测试用的一个 key
+type entry struct {
+	dbIndex    int
+	key        string
+	entity     *database.DataEntity
+	expiration *time.Time
+}
+
+func makeEntries() []*entry {
+	list := List.NewQuickList()
+	for i := 0; i < 1000; i++ {
+		list.PushBack([]byte(strconv.Itoa(i - 500)))
+	}
+	list.PushBack([]byte("x"))
+	intSet := set.Make("1", "-2", "300000")
+	strSet := set.Make("a", "b", "10")
+	hash := Hash.MakeHash()
+	hash.Set("f1", []byte("v1"))
+	hash.Set("f2", []byte(strings.Repeat("v", 100)))
+	zset := SortedSet.Make()
+	zset.Add("a", 1.5)
+	zset.Add("b", math.Inf(1))
+	zset.Add("c", math.Inf(-1))
+	zset.Add("d", -0.1)
+
+	s := stream.Make()
+	for i := 1; i <= 250; i++ {
+		fields := [][]byte{[]byte("f"), []byte(strconv.Itoa(i))}
+		if i%7 == 0 {
+			fields = [][]byte{[]byte("g"), []byte("x"), []byte("h"), []byte("-12345")}
+		}
+		_ = s.Add(stream.ID{Ms: uint64(1000 + i/3), Seq: uint64(i % 3)}, fields)
+	}
+	s.Delete(stream.ID{Ms: 1001, Seq: 0})
+	s.SetMaxDeletedID(stream.ID{Ms: 1001, Seq: 0})
+	now := time.Unix(1700000000, 123000000)
+	group, _ := s.CreateGroup("g1", stream.ID{Ms: 1010, Seq: 1}, 30)
+	c1, _ := group.CreateConsumer("c1", now)
+	c1.ActiveTime = now.Add(time.Second)
+	group.Deliver(stream.ID{Ms: 1002, Seq: 1}, c1, now).DeliveryCount = 3
+	group.Deliver(stream.ID{Ms: 1003, Seq: 0}, c1, now.Add(time.Minute))
+	c2, _ := group.CreateConsumer("c2", now)
+	group.Deliver(stream.ID{Ms: 1004, Seq: 2}, c2, now)
+	group.CreateConsumer("idle", now)
+	s.CreateGroup("g2", stream.MinID, stream.InvalidEntriesRead)
+
+	empty := stream.Make()
+	empty.SetLastID(stream.ID{Ms: 5, Seq: 5})
+	empty.SetEntriesAdded(3)
+
+	expireAt := time.Unix(4000000000, 456000000)
+	return []*entry{
+		{0, "str", &database.DataEntity{Data: []byte("hello")}, nil},
+		{0, "int", &database.DataEntity{Data: []byte("-123456")}, &expireAt},
+		{0, "bigint", &database.DataEntity{Data: []byte("12345678901234")}, nil},
+		{0, "long", &database.DataEntity{Data: bytes.Repeat([]byte("abc"), 10000)}, nil},
+		{0, "list", &database.DataEntity{Data: list}, nil},
+		{0, "intset", &database.DataEntity{Data: intSet}, nil},
+		{0, "set", &database.DataEntity{Data: strSet}, nil},
+		{3, "hash", &database.DataEntity{Data: hash}, &expireAt},
+		{3, "zset", &database.DataEntity{Data: zset}, nil},
+		{3, "stream", &database.DataEntity{Data: s}, nil},
+		{3, "empty", &database.DataEntity{Data: empty}, nil},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := makeEntries()
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	_ = enc.WriteHeader()
+	_ = enc.WriteAux("redis-ver", "7.2.0")
+	dbIndex := -1
+	for _, e := range entries {
+		if e.dbIndex != dbIndex {
+			_ = enc.WriteSelectDB(e.dbIndex)
+			dbIndex = e.dbIndex
+		}
+		if err := enc.WriteEntry(e.key, e.entity, e.expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	i := 0
+	err := NewDecoder(bytes.NewReader(data)).Decode(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) error {
+		expected := entries[i]
+		i++
+		if dbIndex != expected.dbIndex || key != expected.key {
+			return fmt.Errorf("expected %d %s, actually %d %s", expected.dbIndex, expected.key, dbIndex, key)
+		}
+		if (expiration == nil) != (expected.expiration == nil) || (expiration != nil && !expiration.Equal(*expected.expiration)) {
+			return fmt.Errorf("%s: expected expiration %v, actually %v", key, expected.expiration, expiration)
+		}
+		if dumpEntity(entity) != dumpEntity(expected.entity) {
+			return fmt.Errorf("%s: expected %s, actually %s", key, dumpEntity(expected.entity), dumpEntity(entity))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(entries) {
+		t.Errorf("expected %d keys, actually %d", len(entries), i)
+	}
+
+	// 修改任意一个字节都会导致校验和不一致
+	data[len(data)/2] ^= 0xFF
+	err = NewDecoder(bytes.NewReader(data)).Decode(func(int, string, *database.DataEntity, *time.Time) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	_ = enc.WriteHeader()
+	_ = enc.WriteEntry("k", &database.DataEntity{Data: []byte("v")}, nil)
+	_ = enc.WriteEnd()
+	data := buf.Bytes()
+	for i := 0; i < len(data); i++ {
+		err := NewDecoder(bytes.NewReader(data[:i])).Decode(func(int, string, *database.DataEntity, *time.Time) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("expected error when truncated at %d", i)
+		}
+	}
+}
+
+// dumpEntity 把数据转换成和遍历顺序无关的字符串，用于比较
+func dumpEntity(entity *database.DataEntity) string {
+	builder := &strings.Builder{}
+	switch val := entity.Data.(type) {
+	case []byte:
+		builder.WriteString("string " + string(val))
+	case List.List:
+		builder.WriteString("list")
+		val.ForEach(func(i int, v []byte) bool {
+			builder.WriteString(" " + string(v))
+			return true
+		})
+	case *set.Set:
+		members := val.Members()
+		sort.Strings(members)
+		builder.WriteString("set " + strings.Join(members, " "))
+	case *Hash.Hash:
+		fields := make([]string, 0)
+		val.ForEach(func(field string, value []byte) bool {
+			fields = append(fields, field+"="+string(value))
+			return true
+		})
+		sort.Strings(fields)
+		builder.WriteString("hash " + strings.Join(fields, " "))
+	case *SortedSet.SortedSet:
+		builder.WriteString("zset")
+		val.ForEachByRank(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			builder.WriteString(fmt.Sprintf(" %s=%v", element.Member, element.Score))
+			return true
+		})
+	case *stream.Stream:
+		builder.WriteString(fmt.Sprintf("stream len=%d last=%s maxdel=%s added=%d", val.Len(), val.LastID(), val.MaxDeletedID(), val.EntriesAdded()))
+		val.ForEach(func(entry *stream.Entry) bool {
+			builder.WriteString(fmt.Sprintf(" %s%q", entry.ID, entry.Fields))
+			return true
+		})
+		for _, group := range val.Groups() {
+			builder.WriteString(fmt.Sprintf(" group %s last=%s read=%d", group.Name, group.LastID, group.EntriesRead))
+			for _, consumer := range group.Consumers() {
+				builder.WriteString(fmt.Sprintf(" consumer %s seen=%d active=%d", consumer.Name,
+					consumer.SeenTime.UnixNano(), consumer.ActiveTime.UnixNano()))
+				for _, pe := range consumer.Pending() {
+					builder.WriteString(fmt.Sprintf(" %s@%d*%d", pe.ID, pe.DeliveryTime.UnixNano(), pe.DeliveryCount))
+				}
+			}
+		}
+	}
+	return builder.String()
+}
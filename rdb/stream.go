@@ -0,0 +1,435 @@
+// Package rdb -----------------------------
+// @file      : stream.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/14 14:10
+// -------------------------------------------
+package rdb
+
+import (
+	"encoding/binary"
+	"math"
+	"redis-go/datastruct/stream"
+	"redis-go/lib/utils"
+	"strconv"
+	"time"
+)
+
+// stream 按 Redis 的 RDB_TYPE_STREAM_LISTPACKS_3 格式保存
+// 节点数量，每个节点是 16 字节的大端主 ID 和一个 listpack
+// 然后是长度、最后的 ID、第一个 ID、被删除的最大的 ID、添加过的消息总数
+// 最后是消费组：名称、最后投递的 ID、已经读取的数量、PEL（ID、投递时间、投递次数）、消费者（名称、两个时间、PEL 中的 ID）
+//
+// 节点的 listpack 中先是主记录：消息数 | 删除的消息数 | 字段数 | 字段 ... | 0
+// 然后是每条消息：flags | ms 差值 | seq 差值 | [字段数 | 字段 值 ...] 或者 [值 ...] | lp-count
+// 字段和主记录相同时只保存值，flags 带上 sameFields
+
+const (
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+	// 每个节点最多保存的消息数，和 Redis 的 stream-node-max-entries 默认值一样
+	streamNodeMaxEntries = 100
+)
+
+// 从来没有成功读取过的消费者 active_time 为 -1
+const neverActive = math.MaxUint64
+
+func (enc *Encoder) writeStream(s *stream.Stream) {
+	entries := make([]*stream.Entry, 0, s.Len())
+	s.ForEach(func(entry *stream.Entry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	nodes := (len(entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	enc.writeLength(uint64(nodes))
+	for i := 0; i < len(entries); i += streamNodeMaxEntries {
+		end := i + streamNodeMaxEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		enc.writeRawString(streamIDBytes(entries[i].ID))
+		enc.writeRawString(streamNode(entries[i:end]))
+	}
+	enc.writeLength(uint64(s.Len()))
+	enc.writeStreamID(s.LastID())
+	enc.writeStreamID(s.FirstID())
+	enc.writeStreamID(s.MaxDeletedID())
+	enc.writeLength(uint64(s.EntriesAdded()))
+
+	groups := s.Groups()
+	enc.writeLength(uint64(len(groups)))
+	for _, group := range groups {
+		enc.writeRawString([]byte(group.Name))
+		enc.writeStreamID(group.LastID)
+		enc.writeLength(uint64(group.EntriesRead))
+		pel := group.PendingRange(stream.MinID, stream.MaxID, 0)
+		enc.writeLength(uint64(len(pel)))
+		for _, pe := range pel {
+			enc.write(streamIDBytes(pe.ID))
+			enc.writeMillisecondTime(pe.DeliveryTime)
+			enc.writeLength(uint64(pe.DeliveryCount))
+		}
+		consumers := group.Consumers()
+		enc.writeLength(uint64(len(consumers)))
+		for _, consumer := range consumers {
+			enc.writeRawString([]byte(consumer.Name))
+			enc.writeMillisecondTime(consumer.SeenTime)
+			if consumer.ActiveTime.IsZero() {
+				binary.LittleEndian.PutUint64(enc.buf[:8], neverActive)
+				enc.write(enc.buf[:8])
+			} else {
+				enc.writeMillisecondTime(consumer.ActiveTime)
+			}
+			pending := consumer.Pending()
+			enc.writeLength(uint64(len(pending)))
+			for _, pe := range pending {
+				enc.write(streamIDBytes(pe.ID))
+			}
+		}
+	}
+}
+
+func (enc *Encoder) writeStreamID(id stream.ID) {
+	enc.writeLength(id.Ms)
+	enc.writeLength(id.Seq)
+}
+
+// streamIDBytes 16 字节的大端 ID，节点的 key 和 PEL 中的 ID 都是这个格式
+func streamIDBytes(id stream.ID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], id.Ms)
+	binary.BigEndian.PutUint64(buf[8:16], id.Seq)
+	return buf
+}
+
+func parseStreamIDBytes(buf []byte) (stream.ID, bool) {
+	if len(buf) != 16 {
+		return stream.ID{}, false
+	}
+	return stream.ID{
+		Ms:  binary.BigEndian.Uint64(buf[0:8]),
+		Seq: binary.BigEndian.Uint64(buf[8:16]),
+	}, true
+}
+
+// streamNode 把一组消息编码成节点的 listpack，第一条消息的字段作为主记录的字段
+func streamNode(entries []*stream.Entry) []byte {
+	master := entries[0]
+	lp := newListpackBuilder()
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(master.Fields) / 2))
+	for i := 0; i < len(master.Fields); i += 2 {
+		lp.appendString(master.Fields[i])
+	}
+	lp.appendInt(0)
+	for _, entry := range entries {
+		numFields := len(entry.Fields) / 2
+		sameFields := sameStreamFields(master.Fields, entry.Fields)
+		flags := int64(0)
+		if sameFields {
+			flags = streamItemFlagSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.ID.Seq))
+		if sameFields {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+		} else {
+			lp.appendInt(int64(numFields))
+			for _, field := range entry.Fields {
+				lp.appendString(field)
+			}
+		}
+		// lp-count 是这条消息除了自己之外的元素个数，用于从后往前遍历
+		lpCount := int64(numFields + 3)
+		if !sameFields {
+			lpCount += int64(numFields + 1)
+		}
+		lp.appendInt(lpCount)
+	}
+	return lp.bytes()
+}
+
+func sameStreamFields(master [][]byte, fields [][]byte) bool {
+	if len(master) != len(fields) {
+		return false
+	}
+	for i := 0; i < len(fields); i += 2 {
+		if !utils.BytesEquals(master[i], fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// readStream 读取 stream，Redis 7.0 之前的 stream_listpacks 没有第一个 ID、被删除的最大的 ID、添加过的消息总数
+func (dec *Decoder) readStream(valueType byte) (*stream.Stream, error) {
+	s := stream.Make()
+	nodes, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		masterID, ok := parseStreamIDBytes(key)
+		if !ok {
+			return nil, ErrInvalidFormat
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements, err := parseListpack(buf)
+		if err != nil {
+			return nil, err
+		}
+		if err = addStreamNode(s, masterID, elements); err != nil {
+			return nil, err
+		}
+	}
+	// 长度可以由消息得到，读出来之后忽略
+	if _, err = dec.readLength(); err != nil {
+		return nil, err
+	}
+	lastID, err := dec.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	s.SetLastID(lastID)
+	if valueType == typeStreamListpacks {
+		// 和 Redis 一样把当前的消息数作为添加过的消息总数
+		s.SetEntriesAdded(s.Len())
+	} else {
+		// 第一个 ID 也可以由消息得到
+		if _, err = dec.readStreamID(); err != nil {
+			return nil, err
+		}
+		maxDeletedID, err := dec.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		entriesAdded, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		s.SetMaxDeletedID(maxDeletedID)
+		s.SetEntriesAdded(int64(entriesAdded))
+	}
+
+	groups, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err = dec.readStreamGroup(s, valueType); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// addStreamNode 解析节点中的消息，跳过已经删除的消息
+func addStreamNode(s *stream.Stream, masterID stream.ID, elements [][]byte) error {
+	reader := &elementReader{elements: elements}
+	count := reader.int()
+	deleted := reader.int()
+	numMasterFields := reader.int()
+	masterFields := make([][]byte, 0)
+	for i := int64(0); i < numMasterFields && reader.err == nil; i++ {
+		masterFields = append(masterFields, reader.next())
+	}
+	// 主记录的结束标记
+	reader.int()
+	for i := int64(0); i < count+deleted && reader.err == nil; i++ {
+		flags := reader.int()
+		id := stream.ID{
+			Ms:  masterID.Ms + uint64(reader.int()),
+			Seq: masterID.Seq + uint64(reader.int()),
+		}
+		var fields [][]byte
+		if flags&streamItemFlagSameFields != 0 {
+			fields = make([][]byte, 0, 2*len(masterFields))
+			for _, field := range masterFields {
+				fields = append(fields, field, reader.next())
+			}
+		} else {
+			numFields := reader.int()
+			fields = make([][]byte, 0)
+			for j := int64(0); j < 2*numFields && reader.err == nil; j++ {
+				fields = append(fields, reader.next())
+			}
+		}
+		// lp-count
+		reader.int()
+		if reader.err != nil || flags&streamItemFlagDeleted != 0 {
+			continue
+		}
+		if err := s.Add(id, fields); err != nil {
+			return ErrInvalidFormat
+		}
+	}
+	return reader.err
+}
+
+// elementReader 顺序读取 listpack 中的元素，元素不够或者不是整数时记下错误
+type elementReader struct {
+	elements [][]byte
+	pos      int
+	err      error
+}
+
+func (r *elementReader) next() []byte {
+	if r.err != nil || r.pos >= len(r.elements) {
+		r.err = ErrInvalidFormat
+		return nil
+	}
+	element := r.elements[r.pos]
+	r.pos++
+	return element
+}
+
+func (r *elementReader) int() int64 {
+	element := r.next()
+	if r.err != nil {
+		return 0
+	}
+	value, err := strconv.ParseInt(string(element), 10, 64)
+	if err != nil {
+		r.err = ErrInvalidFormat
+	}
+	return value
+}
+
+func (dec *Decoder) readStreamID() (stream.ID, error) {
+	ms, err := dec.readLength()
+	if err != nil {
+		return stream.ID{}, err
+	}
+	seq, err := dec.readLength()
+	if err != nil {
+		return stream.ID{}, err
+	}
+	return stream.ID{Ms: ms, Seq: seq}, nil
+}
+
+// pendingInfo 消费组 PEL 中的一项，读到消费者之后才能投递
+type pendingInfo struct {
+	deliveryTime  time.Time
+	deliveryCount int64
+}
+
+// readStreamGroup 读取消费组，stream_listpacks 没有已经读取的数量，stream_listpacks_3 之前消费者没有 active_time
+func (dec *Decoder) readStreamGroup(s *stream.Stream, valueType byte) error {
+	name, err := dec.readString()
+	if err != nil {
+		return err
+	}
+	lastID, err := dec.readStreamID()
+	if err != nil {
+		return err
+	}
+	var entriesRead int64
+	if valueType == typeStreamListpacks {
+		entriesRead = s.EstimateDistanceFromFirstEverEntry(lastID)
+	} else {
+		length, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		entriesRead = int64(length)
+	}
+	group, ok := s.CreateGroup(string(name), lastID, entriesRead)
+	if !ok {
+		return ErrInvalidFormat
+	}
+	pelSize, err := dec.readLength()
+	if err != nil {
+		return err
+	}
+	pel := make(map[stream.ID]*pendingInfo)
+	for i := uint64(0); i < pelSize; i++ {
+		id, err := dec.readRawStreamID()
+		if err != nil {
+			return err
+		}
+		deliveryTime, err := dec.readMillisecondTime()
+		if err != nil {
+			return err
+		}
+		deliveryCount, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		pel[id] = &pendingInfo{
+			deliveryTime:  deliveryTime,
+			deliveryCount: int64(deliveryCount),
+		}
+	}
+
+	consumers, err := dec.readLength()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		consumerName, err := dec.readString()
+		if err != nil {
+			return err
+		}
+		seenTime, err := dec.readMillisecondTime()
+		if err != nil {
+			return err
+		}
+		consumer, ok := group.CreateConsumer(string(consumerName), seenTime)
+		if !ok {
+			return ErrInvalidFormat
+		}
+		if valueType == typeStreamListpk3 {
+			activeTime, err := dec.readUint64()
+			if err != nil {
+				return err
+			}
+			if activeTime != neverActive {
+				consumer.ActiveTime = msToTime(int64(activeTime))
+			}
+		} else {
+			consumer.ActiveTime = seenTime
+		}
+		pending, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			id, err := dec.readRawStreamID()
+			if err != nil {
+				return err
+			}
+			info, ok := pel[id]
+			if !ok {
+				return ErrInvalidFormat
+			}
+			delete(pel, id)
+			pe := group.Deliver(id, consumer, info.deliveryTime)
+			pe.DeliveryCount = info.deliveryCount
+		}
+	}
+	// 每个待确认的消息都必须属于某个消费者
+	if len(pel) > 0 {
+		return ErrInvalidFormat
+	}
+	return nil
+}
+
+func (dec *Decoder) readRawStreamID() (stream.ID, error) {
+	buf, err := dec.readFull(16)
+	if err != nil {
+		return stream.ID{}, err
+	}
+	id, _ := parseStreamIDBytes(buf)
+	return id, nil
+}
@@ -0,0 +1,135 @@
+// Package rdb -----------------------------
+// @file      : ziplist.go
+// @author    : hcjjj
+// @contact   : hcjjj@foxmail.com
+// @time      : 2024/2/15 11:00
+// -------------------------------------------
+package rdb
+
+import (
+	"encoding/binary"
+)
+
+// Redis 7 之前的紧凑编码，只需要读取
+
+// ziplist，Redis 7 之前的列表、哈希表、有序集合的紧凑编码，参考 Redis 的 ziplist.c
+// 总字节数（4 字节小端）| 最后一个元素的偏移（4 字节小端）| 元素个数（2 字节小端）| 元素 ... | 0xFF
+// 每个元素是 前一个元素的长度 + 编码 + 数据，前一个元素的长度小于 254 时占 1 个字节，否则是 0xFE 加 4 字节小端
+// 编码：
+// 00xxxxxx 长度小于 64 的字符串，01xxxxxx xxxxxxxx 14 位长度的字符串，10000000 加 4 字节大端长度的字符串
+// 0xC0 int16，0xD0 int32，0xE0 int64，0xF0 24 位整数，0xFE int8，都是小端
+// 0xF1 到 0xFD 表示 0 到 12，值是低 4 位减 1
+
+const (
+	zipHeaderSize = 10
+	zipEnd        = 0xFF
+	zipBigPrevlen = 0xFE
+)
+
+// parseZiplist 读取 ziplist 中所有的元素，整数转换成十进制字符串
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < zipHeaderSize+1 || int(binary.LittleEndian.Uint32(buf[0:4])) != len(buf) {
+		return nil, ErrInvalidFormat
+	}
+	result := make([][]byte, 0, binary.LittleEndian.Uint16(buf[8:10]))
+	pos := zipHeaderSize
+	for {
+		if pos >= len(buf) {
+			return nil, ErrInvalidFormat
+		}
+		if buf[pos] == zipEnd {
+			break
+		}
+		// 跳过前一个元素的长度
+		if buf[pos] == zipBigPrevlen {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(buf) {
+			return nil, ErrInvalidFormat
+		}
+		element, size, ok := parseZiplistElement(buf[pos:])
+		if !ok {
+			return nil, ErrInvalidFormat
+		}
+		result = append(result, element)
+		pos += size
+	}
+	return result, nil
+}
+
+// parseZiplistElement 解析编码和数据，返回元素和占用的字节数
+func parseZiplistElement(buf []byte) ([]byte, int, bool) {
+	first := buf[0]
+	switch first >> 6 {
+	case 0:
+		return lpString(buf, 1, int(first&0x3F))
+	case 1:
+		if len(buf) < 2 {
+			return nil, 0, false
+		}
+		return lpString(buf, 2, int(first&0x3F)<<8|int(buf[1]))
+	case 2:
+		if len(buf) < 5 {
+			return nil, 0, false
+		}
+		return lpString(buf, 5, int(binary.BigEndian.Uint32(buf[1:5])))
+	}
+	var width int
+	switch first {
+	case 0xC0:
+		width = 2
+	case 0xD0:
+		width = 4
+	case 0xE0:
+		width = 8
+	case 0xF0:
+		width = 3
+	case 0xFE:
+		width = 1
+	default:
+		if first >= 0xF1 && first <= 0xFD {
+			return formatInt(int64(first&0x0F) - 1), 1, true
+		}
+		return nil, 0, false
+	}
+	if len(buf) < 1+width {
+		return nil, 0, false
+	}
+	var u uint64
+	for i := width; i >= 1; i-- {
+		u = u<<8 | uint64(buf[i])
+	}
+	return formatInt(signExtend(u, uint(width*8))), 1 + width, true
+}
+
+// intset，元素都是整数的集合的紧凑编码，参考 Redis 的 intset.c
+// 每个元素的字节数 2、4、8（4 字节小端）| 元素个数（4 字节小端）| 有序的小端整数 ...
+
+// parseIntset 读取 intset 中所有的元素，转换成十进制字符串
+func parseIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, ErrInvalidFormat
+	}
+	width := int(binary.LittleEndian.Uint32(buf[0:4]))
+	length := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if (width != 2 && width != 4 && width != 8) || length < 0 || len(buf) != 8+width*length {
+		return nil, ErrInvalidFormat
+	}
+	result := make([][]byte, 0, length)
+	for i := 0; i < length; i++ {
+		data := buf[8+i*width : 8+(i+1)*width]
+		var value int64
+		switch width {
+		case 2:
+			value = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 4:
+			value = int64(int32(binary.LittleEndian.Uint32(data)))
+		default:
+			value = int64(binary.LittleEndian.Uint64(data))
+		}
+		result = append(result, formatInt(value))
+	}
+	return result, nil
+}
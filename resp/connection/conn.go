@@ -8,6 +8,7 @@ package connection
 
 import (
 	"net"
+	"redis-go/interface/resp"
 	"redis-go/lib/sync/wait"
 	"sync"
 	"time"
@@ -23,6 +24,21 @@ type Connection struct {
 	mu sync.Mutex
 	// 选择哪个 db
 	selectedDB int
+	// 是否处于 MULTI 之后
+	multiState bool
+	// MULTI 之后排队的命令
+	queue [][][]byte
+	// WATCH 的 key → 版本号
+	watching map[resp.WatchedKey]uint32
+	// 排队时出现的错误，EXEC 时放弃整个事务
+	txErrors []error
+	// 订阅的频道和模式
+	subs  map[string]struct{}
+	psubs map[string]struct{}
+	// 阻塞命令执行期间客户端断开需要立即取消阻塞，由读取数据的协程和执行命令的协程共同访问
+	blockMu sync.Mutex
+	blocked bool
+	closing bool
 }
 
 func NewConn(conn net.Conn) *Connection {
@@ -62,6 +78,35 @@ func (c *Connection) Write(bytes []byte) error {
 	return err
 }
 
+// WriteTimeout 写回数据时设置超时时间，用于异步写回的订阅消息，避免一直阻塞在不读取数据的客户端上
+func (c *Connection) WriteTimeout(bytes []byte, timeout time.Duration) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+
+	c.waitingReply.Add(1)
+	defer func() {
+		c.waitingReply.Done()
+		c.mu.Unlock()
+	}()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(bytes)
+	_ = c.conn.SetWriteDeadline(time.Time{})
+	return err
+}
+
+// Disconnect 直接关闭底层的连接，不等待正在写回的数据
+func (c *Connection) Disconnect() {
+	// 用于 aof 重放的连接没有底层的连接
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
 func (c *Connection) GetDBIndex() int {
 	return c.selectedDB
 }
@@ -69,3 +114,116 @@ func (c *Connection) GetDBIndex() int {
 func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
+
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		// 退出事务的时候清空所有的状态
+		c.queue = nil
+		c.watching = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+func (c *Connection) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+func (c *Connection) GetWatching() map[resp.WatchedKey]uint32 {
+	// 用于 aof 重放的连接没有经过 NewConn 初始化
+	if c.watching == nil {
+		c.watching = make(map[resp.WatchedKey]uint32)
+	}
+	return c.watching
+}
+
+func (c *Connection) ClearWatching() {
+	c.watching = nil
+}
+
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+func (c *Connection) Subscribe(channel string) {
+	if c.subs == nil {
+		c.subs = make(map[string]struct{})
+	}
+	c.subs[channel] = struct{}{}
+}
+
+func (c *Connection) UnSubscribe(channel string) {
+	delete(c.subs, channel)
+}
+
+func (c *Connection) GetChannels() []string {
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (c *Connection) PSubscribe(pattern string) {
+	if c.psubs == nil {
+		c.psubs = make(map[string]struct{})
+	}
+	c.psubs[pattern] = struct{}{}
+}
+
+func (c *Connection) PUnSubscribe(pattern string) {
+	delete(c.psubs, pattern)
+}
+
+func (c *Connection) GetPatterns() []string {
+	patterns := make([]string, 0, len(c.psubs))
+	for pattern := range c.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func (c *Connection) SubsCount() int {
+	return len(c.subs) + len(c.psubs)
+}
+
+func (c *Connection) Block() bool {
+	c.blockMu.Lock()
+	defer c.blockMu.Unlock()
+	if c.closing {
+		return false
+	}
+	c.blocked = true
+	return true
+}
+
+func (c *Connection) Unblock() {
+	c.blockMu.Lock()
+	defer c.blockMu.Unlock()
+	c.blocked = false
+}
+
+// MarkClosing 读取到客户端断开，返回客户端是否正在执行阻塞命令
+// 之后的阻塞命令不会再阻塞
+func (c *Connection) MarkClosing() bool {
+	c.blockMu.Lock()
+	defer c.blockMu.Unlock()
+	c.closing = true
+	return c.blocked
+}
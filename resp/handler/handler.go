@@ -40,12 +40,11 @@ func MakeHandler() *RespHandler {
 	var db databseinterface.Database
 	// 测试解析结果，直接反回解析结果给用户
 	//db = database.NewEchoDatabase()
-	// 单机版的
-	db = database.NewStandaloneDatabase()
 	// 判断是否启动集群版
 	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
 		db = cluster.MakeClusterDatabase()
 	} else {
+		// 单机版的
 		db = database.NewStandaloneDatabase()
 	}
 	return &RespHandler{
@@ -72,15 +71,15 @@ func (r *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	client := connection.NewConn(conn)
 	// k 是 client  v 是空接口体  map → set
 	r.activeConn.Store(client, struct{}{})
-	ch := parser.ParseStream(conn)
+	// 阻塞命令执行期间也要继续读取，才能发现客户端断开
+	payloads := make(chan *parser.Payload)
+	go r.forwardPayloads(client, parser.ParseStream(conn), payloads)
 	// 监听管道
-	for payload := range ch {
+	for payload := range payloads {
 		// 异常逻辑
 		if payload.Err != nil {
 			// 客户端关闭
-			if payload.Err == io.EOF ||
-				errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
+			if isClosedErr(payload.Err) {
 				r.closeClient(client)
 				logger.Info("Connection closed: " + client.RemoteAddr().String())
 				return
@@ -144,6 +143,27 @@ func (r *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	}
 }
 
+// forwardPayloads 把解析结果转发给执行命令的协程
+// 客户端在阻塞命令执行期间断开时直接关闭连接，数据库在 AfterClientClose 中取消阻塞
+func (r *RespHandler) forwardPayloads(client *connection.Connection, ch <-chan *parser.Payload, payloads chan<- *parser.Payload) {
+	defer close(payloads)
+	for payload := range ch {
+		if payload.Err != nil && isClosedErr(payload.Err) && client.MarkClosing() {
+			r.closeClient(client)
+			logger.Info("Connection closed: " + client.RemoteAddr().String())
+			return
+		}
+		payloads <- payload
+	}
+}
+
+// isClosedErr 客户端是否已经断开
+func isClosedErr(err error) bool {
+	return err == io.EOF ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(err.Error(), "use of closed network connection")
+}
+
 // Close 关闭整个 handler
 func (r *RespHandler) Close() error {
 	logger.Info("handler shutting down ...")
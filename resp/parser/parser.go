@@ -36,6 +36,53 @@ type readState struct {
 	args [][]byte
 	// 预期要读取的字节数
 	bulkLen int64
+	// 读到了 $n，下一行按照 bulkLen 读取，$0 的时候也一样
+	readingBody bool
+	// 解析嵌套数组（如 SCAN 的回复）时，外层数组的状态
+	parent *readState
+	// 出现嵌套数组之后，按顺序保存每个元素的回复，args 中对应的位置为 nil
+	replies []resp.Reply
+}
+
+// appendArg 加入一个字符串元素
+func (s *readState) appendArg(arg []byte) {
+	s.args = append(s.args, arg)
+	if s.replies != nil {
+		s.replies = append(s.replies, toBulkReply(arg))
+	}
+}
+
+// appendReply 把解析完的嵌套数组作为一个元素加入到当前数组中
+func (s *readState) appendReply(r resp.Reply) {
+	if s.replies == nil {
+		// 之前的元素都是字符串
+		s.replies = make([]resp.Reply, 0, s.expectedArgsCount)
+		for _, arg := range s.args {
+			s.replies = append(s.replies, toBulkReply(arg))
+		}
+	}
+	s.replies = append(s.replies, r)
+	s.args = append(s.args, nil)
+}
+
+// result 解析完成后的回复
+func (s *readState) result() resp.Reply {
+	if s.msgType == '$' {
+		// 单行字符串
+		return reply.MakeBulkReply(s.args[0])
+	}
+	if s.replies == nil {
+		// 字符串数组
+		return reply.MakeMultiBulkReply(s.args)
+	}
+	return reply.MakeMultiRawReply(s.replies)
+}
+
+func toBulkReply(arg []byte) resp.Reply {
+	if arg == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(arg)
 }
 
 // isFinished 判断是否解析结束
@@ -172,20 +219,25 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 			}
 			// 解析完一整个命令才往 ch 里面发
 			if state.isFinished() {
-				var result resp.Reply
-				if state.msgType == '*' {
-					// 字符串数组
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					// 单行字符串
-					result = reply.MakeBulkReply(state.args[0])
+				result := state.result()
+				// 嵌套的数组解析完之后回到外层数组
+				for state.parent != nil {
+					state = *state.parent
+					state.appendReply(result)
+					if !state.isFinished() {
+						result = nil
+						break
+					}
+					result = state.result()
 				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
+				if result != nil {
+					ch <- &Payload{
+						Data: result,
+						Err:  err,
+					}
+					// 重置解析器状态
+					state = readState{}
 				}
-				// 重置解析器状态
-				state = readState{}
 			}
 		}
 	}
@@ -200,7 +252,7 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	// bulkLen 表示要读取字符的长度
 	// 1. \r\n 切分
 	// 没有预设的长度
-	if state.bulkLen == 0 {
+	if !state.readingBody {
 		// 保证每次读取到完整的一行
 		// xxxx\r\n
 		msg, err = bufReader.ReadBytes('\n')
@@ -275,10 +327,11 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.bulkLen == -1 { // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 {
 		state.msgType = msg[0]
 		// 多行模式，$4\r\nPING\r\n 是两行的，所以要打开
 		state.readingMultiLine = true
+		state.readingBody = true
 		state.expectedArgsCount = 1
 		state.args = make([][]byte, 0, 1)
 		return nil
@@ -318,21 +371,47 @@ func readBody(msg []byte, state *readState) error {
 	// 去掉末尾的 \r\n
 	line := msg[0 : len(msg)-2]
 	var err error
-	// $3
-	if line[0] == '$' {
+	if state.readingBody {
+		// $n 后面的内容，即使以 $ 开头也是参数本身
+		state.appendArg(line)
+		state.readingBody = false
+	} else if len(line) > 0 && line[0] == '$' {
+		// $3
 		// 去掉 $ 然后 str -> int
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
+		if err != nil || state.bulkLen < -1 {
 			return errors.New("protocol error: " + string(msg))
 		}
-		// $0\r\n
-		if state.bulkLen <= 0 {
-			// 塞个空的参数
-			state.args = append(state.args, []byte{})
+		if state.bulkLen == -1 {
+			// $-1\r\n 没有内容
+			state.appendArg(nil)
 			state.bulkLen = 0
+		} else {
+			// $0\r\n 后面还有一个 \r\n
+			state.readingBody = true
+		}
+	} else if len(line) > 0 && line[0] == '*' && state.msgType == '*' {
+		// 嵌套数组
+		count, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || count < -1 {
+			return errors.New("protocol error: " + string(msg))
+		}
+		if count == -1 {
+			state.appendReply(reply.MakeNullMultiBulkReply())
+		} else if count == 0 {
+			state.appendReply(reply.MakeEmptyMultiBulkReply())
+		} else {
+			parent := *state
+			*state = readState{
+				readingMultiLine:  true,
+				expectedArgsCount: int(count),
+				msgType:           '*',
+				args:              make([][]byte, 0, count),
+				parent:            &parent,
+			}
 		}
 	} else {
-		state.args = append(state.args, line)
+		state.appendArg(line)
 	}
 	return nil
 }
@@ -21,6 +21,12 @@ func TestParseStream(t *testing.T) {
 			[]byte("\r\n"),
 		}),
 		reply.MakeEmptyMultiBulkReply(),
+		reply.MakeBulkReply([]byte{}),
+		reply.MakeMultiBulkReply([][]byte{
+			[]byte("set"),
+			[]byte{},
+			[]byte("$1"), // 以 $ 开头的参数
+		}),
 	}
 	reqs := bytes.Buffer{}
 	for _, re := range replies {
@@ -68,6 +74,12 @@ func TestParseOne(t *testing.T) {
 			[]byte("\r\n"),
 		}),
 		reply.MakeEmptyMultiBulkReply(),
+		reply.MakeBulkReply([]byte{}),
+		reply.MakeMultiBulkReply([][]byte{
+			[]byte("set"),
+			[]byte{},
+			[]byte("$1"), // 以 $ 开头的参数
+		}),
 	}
 	for _, re := range replies {
 		result, err := ParseOne(re.ToBytes())
@@ -80,3 +92,49 @@ func TestParseOne(t *testing.T) {
 		}
 	}
 }
+
+func TestParseNested(t *testing.T) {
+	replies := []resp.Reply{
+		// SCAN 的回复
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("17")),
+			reply.MakeMultiBulkReply([][]byte{[]byte("a"), []byte("*1")}),
+		}),
+		reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte("x")),
+				reply.MakeMultiBulkReply([][]byte{[]byte("f"), nil}),
+			}),
+			reply.MakeNullMultiBulkReply(),
+			reply.MakeEmptyMultiBulkReply(),
+			reply.MakeNullBulkReply(),
+			reply.MakeBulkReply([]byte("y")),
+		}),
+		reply.MakeMultiBulkReply([][]byte{[]byte("get"), []byte("a")}),
+	}
+	reqs := bytes.Buffer{}
+	for _, re := range replies {
+		reqs.Write(re.ToBytes())
+	}
+	ch := ParseStream(bytes.NewReader(reqs.Bytes()))
+	i := 0
+	for payload := range ch {
+		if payload.Err != nil {
+			if payload.Err == io.EOF {
+				break
+			}
+			t.Fatal(payload.Err)
+		}
+		if !utils.BytesEquals(replies[i].ToBytes(), payload.Data.ToBytes()) {
+			t.Fatalf("parse failed: %q, actually %q", replies[i].ToBytes(), payload.Data.ToBytes())
+		}
+		// 没有嵌套的数组仍然解析为字符串数组
+		if _, ok := payload.Data.(*reply.MultiBulkReply); i == 2 && !ok {
+			t.Error("expected multi bulk reply")
+		}
+		i++
+	}
+	if i != len(replies) {
+		t.Errorf("expected %d replies, actually %d", len(replies), i)
+	}
+}
@@ -37,6 +37,22 @@ func MakeOkReply() *OkReply {
 	return theOkReply
 }
 
+// QueuedReply MULTI 之后命令排队的回复
+type QueuedReply struct {
+}
+
+var queuedBytes = []byte("+QUEUED\r\n")
+
+func (q QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+var theQueuedReply = new(QueuedReply)
+
+func MakeQueuedReply() *QueuedReply {
+	return theQueuedReply
+}
+
 // NullBulkReply 空的字符串回复
 type NullBulkReply struct {
 }
@@ -52,6 +68,20 @@ func MakeNullBulkReply() *NullBulkReply {
 	return &NullBulkReply{}
 }
 
+// 空的数组回复，不是空数组
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+// NullMultiBulkReply is a null list
+type NullMultiBulkReply struct{}
+
+func (n NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
 // 空数组
 var emptyMultiBulkBytes = []byte("*0\r\n")
 
@@ -41,7 +41,7 @@ func MakeArgNumErrReply(cmd string) *ArgNumErrReply {
 // SyntaxErrReply represents meeting unexpected arguments
 type SyntaxErrReply struct{}
 
-var syntaxErrBytes = []byte("-Err syntax error\r\n")
+var syntaxErrBytes = []byte("-ERR syntax error\r\n")
 var theSyntaxErrReply = &SyntaxErrReply{}
 
 // MakeSyntaxErrReply creates syntax error
@@ -55,13 +55,13 @@ func (r *SyntaxErrReply) ToBytes() []byte {
 }
 
 func (r *SyntaxErrReply) Error() string {
-	return "Err syntax error"
+	return "ERR syntax error"
 }
 
 // WrongTypeErrReply represents operation against a key holding the wrong kind of value
 type WrongTypeErrReply struct{}
 
-var wrongTypeErrBytes = []byte("-WRONG TYPE Operation against a key holding the wrong kind of value\r\n")
+var wrongTypeErrBytes = []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
 
 // ToBytes marshals redis.Reply
 func (r *WrongTypeErrReply) ToBytes() []byte {
@@ -69,7 +69,7 @@ func (r *WrongTypeErrReply) ToBytes() []byte {
 }
 
 func (r *WrongTypeErrReply) Error() string {
-	return "WRONG TYPE Operation against a key holding the wrong kind of value"
+	return "WRONGTYPE Operation against a key holding the wrong kind of value"
 }
 
 // ProtocolErr 协议错误
@@ -22,7 +22,8 @@ type BulkReply struct {
 }
 
 func (b *BulkReply) ToBytes() []byte {
-	if len(b.Arg) == 0 {
+	// nil 表示不存在，空字符串需要正常输出 "$0\r\n\r\n"
+	if b.Arg == nil {
 		return []byte(string(nullBulkReplyBytes) + CRLF)
 	}
 	// "hcjjj" → "$5\r\nhcjjj\r\n"
@@ -129,3 +130,28 @@ type ErrorReply interface {
 	Error() string
 	ToBytes() []byte
 }
+
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply 元素可以是任意类型回复的数组，如整数数组、嵌套数组
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}